@@ -0,0 +1,170 @@
+// Package actor is a small actor runtime in the spirit of the channel
+// ownership lesson: every actor is a goroutine that owns its state and is
+// reached only through its mailbox.
+//
+// Actors are spawned under a Supervisor, which restarts them with fresh
+// state when their handler returns an error or panics. Supervisors can be
+// nested into a tree and give up, escalating to their parent, when
+// children restart too often. Ask sends a request and waits for the
+// reply.
+package actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/go-concurrency-lesson/safe"
+)
+
+var (
+	// ErrStopped is returned when sending to an actor whose supervisor has
+	// stopped
+	ErrStopped = errors.New("actor: stopped")
+	// ErrMailboxFull is returned by TryTell when the mailbox is full
+	ErrMailboxFull = errors.New("actor: mailbox full")
+)
+
+// Handler processes one message at a time. Returning an error or
+// panicking crashes the actor, and its supervisor decides what happens
+// next. ctx is cancelled when the actor is stopped.
+type Handler[M any] func(ctx context.Context, msg M) error
+
+// Options configure an actor
+type Options struct {
+	// Mailbox is the number of messages that can wait for the actor [64]
+	Mailbox int
+}
+
+// Ref addresses an actor. It stays valid across restarts: messages still
+// in the mailbox are handled by the restarted actor.
+type Ref[M any] struct {
+	name    string
+	mailbox chan M
+	stopped chan struct{}
+}
+
+// Name returns the name the actor was spawned with
+func (r *Ref[M]) Name() string {
+	return r.name
+}
+
+// Tell puts msg in the mailbox, waiting while it is full
+func (r *Ref[M]) Tell(ctx context.Context, msg M) error {
+	// Check first so a stopped actor never accepts a message
+	select {
+	case <-r.stopped:
+		return ErrStopped
+	default:
+	}
+	select {
+	case r.mailbox <- msg:
+		return nil
+	case <-r.stopped:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryTell puts msg in the mailbox or returns ErrMailboxFull at once
+func (r *Ref[M]) TryTell(msg M) error {
+	select {
+	case <-r.stopped:
+		return ErrStopped
+	default:
+	}
+	select {
+	case r.mailbox <- msg:
+		return nil
+	default:
+		return ErrMailboxFull
+	}
+}
+
+// Replier carries the answer to a request; the handler calls Reply once
+type Replier[R any] chan R
+
+// Reply sends the answer. Replies after the first are dropped.
+func (r Replier[R]) Reply(v R) {
+	select {
+	case r <- v:
+	default:
+	}
+}
+
+// Ask sends the message built by mk to ref and waits for the reply, until
+// ctx is done. Use context.WithTimeout for request timeouts.
+func Ask[M, R any](ctx context.Context, ref *Ref[M], mk func(Replier[R]) M) (R, error) {
+	var zero R
+	reply := make(Replier[R], 1)
+	if err := ref.Tell(ctx, mk(reply)); err != nil {
+		return zero, err
+	}
+	select {
+	case v := <-reply:
+		return v, nil
+	case <-ref.stopped:
+		return zero, ErrStopped
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// actorChild runs instances of one actor for a supervisor
+type actorChild[M any] struct {
+	ref        *Ref[M]
+	newHandler func() Handler[M]
+	done       chan struct{}
+}
+
+func (a *actorChild[M]) childName() string {
+	return a.ref.name
+}
+
+func (a *actorChild[M]) start(ctx context.Context, failed func(error)) {
+	done := make(chan struct{})
+	a.done = done
+	go func() {
+		defer close(done)
+		if err := a.run(ctx); err != nil && ctx.Err() == nil {
+			failed(err)
+		}
+	}()
+}
+
+// run handles messages with a fresh handler until ctx is done or the
+// handler fails. A panic is returned as a *safe.PanicError whose Input is
+// the message being handled, or nil if newHandler panicked.
+func (a *actorChild[M]) run(ctx context.Context) (err error) {
+	var input any
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("actor %s: %w", a.ref.name, &safe.PanicError{Value: v, Input: input, Stack: debug.Stack()})
+		}
+	}()
+	h := a.newHandler()
+	for {
+		select {
+		case msg := <-a.ref.mailbox:
+			input = msg
+			if err := h(ctx, msg); err != nil {
+				return fmt.Errorf("actor %s: %w", a.ref.name, err)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// stop waits for the current instance; its context is already cancelled
+func (a *actorChild[M]) stop() {
+	if a.done != nil {
+		<-a.done
+	}
+}
+
+func (a *actorChild[M]) terminate() {
+	close(a.ref.stopped)
+}
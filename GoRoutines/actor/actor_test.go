@@ -0,0 +1,380 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+	"github.com/go-concurrency-lesson/internal/leaktest"
+	"github.com/go-concurrency-lesson/safe"
+)
+
+type msg struct {
+	op    string
+	reply Replier[int]
+}
+
+// counter counts "inc" messages, answers "get", panics on "boom" and
+// fails on "fail". starts counts how often it was (re)started.
+func counter(starts *atomic.Int32) func() Handler[msg] {
+	return func() Handler[msg] {
+		if starts != nil {
+			starts.Add(1)
+		}
+		n := 0
+		return func(_ context.Context, m msg) error {
+			switch m.op {
+			case "inc":
+				n++
+			case "get":
+				m.reply.Reply(n)
+			case "boom":
+				panic("boom")
+			case "fail":
+				return errors.New("failed")
+			}
+			return nil
+		}
+	}
+}
+
+func get(r Replier[int]) msg { return msg{op: "get", reply: r} }
+
+func ask(t *testing.T, ref *Ref[msg]) int {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err := Ask(ctx, ref, get)
+	if err != nil {
+		t.Fatalf("Ask(%s) error: %v", ref.Name(), err)
+	}
+	return n
+}
+
+func tell(t *testing.T, ref *Ref[msg], op string) {
+	t.Helper()
+	if err := ref.Tell(context.Background(), msg{op: op}); err != nil {
+		t.Fatalf("Tell(%s, %s) error: %v", ref.Name(), op, err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTellAsk(t *testing.T) {
+	leaktest.Check(t)
+	s := NewSupervisor(context.Background(), SupervisorOptions{})
+	defer s.Stop()
+	ref, err := Spawn(s, "counter", counter(nil), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		tell(t, ref, "inc")
+	}
+	if n := ask(t, ref); n != 3 {
+		t.Errorf("Ask() = %d, want 3", n)
+	}
+	if ref.Name() != "counter" {
+		t.Errorf("Name() = %q", ref.Name())
+	}
+}
+
+func TestAskTimeout(t *testing.T) {
+	s := NewSupervisor(context.Background(), SupervisorOptions{})
+	defer s.Stop()
+	silent, _ := Spawn(s, "silent", func() Handler[msg] {
+		return func(context.Context, msg) error { return nil }
+	}, Options{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := Ask(ctx, silent, get); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Ask() of an actor that never replies = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestTryTell(t *testing.T) {
+	s := NewSupervisor(context.Background(), SupervisorOptions{})
+	defer s.Stop()
+	release := make(chan struct{})
+	ref, _ := Spawn(s, "slow", func() Handler[msg] {
+		return func(context.Context, msg) error { <-release; return nil }
+	}, Options{Mailbox: 1})
+
+	// One message being handled, one in the mailbox
+	ref.TryTell(msg{})
+	waitFor(t, "first message taken", func() bool { return ref.TryTell(msg{}) == nil })
+	if err := ref.TryTell(msg{}); !errors.Is(err, ErrMailboxFull) {
+		t.Errorf("TryTell() on full mailbox = %v, want ErrMailboxFull", err)
+	}
+	close(release)
+}
+
+func TestCrashRestarts(t *testing.T) {
+	for _, op := range []string{"boom", "fail"} {
+		t.Run(op, func(t *testing.T) {
+			var starts atomic.Int32
+			reasons := make(chan error, 1)
+			s := NewSupervisor(context.Background(), SupervisorOptions{
+				OnRestart: func(_ string, err error) { reasons <- err },
+			})
+			defer s.Stop()
+			ref, _ := Spawn(s, "counter", counter(&starts), Options{})
+
+			tell(t, ref, "inc")
+			tell(t, ref, op)
+			tell(t, ref, "inc") // waits in the mailbox for the restarted actor
+			if n := ask(t, ref); n != 1 {
+				t.Errorf("count after restart = %d, want 1 (state is reset)", n)
+			}
+			if n := starts.Load(); n != 2 {
+				t.Errorf("actor started %d times, want 2", n)
+			}
+
+			err := <-reasons
+			var pe *safe.PanicError
+			if op == "boom" && (!errors.As(err, &pe) || pe.Value != "boom" || pe.Input == nil || len(pe.Stack) == 0 || !strings.Contains(err.Error(), "counter")) {
+				t.Errorf("restart reason = %v, want a safe.PanicError naming the actor, with the message and a stack", err)
+			}
+			if op == "fail" && (err == nil || errors.As(err, &pe)) {
+				t.Errorf("restart reason = %v, want the handler error", err)
+			}
+		})
+	}
+}
+
+func TestOnRestartUsesSupervisor(t *testing.T) {
+	var s *Supervisor
+	spawned := make(chan error, 1)
+	s = NewSupervisor(context.Background(), SupervisorOptions{
+		// Spawning from the callback needs the supervisor's lock
+		OnRestart: func(child string, _ error) {
+			_, err := Spawn(s, child+"-witness", counter(nil), Options{})
+			spawned <- err
+		},
+	})
+	defer s.Stop()
+	ref, _ := Spawn(s, "counter", counter(nil), Options{})
+
+	tell(t, ref, "boom")
+	select {
+	case err := <-spawned:
+		if err != nil {
+			t.Errorf("Spawn() in OnRestart error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Spawn() in OnRestart deadlocked")
+	}
+	if n := ask(t, ref); n != 0 {
+		t.Errorf("count after restart = %d, want 0", n)
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	tests := []struct {
+		strategy Strategy
+		want     [3]int32
+	}{
+		{OneForOne, [3]int32{1, 2, 1}},
+		{OneForAll, [3]int32{2, 2, 2}},
+		{RestForOne, [3]int32{1, 2, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy.String(), func(t *testing.T) {
+			leaktest.Check(t)
+			s := NewSupervisor(context.Background(), SupervisorOptions{Strategy: tt.strategy})
+			defer s.Stop()
+			var starts [3]atomic.Int32
+			var refs [3]*Ref[msg]
+			for i, name := range []string{"a", "b", "c"} {
+				refs[i], _ = Spawn(s, name, counter(&starts[i]), Options{})
+				tell(t, refs[i], "inc")
+				ask(t, refs[i]) // the increment is handled before any crash
+			}
+
+			tell(t, refs[1], "boom")
+			waitFor(t, "restarts", func() bool {
+				for i := range starts {
+					if starts[i].Load() != tt.want[i] {
+						return false
+					}
+				}
+				return true
+			})
+			// Restarted actors lost their count, the others kept it
+			for i, ref := range refs {
+				wantCount := 1
+				if tt.want[i] == 2 {
+					wantCount = 0
+				}
+				if n := ask(t, ref); n != wantCount {
+					t.Errorf("actor %s count = %d, want %d", ref.Name(), n, wantCount)
+				}
+			}
+		})
+	}
+}
+
+func TestRestartIntensity(t *testing.T) {
+	leaktest.Check(t)
+	fake := clock.NewFake(time.Now())
+	var restarts atomic.Int32
+	s := NewSupervisor(context.Background(), SupervisorOptions{
+		MaxRestarts: 2,
+		Within:      time.Minute,
+		Clock:       fake,
+		OnRestart:   func(string, error) { restarts.Add(1) },
+	})
+	ref, _ := Spawn(s, "crasher", counter(nil), Options{})
+
+	crash := func(wantRestarts int32) {
+		t.Helper()
+		tell(t, ref, "boom")
+		waitFor(t, "restart", func() bool { return restarts.Load() == wantRestarts })
+	}
+	crash(1)
+	crash(2)
+	// Old restarts fall out of the window
+	fake.Advance(2 * time.Minute)
+	crash(3)
+	crash(4)
+	tell(t, ref, "boom")
+
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("supervisor did not give up")
+	}
+	if err := s.Err(); !errors.Is(err, ErrRestartIntensity) {
+		t.Errorf("Err() = %v, want ErrRestartIntensity", err)
+	}
+	if err := ref.Tell(context.Background(), msg{}); !errors.Is(err, ErrStopped) {
+		t.Errorf("Tell() after give-up = %v, want ErrStopped", err)
+	}
+}
+
+func TestSupervisionTree(t *testing.T) {
+	leaktest.Check(t)
+	var mu sync.Mutex
+	var rootRestarts []string
+	var rootErr error
+	root := NewSupervisor(context.Background(), SupervisorOptions{
+		OnRestart: func(child string, err error) {
+			mu.Lock()
+			rootRestarts = append(rootRestarts, child)
+			rootErr = err
+			mu.Unlock()
+		},
+	})
+	defer root.Stop()
+
+	workers, err := root.Child("workers", SupervisorOptions{MaxRestarts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts atomic.Int32
+	w, _ := Spawn(workers, "worker", counter(&starts), Options{})
+	other, _ := Spawn(root, "other", counter(nil), Options{})
+	tell(t, other, "inc")
+
+	// The second crash exceeds the workers' intensity; the root restarts
+	// the whole subtree
+	tell(t, w, "boom")
+	tell(t, w, "boom")
+	waitFor(t, "subtree restart", func() bool { return starts.Load() == 3 })
+
+	mu.Lock()
+	if len(rootRestarts) != 1 || rootRestarts[0] != "workers" || !errors.Is(rootErr, ErrRestartIntensity) {
+		t.Errorf("root restarts %v (%v), want workers with ErrRestartIntensity", rootRestarts, rootErr)
+	}
+	mu.Unlock()
+
+	tell(t, w, "inc")
+	if n := ask(t, w); n != 1 {
+		t.Errorf("worker count after subtree restart = %d, want 1", n)
+	}
+	if n := ask(t, other); n != 1 {
+		t.Errorf("sibling count = %d, want 1 (not restarted)", n)
+	}
+
+	// The restarted subtree has a fresh intensity budget and accepts new
+	// children
+	late, err := Spawn(workers, "late", counter(nil), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tell(t, late, "inc")
+	if n := ask(t, late); n != 1 {
+		t.Errorf("late child count = %d, want 1", n)
+	}
+}
+
+func TestStop(t *testing.T) {
+	leaktest.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewSupervisor(ctx, SupervisorOptions{})
+	ref, _ := Spawn(s, "counter", counter(nil), Options{})
+	sub, _ := s.Child("sub", SupervisorOptions{})
+	subRef, _ := Spawn(sub, "leaf", counter(nil), Options{})
+
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("supervisor not stopped after cancel")
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+	for _, r := range []*Ref[msg]{ref, subRef} {
+		if err := r.Tell(context.Background(), msg{}); !errors.Is(err, ErrStopped) {
+			t.Errorf("Tell(%s) after stop = %v, want ErrStopped", r.Name(), err)
+		}
+		if _, err := Ask(context.Background(), r, get); !errors.Is(err, ErrStopped) {
+			t.Errorf("Ask(%s) after stop = %v, want ErrStopped", r.Name(), err)
+		}
+	}
+	if _, err := Spawn(s, "late", counter(nil), Options{}); !errors.Is(err, ErrStopped) {
+		t.Errorf("Spawn() after stop = %v, want ErrStopped", err)
+	}
+	s.Stop()
+}
+
+func TestAskStoppedWhileWaiting(t *testing.T) {
+	s := NewSupervisor(context.Background(), SupervisorOptions{})
+	ref, _ := Spawn(s, "silent", func() Handler[msg] {
+		return func(context.Context, msg) error { return nil }
+	}, Options{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.Stop()
+	}()
+	if _, err := Ask(context.Background(), ref, get); !errors.Is(err, ErrStopped) {
+		t.Errorf("Ask() = %v, want ErrStopped", err)
+	}
+}
+
+func BenchmarkAsk(b *testing.B) {
+	s := NewSupervisor(context.Background(), SupervisorOptions{})
+	defer s.Stop()
+	ref, _ := Spawn(s, "counter", counter(nil), Options{})
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Ask(ctx, ref, get)
+	}
+}
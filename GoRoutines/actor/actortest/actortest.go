@@ -0,0 +1,170 @@
+// Package actortest runs actors in tests: a Kit owns a supervisor that is
+// stopped with the test, Tell and Ask fail the test instead of returning
+// errors, and a Probe is an actor that hands the messages it receives to
+// the test.
+package actortest
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/actor"
+)
+
+// Timeout is how long the helpers wait before failing the test
+const Timeout = time.Second
+
+// Kit is a supervisor bound to a test
+type Kit struct {
+	t   testing.TB
+	Sup *actor.Supervisor
+
+	mu       sync.Mutex
+	restarts []error
+	changed  chan struct{}
+}
+
+// New starts a root supervisor that is stopped when the test ends. Every
+// restart is recorded for Restarts and WaitRestarts.
+func New(t testing.TB, opts actor.SupervisorOptions) *Kit {
+	k := &Kit{t: t, changed: make(chan struct{})}
+	onRestart := opts.OnRestart
+	opts.OnRestart = func(child string, err error) {
+		k.mu.Lock()
+		k.restarts = append(k.restarts, err)
+		close(k.changed)
+		k.changed = make(chan struct{})
+		k.mu.Unlock()
+		if onRestart != nil {
+			onRestart(child, err)
+		}
+	}
+	k.Sup = actor.NewSupervisor(context.Background(), opts)
+	t.Cleanup(k.Sup.Stop)
+	return k
+}
+
+// Restarts returns the crash reasons of every restart so far
+func (k *Kit) Restarts() []error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]error(nil), k.restarts...)
+}
+
+// WaitRestarts waits until at least n restarts happened
+func (k *Kit) WaitRestarts(n int) {
+	k.t.Helper()
+	deadline := time.After(Timeout)
+	for {
+		k.mu.Lock()
+		got, changed := len(k.restarts), k.changed
+		k.mu.Unlock()
+		if got >= n {
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			k.t.Fatalf("got %d restarts, want %d", got, n)
+		}
+	}
+}
+
+// Spawn starts an actor under the kit's supervisor
+func Spawn[M any](k *Kit, name string, newHandler func() actor.Handler[M]) *actor.Ref[M] {
+	k.t.Helper()
+	ref, err := actor.Spawn(k.Sup, name, newHandler, actor.Options{})
+	if err != nil {
+		k.t.Fatalf("Spawn(%s): %v", name, err)
+	}
+	return ref
+}
+
+// Tell delivers msg or fails the test
+func Tell[M any](k *Kit, ref *actor.Ref[M], msg M) {
+	k.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	if err := ref.Tell(ctx, msg); err != nil {
+		k.t.Fatalf("Tell(%s, %v): %v", ref.Name(), msg, err)
+	}
+}
+
+// Ask sends a request and returns the reply, failing the test if none
+// arrives in time
+func Ask[M, R any](k *Kit, ref *actor.Ref[M], mk func(actor.Replier[R]) M) R {
+	k.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	v, err := actor.Ask(ctx, ref, mk)
+	if err != nil {
+		k.t.Fatalf("Ask(%s): %v", ref.Name(), err)
+	}
+	return v
+}
+
+// ExpectReply asks and fails the test unless the reply equals want
+func ExpectReply[M, R any](k *Kit, ref *actor.Ref[M], mk func(actor.Replier[R]) M, want R) {
+	k.t.Helper()
+	if got := Ask(k, ref, mk); !reflect.DeepEqual(got, want) {
+		k.t.Errorf("Ask(%s) = %v, want %v", ref.Name(), got, want)
+	}
+}
+
+// Probe is an actor that records what it receives; pass Ref to the actor
+// under test
+type Probe[T any] struct {
+	Ref *actor.Ref[T]
+	k   *Kit
+	ch  chan T
+}
+
+// NewProbe spawns a probe under the kit's supervisor
+func NewProbe[T any](k *Kit, name string) *Probe[T] {
+	k.t.Helper()
+	p := &Probe[T]{k: k, ch: make(chan T, 64)}
+	p.Ref = Spawn(k, name, func() actor.Handler[T] {
+		return func(ctx context.Context, msg T) error {
+			select {
+			case p.ch <- msg:
+			case <-ctx.Done():
+			}
+			return nil
+		}
+	})
+	return p
+}
+
+// Expect returns the next message, failing the test if none arrives
+func (p *Probe[T]) Expect() T {
+	p.k.t.Helper()
+	select {
+	case v := <-p.ch:
+		return v
+	case <-time.After(Timeout):
+		p.k.t.Fatalf("probe %s received nothing", p.Ref.Name())
+		var zero T
+		return zero
+	}
+}
+
+// ExpectMsg fails the test unless the next message equals want
+func (p *Probe[T]) ExpectMsg(want T) {
+	p.k.t.Helper()
+	if got := p.Expect(); !reflect.DeepEqual(got, want) {
+		p.k.t.Errorf("probe %s received %v, want %v", p.Ref.Name(), got, want)
+	}
+}
+
+// ExpectNone fails the test if a message arrives within d
+func (p *Probe[T]) ExpectNone(d time.Duration) {
+	p.k.t.Helper()
+	select {
+	case v := <-p.ch:
+		p.k.t.Errorf("probe %s received %v, want nothing", p.Ref.Name(), v)
+	case <-time.After(d):
+	}
+}
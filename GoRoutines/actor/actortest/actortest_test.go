@@ -0,0 +1,67 @@
+package actortest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/actor"
+	"github.com/go-concurrency-lesson/safe"
+)
+
+// greeting asks the greeter to greet Name and forward the text to To
+type greeting struct {
+	Name  string
+	To    *actor.Ref[string]
+	reply actor.Replier[int]
+}
+
+// greeter forwards greetings, answers with how many it sent and
+// panics on an empty name
+func greeter() actor.Handler[greeting] {
+	sent := 0
+	return func(ctx context.Context, g greeting) error {
+		if g.Name == "" {
+			panic("no name")
+		}
+		if g.To != nil {
+			if err := g.To.Tell(ctx, "hello "+g.Name); err != nil {
+				return err
+			}
+		}
+		sent++
+		if g.reply != nil {
+			g.reply.Reply(sent)
+		}
+		return nil
+	}
+}
+
+func TestKit(t *testing.T) {
+	k := New(t, actor.SupervisorOptions{})
+	probe := NewProbe[string](k, "probe")
+	ref := Spawn(k, "greeter", greeter)
+
+	Tell(k, ref, greeting{Name: "ann", To: probe.Ref})
+	probe.ExpectMsg("hello ann")
+	ExpectReply(k, ref, func(r actor.Replier[int]) greeting {
+		return greeting{Name: "bob", To: probe.Ref, reply: r}
+	}, 2)
+	if got := probe.Expect(); got != "hello bob" {
+		t.Errorf("probe got %q, want hello bob", got)
+	}
+	probe.ExpectNone(10 * time.Millisecond)
+
+	// A crash is recorded and the actor starts over
+	Tell(k, ref, greeting{})
+	k.WaitRestarts(1)
+	var pe *safe.PanicError
+	if r := k.Restarts(); len(r) != 1 || !errors.As(r[0], &pe) {
+		t.Errorf("Restarts() = %v, want one panic", r)
+	}
+	n := Ask(k, ref, func(r actor.Replier[int]) greeting { return greeting{Name: "cy", reply: r} })
+	if n != 1 {
+		t.Errorf("greetings after restart = %d, want 1", n)
+	}
+}
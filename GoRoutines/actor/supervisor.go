@@ -0,0 +1,346 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+// ErrRestartIntensity is the error of a supervisor that gave up because
+// its children restarted more than MaxRestarts times within Within
+var ErrRestartIntensity = errors.New("actor: restart intensity exceeded")
+
+// Strategy decides which children restart when one of them crashes
+type Strategy int
+
+const (
+	// OneForOne restarts only the crashed child
+	OneForOne Strategy = iota
+	// OneForAll restarts every child
+	OneForAll
+	// RestForOne restarts the crashed child and the children spawned
+	// after it, which usually depend on it
+	RestForOne
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case OneForOne:
+		return "one-for-one"
+	case OneForAll:
+		return "one-for-all"
+	case RestForOne:
+		return "rest-for-one"
+	default:
+		return "unknown"
+	}
+}
+
+// SupervisorOptions configure a supervisor
+type SupervisorOptions struct {
+	// Strategy picks the children to restart [OneForOne]
+	Strategy Strategy
+	// MaxRestarts is how many restarts are allowed within Within before
+	// the supervisor gives up [3]
+	MaxRestarts int
+	// Within is the window for MaxRestarts [5s]
+	Within time.Duration
+	// OnRestart is called with the child that crashed and why, once it
+	// has been restarted. It runs without the supervisor's lock, so it may
+	// spawn or stop actors in the same tree.
+	OnRestart func(child string, err error)
+	// Clock measures Within [real time]
+	Clock clock.Clock
+}
+
+// child is anything a supervisor can run: an actor or a supervisor
+type child interface {
+	childName() string
+	// start runs a fresh instance until ctx is done; failed reports a crash
+	start(ctx context.Context, failed func(error))
+	// stop waits for the instance to exit after its ctx was cancelled
+	stop()
+	// terminate releases the child for good
+	terminate()
+}
+
+type entry struct {
+	c      child
+	gen    uint64 // incremented on every start, to ignore stale crashes
+	cancel context.CancelFunc
+}
+
+type failure struct {
+	e   *entry
+	gen uint64
+	err error
+}
+
+// Supervisor runs actors and child supervisors and restarts them when
+// they crash
+type Supervisor struct {
+	name  string
+	opts  SupervisorOptions
+	clock clock.Clock
+
+	mu         sync.Mutex
+	entries    []*entry
+	running    bool
+	terminated bool
+	ctx        context.Context
+	cancel     context.CancelFunc
+	failures   chan failure
+	loopDone   chan struct{}
+	restarts   []time.Time
+
+	// set for the root supervisor only
+	done     chan struct{}
+	err      error
+	shutdown sync.Once
+}
+
+// NewSupervisor starts a root supervisor. It stops when ctx is cancelled,
+// when Stop is called or when it gives up.
+func NewSupervisor(ctx context.Context, opts SupervisorOptions) *Supervisor {
+	s := newSupervisor("root", opts)
+	s.done = make(chan struct{})
+	s.start(ctx, func(err error) {
+		// Called from the supervisor's own loop, which stop waits for
+		go s.finish(err)
+	})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.finish(nil)
+		case <-s.done:
+		}
+	}()
+	return s
+}
+
+func newSupervisor(name string, opts SupervisorOptions) *Supervisor {
+	if opts.MaxRestarts <= 0 {
+		opts.MaxRestarts = 3
+	}
+	if opts.Within <= 0 {
+		opts.Within = 5 * time.Second
+	}
+	return &Supervisor{name: name, opts: opts, clock: clock.Or(opts.Clock)}
+}
+
+// Child adds a supervisor under s, for a subtree with its own strategy
+func (s *Supervisor) Child(name string, opts SupervisorOptions) (*Supervisor, error) {
+	c := newSupervisor(name, opts)
+	if err := s.add(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Spawn starts an actor under s. newHandler is called on every (re)start,
+// so state it captures is fresh after a crash.
+func Spawn[M any](s *Supervisor, name string, newHandler func() Handler[M], opts Options) (*Ref[M], error) {
+	if opts.Mailbox <= 0 {
+		opts.Mailbox = 64
+	}
+	ref := &Ref[M]{name: name, mailbox: make(chan M, opts.Mailbox), stopped: make(chan struct{})}
+	if err := s.add(&actorChild[M]{ref: ref, newHandler: newHandler}); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// Stop stops every child, in reverse spawn order, and waits for them.
+// Only the root supervisor can be stopped; child supervisors stop with it.
+func (s *Supervisor) Stop() {
+	if s.done == nil {
+		return
+	}
+	s.finish(nil)
+}
+
+// Done is closed when the root supervisor has stopped
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason the root supervisor stopped: nil for Stop or a
+// cancelled context, an ErrRestartIntensity error if it gave up
+func (s *Supervisor) Err() error {
+	if s.done == nil {
+		return nil
+	}
+	<-s.done
+	return s.err
+}
+
+func (s *Supervisor) finish(err error) {
+	s.shutdown.Do(func() {
+		s.stop()
+		s.terminate()
+		s.err = err
+		close(s.done)
+	})
+}
+
+func (s *Supervisor) add(c child) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.terminated {
+		return ErrStopped
+	}
+	e := &entry{c: c}
+	s.entries = append(s.entries, e)
+	if s.running {
+		s.startEntry(e)
+	}
+	return nil
+}
+
+func (s *Supervisor) childName() string {
+	return s.name
+}
+
+// start runs the loop and every child; s.mu must not be held
+func (s *Supervisor) start(ctx context.Context, failed func(error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.failures = make(chan failure)
+	s.loopDone = make(chan struct{})
+	s.restarts = nil
+	s.running = true
+	for _, e := range s.entries {
+		s.startEntry(e)
+	}
+	go s.loop(s.ctx, s.failures, s.loopDone, failed)
+}
+
+// startEntry starts one child under its own context; s.mu is held
+func (s *Supervisor) startEntry(e *entry) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	e.cancel = cancel
+	e.gen++
+	gen, failures := e.gen, s.failures
+	e.c.start(ctx, func(err error) {
+		select {
+		case failures <- failure{e: e, gen: gen, err: err}:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// stopEntries stops entries in reverse order; s.mu is held
+func (s *Supervisor) stopEntries(entries []*entry) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.cancel != nil {
+			e.cancel()
+			e.c.stop()
+		}
+	}
+}
+
+func (s *Supervisor) stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	cancel, loopDone := s.cancel, s.loopDone
+	s.mu.Unlock()
+
+	// The loop may be waiting for s.mu in handle, so wait without it
+	cancel()
+	<-loopDone
+	s.mu.Lock()
+	s.stopEntries(s.entries)
+	s.mu.Unlock()
+}
+
+func (s *Supervisor) terminate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.terminated {
+		return
+	}
+	s.terminated = true
+	for _, e := range s.entries {
+		e.c.terminate()
+	}
+}
+
+func (s *Supervisor) loop(ctx context.Context, failures <-chan failure, done chan struct{}, failed func(error)) {
+	defer close(done)
+	for {
+		select {
+		case f := <-failures:
+			if err := s.handle(ctx, f); err != nil {
+				failed(err)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handle restarts children after a crash and reports it to OnRestart, or
+// returns an error if the restart intensity is exceeded
+func (s *Supervisor) handle(ctx context.Context, f failure) error {
+	restarted, err := s.restart(ctx, f)
+	if restarted && s.opts.OnRestart != nil {
+		s.opts.OnRestart(f.e.c.childName(), f.err)
+	}
+	return err
+}
+
+// restart does the work of handle under s.mu and reports whether it
+// restarted anything
+func (s *Supervisor) restart(ctx context.Context, f failure) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f.gen != f.e.gen || ctx.Err() != nil {
+		return false, nil
+	}
+
+	now := s.clock.Now()
+	recent := s.restarts[:0]
+	for _, t := range s.restarts {
+		if now.Sub(t) < s.opts.Within {
+			recent = append(recent, t)
+		}
+	}
+	s.restarts = append(recent, now)
+	if len(s.restarts) > s.opts.MaxRestarts {
+		s.stopEntries(s.entries)
+		return false, fmt.Errorf("%w: supervisor %s: %d restarts within %v, last: %w",
+			ErrRestartIntensity, s.name, len(s.restarts), s.opts.Within, f.err)
+	}
+
+	idx := 0
+	for i, e := range s.entries {
+		if e == f.e {
+			idx = i
+		}
+	}
+	var restart []*entry
+	switch s.opts.Strategy {
+	case OneForAll:
+		restart = s.entries
+	case RestForOne:
+		restart = s.entries[idx:]
+	default:
+		restart = s.entries[idx : idx+1]
+	}
+	s.stopEntries(restart)
+	for _, e := range restart {
+		s.startEntry(e)
+	}
+	return true, nil
+}
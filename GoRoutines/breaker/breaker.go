@@ -0,0 +1,355 @@
+// Package breaker implements a circuit breaker that stops calling a
+// failing dependency for a while instead of piling retries onto it.
+//
+// A Breaker starts Closed and records the outcome of every call in a
+// rolling time window. When the failure rate or the slow-call rate in the
+// window crosses its threshold the breaker opens and rejects calls with
+// ErrOpen. After OpenTimeout it lets a few trial calls through
+// (HalfOpen): if they all succeed it closes again, otherwise it reopens.
+//
+// Set keeps one breaker per host, and Transport applies them to every
+// request of an http.Client.
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+var (
+	// ErrOpen is returned while the breaker rejects calls
+	ErrOpen = errors.New("breaker: circuit open")
+	// ErrTooManyCalls is returned in the half-open state when every trial
+	// call is already taken
+	ErrTooManyCalls = errors.New("breaker: too many calls in half-open state")
+)
+
+// State is the state of a breaker
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Settings configure a Breaker. Zero fields take the defaults in brackets.
+type Settings struct {
+	// FailureRate opens the breaker when failures/calls in the window
+	// reaches it [0.5]
+	FailureRate float64
+	// SlowCallDuration marks calls that take at least this long as slow;
+	// zero disables slow-call tracking
+	SlowCallDuration time.Duration
+	// SlowCallRate opens the breaker when slow/calls in the window
+	// reaches it [1]
+	SlowCallRate float64
+	// MinCalls is the number of calls in the window before the rates are
+	// checked, so a single early failure cannot open the breaker [10]
+	MinCalls int
+	// Window is the length of the rolling window [10s], split into
+	// Buckets that expire one at a time [10]
+	Window  time.Duration
+	Buckets int
+	// OpenTimeout is how long the breaker stays open before it lets trial
+	// calls through [30s]
+	OpenTimeout time.Duration
+	// HalfOpenCalls is the number of trial calls that must succeed to
+	// close the breaker [1]
+	HalfOpenCalls int
+	// OnStateChange is called after every transition, outside the lock
+	OnStateChange func(name string, from, to State)
+	// Clock is the time source [clock.Real()]
+	Clock clock.Clock
+}
+
+func (s Settings) withDefaults() Settings {
+	if s.FailureRate <= 0 {
+		s.FailureRate = 0.5
+	}
+	if s.SlowCallRate <= 0 {
+		s.SlowCallRate = 1
+	}
+	if s.MinCalls <= 0 {
+		s.MinCalls = 10
+	}
+	if s.Window <= 0 {
+		s.Window = 10 * time.Second
+	}
+	if s.Buckets <= 0 {
+		s.Buckets = 10
+	}
+	if s.OpenTimeout <= 0 {
+		s.OpenTimeout = 30 * time.Second
+	}
+	if s.HalfOpenCalls <= 0 {
+		s.HalfOpenCalls = 1
+	}
+	s.Clock = clock.Or(s.Clock)
+	return s
+}
+
+// Counts are the outcomes recorded in the current window
+type Counts struct {
+	Calls    int
+	Failures int
+	Slow     int
+}
+
+// Breaker is a circuit breaker for one dependency
+type Breaker struct {
+	name string
+	s    Settings
+
+	mu       sync.Mutex
+	state    State
+	gen      uint64 // bumped on every transition to drop stale tokens
+	openedAt time.Time
+	window   window
+	trials   int // half-open calls in flight
+	passed   int // half-open calls that succeeded
+	changes  []change
+}
+
+type change struct{ from, to State }
+
+// New creates a closed breaker. name is passed to OnStateChange.
+func New(name string, s Settings) *Breaker {
+	s = s.withDefaults()
+	return &Breaker{
+		name:   name,
+		s:      s,
+		window: newWindow(s.Window, s.Buckets, s.Clock.Now()),
+	}
+}
+
+// Name returns the name given to New
+func (b *Breaker) Name() string { return b.name }
+
+// State returns the current state
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.unlock()
+	return b.current(b.s.Clock.Now())
+}
+
+// Counts returns the outcomes recorded in the current window
+func (b *Breaker) Counts() Counts {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.window.advance(b.s.Clock.Now())
+	return b.window.totals()
+}
+
+// Allow asks to make a call. It returns ErrOpen or ErrTooManyCalls if the
+// call must not be made; otherwise the returned token must be released
+// with exactly one of Success, Failure or Ignore.
+func (b *Breaker) Allow() (*Token, error) {
+	b.mu.Lock()
+	defer b.unlock()
+
+	now := b.s.Clock.Now()
+	switch b.current(now) {
+	case Open:
+		return nil, ErrOpen
+	case HalfOpen:
+		if b.trials >= b.s.HalfOpenCalls-b.passed {
+			return nil, ErrTooManyCalls
+		}
+		b.trials++
+	}
+	return &Token{b: b, gen: b.gen, start: now}, nil
+}
+
+// Execute runs fn if the breaker allows it and records its error as a
+// failure
+func (b *Breaker) Execute(fn func() error) error {
+	tok, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		tok.Failure()
+		return err
+	}
+	tok.Success()
+	return nil
+}
+
+// current returns the state at now, moving an expired Open to HalfOpen
+func (b *Breaker) current(now time.Time) State {
+	if b.state == Open && now.Sub(b.openedAt) >= b.s.OpenTimeout {
+		b.setState(HalfOpen, now)
+	}
+	return b.state
+}
+
+func (b *Breaker) setState(to State, now time.Time) {
+	if b.state == to {
+		return
+	}
+	b.changes = append(b.changes, change{b.state, to})
+	b.state = to
+	b.gen++
+	b.trials, b.passed = 0, 0
+	switch to {
+	case Open:
+		b.openedAt = now
+	case Closed:
+		b.window = newWindow(b.s.Window, b.s.Buckets, now)
+	}
+}
+
+// unlock releases mu and then reports the transitions made while holding
+// it, so callbacks may use the breaker
+func (b *Breaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+	if b.s.OnStateChange == nil {
+		return
+	}
+	for _, c := range changes {
+		b.s.OnStateChange(b.name, c.from, c.to)
+	}
+}
+
+func (b *Breaker) record(t *Token, failed, ignored bool) {
+	b.mu.Lock()
+	defer b.unlock()
+
+	now := b.s.Clock.Now()
+	state := b.current(now)
+	if t.gen != b.gen {
+		// The breaker changed state while the call ran
+		return
+	}
+	slow := b.s.SlowCallDuration > 0 && now.Sub(t.start) >= b.s.SlowCallDuration
+
+	switch state {
+	case HalfOpen:
+		b.trials--
+		switch {
+		case ignored:
+		case failed || slow:
+			b.setState(Open, now)
+		default:
+			b.passed++
+			if b.passed >= b.s.HalfOpenCalls {
+				b.setState(Closed, now)
+			}
+		}
+	case Closed:
+		if ignored {
+			return
+		}
+		b.window.add(now, failed, slow)
+		c := b.window.totals()
+		if c.Calls < b.s.MinCalls {
+			return
+		}
+		if float64(c.Failures) >= b.s.FailureRate*float64(c.Calls) ||
+			(b.s.SlowCallDuration > 0 && float64(c.Slow) >= b.s.SlowCallRate*float64(c.Calls)) {
+			b.setState(Open, now)
+		}
+	}
+}
+
+// Token is a permission to make one call
+type Token struct {
+	b     *Breaker
+	gen   uint64
+	start time.Time
+	once  sync.Once
+}
+
+// Success records a successful call. It still counts as slow if it took
+// longer than SlowCallDuration.
+func (t *Token) Success() {
+	t.once.Do(func() { t.b.record(t, false, false) })
+}
+
+// Failure records a failed call
+func (t *Token) Failure() {
+	t.once.Do(func() { t.b.record(t, true, false) })
+}
+
+// Ignore releases the token without recording an outcome, for errors that
+// say nothing about the dependency's health (cancelled by the caller)
+func (t *Token) Ignore() {
+	t.once.Do(func() { t.b.record(t, false, true) })
+}
+
+// window counts outcomes in a ring of time buckets
+type window struct {
+	buckets []Counts
+	width   time.Duration
+	head    int
+	start   time.Time // start of the head bucket
+}
+
+func newWindow(length time.Duration, buckets int, now time.Time) window {
+	width := length / time.Duration(buckets)
+	if width <= 0 {
+		width = 1
+	}
+	return window{buckets: make([]Counts, buckets), width: width, start: now}
+}
+
+// advance moves the head to the bucket containing now, clearing the
+// buckets that fell out of the window
+func (w *window) advance(now time.Time) {
+	steps := int(now.Sub(w.start) / w.width)
+	if steps <= 0 {
+		return
+	}
+	if steps >= len(w.buckets) {
+		clear(w.buckets)
+		w.head = 0
+		w.start = now
+		return
+	}
+	for i := 0; i < steps; i++ {
+		w.head = (w.head + 1) % len(w.buckets)
+		w.buckets[w.head] = Counts{}
+	}
+	w.start = w.start.Add(time.Duration(steps) * w.width)
+}
+
+func (w *window) add(now time.Time, failed, slow bool) {
+	w.advance(now)
+	c := &w.buckets[w.head]
+	c.Calls++
+	if failed {
+		c.Failures++
+	}
+	if slow {
+		c.Slow++
+	}
+}
+
+func (w *window) totals() Counts {
+	var t Counts
+	for _, c := range w.buckets {
+		t.Calls += c.Calls
+		t.Failures += c.Failures
+		t.Slow += c.Slow
+	}
+	return t
+}
@@ -0,0 +1,288 @@
+package breaker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+var errBoom = errors.New("boom")
+
+func newTestBreaker(s Settings) (*Breaker, *clock.Fake, *[]string) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var mu sync.Mutex
+	var changes []string
+	s.Clock = clk
+	s.OnStateChange = func(name string, from, to State) {
+		mu.Lock()
+		changes = append(changes, from.String()+"->"+to.String())
+		mu.Unlock()
+	}
+	return New("test", s), clk, &changes
+}
+
+func call(b *Breaker, fail bool) error {
+	return b.Execute(func() error {
+		if fail {
+			return errBoom
+		}
+		return nil
+	})
+}
+
+func TestBreakerOpensOnFailureRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcomes []bool // true = failure
+		want     State
+	}{
+		{"below min calls", []bool{true, true, true}, Closed},
+		{"below failure rate", []bool{false, false, false, true}, Closed},
+		{"at failure rate", []bool{false, true, false, true}, Open},
+		{"all failures", []bool{true, true, true, true}, Open},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _, _ := newTestBreaker(Settings{MinCalls: 4})
+			for _, fail := range tt.outcomes {
+				call(b, fail)
+			}
+			if got := b.State(); got != tt.want {
+				t.Errorf("State() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerLifecycle(t *testing.T) {
+	b, clk, changes := newTestBreaker(Settings{MinCalls: 2, OpenTimeout: time.Minute, HalfOpenCalls: 2})
+
+	call(b, true)
+	call(b, true)
+	if err := call(b, false); !errors.Is(err, ErrOpen) {
+		t.Fatalf("Execute() on open breaker = %v, want ErrOpen", err)
+	}
+
+	clk.Advance(59 * time.Second)
+	if b.State() != Open {
+		t.Fatalf("State() before OpenTimeout = %v, want open", b.State())
+	}
+	clk.Advance(time.Second)
+	if b.State() != HalfOpen {
+		t.Fatalf("State() after OpenTimeout = %v, want half-open", b.State())
+	}
+
+	// A failed trial reopens the breaker
+	call(b, true)
+	if b.State() != Open {
+		t.Fatalf("State() after failed trial = %v, want open", b.State())
+	}
+
+	// Two successful trials close it
+	clk.Advance(time.Minute)
+	call(b, false)
+	if b.State() != HalfOpen {
+		t.Fatalf("State() after one of two trials = %v, want half-open", b.State())
+	}
+	call(b, false)
+	if b.State() != Closed {
+		t.Fatalf("State() after successful trials = %v, want closed", b.State())
+	}
+	if c := b.Counts(); c != (Counts{}) {
+		t.Errorf("Counts() after closing = %+v, want empty window", c)
+	}
+
+	want := []string{
+		"closed->open", "open->half-open", "half-open->open",
+		"open->half-open", "half-open->closed",
+	}
+	if len(*changes) != len(want) {
+		t.Fatalf("state changes = %v, want %v", *changes, want)
+	}
+	for i := range want {
+		if (*changes)[i] != want[i] {
+			t.Errorf("state change %d = %s, want %s", i, (*changes)[i], want[i])
+		}
+	}
+}
+
+func TestBreakerHalfOpenLimitsTrials(t *testing.T) {
+	b, clk, _ := newTestBreaker(Settings{MinCalls: 1, OpenTimeout: time.Second})
+	call(b, true)
+	clk.Advance(time.Second)
+
+	tok, err := b.Allow()
+	if err != nil {
+		t.Fatalf("Allow() trial call error = %v", err)
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrTooManyCalls) {
+		t.Errorf("Allow() second trial = %v, want ErrTooManyCalls", err)
+	}
+
+	// An ignored trial frees its slot without deciding anything
+	tok.Ignore()
+	if b.State() != HalfOpen {
+		t.Errorf("State() after ignored trial = %v, want half-open", b.State())
+	}
+	tok, err = b.Allow()
+	if err != nil {
+		t.Fatalf("Allow() after ignored trial error = %v", err)
+	}
+	tok.Success()
+	if b.State() != Closed {
+		t.Errorf("State() after successful trial = %v, want closed", b.State())
+	}
+}
+
+func TestBreakerSlowCalls(t *testing.T) {
+	b, clk, _ := newTestBreaker(Settings{
+		MinCalls:         3,
+		SlowCallDuration: time.Second,
+		SlowCallRate:     0.6,
+	})
+
+	slow := func() {
+		tok, err := b.Allow()
+		if err != nil {
+			t.Fatalf("Allow() error = %v", err)
+		}
+		clk.Advance(2 * time.Second)
+		tok.Success()
+	}
+
+	slow()
+	call(b, false)
+	if b.State() != Closed {
+		t.Fatalf("State() below min calls = %v, want closed", b.State())
+	}
+	slow()
+	if b.State() != Open {
+		t.Errorf("State() with 2 of 3 calls slow = %v, want open", b.State())
+	}
+	if c := b.Counts(); c.Failures != 0 || c.Slow != 2 {
+		t.Errorf("Counts() = %+v, want 2 slow and no failures", c)
+	}
+}
+
+func TestBreakerWindowRolls(t *testing.T) {
+	b, clk, _ := newTestBreaker(Settings{MinCalls: 4, Window: 10 * time.Second, Buckets: 10})
+
+	call(b, true)
+	call(b, true)
+	clk.Advance(6 * time.Second)
+	call(b, false)
+	if c := b.Counts(); c.Calls != 3 {
+		t.Fatalf("Counts().Calls = %d, want 3", c.Calls)
+	}
+
+	// The two early failures fall out of the window
+	clk.Advance(5 * time.Second)
+	if c := b.Counts(); c.Calls != 1 || c.Failures != 0 {
+		t.Fatalf("Counts() after 11s = %+v, want 1 call and no failures", c)
+	}
+	call(b, false)
+	call(b, false)
+	call(b, true)
+	if b.State() != Closed {
+		t.Errorf("State() = %v, want closed: 1 of 4 calls failed in the window", b.State())
+	}
+
+	clk.Advance(time.Minute)
+	if c := b.Counts(); c != (Counts{}) {
+		t.Errorf("Counts() after a long pause = %+v, want empty", c)
+	}
+}
+
+func TestBreakerStaleToken(t *testing.T) {
+	b, clk, _ := newTestBreaker(Settings{MinCalls: 1, OpenTimeout: time.Second})
+
+	late, _ := b.Allow()
+	call(b, true)
+	clk.Advance(time.Second)
+	if b.State() != HalfOpen {
+		t.Fatalf("State() = %v, want half-open", b.State())
+	}
+
+	// A call started before the breaker opened must not close it
+	late.Success()
+	if b.State() != HalfOpen {
+		t.Errorf("State() after stale success = %v, want half-open", b.State())
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var downCalls, upCalls atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downCalls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upCalls.Add(1)
+	}))
+	defer up.Close()
+
+	clk := clock.NewFake(time.Now())
+	set := NewSet(Settings{MinCalls: 3, OpenTimeout: time.Minute, Clock: clk})
+	client := NewTransport(nil, set).Client()
+
+	for i := 0; i < 10; i++ {
+		if resp, err := client.Get(down.URL); err == nil {
+			resp.Body.Close()
+		} else {
+			var oe *OpenError
+			if !errors.As(err, &oe) || !errors.Is(err, ErrOpen) {
+				t.Fatalf("Get() error = %v, want OpenError wrapping ErrOpen", err)
+			}
+		}
+		resp, err := client.Get(up.URL)
+		if err != nil {
+			t.Fatalf("Get() on healthy host error = %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if got := downCalls.Load(); got != 3 {
+		t.Errorf("failing host saw %d requests, want 3", got)
+	}
+	if got := upCalls.Load(); got != 10 {
+		t.Errorf("healthy host saw %d requests, want 10", got)
+	}
+	states := set.States()
+	if states[hostOf(down.URL)] != Open || states[hostOf(up.URL)] != Closed {
+		t.Errorf("States() = %v, want failing host open and healthy host closed", states)
+	}
+
+	// After the timeout one trial request goes through
+	clk.Advance(time.Minute)
+	if resp, err := client.Get(down.URL); err == nil {
+		resp.Body.Close()
+	}
+	if got := downCalls.Load(); got != 4 {
+		t.Errorf("failing host saw %d requests after OpenTimeout, want 4", got)
+	}
+	if set.Get(hostOf(down.URL)).State() != Open {
+		t.Error("failed trial request did not reopen the breaker")
+	}
+}
+
+func hostOf(rawURL string) string {
+	req, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+	return req.URL.Host
+}
+
+func BenchmarkBreakerExecute(b *testing.B) {
+	br := New("bench", Settings{})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			br.Execute(func() error { return nil })
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package breaker
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Set holds one breaker per host, all created with the same settings
+type Set struct {
+	s Settings
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewSet creates an empty set
+func NewSet(s Settings) *Set {
+	return &Set{s: s, breakers: make(map[string]*Breaker)}
+}
+
+// Get returns the breaker for host, creating it on first use
+func (s *Set) Get(host string) *Breaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.breakers[host]
+	if !ok {
+		b = New(host, s.s)
+		s.breakers[host] = b
+	}
+	return b
+}
+
+// States returns the state of every breaker, keyed by host
+func (s *Set) States() map[string]State {
+	s.mu.Lock()
+	breakers := make([]*Breaker, 0, len(s.breakers))
+	for _, b := range s.breakers {
+		breakers = append(breakers, b)
+	}
+	s.mu.Unlock()
+
+	states := make(map[string]State, len(breakers))
+	for _, b := range breakers {
+		states[b.Name()] = b.State()
+	}
+	return states
+}
+
+// OpenError is returned by Transport for a request rejected by its host's
+// breaker. It wraps ErrOpen or ErrTooManyCalls.
+type OpenError struct {
+	Host string
+	Err  error
+}
+
+func (e *OpenError) Error() string { return fmt.Sprintf("%s: %v", e.Host, e.Err) }
+func (e *OpenError) Unwrap() error { return e.Err }
+
+// Transport is an http.RoundTripper that guards every host with its own
+// breaker. Transport errors, 429 and 5xx responses count as failures;
+// requests cancelled by the caller are not counted.
+type Transport struct {
+	// Base performs the requests; nil means http.DefaultTransport
+	Base http.RoundTripper
+	// Breakers holds the per-host breakers
+	Breakers *Set
+}
+
+// NewTransport creates a transport guarding base with set
+func NewTransport(base http.RoundTripper, set *Set) *Transport {
+	return &Transport{Base: base, Breakers: set}
+}
+
+// Client returns an http.Client that uses t
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	tok, err := t.Breakers.Get(host).Allow()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, &OpenError{Host: host, Err: err}
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		tok.Ignore()
+	case err != nil:
+		tok.Failure()
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		tok.Failure()
+	default:
+		tok.Success()
+	}
+	return resp, err
+}
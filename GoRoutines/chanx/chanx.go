@@ -0,0 +1,130 @@
+// Package chanx collects the channel helpers from the channels/ lessons
+// that every pipeline ends up rewriting: OrDone, Tee, Bridge, Merge, Or,
+// a dynamically growing Mux and context-aware Send and Recv.
+//
+// Every helper that starts goroutines stops them when its context is
+// cancelled or its inputs are closed, and closes the channels it owns.
+package chanx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// ErrClosed is returned by Recv when the channel is closed
+var ErrClosed = errors.New("chanx: channel closed")
+
+// Send sends v on ch unless ctx is done first
+func Send[T any](ctx context.Context, ch chan<- T, v T) error {
+	select {
+	case ch <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Recv receives from ch unless ctx is done first. It returns ErrClosed
+// once ch is closed and drained.
+func Recv[T any](ctx context.Context, ch <-chan T) (T, error) {
+	select {
+	case v, ok := <-ch:
+		if !ok {
+			return v, ErrClosed
+		}
+		return v, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// OrDone forwards values from in until in is closed or ctx is done, so a
+// consumer can range over the result without its own select
+func OrDone[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			v, err := Recv(ctx, in)
+			if err != nil || Send(ctx, out, v) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Bridge flattens a channel of channels into one channel, reading each
+// inner channel to the end before moving on to the next
+func Bridge[T any](ctx context.Context, chans <-chan (<-chan T)) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			in, err := Recv(ctx, chans)
+			if err != nil {
+				return
+			}
+			for v := range OrDone(ctx, in) {
+				if Send(ctx, out, v) != nil {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Merge forwards the values of every input channel to one output channel,
+// which is closed when all inputs are closed or ctx is done
+func Merge[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	wg.Add(len(ins))
+	for _, in := range ins {
+		go func(in <-chan T) {
+			defer wg.Done()
+			for {
+				v, err := Recv(ctx, in)
+				if err != nil || Send(ctx, out, v) != nil {
+					return
+				}
+			}
+		}(in)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// Or returns a channel that is closed as soon as any of chans is closed.
+// With no channels it returns nil, which never becomes ready. One
+// goroutine waits on all of chans at once until then.
+func Or(chans ...<-chan struct{}) <-chan struct{} {
+	switch len(chans) {
+	case 0:
+		return nil
+	case 1:
+		return chans[0]
+	}
+	done := make(chan struct{})
+	cases := make([]reflect.SelectCase, len(chans))
+	for i, ch := range chans {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)}
+	}
+	go func() {
+		defer close(done)
+		// A value sent instead of a close is ignored
+		for {
+			if _, _, ok := reflect.Select(cases); !ok {
+				return
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,308 @@
+package chanx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/internal/leaktest"
+)
+
+// drainWithin drains ch or fails if it is not closed within a second
+func drainWithin[T any](t *testing.T, ch <-chan T) []T {
+	t.Helper()
+	done := make(chan []T)
+	go func() { done <- leaktest.Drain(ch) }()
+	select {
+	case out := <-done:
+		return out
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed")
+		return nil
+	}
+}
+
+func TestSendRecv(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan int, 1)
+	if err := Send(ctx, ch, 1); err != nil {
+		t.Errorf("Send() = %v, want nil", err)
+	}
+	if v, err := Recv(ctx, ch); v != 1 || err != nil {
+		t.Errorf("Recv() = %d, %v, want 1, nil", v, err)
+	}
+
+	cancel()
+	if err := Send(ctx, make(chan int), 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Send() after cancel = %v, want context.Canceled", err)
+	}
+	if _, err := Recv(ctx, make(chan int)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Recv() after cancel = %v, want context.Canceled", err)
+	}
+	close(ch)
+	if _, err := Recv(context.Background(), ch); !errors.Is(err, ErrClosed) {
+		t.Errorf("Recv() on closed channel = %v, want ErrClosed", err)
+	}
+}
+
+func TestOrDone(t *testing.T) {
+	leaktest.Check(t)
+	if got := leaktest.Drain(OrDone(context.Background(), leaktest.Source(1, 2, 3))); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("OrDone() = %v, want [1 2 3]", got)
+	}
+
+	// An input that never closes is abandoned on cancel
+	ctx, cancel := context.WithCancel(context.Background())
+	out := OrDone(ctx, make(chan int))
+	cancel()
+	drainWithin(t, out)
+}
+
+func TestBridge(t *testing.T) {
+	leaktest.Check(t)
+	chans := make(chan (<-chan int), 3)
+	chans <- leaktest.Source(1, 2)
+	chans <- leaktest.Source[int]()
+	chans <- leaktest.Source(3)
+	close(chans)
+	if got := leaktest.Drain(Bridge(context.Background(), chans)); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("Bridge() = %v, want [1 2 3]", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stuck := make(chan (<-chan int), 1)
+	stuck <- make(chan int)
+	out := Bridge(ctx, stuck)
+	cancel()
+	drainWithin(t, out)
+}
+
+func TestMerge(t *testing.T) {
+	leaktest.Check(t)
+	got := leaktest.Drain(Merge(context.Background(), leaktest.Source(1, 4), leaktest.Source(2), leaktest.Source[int](), leaktest.Source(3, 5)))
+	sort.Ints(got)
+	if !reflect.DeepEqual(got, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("Merge() = %v, want [1 2 3 4 5]", got)
+	}
+	if got := leaktest.Drain(Merge[int](context.Background())); len(got) != 0 {
+		t.Errorf("Merge() of nothing = %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := Merge(ctx, leaktest.Source(1, 2, 3), make(chan int))
+	<-out
+	cancel()
+	drainWithin(t, out)
+}
+
+func TestOr(t *testing.T) {
+	leaktest.Check(t)
+	if Or() != nil {
+		t.Error("Or() of nothing is not nil")
+	}
+	single := make(chan struct{})
+	if Or(single) != single {
+		t.Error("Or() of one channel does not return it")
+	}
+
+	chans := make([]chan struct{}, 5)
+	ins := make([]<-chan struct{}, 5)
+	for i := range chans {
+		chans[i] = make(chan struct{})
+		ins[i] = chans[i]
+	}
+	done := Or(ins...)
+	select {
+	case <-done:
+		t.Fatal("Or() closed before any input")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(chans[3])
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Or() not closed after an input closed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	<-Or(ctx.Done(), make(chan struct{}))
+}
+
+func TestTeeBlock(t *testing.T) {
+	leaktest.Check(t)
+	outs := Tee(context.Background(), leaktest.Source(1, 2, 3), 3, TeeOptions[int]{})
+	var wg sync.WaitGroup
+	got := make([][]int, len(outs))
+	for i, out := range outs {
+		wg.Add(1)
+		go func(i int, out <-chan int) {
+			defer wg.Done()
+			got[i] = leaktest.Drain(out)
+		}(i, out)
+	}
+	wg.Wait()
+	for i, g := range got {
+		if !reflect.DeepEqual(g, []int{1, 2, 3}) {
+			t.Errorf("Tee() output %d = %v, want [1 2 3]", i, g)
+		}
+	}
+}
+
+func TestTeeBlockFastConsumerFirst(t *testing.T) {
+	leaktest.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	outs := Tee(ctx, leaktest.Source(1), 2, TeeOptions[int]{})
+	// Output 0 is never read, but output 1 still gets the value
+	select {
+	case v := <-outs[1]:
+		if v != 1 {
+			t.Errorf("Tee() output 1 = %d, want 1", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Tee() held output 1 back behind output 0")
+	}
+	cancel()
+	drainWithin(t, outs[0])
+	drainWithin(t, outs[1])
+}
+
+func TestTeeDrop(t *testing.T) {
+	tests := []struct {
+		policy   Policy
+		wantSlow []int
+		wantLost []int
+	}{
+		{DropNewest, []int{1, 2}, []int{3, 4, 5}},
+		{DropOldest, []int{4, 5}, []int{1, 2, 3}},
+		{Disconnect, []int{1, 2}, []int{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			leaktest.Check(t)
+			in := make(chan int)
+			var lost []int
+			outs := Tee(context.Background(), in, 2, TeeOptions[int]{
+				Buffer: 2,
+				Policy: tt.policy,
+				OnDrop: func(output int, v int) {
+					if output != 0 {
+						t.Errorf("output %d dropped %d, only output 0 is slow", output, v)
+					}
+					lost = append(lost, v)
+				},
+			})
+
+			// Output 1 keeps up; output 0 is not read until the end
+			var fast []int
+			for v := 1; v <= 5; v++ {
+				in <- v
+				fast = append(fast, <-outs[1])
+			}
+			close(in)
+			fast = append(fast, leaktest.Drain(outs[1])...)
+			slow := leaktest.Drain(outs[0])
+
+			if !reflect.DeepEqual(fast, []int{1, 2, 3, 4, 5}) {
+				t.Errorf("fast output = %v, want all values", fast)
+			}
+			if !reflect.DeepEqual(slow, tt.wantSlow) || !reflect.DeepEqual(lost, tt.wantLost) {
+				t.Errorf("slow output = %v, lost %v, want %v, lost %v", slow, lost, tt.wantSlow, tt.wantLost)
+			}
+		})
+	}
+}
+
+func TestTeeCancel(t *testing.T) {
+	leaktest.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	outs := Tee(ctx, make(chan int), 2, TeeOptions[int]{})
+	cancel()
+	for _, out := range outs {
+		drainWithin(t, out)
+	}
+}
+
+func TestMux(t *testing.T) {
+	leaktest.Check(t)
+	m := NewMux[int](context.Background())
+	a := make(chan int)
+	if !m.Add(a) || !m.Add(leaktest.Source(10, 11)) {
+		t.Fatal("Add() to an open Mux returned false")
+	}
+
+	got := []int{<-m.Out(), <-m.Out()}
+	go func() {
+		a <- 1
+		// Added while the Mux is running
+		m.Add(leaktest.Source(20))
+		close(a)
+		m.Close()
+	}()
+	got = append(got, drainWithin(t, m.Out())...)
+	sort.Ints(got)
+	if !reflect.DeepEqual(got, []int{1, 10, 11, 20}) {
+		t.Errorf("Mux output = %v, want [1 10 11 20]", got)
+	}
+	if m.Add(leaktest.Source(30)) {
+		t.Error("Add() after Close returned true")
+	}
+	m.Close()
+}
+
+func TestMuxCloseWaitsForInputs(t *testing.T) {
+	leaktest.Check(t)
+	m := NewMux[int](context.Background())
+	in := make(chan int)
+	m.Add(in)
+	m.Close()
+	select {
+	case <-m.Out():
+		t.Fatal("Out closed while an input is still open")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(in)
+	drainWithin(t, m.Out())
+}
+
+func TestMuxCancel(t *testing.T) {
+	leaktest.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewMux[error](ctx)
+	errc := make(chan error, 1)
+	errc <- nil // a nil interface value passes through
+	m.Add(errc)
+	if err := <-m.Out(); err != nil {
+		t.Errorf("Mux output = %v, want nil", err)
+	}
+	m.Add(make(chan error))
+	cancel()
+	drainWithin(t, m.Out())
+	if m.Add(make(chan error)) {
+		t.Error("Add() after cancel returned true")
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	ctx := context.Background()
+	items := make([]int, 100)
+	b.Run("goroutines", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			leaktest.Drain(Merge(ctx, leaktest.Source(items...), leaktest.Source(items...), leaktest.Source(items...), leaktest.Source(items...)))
+		}
+	})
+	b.Run("mux", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m := NewMux[int](ctx)
+			for j := 0; j < 4; j++ {
+				m.Add(leaktest.Source(items...))
+			}
+			m.Close()
+			leaktest.Drain(m.Out())
+		}
+	})
+}
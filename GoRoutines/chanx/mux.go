@@ -0,0 +1,97 @@
+package chanx
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// Mux merges a set of channels that can grow while it runs, using a
+// single goroutine and reflect.Select instead of one goroutine per input.
+// Channels are added with Add; after Close no more can be added and Out
+// is closed once every added channel is closed. Cancelling the context
+// closes Out at once.
+type Mux[T any] struct {
+	ctx       context.Context
+	add       chan (<-chan T)
+	closing   chan struct{}
+	closeOnce sync.Once
+	out       chan T
+}
+
+// NewMux starts an empty Mux
+func NewMux[T any](ctx context.Context) *Mux[T] {
+	m := &Mux[T]{
+		ctx:     ctx,
+		add:     make(chan (<-chan T)),
+		closing: make(chan struct{}),
+		out:     make(chan T),
+	}
+	go m.run()
+	return m
+}
+
+// Out returns the merged channel
+func (m *Mux[T]) Out() <-chan T {
+	return m.out
+}
+
+// Add starts merging ch. It returns false if the Mux is closed or its
+// context is done.
+func (m *Mux[T]) Add(ch <-chan T) bool {
+	select {
+	case m.add <- ch:
+		return true
+	case <-m.closing:
+		return false
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+// Close stops accepting channels. It is safe to call more than once.
+func (m *Mux[T]) Close() {
+	m.closeOnce.Do(func() { close(m.closing) })
+}
+
+// Fixed select cases in front of the inputs
+const (
+	caseDone = iota
+	caseAdd
+	caseClosing
+	numFixed
+)
+
+func (m *Mux[T]) run() {
+	defer close(m.out)
+	cases := make([]reflect.SelectCase, numFixed)
+	cases[caseDone] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.ctx.Done())}
+	cases[caseAdd] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.add)}
+	cases[caseClosing] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.closing)}
+	closing := false
+
+	for !closing || len(cases) > numFixed {
+		chosen, v, ok := reflect.Select(cases)
+		switch chosen {
+		case caseDone:
+			return
+		case caseAdd:
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: v})
+		case caseClosing:
+			// Stop receiving adds; Add sees closing and gives up
+			closing = true
+			cases[caseAdd].Chan = reflect.Value{}
+			cases[caseClosing].Chan = reflect.Value{}
+		default:
+			if !ok {
+				cases = append(cases[:chosen], cases[chosen+1:]...)
+				continue
+			}
+			var x T
+			reflect.ValueOf(&x).Elem().Set(v)
+			if Send(m.ctx, m.out, x) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package chanx
+
+import (
+	"context"
+	"reflect"
+)
+
+// Policy decides what Tee does when an output is not ready for a value.
+// pubsub applies the same policies to a subscriber whose buffer is full.
+type Policy int
+
+const (
+	// Block waits until every output has taken the value, so the slowest
+	// consumer sets the pace
+	Block Policy = iota
+	// DropNewest skips an output whose buffer is full
+	DropNewest
+	// DropOldest discards the oldest buffered value of a full output to
+	// make room, so a slow consumer sees the most recent values
+	DropOldest
+	// Disconnect closes a full output, which gets no more values
+	Disconnect
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Disconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// TeeOptions configure Tee
+type TeeOptions[T any] struct {
+	// Buffer is the capacity of every output [0, or 1 for drop policies]
+	Buffer int
+	// Policy handles outputs that are not ready [Block]
+	Policy Policy
+	// OnDrop is called with the index of the output and the value it lost
+	OnDrop func(output int, v T)
+}
+
+// Tee copies every value from in to n output channels. The outputs are
+// closed when in is closed or ctx is done.
+func Tee[T any](ctx context.Context, in <-chan T, n int, opts TeeOptions[T]) []<-chan T {
+	if opts.Policy != Block {
+		opts.Buffer = max(opts.Buffer, 1)
+	}
+	chans := make([]chan T, n)
+	outs := make([]<-chan T, n)
+	for i := range chans {
+		chans[i] = make(chan T, opts.Buffer)
+		outs[i] = chans[i]
+	}
+
+	go func() {
+		defer func() {
+			for _, ch := range chans {
+				if ch != nil {
+					close(ch)
+				}
+			}
+		}()
+		for {
+			v, err := Recv(ctx, in)
+			if err != nil {
+				return
+			}
+			if opts.Policy == Block {
+				if !sendAll(ctx, chans, v) {
+					return
+				}
+				continue
+			}
+			for i, ch := range chans {
+				if ch == nil {
+					continue
+				}
+				kept := offer(ch, v, opts.Policy, func(lost T) {
+					if opts.OnDrop != nil {
+						opts.OnDrop(i, lost)
+					}
+				})
+				if !kept {
+					close(ch)
+					chans[i] = nil
+				}
+			}
+		}
+	}()
+	return outs
+}
+
+// sendAll sends v to every channel in whatever order they become ready,
+// so a fast consumer is not held up behind a slow one
+func sendAll[T any](ctx context.Context, chans []chan T, v T) bool {
+	cases := make([]reflect.SelectCase, len(chans)+1)
+	cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+	val := reflect.ValueOf(&v).Elem()
+	for i, ch := range chans {
+		cases[i+1] = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ch), Send: val}
+	}
+	for left := len(chans); left > 0; left-- {
+		chosen, _, _ := reflect.Select(cases)
+		if chosen == 0 {
+			return false
+		}
+		// A zero Chan disables the case
+		cases[chosen].Chan = reflect.Value{}
+	}
+	return true
+}
+
+// offer puts v into ch without blocking, applying policy if ch is full.
+// It returns false if ch is to be disconnected.
+func offer[T any](ch chan T, v T, policy Policy, drop func(T)) bool {
+	select {
+	case ch <- v:
+		return true
+	default:
+	}
+	if policy == DropOldest {
+		select {
+		case old := <-ch:
+			drop(old)
+		default:
+		}
+		// Only this goroutine sends on ch, so there is room now
+		select {
+		case ch <- v:
+			return true
+		default:
+		}
+	}
+	drop(v)
+	return policy != Disconnect
+}
@@ -0,0 +1,150 @@
+package clock
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeTimersFireInOrder(t *testing.T) {
+	f := NewFake(epoch)
+	var mu sync.Mutex
+	var order []int
+	for _, d := range []int{3, 1, 2} {
+		d := d
+		f.AfterFunc(time.Duration(d)*time.Second, func() {
+			mu.Lock()
+			order = append(order, d)
+			mu.Unlock()
+		})
+	}
+
+	f.Advance(1500 * time.Millisecond)
+	if len(order) != 1 {
+		t.Fatalf("after 1.5s fired %v, want [1]", order)
+	}
+	f.Advance(2 * time.Second)
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Errorf("fired %v, want [1 2 3]", order)
+	}
+	if got := f.Since(epoch); got != 3500*time.Millisecond {
+		t.Errorf("Since(start) = %v, want 3.5s", got)
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	f := NewFake(epoch)
+	timer := f.NewTimer(time.Second)
+
+	f.Advance(999 * time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	f.Advance(time.Millisecond)
+	select {
+	case got := <-timer.C():
+		if !got.Equal(epoch.Add(time.Second)) {
+			t.Errorf("timer fired at %v, want %v", got, epoch.Add(time.Second))
+		}
+	default:
+		t.Fatal("timer did not fire at its deadline")
+	}
+
+	if timer.Stop() {
+		t.Error("Stop() on fired timer = true")
+	}
+	if timer.Reset(time.Second) {
+		t.Error("Reset() on fired timer = true")
+	}
+	if !timer.Stop() {
+		t.Error("Stop() on pending timer = false")
+	}
+	f.Advance(time.Hour)
+	select {
+	case <-timer.C():
+		t.Error("stopped timer fired")
+	default:
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(epoch)
+	ticker := f.NewTicker(time.Second)
+
+	for i := 1; i <= 3; i++ {
+		f.Advance(time.Second)
+		select {
+		case got := <-ticker.C():
+			if want := epoch.Add(time.Duration(i) * time.Second); !got.Equal(want) {
+				t.Errorf("tick %d at %v, want %v", i, got, want)
+			}
+		default:
+			t.Fatalf("tick %d missing", i)
+		}
+	}
+
+	// Ticks are dropped, not queued, when nobody reads them
+	f.Advance(5 * time.Second)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Error("ticker queued more than one tick")
+	default:
+	}
+
+	ticker.Reset(10 * time.Second)
+	f.Advance(5 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Error("ticker fired before reset interval")
+	default:
+	}
+	ticker.Stop()
+	if f.Waiters() != 0 {
+		t.Errorf("Waiters() = %d after Stop, want 0", f.Waiters())
+	}
+}
+
+func TestFakeSleepAndBlockUntil(t *testing.T) {
+	f := NewFake(epoch)
+	done := make(chan struct{})
+	go func() {
+		f.Sleep(time.Minute)
+		close(done)
+	}()
+
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Sleep() did not return after Advance")
+	}
+}
+
+func TestFakeSetBackwardsPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Set() to an earlier time did not panic")
+		}
+	}()
+	NewFake(epoch).Set(epoch.Add(-time.Second))
+}
+
+func TestReal(t *testing.T) {
+	c := Or(nil)
+	start := c.Now()
+	select {
+	case <-c.After(time.Millisecond):
+	case <-time.After(time.Second):
+		t.Fatal("Real().After did not fire")
+	}
+	if c.Since(start) < time.Millisecond {
+		t.Error("Real().Since reported less than the time waited")
+	}
+}
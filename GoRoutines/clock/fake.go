@@ -0,0 +1,215 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only moves when Advance or Set is called.
+// Timers, tickers and AfterFunc callbacks fire in deadline order while
+// time is advanced; AfterFunc callbacks run synchronously inside Advance.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	changed chan struct{}
+}
+
+// NewFake returns a fake clock set to start
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start, changed: make(chan struct{})}
+}
+
+// Now returns the fake current time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since returns the fake time elapsed since t
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// After returns a channel that receives the fake time after d
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// Sleep blocks until the fake time has advanced by d
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// NewTimer creates a timer that fires after d of fake time
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{f: f, ch: make(chan time.Time, 1)}
+	f.schedule(t, d)
+	return t
+}
+
+// NewTicker creates a ticker that fires every d of fake time
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{f: f, ch: make(chan time.Time, 1), period: d}
+	f.schedule(t, d)
+	return fakeTicker{t}
+}
+
+// AfterFunc calls fn once d of fake time has passed
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	t := &fakeTimer{f: f, fn: fn}
+	f.schedule(t, d)
+	return t
+}
+
+// Advance moves the fake time forward by d, firing every timer whose
+// deadline is reached along the way
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the fake time forward to t. Moving backwards is not allowed.
+func (f *Fake) Set(t time.Time) {
+	for {
+		f.mu.Lock()
+		if t.Before(f.now) {
+			f.mu.Unlock()
+			panic("clock: cannot move fake time backwards")
+		}
+		if len(f.timers) == 0 || f.timers[0].when.After(t) {
+			f.now = t
+			f.mu.Unlock()
+			return
+		}
+
+		timer := f.timers[0]
+		f.timers = f.timers[1:]
+		f.now = timer.when
+		now := f.now
+		if timer.period > 0 {
+			timer.when = timer.when.Add(timer.period)
+			f.insert(timer)
+		} else {
+			timer.active = false
+		}
+		f.mu.Unlock()
+
+		timer.fire(now)
+	}
+}
+
+// Waiters returns the number of pending timers, tickers and sleepers
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// BlockUntil waits until at least n timers are pending. Tests use it to
+// make sure the code under test is waiting before they call Advance.
+func (f *Fake) BlockUntil(n int) {
+	for {
+		f.mu.Lock()
+		if len(f.timers) >= n {
+			f.mu.Unlock()
+			return
+		}
+		changed := f.changed
+		f.mu.Unlock()
+		<-changed
+	}
+}
+
+func (f *Fake) schedule(t *fakeTimer, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t.when = f.now.Add(d)
+	t.active = true
+	f.insert(t)
+}
+
+// insert adds t in deadline order, after timers with the same deadline.
+// Must be called with f.mu held.
+func (f *Fake) insert(t *fakeTimer) {
+	i := sort.Search(len(f.timers), func(i int) bool { return f.timers[i].when.After(t.when) })
+	f.timers = append(f.timers, nil)
+	copy(f.timers[i+1:], f.timers[i:])
+	f.timers[i] = t
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// remove drops t from the pending list and reports whether it was there.
+// Must be called with f.mu held.
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, other := range f.timers {
+		if other == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeTimer implements Timer and, wrapped in fakeTicker, Ticker
+type fakeTimer struct {
+	f      *Fake
+	ch     chan time.Time
+	fn     func()
+	period time.Duration
+	when   time.Time
+	active bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.fn != nil {
+		t.fn()
+		return
+	}
+	// Like time.Ticker, drop ticks the receiver is too slow for
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
+
+// Stop implements Timer
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	t.f.remove(t)
+	return wasActive
+}
+
+// Reset implements Timer
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.f.mu.Lock()
+	wasActive := t.active
+	t.f.remove(t)
+	if t.period > 0 {
+		t.period = d
+	}
+	t.f.mu.Unlock()
+	t.f.schedule(t, d)
+	return wasActive
+}
+
+// fakeTicker adapts fakeTimer to the Ticker method set
+type fakeTicker struct {
+	t *fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time   { return t.t.ch }
+func (t fakeTicker) Stop()                 { t.t.Stop() }
+func (t fakeTicker) Reset(d time.Duration) { t.t.Reset(d) }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// waitDelay is how long a killed job may keep its output pipes open
+const waitDelay = time.Second
+
+// item is one line of input with its 1-based job number
+type item struct {
+	seq   int
+	value string
+}
+
+// readItems sends the items on the returned channel and then reports the
+// first read error, or nil, on the error channel
+func readItems(ctx context.Context, cfg *config, stdin io.Reader) (<-chan item, <-chan error) {
+	items := make(chan item)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(items)
+		seq := 0
+		send := func(v string) bool {
+			seq++
+			select {
+			case items <- item{seq: seq, value: v}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if cfg.hasArgs {
+			for _, a := range cfg.args {
+				if !send(a) {
+					break
+				}
+			}
+			errc <- nil
+			return
+		}
+		if len(cfg.inputs) == 0 {
+			errc <- scanItems(stdin, cfg.null, send)
+			return
+		}
+		for _, name := range cfg.inputs {
+			f, err := os.Open(name)
+			if err != nil {
+				errc <- err
+				return
+			}
+			err = scanItems(f, cfg.null, send)
+			f.Close()
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- nil
+	}()
+	return items, errc
+}
+
+// scanItems calls send for every non-empty item in r until send returns
+// false
+func scanItems(r io.Reader, null bool, send func(string) bool) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	if null {
+		sc.Split(scanNull)
+	}
+	for sc.Scan() {
+		if sc.Text() == "" {
+			continue
+		}
+		if !send(sc.Text()) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+// scanNull is a bufio.SplitFunc for NUL-separated input
+func scanNull(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// result is the outcome of one job
+type result struct {
+	Seq      int
+	Item     string
+	Command  []string
+	ExitCode int
+	Attempts int
+	Start    time.Time
+	Duration time.Duration
+	TimedOut bool
+
+	stdout, stderr []byte
+	err            error
+}
+
+// record is the joblog line of a result
+type record struct {
+	Seq        int      `json:"seq"`
+	Item       string   `json:"item"`
+	Command    []string `json:"command"`
+	ExitCode   int      `json:"exit_code"`
+	Attempts   int      `json:"attempts"`
+	Start      string   `json:"start"`
+	DurationMs float64  `json:"duration_ms"`
+	TimedOut   bool     `json:"timed_out,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func (r result) record() record {
+	rec := record{
+		Seq:        r.Seq,
+		Item:       r.Item,
+		Command:    r.Command,
+		ExitCode:   r.ExitCode,
+		Attempts:   r.Attempts,
+		Start:      r.Start.UTC().Format(time.RFC3339Nano),
+		DurationMs: float64(r.Duration) / float64(time.Millisecond),
+		TimedOut:   r.TimedOut,
+	}
+	if r.err != nil {
+		rec.Error = r.err.Error()
+	}
+	return rec
+}
+
+// runner runs the jobs of one gopar invocation
+type runner struct {
+	cfg *config
+}
+
+// run executes the job for it, retrying failed attempts
+func (r *runner) run(ctx context.Context, it item) result {
+	res := result{
+		Seq:     it.seq,
+		Item:    it.value,
+		Command: command(r.cfg.template, it.value, it.seq, r.cfg.shell),
+		Start:   time.Now(),
+	}
+	if r.cfg.dryRun {
+		res.stdout = []byte(strings.Join(quoteAll(res.Command), " ") + "\n")
+		return res
+	}
+
+	for attempt := 0; attempt <= r.cfg.retries; attempt++ {
+		res.Attempts++
+		r.attempt(ctx, &res)
+		if res.err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	res.Duration = time.Since(res.Start)
+	return res
+}
+
+// attempt runs the command once and stores its output and status in res
+func (r *runner) attempt(ctx context.Context, res *result) {
+	if r.cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, res.Command[0], res.Command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = waitDelay
+	err := cmd.Run()
+
+	res.stdout, res.stderr = stdout.Bytes(), stderr.Bytes()
+	res.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	res.ExitCode = 0
+	res.err = nil
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case res.TimedOut:
+		res.ExitCode = -1
+		res.err = fmt.Errorf("timed out after %v", r.cfg.timeout)
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+		res.err = err
+	default:
+		res.ExitCode = -1
+		res.err = err
+	}
+}
+
+func quoteAll(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return quoted
+}
@@ -0,0 +1,217 @@
+// Command gopar runs a command for every input item, several at a time,
+// in the spirit of GNU parallel and xargs -P. It is built on the worker
+// pool and fan-out/fan-in patterns from package pool.
+//
+// Usage:
+//
+//	gopar [flags] command [args...] [::: item...]
+//
+// Items are read one per line from the files given with -a, from the
+// arguments after :::, or from standard input. In the command template
+//
+//	{}    is replaced by the item
+//	{.}   by the item without its extension
+//	{/}   by the basename of the item
+//	{//}  by the directory of the item
+//	{/.}  by the basename without its extension
+//	{#}   by the job number, starting at 1
+//
+// and the item is appended as the last argument if no placeholder is used.
+// The output of every job is printed in one piece when it finishes; -k
+// prints it in input order instead.
+//
+// The exit status is 0 if every job succeeded, the number of failed jobs
+// (at most 101) otherwise, and 255 on usage or input errors.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-concurrency-lesson/pool"
+)
+
+// exitError is the exit status for usage and input errors
+const exitError = 255
+
+// maxFailStatus caps the exit status that counts failed jobs
+const maxFailStatus = 101
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+type config struct {
+	jobs      int
+	keepOrder bool
+	rate      float64
+	timeout   time.Duration
+	retries   int
+	joblog    string
+	shell     bool
+	dryRun    bool
+	null      bool
+	inputs    []string
+	template  []string
+	args      []string // items after :::
+	hasArgs   bool
+}
+
+// listFlag collects a flag that may be repeated
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(v string) error { *l = append(*l, v); return nil }
+
+func parseArgs(args []string, stderr io.Writer) (*config, error) {
+	cfg := &config{}
+	fs := flag.NewFlagSet("gopar", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: gopar [flags] command [args...] [::: item...]")
+		fs.PrintDefaults()
+	}
+	fs.IntVar(&cfg.jobs, "j", runtime.NumCPU(), "number of jobs to run at once")
+	fs.BoolVar(&cfg.keepOrder, "k", false, "print output in input order")
+	fs.Float64Var(&cfg.rate, "rate", 0, "start at most this many jobs per second (0 = no limit)")
+	fs.DurationVar(&cfg.timeout, "timeout", 0, "kill a job attempt after this long (0 = no limit)")
+	fs.IntVar(&cfg.retries, "retries", 0, "run a failed job up to this many more times")
+	fs.StringVar(&cfg.joblog, "joblog", "", "write one JSON record per job to this file")
+	fs.BoolVar(&cfg.shell, "shell", false, "run the command through sh -c, quoting the item")
+	fs.BoolVar(&cfg.dryRun, "dry-run", false, "print the commands instead of running them")
+	fs.BoolVar(&cfg.null, "0", false, "items are separated by NUL instead of newline")
+	fs.Var((*listFlag)(&cfg.inputs), "a", "read items from this file (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	rest := fs.Args()
+	for i, a := range rest {
+		if a == ":::" {
+			cfg.args = rest[i+1:]
+			cfg.hasArgs = true
+			rest = rest[:i]
+			break
+		}
+	}
+	cfg.template = rest
+
+	switch {
+	case len(cfg.template) == 0:
+		return nil, errors.New("gopar: no command given")
+	case cfg.jobs < 1:
+		return nil, errors.New("gopar: -j must be at least 1")
+	case cfg.retries < 0:
+		return nil, errors.New("gopar: -retries must not be negative")
+	case cfg.rate < 0:
+		return nil, errors.New("gopar: -rate must not be negative")
+	case cfg.hasArgs && len(cfg.inputs) > 0:
+		return nil, errors.New("gopar: use either -a or :::, not both")
+	}
+	return cfg, nil
+}
+
+// run is main without the process: it returns the exit status
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cfg, err := parseArgs(args, stderr)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(stderr, err)
+		}
+		return exitError
+	}
+
+	var joblog *json.Encoder
+	if cfg.joblog != "" {
+		f, err := os.Create(cfg.joblog)
+		if err != nil {
+			fmt.Fprintln(stderr, "gopar:", err)
+			return exitError
+		}
+		defer f.Close()
+		joblog = json.NewEncoder(f)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	items, readErr := readItems(ctx, cfg, stdin)
+	if cfg.rate > 0 {
+		items = throttle(ctx, items, cfg.rate)
+	}
+
+	r := &runner{cfg: cfg}
+	var results <-chan result
+	if cfg.keepOrder {
+		results = pool.RunOrdered(ctx, cfg.jobs, items, r.run)
+	} else {
+		results = pool.Run(ctx, cfg.jobs, items, r.run)
+	}
+
+	failed := 0
+	for res := range results {
+		stdout.Write(res.stdout)
+		stderr.Write(res.stderr)
+		if res.err != nil {
+			failed++
+			fmt.Fprintf(stderr, "gopar: job %d (%s): %v\n", res.Seq, res.Item, res.err)
+		}
+		if joblog != nil {
+			if err := joblog.Encode(res.record()); err != nil {
+				fmt.Fprintln(stderr, "gopar: joblog:", err)
+				return exitError
+			}
+		}
+	}
+
+	// The reader may be stuck on stdin after a cancel, so check that first
+	if ctx.Err() != nil {
+		fmt.Fprintln(stderr, "gopar: interrupted")
+		return exitError
+	}
+	if err := <-readErr; err != nil {
+		fmt.Fprintln(stderr, "gopar:", err)
+		return exitError
+	}
+	return min(failed, maxFailStatus)
+}
+
+// throttle passes items on at most rate per second
+func throttle(ctx context.Context, in <-chan item, rate float64) <-chan item {
+	out := make(chan item)
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		first := true
+		for it := range in {
+			// The first job starts at once
+			if !first {
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+			first = false
+			select {
+			case out <- it:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gopar(t *testing.T, stdin string, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = run(context.Background(), args, strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func sortedLines(s string) []string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		stdin    string
+		args     []string
+		wantCode int
+		want     string
+		ordered  bool
+	}{
+		{"stdin", "a\nb\n\nc\n", []string{"echo"}, 0, "a\nb\nc\n", false},
+		{"args", "", []string{"echo", "x", ":::", "1", "2"}, 0, "x 1\nx 2\n", false},
+		{"keep order", "", []string{"-j", "4", "-k", "-shell", "sleep 0.0{} && echo {}", ":::", "5", "1", "3", "2"}, 0, "5\n1\n3\n2\n", true},
+		{"placeholders", "", []string{"-k", "echo", "{#}", "{}", "{.}", "{/}", "{//}", "{/.}", ":::", "dir/file.tar.gz"}, 0, "1 dir/file.tar.gz dir/file.tar file.tar.gz dir file.tar\n", true},
+		{"nul separated", "a b\x00c\n", []string{"-0", "-k", "echo", "[{}]"}, 0, "[a b]\n[c\n]\n", true},
+		{"shell quoting", "", []string{"-shell", "echo {}", ":::", "it's $HOME; a"}, 0, "it's $HOME; a\n", false},
+		{"dry run", "", []string{"-dry-run", "-k", "rm", "{}", ":::", "a b", "c"}, 0, "rm 'a b'\nrm c\n", true},
+		{"failures", "", []string{"-shell", "exit {}", ":::", "0", "1", "2", "0"}, 2, "", false},
+		{"missing command", "", []string{"/nonexistent/cmd", ":::", "a"}, 1, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, out, _ := gopar(t, tt.stdin, tt.args...)
+			if code != tt.wantCode {
+				t.Errorf("gopar %v exit status = %d, want %d", tt.args, code, tt.wantCode)
+			}
+			if tt.ordered {
+				if out != tt.want {
+					t.Errorf("gopar %v output = %q, want %q", tt.args, out, tt.want)
+				}
+			} else if tt.want != "" && !reflect.DeepEqual(sortedLines(out), sortedLines(tt.want)) {
+				t.Errorf("gopar %v output = %q, want lines of %q", tt.args, out, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-j", "0", "echo"},
+		{"-retries", "-1", "echo"},
+		{"-a", "x", "echo", ":::", "y"},
+		{"-nosuchflag", "echo"},
+		{"-a", "/nonexistent/input", "echo"},
+	} {
+		if code, _, _ := gopar(t, "", args...); code != exitError {
+			t.Errorf("gopar %v exit status = %d, want %d", args, code, exitError)
+		}
+	}
+}
+
+func TestInputFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	os.WriteFile(a, []byte("1\n2\n"), 0o644)
+	os.WriteFile(b, []byte("3\n"), 0o644)
+
+	code, out, _ := gopar(t, "ignored\n", "-k", "-a", a, "-a", b, "echo")
+	if code != 0 || out != "1\n2\n3\n" {
+		t.Errorf("gopar -a a -a b = %d, %q, want 0, %q", code, out, "1\n2\n3\n")
+	}
+}
+
+func TestOutputNotInterleaved(t *testing.T) {
+	// Every job writes two lines with a pause between them
+	code, out, _ := gopar(t, "", "-j", "8", "-shell", "echo {}; sleep 0.01; echo {}",
+		":::", "a", "b", "c", "d", "e", "f", "g", "h")
+	if code != 0 {
+		t.Fatalf("exit status = %d, want 0", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 16 {
+		t.Fatalf("got %d lines, want 16: %q", len(lines), out)
+	}
+	for i := 0; i < len(lines); i += 2 {
+		if lines[i] != lines[i+1] {
+			t.Errorf("output of jobs interleaved: %q", out)
+			break
+		}
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	items := []string{":::", "1", "2", "3", "4"}
+	start := time.Now()
+	gopar(t, "", append([]string{"-j", "4", "-shell", "sleep 0.1 #"}, items...)...)
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Errorf("-j 4 ran 4 jobs of 100ms in %v, want them in parallel", d)
+	}
+
+	start = time.Now()
+	gopar(t, "", append([]string{"-j", "4", "-rate", "20", "true"}, items...)...)
+	if d := time.Since(start); d < 140*time.Millisecond {
+		t.Errorf("-rate 20 started 4 jobs in %v, want at least 150ms", d)
+	}
+}
+
+func TestTimeoutRetriesJoblog(t *testing.T) {
+	dir := t.TempDir()
+	joblog := filepath.Join(dir, "joblog.json")
+	counter := filepath.Join(dir, "counter")
+
+	// "flaky" fails on its first attempt and succeeds on the second
+	script := `case {} in
+slow) sleep 5 ;;
+flaky) [ -f ` + counter + ` ] || { touch ` + counter + `; exit 3; } ;;
+bad) exit 4 ;;
+esac`
+	start := time.Now()
+	code, _, stderr := gopar(t, "", "-k", "-shell", "-timeout", "100ms", "-retries", "1", "-joblog", joblog,
+		script, ":::", "ok", "slow", "flaky", "bad")
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("timed out job was not killed, took %v", d)
+	}
+	if code != 2 {
+		t.Errorf("exit status = %d, want 2; stderr %s", code, stderr)
+	}
+
+	f, err := os.Open(joblog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := make(map[string]record)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("joblog line %q: %v", sc.Text(), err)
+		}
+		got[rec.Item] = rec
+	}
+
+	want := map[string]struct {
+		seq, exit, attempts int
+		timedOut, failed    bool
+	}{
+		"ok":    {1, 0, 1, false, false},
+		"slow":  {2, -1, 2, true, true},
+		"flaky": {3, 0, 2, false, false},
+		"bad":   {4, 4, 2, false, true},
+	}
+	if len(got) != len(want) {
+		t.Errorf("joblog has %d records, want %d", len(got), len(want))
+	}
+	for name, w := range want {
+		rec := got[name]
+		if rec.Seq != w.seq || rec.ExitCode != w.exit || rec.Attempts != w.attempts ||
+			rec.TimedOut != w.timedOut || (rec.Error != "") != w.failed {
+			t.Errorf("joblog %s = %+v, want %+v", name, rec, w)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, rec.Start); err != nil {
+			t.Errorf("joblog %s start %q: %v", name, rec.Start, err)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	// stdin never ends, like a terminal nobody types into
+	pr, pw, _ := os.Pipe()
+	defer pw.Close()
+	defer pr.Close()
+	pw.Write([]byte("1\n2\n"))
+	code := run(ctx, []string{"sleep", "5"}, pr, &bytes.Buffer{}, &bytes.Buffer{})
+	if code != exitError {
+		t.Errorf("exit status after cancel = %d, want %d", code, exitError)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("run() took %v after cancel", d)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		template []string
+		item     string
+		shell    bool
+		want     []string
+	}{
+		{[]string{"gzip", "-k"}, "a.txt", false, []string{"gzip", "-k", "a.txt"}},
+		{[]string{"mv", "{}", "{.}.bak"}, "x/y.txt", false, []string{"mv", "x/y.txt", "x/y.bak"}},
+		{[]string{"echo", "{unknown}", "{"}, "v", false, []string{"echo", "{unknown}", "{", "v"}},
+		{[]string{"echo", "{#}-{}"}, "v", false, []string{"echo", "7-v"}},
+		{[]string{"wc -l <", "{}"}, "my file", true, []string{"sh", "-c", "wc -l < 'my file'"}},
+		{[]string{"cat"}, "", true, []string{"sh", "-c", "cat ''"}},
+	}
+	for _, tt := range tests {
+		if got := command(tt.template, tt.item, 7, tt.shell); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("command(%q, %q) = %q, want %q", tt.template, tt.item, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkCommand(b *testing.B) {
+	template := []string{"convert", "{}", "-resize", "50%", "{//}/thumbs/{/.}.png"}
+	for i := 0; i < b.N; i++ {
+		command(template, "photos/2024/img_0001.jpg", i, false)
+	}
+}
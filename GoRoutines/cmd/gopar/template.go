@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// placeholders in the order they are matched, longest first so that {/.}
+// is not read as {/} followed by text
+var placeholders = []string{"{//}", "{/.}", "{/}", "{.}", "{#}", "{}"}
+
+// expand replaces the placeholders in s. quote is applied to every
+// substituted value and is the identity outside shell mode.
+func expand(s, item string, seq int, quote func(string) string) (string, bool) {
+	var sb strings.Builder
+	found := false
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			sb.WriteString(s)
+			break
+		}
+		sb.WriteString(s[:i])
+		s = s[i:]
+		matched := false
+		for _, p := range placeholders {
+			if strings.HasPrefix(s, p) {
+				sb.WriteString(quote(replacement(p, item, seq)))
+				s = s[len(p):]
+				matched, found = true, true
+				break
+			}
+		}
+		if !matched {
+			sb.WriteByte('{')
+			s = s[1:]
+		}
+	}
+	return sb.String(), found
+}
+
+func replacement(p, item string, seq int) string {
+	switch p {
+	case "{//}":
+		return path.Dir(item)
+	case "{/.}":
+		return trimExt(path.Base(item))
+	case "{/}":
+		return path.Base(item)
+	case "{.}":
+		return trimExt(item)
+	case "{#}":
+		return strconv.Itoa(seq)
+	default:
+		return item
+	}
+}
+
+// trimExt removes the extension of the last path element
+func trimExt(s string) string {
+	return strings.TrimSuffix(s, path.Ext(s))
+}
+
+// command builds the argv of one job. In shell mode the template is joined
+// into a script for sh -c and substituted values are quoted.
+func command(template []string, item string, seq int, shell bool) []string {
+	quote := func(s string) string { return s }
+	if shell {
+		quote = shellQuote
+	}
+
+	argv := make([]string, 0, len(template)+1)
+	found := false
+	for _, arg := range template {
+		a, ok := expand(arg, item, seq, quote)
+		argv = append(argv, a)
+		found = found || ok
+	}
+	if !found {
+		argv = append(argv, quote(item))
+	}
+	if shell {
+		return []string{"sh", "-c", strings.Join(argv, " ")}
+	}
+	return argv
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=,+@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
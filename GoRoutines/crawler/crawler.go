@@ -0,0 +1,382 @@
+// Package crawler follows links from seed URLs with a bounded number of
+// workers, using the same building blocks as FetchURLs: fetch for traced
+// requests and hostsched for per-host politeness.
+//
+// Every URL is normalized and fetched at most once. robots.txt is read
+// once per host and its Crawl-delay, if longer than Options.Delay, slows
+// that host down. Pages are streamed on a channel that is closed when the
+// crawl is finished or its context is cancelled.
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-concurrency-lesson/fetch"
+	"github.com/go-concurrency-lesson/hostsched"
+	"github.com/go-concurrency-lesson/syncx"
+)
+
+// ErrDisallowed is the error of a page that robots.txt forbids fetching
+var ErrDisallowed = errors.New("crawler: disallowed by robots.txt")
+
+// DefaultUserAgent identifies the crawler to servers and robots.txt
+const DefaultUserAgent = "go-concurrency-lesson-crawler/1.0"
+
+// Options configure a crawl
+type Options struct {
+	// MaxDepth is how many links away from a seed the crawl goes;
+	// seeds have depth 0 and zero fetches only the seeds
+	MaxDepth int
+	// MaxPages stops the crawl after this many fetches (0 = no limit)
+	MaxPages int
+	// Workers is the number of concurrent fetches [8]
+	Workers int
+	// Delay is the minimum time between two requests to the same host
+	Delay time.Duration
+	// MaxPerHost caps concurrent requests to one host [2]
+	MaxPerHost int
+	// MaxBodyBytes is how much of a page is searched for links [1 MiB]
+	MaxBodyBytes int64
+	// Client sends the requests [http.Client with a 10s timeout]
+	Client *http.Client
+	// UserAgent is sent with every request and matched against
+	// robots.txt [DefaultUserAgent]
+	UserAgent string
+	// IgnoreRobots skips robots.txt
+	IgnoreRobots bool
+	// Follow decides whether a link is crawled; nil follows links to
+	// the hosts of the seeds
+	Follow func(u *url.URL) bool
+}
+
+// Page is the outcome of visiting one URL
+type Page struct {
+	// URL is the normalized URL that was requested
+	URL string
+	// Depth is the number of links followed from a seed
+	Depth int
+	// Parent is the page the link was found on; empty for seeds
+	Parent string
+	// Result holds status, timings and redirects of the request
+	Result fetch.Result
+	// Links are the normalized links found on the page
+	Links []string
+	// Err is set if the page could not be fetched or robots.txt
+	// disallows it
+	Err error
+}
+
+// Crawl starts crawling from seeds and returns a channel of pages. The
+// channel is closed when there is nothing left to crawl, MaxPages is
+// reached or ctx is cancelled; no goroutines outlive it. An invalid seed
+// fails the whole call before anything is fetched.
+func Crawl(ctx context.Context, seeds []string, opts Options) (<-chan Page, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 8
+	}
+	if opts.MaxPerHost <= 0 {
+		opts.MaxPerHost = 2
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = 1 << 20
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = DefaultUserAgent
+	}
+
+	c := &crawl{
+		opts:   opts,
+		out:    make(chan Page),
+		seen:   make(map[string]bool),
+		robots: make(map[string]*robots),
+	}
+	c.cond = syncx.NewCond(&c.mu)
+
+	hosts := make(map[string]bool)
+	for _, s := range seeds {
+		n, err := Normalize(s)
+		if err != nil {
+			return nil, fmt.Errorf("crawler: seed %q: %w", s, err)
+		}
+		u, _ := url.Parse(n)
+		hosts[u.Host] = true
+		if !c.seen[n] {
+			c.seen[n] = true
+			c.queue = append(c.queue, task{url: n})
+		}
+	}
+	c.pending = len(c.queue)
+	if c.opts.Follow == nil {
+		c.opts.Follow = func(u *url.URL) bool { return hosts[u.Host] }
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	c.sched = hostsched.New(hostsched.Options{PerHost: c.hostLimits(0)})
+	scheduled := *client
+	scheduled.Transport = hostsched.NewTransport(client.Transport, c.sched)
+	scheduled.CheckRedirect = c.checkRedirect
+	c.client = &scheduled
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.work(ctx)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(c.out)
+	}()
+	return c.out, nil
+}
+
+type task struct {
+	url    string
+	depth  int
+	parent string
+}
+
+type crawl struct {
+	opts   Options
+	client *http.Client
+	sched  *hostsched.Scheduler
+	out    chan Page
+
+	mu      sync.Mutex
+	cond    *syncx.Cond
+	queue   []task
+	pending int // tasks queued or being visited
+	started int // pages claimed against MaxPages
+	fetched int // pages fetched, robots.txt refusals excluded
+	seen    map[string]bool
+
+	robotsMu     sync.Mutex
+	robots       map[string]*robots
+	robotsFlight syncx.Group[string, *robots]
+}
+
+// work visits tasks until the frontier is exhausted or ctx is done
+func (c *crawl) work(ctx context.Context) {
+	for {
+		t, ok := c.next(ctx)
+		if !ok {
+			return
+		}
+		page, counted := c.visit(ctx, t)
+		c.finish(page, counted)
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case c.out <- page:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// next takes a task from the queue, waiting while other workers may
+// still discover links or give back a MaxPages slot
+func (c *crawl) next(ctx context.Context) (task, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if c.opts.MaxPages > 0 && c.fetched >= c.opts.MaxPages {
+			// Drop the frontier; pages already being fetched still finish
+			c.pending -= len(c.queue)
+			c.queue = nil
+			return task{}, false
+		}
+		if len(c.queue) > 0 && (c.opts.MaxPages == 0 || c.started < c.opts.MaxPages) {
+			t := c.queue[0]
+			c.queue = c.queue[1:]
+			c.started++
+			return t, true
+		}
+		if c.pending == 0 {
+			return task{}, false
+		}
+		if err := c.cond.Wait(ctx); err != nil {
+			return task{}, false
+		}
+	}
+}
+
+// finish queues the new links of page and retires its task
+func (c *crawl) finish(page Page, counted bool) {
+	c.mu.Lock()
+	if counted {
+		c.fetched++
+	} else {
+		c.started--
+	}
+	if page.Depth < c.opts.MaxDepth && (c.opts.MaxPages == 0 || c.fetched < c.opts.MaxPages) {
+		for _, link := range page.Links {
+			if c.seen[link] || !c.follow(link) {
+				continue
+			}
+			c.seen[link] = true
+			c.queue = append(c.queue, task{url: link, depth: page.Depth + 1, parent: page.URL})
+			c.pending++
+		}
+	}
+	c.pending--
+	c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+func (c *crawl) follow(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && c.opts.Follow(u)
+}
+
+// visit fetches one page and extracts its links. counted reports whether
+// the page counts towards MaxPages.
+func (c *crawl) visit(ctx context.Context, t task) (Page, bool) {
+	page := Page{URL: t.url, Depth: t.depth, Parent: t.parent}
+	u, _ := url.Parse(t.url)
+
+	if !c.opts.IgnoreRobots && !c.robotsFor(ctx, u).allowed(u.RequestURI()) {
+		page.Err = ErrDisallowed
+		return page, false
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url, nil)
+	if err != nil {
+		page.Err = err
+		return page, true
+	}
+	req.Header.Set("User-Agent", c.opts.UserAgent)
+	body := &limitedBuffer{max: c.opts.MaxBodyBytes}
+	page.Result = fetch.Do(c.client, req, body)
+	page.Err = page.Result.Err
+	if page.Err != nil || page.Result.Status != http.StatusOK || !isHTML(page.Result.ResponseHeader) {
+		return page, true
+	}
+
+	base, err := url.Parse(page.Result.FinalURL)
+	if err != nil {
+		base = u
+	}
+	page.Links = extractLinks(base, body.Bytes())
+	return page, true
+}
+
+// checkRedirect follows a redirect only to a URL that the crawl would
+// fetch itself: one that Follow accepts, robots.txt allows and that is not
+// crawled anyway, so every page is still fetched once. The page that
+// redirected is reported with its 3xx status.
+func (c *crawl) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.Context().Value(robotsRequest{}) != nil {
+		// robots.txt may move within its host or elsewhere
+		return nil
+	}
+	target, err := normalizeURL(req.URL)
+	if err != nil {
+		return err
+	}
+	if !c.follow(target) {
+		return http.ErrUseLastResponse
+	}
+	if !c.opts.IgnoreRobots && !c.robotsFor(req.Context(), req.URL).allowed(req.URL.RequestURI()) {
+		return http.ErrUseLastResponse
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen[target] {
+		return http.ErrUseLastResponse
+	}
+	c.seen[target] = true
+	return nil
+}
+
+// robotsRequest marks the context of a robots.txt request
+type robotsRequest struct{}
+
+// robotsFor returns the robots.txt rules of u's host, fetching them once
+func (c *crawl) robotsFor(ctx context.Context, u *url.URL) *robots {
+	key := u.Scheme + "://" + u.Host
+	c.robotsMu.Lock()
+	r, ok := c.robots[key]
+	c.robotsMu.Unlock()
+	if ok {
+		return r
+	}
+
+	r, err, _ := c.robotsFlight.DoContext(ctx, key, func(ctx context.Context) (*robots, error) {
+		r := c.fetchRobots(ctx, key+"/robots.txt")
+		if r.crawlDelay > c.opts.Delay {
+			c.sched.SetHostLimits(u.Host, c.hostLimits(r.crawlDelay))
+		}
+		c.robotsMu.Lock()
+		c.robots[key] = r
+		c.robotsMu.Unlock()
+		return r, nil
+	})
+	if err != nil {
+		return allowAll
+	}
+	return r
+}
+
+// fetchRobots downloads and parses a robots.txt. A missing or unreadable
+// file allows everything.
+func (c *crawl) fetchRobots(ctx context.Context, robotsURL string) *robots {
+	ctx = context.WithValue(ctx, robotsRequest{}, true)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return allowAll
+	}
+	req.Header.Set("User-Agent", c.opts.UserAgent)
+	body := &limitedBuffer{max: 512 << 10}
+	res := fetch.Do(c.client, req, body)
+	if res.Err != nil || res.Status != http.StatusOK {
+		return allowAll
+	}
+	return parseRobots(bytes.NewReader(body.Bytes()), c.opts.UserAgent)
+}
+
+// hostLimits returns the scheduler limits for a host with the given crawl
+// delay (the larger of it and Options.Delay applies)
+func (c *crawl) hostLimits(crawlDelay time.Duration) hostsched.Limits {
+	l := hostsched.Limits{MaxInFlight: c.opts.MaxPerHost}
+	if d := max(c.opts.Delay, crawlDelay); d > 0 {
+		l.RatePerSecond = float64(time.Second) / float64(d)
+	}
+	return l
+}
+
+func isHTML(h http.Header) bool {
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && (mt == "text/html" || mt == "application/xhtml+xml")
+}
+
+// limitedBuffer keeps the first max bytes written and discards the rest,
+// so the whole body is still read and counted
+type limitedBuffer struct {
+	bytes.Buffer
+	max int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - int64(b.Len()); room > 0 {
+		b.Buffer.Write(p[:min(int64(len(p)), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,416 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// site is a small local website with cycles, redirects, broken links and
+// a robots.txt
+type site struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string]int
+	times    []time.Time
+	delay    time.Duration
+	robots   string
+}
+
+func newSite(robots string) *site {
+	s := &site{requests: make(map[string]int), robots: robots}
+	pages := map[string]string{
+		"/":               `<a href="/a">A</a> <a href='b'>B</a> <a href=/private/secret>x</a> <a href="/missing">broken</a> <a href="/redirect">r</a> <a href="http://other.invalid/">ext</a> <a href="#top">top</a> <a href="/a#section">A again</a> <a href="mailto:me@example.com">mail</a>`,
+		"/a":              `<a href="/b">B</a> <a href="/">home</a> <a href="/a/deep">deep</a>`,
+		"/b":              `<A HREF="/a">A</A> <a class="q" href="/b?y=2&amp;x=1">query</a>`,
+		"/a/deep":         `<a href="deeper">deeper</a> <a href="../b">up</a>`,
+		"/a/deeper":       `<a href="/">home</a>`,
+		"/private/secret": `secret`,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.RequestURI()]++
+		s.times = append(s.times, time.Now())
+		s.mu.Unlock()
+		time.Sleep(s.delay)
+
+		switch r.URL.Path {
+		case "/robots.txt":
+			if s.robots == "" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(s.robots))
+		case "/redirect":
+			to := r.URL.Query().Get("to")
+			if to == "" {
+				to = "/b"
+			}
+			http.Redirect(w, r, to, http.StatusFound)
+		default:
+			body, ok := pages[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html><body>" + body + "</body></html>"))
+		}
+	}))
+	return s
+}
+
+func (s *site) count(uri string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[uri]
+}
+
+func collect(pages <-chan Page) map[string]Page {
+	got := make(map[string]Page)
+	for p := range pages {
+		got[p.URL] = p
+	}
+	return got
+}
+
+func TestCrawl(t *testing.T) {
+	s := newSite("User-agent: *\nDisallow: /private\n")
+	defer s.Close()
+
+	pages, err := Crawl(context.Background(), []string{s.URL, s.URL + "/"}, Options{MaxDepth: 5, Workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := collect(pages)
+
+	want := map[string]struct {
+		status int
+		depth  int
+		parent string
+		err    error
+	}{
+		"/":               {200, 0, "", nil},
+		"/a":              {200, 1, "/", nil},
+		"/b":              {200, 1, "/", nil},
+		"/missing":        {404, 1, "/", nil},
+		"/redirect":       {302, 1, "/", nil},
+		"/private/secret": {0, 1, "/", ErrDisallowed},
+		"/a/deep":         {200, 2, "/a", nil},
+		"/b?x=1&y=2":      {200, 2, "/b", nil},
+		"/a/deeper":       {200, 3, "/a/deep", nil},
+	}
+	if len(got) != len(want) {
+		t.Errorf("Crawl() visited %d pages, want %d: %v", len(got), len(want), keys(got))
+	}
+	for path, w := range want {
+		p, ok := got[s.URL+path]
+		if !ok {
+			t.Errorf("Crawl() did not visit %s", path)
+			continue
+		}
+		parent := ""
+		if w.parent != "" {
+			parent = s.URL + w.parent
+		}
+		if p.Result.Status != w.status || p.Depth != w.depth || p.Parent != parent || !errors.Is(p.Err, w.err) {
+			t.Errorf("page %s = status %d depth %d parent %q err %v, want %d %d %q %v",
+				path, p.Result.Status, p.Depth, p.Parent, p.Err, w.status, w.depth, parent, w.err)
+		}
+	}
+
+	for _, uri := range []string{"/", "/a", "/b", "/b?x=1&y=2", "/a/deep", "/robots.txt"} {
+		if n := s.count(uri); n != 1 {
+			t.Errorf("%s requested %d times, want once", uri, n)
+		}
+	}
+	if n := s.count("/private/secret"); n != 0 {
+		t.Errorf("page disallowed by robots.txt was requested %d times", n)
+	}
+}
+
+func TestCrawlRedirectTargets(t *testing.T) {
+	var offsite sync.Mutex
+	offsiteHits := 0
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offsite.Lock()
+		offsiteHits++
+		offsite.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	}))
+	defer other.Close()
+	s := newSite("User-agent: *\nDisallow: /private\n")
+	defer s.Close()
+
+	tests := []struct {
+		name   string
+		target string
+		hits   func() int
+	}{
+		{"off-site", other.URL + "/", func() int {
+			offsite.Lock()
+			defer offsite.Unlock()
+			return offsiteHits
+		}},
+		{"disallowed", s.URL + "/private/secret", func() int { return s.count("/private/secret") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seed := s.URL + "/redirect?to=" + url.QueryEscape(tt.target)
+			pages, err := Crawl(context.Background(), []string{seed}, Options{MaxDepth: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := collect(pages)
+			if len(got) != 1 {
+				t.Errorf("Crawl() visited %d pages, want 1: %v", len(got), keys(got))
+			}
+			for _, p := range got {
+				if p.Result.Status != http.StatusFound {
+					t.Errorf("redirecting page status = %d, want 302", p.Result.Status)
+				}
+			}
+			if n := tt.hits(); n != 0 {
+				t.Errorf("redirect target %s was requested %d times", tt.target, n)
+			}
+		})
+	}
+}
+
+func TestCrawlLimits(t *testing.T) {
+	s := newSite("")
+	defer s.Close()
+
+	tests := []struct {
+		name      string
+		opts      Options
+		wantPages int
+	}{
+		{"seeds only", Options{MaxDepth: 0}, 1},
+		{"depth 1", Options{MaxDepth: 1}, 6},
+		{"max pages", Options{MaxDepth: 5, MaxPages: 3}, 3},
+		{"max pages single worker", Options{MaxDepth: 5, MaxPages: 4, Workers: 1}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := Crawl(context.Background(), []string{s.URL}, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := collect(pages)
+			if len(got) != tt.wantPages {
+				t.Errorf("Crawl() visited %d pages, want %d: %v", len(got), tt.wantPages, keys(got))
+			}
+		})
+	}
+}
+
+func TestCrawlCancel(t *testing.T) {
+	s := newSite("")
+	s.delay = 20 * time.Millisecond
+	defer s.Close()
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pages, err := Crawl(ctx, []string{s.URL}, Options{MaxDepth: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pages
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range pages {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("page channel not closed after cancel")
+	}
+
+	s.CloseClientConnections()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before+2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines leaked: %d before, %d after", before, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCrawlPoliteness(t *testing.T) {
+	tests := []struct {
+		name    string
+		robots  string
+		delay   time.Duration
+		wantGap time.Duration
+	}{
+		{"delay option", "", 30 * time.Millisecond, 30 * time.Millisecond},
+		{"crawl-delay", "User-agent: *\nCrawl-delay: 0.03\n", 0, 30 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSite(tt.robots)
+			defer s.Close()
+
+			pages, err := Crawl(context.Background(), []string{s.URL}, Options{MaxDepth: 1, Delay: tt.delay, Workers: 4})
+			if err != nil {
+				t.Fatal(err)
+			}
+			collect(pages)
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			// The robots.txt request comes before the delay is known
+			times := s.times[1:]
+			slack := 5 * time.Millisecond
+			for i := 1; i < len(times); i++ {
+				if gap := times[i].Sub(times[i-1]); gap < tt.wantGap-slack {
+					t.Errorf("requests %d and %d were %v apart, want at least %v", i, i+1, gap, tt.wantGap)
+				}
+			}
+		})
+	}
+}
+
+func TestCrawlInvalidSeed(t *testing.T) {
+	for _, seed := range []string{"ftp://example.com/", "/relative", "http://%zz"} {
+		if _, err := Crawl(context.Background(), []string{seed}, Options{}); err == nil {
+			t.Errorf("Crawl(%q) returned no error", seed)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"http://Example.COM", "http://example.com/"},
+		{"HTTPS://example.com:443/a", "https://example.com/a"},
+		{"http://example.com:80/a#frag", "http://example.com/a"},
+		{"http://example.com:8080/a/./b/../c", "http://example.com:8080/a/c"},
+		{"http://example.com/?b=2&a=1", "http://example.com/?a=1&b=2"},
+		{"http://[::1]:80/", "http://[::1]/"},
+		{"  http://example.com/x  ", "http://example.com/x"},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"mailto:me@example.com", "http:///path", "javascript:void(0)"} {
+		if _, err := Normalize(bad); err == nil {
+			t.Errorf("Normalize(%q) returned no error", bad)
+		}
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	base, _ := url.Parse("http://example.com/dir/page")
+	body := `<a href="other">1</a> <a id=x href='/abs?q=1&amp;r=2'>2</a> <a href=plain>3</a>
+		<a
+		  href="../up">4</a> <a href="#only-fragment">5</a> <a href="other#frag">dup</a>
+		<link href="/style.css"> <a name="no-href">6</a> <a href="https://Example.com:443/">7</a>`
+	want := []string{
+		"http://example.com/dir/other",
+		"http://example.com/abs?q=1&r=2",
+		"http://example.com/dir/plain",
+		"http://example.com/up",
+		"https://example.com/",
+	}
+	got := extractLinks(base, []byte(body))
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("extractLinks() = %v, want %v", got, want)
+	}
+}
+
+func TestRobots(t *testing.T) {
+	const txt = `
+# comment
+User-agent: otherbot
+Disallow: /
+
+User-agent: *
+Disallow: /private
+Disallow: /*.pdf$
+Allow: /private/public
+Crawl-delay: 2
+
+User-agent: go-concurrency-lesson-crawler
+User-agent: mybot
+Disallow: /tmp/
+Disallow:
+Crawl-delay: 0.5
+`
+	tests := []struct {
+		agent string
+		path  string
+		want  bool
+	}{
+		{"somebot", "/", true},
+		{"somebot", "/private", false},
+		{"somebot", "/private/x", false},
+		{"somebot", "/private/public/x", true},
+		{"somebot", "/docs/a.pdf", false},
+		{"somebot", "/docs/a.pdf?x", true},
+		{"otherbot/2.0", "/anything", false},
+		{"MyBot/1.0", "/private", true},
+		{"MyBot/1.0", "/tmp/x", false},
+		{DefaultUserAgent, "/tmp/", false},
+	}
+	for _, tt := range tests {
+		r := parseRobots(strings.NewReader(txt), tt.agent)
+		if got := r.allowed(tt.path); got != tt.want {
+			t.Errorf("robots for %s allowed(%q) = %v, want %v", tt.agent, tt.path, got, tt.want)
+		}
+	}
+
+	if d := parseRobots(strings.NewReader(txt), "somebot").crawlDelay; d != 2*time.Second {
+		t.Errorf("crawl delay for * = %v, want 2s", d)
+	}
+	if d := parseRobots(strings.NewReader(txt), "mybot").crawlDelay; d != 500*time.Millisecond {
+		t.Errorf("crawl delay for mybot = %v, want 500ms", d)
+	}
+	if r := parseRobots(strings.NewReader(""), "bot"); !r.allowed("/x") {
+		t.Error("empty robots.txt disallows pages")
+	}
+}
+
+func keys(m map[string]Page) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func BenchmarkExtractLinks(b *testing.B) {
+	base, _ := url.Parse("http://example.com/")
+	var sb strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&sb, `<p>text <a href="/page%d?x=%d">link</a></p>`, i, i)
+	}
+	body := []byte(sb.String())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		extractLinks(base, body)
+	}
+}
@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Normalize returns the canonical form of an absolute http(s) URL so that
+// equivalent spellings are crawled once: scheme and host are lower-cased,
+// default ports, fragments and dot segments are removed, an empty path
+// becomes "/" and query parameters are sorted.
+func Normalize(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	return normalizeURL(u)
+}
+
+func normalizeURL(u *url.URL) (string, error) {
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("crawler: not an http(s) URL: " + u.String())
+	}
+	if u.Host == "" {
+		return "", errors.New("crawler: URL has no host: " + u.String())
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	n := url.URL{
+		Scheme:   u.Scheme,
+		User:     u.User,
+		Host:     host,
+		Path:     u.Path,
+		RawQuery: u.Query().Encode(),
+	}
+	// Resolving against itself removes . and .. segments
+	n = *n.ResolveReference(&url.URL{Path: n.Path, RawQuery: n.RawQuery})
+	if n.Path == "" {
+		n.Path = "/"
+	}
+	return n.String(), nil
+}
+
+var hrefRE = regexp.MustCompile(`(?is)<a\s[^>]*?\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// extractLinks returns the normalized targets of the <a href> links in an
+// HTML page, resolved against base, in document order without duplicates
+func extractLinks(base *url.URL, body []byte) []string {
+	seen := make(map[string]bool)
+	var links []string
+	for _, m := range hrefRE.FindAllSubmatch(body, -1) {
+		href := string(m[1]) + string(m[2]) + string(m[3])
+		href = strings.TrimSpace(unescapeHTML(href))
+		if href == "" || strings.HasPrefix(href, "#") {
+			continue
+		}
+		ref, err := url.Parse(href)
+		if err != nil {
+			continue
+		}
+		link, err := normalizeURL(base.ResolveReference(ref))
+		if err != nil || seen[link] {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+	}
+	return links
+}
+
+var htmlEntities = strings.NewReplacer("&amp;", "&", "&quot;", `"`, "&#39;", "'", "&lt;", "<", "&gt;", ">")
+
+func unescapeHTML(s string) string {
+	if !strings.Contains(s, "&") {
+		return s
+	}
+	return htmlEntities.Replace(s)
+}
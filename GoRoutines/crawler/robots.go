@@ -0,0 +1,133 @@
+package crawler
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// robots holds the rules of one robots.txt that apply to our user agent
+type robots struct {
+	rules      []rule
+	crawlDelay time.Duration
+}
+
+type rule struct {
+	allow  bool
+	prefix string
+}
+
+// allowAll is used for hosts without a readable robots.txt
+var allowAll = &robots{}
+
+// parseRobots reads a robots.txt and keeps the group for userAgent, or
+// the "*" group if no group names it
+func parseRobots(r io.Reader, userAgent string) *robots {
+	type group struct {
+		agents []string
+		robots robots
+	}
+	var groups []*group
+	var cur *group
+	inAgents := false
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive User-agent lines share one group
+			if !inAgents {
+				cur = &group{}
+				groups = append(groups, cur)
+			}
+			cur.agents = append(cur.agents, strings.ToLower(value))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			// An empty Disallow allows everything
+			if cur == nil || value == "" {
+				continue
+			}
+			cur.robots.rules = append(cur.robots.rules, rule{allow: key == "allow", prefix: value})
+		case "crawl-delay":
+			inAgents = false
+			if cur == nil {
+				continue
+			}
+			if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+				cur.robots.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+		}
+	}
+
+	ua := strings.ToLower(userAgent)
+	var wildcard *robots
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if a == "*" {
+				if wildcard == nil {
+					wildcard = &g.robots
+				}
+			} else if a != "" && strings.Contains(ua, a) {
+				return &g.robots
+			}
+		}
+	}
+	if wildcard != nil {
+		return wildcard
+	}
+	return allowAll
+}
+
+// allowed reports whether path (with its query) may be fetched. The
+// longest matching rule wins and Allow wins a tie, as in RFC 9309.
+func (r *robots) allowed(path string) bool {
+	best, allow := -1, true
+	for _, rl := range r.rules {
+		if !matchRule(rl.prefix, path) {
+			continue
+		}
+		if n := len(rl.prefix); n > best || (n == best && rl.allow) {
+			best, allow = n, rl.allow
+		}
+	}
+	return allow
+}
+
+// matchRule matches a robots.txt path pattern, where * matches any run
+// of characters and a trailing $ anchors the end
+func matchRule(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for _, p := range parts[1:] {
+		i := strings.Index(rest, p)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(p):]
+	}
+	if anchored {
+		last := parts[len(parts)-1]
+		return rest == "" || (len(parts) > 1 && strings.HasSuffix(path, last))
+	}
+	return true
+}
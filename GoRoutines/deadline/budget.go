@@ -0,0 +1,230 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+var (
+	// ErrUnknownStage is returned by Start for a name not given to NewBudget
+	ErrUnknownStage = errors.New("deadline: unknown stage")
+	// ErrStageStarted is returned by Start for a stage that already started
+	ErrStageStarted = errors.New("deadline: stage already started")
+)
+
+// Stage is one stage's share of a Budget. A stage gets its Fixed time if
+// set, and otherwise its Weight's share of the time left when it starts.
+type Stage struct {
+	Name string
+	// Weight against the other weighted stages [1]
+	Weight float64
+	Fixed  time.Duration
+}
+
+// BudgetOptions configure a Budget
+type BudgetOptions struct {
+	// Total is the time to split; ctx's deadline, if earlier, wins.
+	// With neither set every stage is unbounded.
+	Total time.Duration
+	// Clock drives the stage deadlines [clock.Real()]
+	Clock clock.Clock
+}
+
+// StageReport is the time a stage was given and the time it used
+type StageReport struct {
+	Name      string
+	Allocated time.Duration // zero if the stage never started
+	Spent     time.Duration
+	// Exceeded is set if the stage's deadline passed before it finished
+	Exceeded bool
+}
+
+// Budget splits one deadline across stages that run one after another.
+// Allocations are made when a stage starts, from the time actually left,
+// so a stage that finishes early leaves its unused time to the stages
+// after it, and one that overruns takes it from them.
+type Budget struct {
+	ctx context.Context
+	clk clock.Clock
+	end time.Time // zero if unbounded
+
+	mu      sync.Mutex
+	stages  []Stage
+	reports []StageReport
+	started []time.Time
+	index   map[string]int
+}
+
+// NewBudget returns a budget for stages ending at ctx's deadline or after
+// opts.Total, whichever comes first. Stage names must be unique and
+// weights must not be negative.
+func NewBudget(ctx context.Context, stages []Stage, opts BudgetOptions) (*Budget, error) {
+	clk := clock.Or(opts.Clock)
+	b := &Budget{
+		ctx:     ctx,
+		clk:     clk,
+		stages:  append([]Stage(nil), stages...),
+		reports: make([]StageReport, len(stages)),
+		started: make([]time.Time, len(stages)),
+		index:   make(map[string]int, len(stages)),
+	}
+	for i, s := range b.stages {
+		if _, dup := b.index[s.Name]; dup {
+			return nil, fmt.Errorf("deadline: duplicate stage %q", s.Name)
+		}
+		if s.Weight < 0 || s.Fixed < 0 {
+			return nil, fmt.Errorf("deadline: stage %q: negative allocation", s.Name)
+		}
+		if s.Weight == 0 && s.Fixed == 0 {
+			b.stages[i].Weight = 1
+		}
+		b.index[s.Name] = i
+		b.reports[i].Name = s.Name
+	}
+	if opts.Total > 0 {
+		b.end = clk.Now().Add(opts.Total)
+	}
+	if d, ok := ctx.Deadline(); ok && (b.end.IsZero() || d.Before(b.end)) {
+		b.end = d
+	}
+	return b, nil
+}
+
+// Start begins the named stage and returns its context, which expires when
+// the stage's allocation is used up, and a function to call when the stage
+// is done. It returns ErrUnknownStage or ErrStageStarted if the stage was
+// not given to NewBudget or has already started.
+func (b *Budget) Start(name string) (context.Context, context.CancelFunc, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i, ok := b.index[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnknownStage, name)
+	}
+	if !b.started[i].IsZero() {
+		return nil, nil, fmt.Errorf("%w: %q", ErrStageStarted, name)
+	}
+	now := b.clk.Now()
+	b.started[i] = now
+	if b.end.IsZero() {
+		ctx, cancel := context.WithCancel(b.ctx)
+		return ctx, b.stop(i, ctx, cancel), nil
+	}
+
+	alloc := b.allocate(i, now)
+	b.reports[i].Allocated = alloc
+	ctx, cancel := withDeadline(b.ctx, b.clk, now.Add(alloc))
+	return ctx, b.stop(i, ctx, cancel), nil
+}
+
+// allocate returns stage i's share of the time left; b.mu is held
+func (b *Budget) allocate(i int, now time.Time) time.Duration {
+	left := max(b.end.Sub(now), 0)
+	s := b.stages[i]
+	if s.Fixed > 0 {
+		return min(s.Fixed, left)
+	}
+	// Fixed allocations of the stages still to come are kept aside, the
+	// rest is shared by weight among the stages that have not started
+	var reserved time.Duration
+	var weights float64
+	for j, t := range b.stages {
+		if j == i || !b.started[j].IsZero() {
+			continue
+		}
+		if t.Fixed > 0 {
+			reserved += t.Fixed
+		} else {
+			weights += t.Weight
+		}
+	}
+	share := max(left-reserved, 0)
+	return time.Duration(float64(share) * s.Weight / (s.Weight + weights))
+}
+
+func (b *Budget) stop(i int, ctx context.Context, cancel context.CancelFunc) context.CancelFunc {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			r := &b.reports[i]
+			r.Spent = b.clk.Since(b.started[i])
+			r.Exceeded = ctx.Err() == context.DeadlineExceeded
+			b.mu.Unlock()
+			cancel()
+		})
+	}
+}
+
+// Remaining returns the time left in the budget, and false if it is
+// unbounded
+func (b *Budget) Remaining() (time.Duration, bool) {
+	if b.end.IsZero() {
+		return 0, false
+	}
+	return max(b.end.Sub(b.clk.Now()), 0), true
+}
+
+// Report returns the allocated and spent time of every stage, in the
+// order they were given to NewBudget
+func (b *Budget) Report() []StageReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]StageReport(nil), b.reports...)
+}
+
+// withDeadline is context.WithDeadline on clk
+func withDeadline(parent context.Context, clk clock.Clock, at time.Time) (context.Context, context.CancelFunc) {
+	if clk == clock.Real() {
+		return context.WithDeadline(parent, at)
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	c := &clockCtx{Context: ctx, deadline: at, done: make(chan struct{})}
+	var once sync.Once
+	finish := func(cause error) {
+		cancel(cause)
+		once.Do(func() { close(c.done) })
+	}
+	t := clk.AfterFunc(at.Sub(clk.Now()), func() { finish(context.DeadlineExceeded) })
+	stop := context.AfterFunc(parent, func() { finish(context.Cause(parent)) })
+	return c, func() {
+		t.Stop()
+		stop()
+		finish(context.Canceled)
+	}
+}
+
+// clockCtx is a context whose deadline is kept by a clock.Clock. It closes
+// its own done channel so that contexts derived from it do not attach to the
+// inner cancel context and take their Err from clockCtx.Err instead.
+type clockCtx struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+}
+
+func (c *clockCtx) Deadline() (time.Time, bool) {
+	if d, ok := c.Context.Deadline(); ok && d.Before(c.deadline) {
+		return d, true
+	}
+	return c.deadline, true
+}
+
+func (c *clockCtx) Done() <-chan struct{} { return c.done }
+
+func (c *clockCtx) Err() error {
+	select {
+	case <-c.done:
+	default:
+		return nil
+	}
+	if context.Cause(c.Context) == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
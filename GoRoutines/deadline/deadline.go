@@ -0,0 +1,175 @@
+// Package deadline runs work against time limits and, when a limit is
+// hit, stops it cleanly instead of walking away from it.
+//
+// The plain timeout pattern selects on the work and time.After and
+// returns ErrTimeout when the timer wins, leaving the worker running and
+// throwing away what it had done. Process cancels the worker's context
+// instead, waits a grace period for it to return, and hands back the
+// results finished so far with a *TimeoutError saying which limit was hit.
+//
+// Budget splits one deadline across stages that run in turn, by weight or
+// fixed allocation, handing time a stage did not use on to the next.
+package deadline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+// Options configure Process
+type Options struct {
+	// Total is the budget for all items; zero leaves only ctx's deadline
+	Total time.Duration
+	// PerItem is the deadline for a single item; zero means none
+	PerItem time.Duration
+	// Grace is how long to wait for fn to return once its context is
+	// cancelled [100ms]
+	Grace time.Duration
+	// Clock drives the deadlines [clock.Real()]
+	Clock clock.Clock
+}
+
+func (o Options) withDefaults() Options {
+	if o.Grace <= 0 {
+		o.Grace = 100 * time.Millisecond
+	}
+	o.Clock = clock.Or(o.Clock)
+	return o
+}
+
+// TimeoutError reports a run stopped by a deadline. It wraps
+// context.DeadlineExceeded.
+type TimeoutError struct {
+	// Processed is the number of items finished in time, Total the number
+	// of items given
+	Processed, Total int
+	// PerItem is set if the item's own deadline passed rather than the
+	// total budget or ctx's deadline
+	PerItem bool
+	// Abandoned is set if fn did not return within the grace period; it
+	// is still running
+	Abandoned bool
+}
+
+func (e *TimeoutError) Error() string {
+	what := "deadline exceeded"
+	if e.PerItem {
+		what = fmt.Sprintf("item %d exceeded its deadline", e.Processed)
+	}
+	msg := fmt.Sprintf("deadline: %s after %d of %d items", what, e.Processed, e.Total)
+	if e.Abandoned {
+		msg += ", worker abandoned"
+	}
+	return msg
+}
+
+func (e *TimeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// errItemDeadline is the cancel cause when an item's deadline passes
+var errItemDeadline = fmt.Errorf("deadline: item deadline exceeded: %w", context.DeadlineExceeded)
+
+// Process calls fn on each item in order from a single worker goroutine
+// and returns the results. When the total budget, an item's deadline or
+// ctx's deadline passes, the context given to fn is cancelled, with
+// a context.Cause wrapping context.DeadlineExceeded, and Process waits up
+// to the grace period for fn to return. It then returns the results of
+// the items finished so far with a *TimeoutError. If fn fails, Process
+// returns the earlier results and fn's error; if ctx is cancelled, the
+// earlier results and the cause.
+func Process[T, R any](ctx context.Context, items []T, fn func(context.Context, T) (R, error), opts Options) ([]R, error) {
+	opts = opts.withDefaults()
+	clk := opts.Clock
+	run, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if opts.Total > 0 {
+		t := clk.AfterFunc(opts.Total, func() { cancel(context.DeadlineExceeded) })
+		defer t.Stop()
+	}
+
+	var (
+		mu      sync.Mutex
+		results = make([]R, 0, len(items))
+		current = -1 // index of the item fn is working on
+		failed  error
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i, item := range items {
+			i := i
+			if run.Err() != nil {
+				return
+			}
+			mu.Lock()
+			current = i
+			mu.Unlock()
+			var timer clock.Timer
+			if opts.PerItem > 0 {
+				timer = clk.AfterFunc(opts.PerItem, func() {
+					mu.Lock()
+					defer mu.Unlock()
+					if current == i {
+						cancel(errItemDeadline)
+					}
+				})
+			}
+			v, err := fn(run, item)
+
+			mu.Lock()
+			current = -1
+			// Work finished after the deadline does not count
+			if run.Err() == nil {
+				if err != nil {
+					failed = err
+				} else {
+					results = append(results, v)
+				}
+			}
+			mu.Unlock()
+			if timer != nil {
+				timer.Stop()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	abandoned := false
+	select {
+	case <-done:
+	case <-run.Done():
+		grace := clk.NewTimer(opts.Grace)
+		select {
+		case <-done:
+		case <-grace.C():
+			abandoned = true
+		}
+		grace.Stop()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	out := append([]R(nil), results...)
+	if failed != nil {
+		return out, failed
+	}
+	if len(out) == len(items) {
+		return out, nil
+	}
+	cause := context.Cause(run)
+	if !errors.Is(cause, context.DeadlineExceeded) {
+		return out, cause
+	}
+	return out, &TimeoutError{
+		Processed: len(out),
+		Total:     len(items),
+		PerItem:   cause == errItemDeadline,
+		Abandoned: abandoned,
+	}
+}
@@ -0,0 +1,404 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+	"github.com/go-concurrency-lesson/internal/leaktest"
+)
+
+// work takes cost[item] of fake time per item and stops when ctx is done,
+// unless stubborn is set for the item
+type work struct {
+	clk      *clock.Fake
+	cost     map[int]time.Duration
+	stubborn map[int]bool
+	release  chan struct{}
+}
+
+func (w *work) fn(ctx context.Context, item int) (int, error) {
+	d, ok := w.cost[item]
+	if !ok {
+		return item * 10, nil
+	}
+	t := w.clk.NewTimer(d)
+	defer t.Stop()
+	if w.stubborn[item] {
+		<-w.release
+		return item * 10, nil
+	}
+	select {
+	case <-t.C():
+		return item * 10, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+type outcome struct {
+	results []int
+	err     error
+}
+
+func start(ctx context.Context, items []int, w *work, opts Options) <-chan outcome {
+	opts.Clock = w.clk
+	out := make(chan outcome, 1)
+	go func() {
+		res, err := Process(ctx, items, w.fn, opts)
+		out <- outcome{res, err}
+	}()
+	return out
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		cost    map[int]time.Duration
+		advance []time.Duration // each step waits for the worker's timer first
+		want    []int
+		perItem bool
+		ok      bool
+	}{
+		{
+			name: "no deadline",
+			want: []int{10, 20, 30, 40},
+			ok:   true,
+		},
+		{
+			name:    "within budget",
+			opts:    Options{Total: time.Second},
+			cost:    map[int]time.Duration{2: 300 * time.Millisecond, 3: 300 * time.Millisecond},
+			advance: []time.Duration{300 * time.Millisecond, 300 * time.Millisecond},
+			want:    []int{10, 20, 30, 40},
+			ok:      true,
+		},
+		{
+			name:    "total budget",
+			opts:    Options{Total: time.Second},
+			cost:    map[int]time.Duration{2: 600 * time.Millisecond, 3: 600 * time.Millisecond},
+			advance: []time.Duration{600 * time.Millisecond, 400 * time.Millisecond},
+			want:    []int{10, 20},
+		},
+		{
+			name:    "item deadline",
+			opts:    Options{Total: time.Hour, PerItem: 100 * time.Millisecond},
+			cost:    map[int]time.Duration{1: 50 * time.Millisecond, 3: time.Second},
+			advance: []time.Duration{50 * time.Millisecond, 100 * time.Millisecond},
+			want:    []int{10, 20},
+			perItem: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaktest.Check(t)
+			w := &work{clk: clock.NewFake(time.Now()), cost: tt.cost}
+			out := start(context.Background(), []int{1, 2, 3, 4}, w, tt.opts)
+			for _, d := range tt.advance {
+				// The budget timer, an item timer and the work timer
+				w.clk.BlockUntil(waiters(tt.opts))
+				w.clk.Advance(d)
+			}
+			got := <-out
+			if !equal(got.results, tt.want) {
+				t.Errorf("Process() = %v, want %v", got.results, tt.want)
+			}
+			if tt.ok {
+				if got.err != nil {
+					t.Errorf("Process() unexpected error: %v", got.err)
+				}
+				return
+			}
+			var te *TimeoutError
+			if !errors.As(got.err, &te) || !errors.Is(got.err, context.DeadlineExceeded) {
+				t.Fatalf("Process() error = %v, want a *TimeoutError", got.err)
+			}
+			if te.Processed != len(tt.want) || te.Total != 4 || te.PerItem != tt.perItem || te.Abandoned {
+				t.Errorf("TimeoutError = %+v", *te)
+			}
+		})
+	}
+}
+
+// waiters returns the number of fake timers set while an item works
+func waiters(opts Options) int {
+	n := 1
+	if opts.Total > 0 {
+		n++
+	}
+	if opts.PerItem > 0 {
+		n++
+	}
+	return n
+}
+
+func TestProcessAbandoned(t *testing.T) {
+	w := &work{
+		clk:      clock.NewFake(time.Now()),
+		cost:     map[int]time.Duration{2: time.Hour},
+		stubborn: map[int]bool{2: true},
+		release:  make(chan struct{}),
+	}
+	out := start(context.Background(), []int{1, 2, 3}, w, Options{Total: time.Second, Grace: 50 * time.Millisecond})
+	w.clk.BlockUntil(2)
+	w.clk.Advance(time.Second)
+	// Waiting out the grace period
+	w.clk.BlockUntil(2)
+	w.clk.Advance(50 * time.Millisecond)
+
+	got := <-out
+	var te *TimeoutError
+	if !errors.As(got.err, &te) || !te.Abandoned || te.Processed != 1 {
+		t.Fatalf("Process() error = %v, want an abandoned *TimeoutError after 1 item", got.err)
+	}
+	if !equal(got.results, []int{10}) {
+		t.Errorf("Process() = %v, want [10]", got.results)
+	}
+	close(w.release)
+}
+
+func TestProcessErrors(t *testing.T) {
+	leaktest.Check(t)
+	boom := errors.New("boom")
+	res, err := Process(context.Background(), []int{1, 2, 3}, func(_ context.Context, i int) (int, error) {
+		if i == 3 {
+			return 0, boom
+		}
+		return i, nil
+	}, Options{})
+	if !errors.Is(err, boom) || !equal(res, []int{1, 2}) {
+		t.Errorf("Process() = %v, %v, want [1 2], boom", res, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	res, err = Process(ctx, []int{1, 2, 3}, func(ctx context.Context, i int) (int, error) {
+		if i == 2 {
+			cancel()
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return i, nil
+	}, Options{})
+	if !errors.Is(err, context.Canceled) || !equal(res, []int{1}) {
+		t.Errorf("Process() = %v, %v, want [1], context.Canceled", res, err)
+	}
+
+	// A parent deadline is reported like the total budget
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	res, err = Process(ctx, []int{1, 2, 3}, func(ctx context.Context, i int) (int, error) {
+		if i == 2 {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return i, nil
+	}, Options{})
+	var te *TimeoutError
+	if !errors.As(err, &te) || te.PerItem || !equal(res, []int{1}) {
+		t.Errorf("Process() = %v, %v, want [1] and a *TimeoutError", res, err)
+	}
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func BenchmarkProcess(b *testing.B) {
+	items := make([]int, 1000)
+	fn := func(_ context.Context, i int) (int, error) { return i, nil }
+	for i := 0; i < b.N; i++ {
+		Process(context.Background(), items, fn, Options{Total: time.Second, PerItem: time.Second})
+	}
+}
+
+func TestBudgetAllocation(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name   string
+		stages []Stage
+		spend  []time.Duration // time each stage runs, in order
+		want   []time.Duration // allocations
+	}{
+		{
+			name:   "weights",
+			stages: []Stage{{Name: "a"}, {Name: "b", Weight: 2}, {Name: "c"}},
+			spend:  []time.Duration{250 * ms, 500 * ms, 250 * ms},
+			want:   []time.Duration{250 * ms, 500 * ms, 250 * ms},
+		},
+		{
+			name:   "unused time moves on",
+			stages: []Stage{{Name: "a"}, {Name: "b", Weight: 2}, {Name: "c"}},
+			spend:  []time.Duration{100 * ms, 300 * ms, 0},
+			want:   []time.Duration{250 * ms, 600 * ms, 600 * ms},
+		},
+		{
+			name:   "fixed reserved",
+			stages: []Stage{{Name: "a", Fixed: 200 * ms}, {Name: "b"}, {Name: "c", Fixed: 300 * ms}},
+			spend:  []time.Duration{50 * ms, 650 * ms, 0},
+			want:   []time.Duration{200 * ms, 650 * ms, 300 * ms},
+		},
+		{
+			name:   "overrun",
+			stages: []Stage{{Name: "a"}, {Name: "b"}},
+			spend:  []time.Duration{900 * ms, 0},
+			want:   []time.Duration{500 * ms, 100 * ms},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			b, err := NewBudget(context.Background(), tt.stages, BudgetOptions{Total: time.Second, Clock: clk})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range tt.stages {
+				_, done, err := b.Start(s.Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				clk.Advance(tt.spend[i])
+				done()
+			}
+			for i, r := range b.Report() {
+				if r.Allocated != tt.want[i] || r.Spent != tt.spend[i] {
+					t.Errorf("stage %s: allocated %v, spent %v, want %v, %v", r.Name, r.Allocated, r.Spent, tt.want[i], tt.spend[i])
+				}
+				if r.Exceeded != (r.Spent >= r.Allocated) {
+					t.Errorf("stage %s: Exceeded = %v", r.Name, r.Exceeded)
+				}
+			}
+		})
+	}
+}
+
+func TestBudgetStageContext(t *testing.T) {
+	leaktest.Check(t)
+	clk := clock.NewFake(time.Now())
+	b, err := NewBudget(context.Background(), []Stage{{Name: "fetch"}, {Name: "parse"}}, BudgetOptions{Total: time.Second, Clock: clk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, done, err := b.Start("fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := ctx.Deadline(); !ok || d.Sub(clk.Now()) != 500*time.Millisecond {
+		t.Errorf("Deadline() = %v, %v, want 500ms from now", d.Sub(clk.Now()), ok)
+	}
+	clk.Advance(499 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatalf("stage context done early: %v", ctx.Err())
+	}
+	clk.Advance(time.Millisecond)
+	<-ctx.Done()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("Err() = %v, want context.DeadlineExceeded", ctx.Err())
+	}
+	done()
+	if rest, ok := b.Remaining(); !ok || rest != 500*time.Millisecond {
+		t.Errorf("Remaining() = %v, %v, want 500ms", rest, ok)
+	}
+
+	// A stage runs Process under its own deadline
+	ctx, done, err = b.Start("parse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+	w := &work{clk: clk, cost: map[int]time.Duration{1: 300 * time.Millisecond, 2: 300 * time.Millisecond}}
+	out := start(ctx, []int{1, 2}, w, Options{})
+	clk.BlockUntil(2)
+	clk.Advance(300 * time.Millisecond)
+	clk.BlockUntil(2)
+	clk.Advance(200 * time.Millisecond)
+	got := <-out
+	var te *TimeoutError
+	if !errors.As(got.err, &te) || te.Processed != 1 {
+		t.Errorf("Process() in stage = %v, %v, want 1 item and a *TimeoutError", got.results, got.err)
+	}
+}
+
+func TestBudgetStageChildContext(t *testing.T) {
+	leaktest.Check(t)
+	clk := clock.NewFake(time.Now())
+	b, err := NewBudget(context.Background(), []Stage{{Name: "fetch"}}, BudgetOptions{Total: time.Second, Clock: clk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, done, err := b.Start("fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	child, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timeout, cancelTimeout := context.WithTimeout(ctx, time.Hour)
+	defer cancelTimeout()
+
+	clk.Advance(time.Second)
+	for name, c := range map[string]context.Context{"WithCancel": child, "WithTimeout": timeout} {
+		<-c.Done()
+		if !errors.Is(c.Err(), context.DeadlineExceeded) {
+			t.Errorf("%s child Err() = %v, want context.DeadlineExceeded", name, c.Err())
+		}
+		if context.Cause(c) != context.DeadlineExceeded {
+			t.Errorf("%s child Cause() = %v, want context.DeadlineExceeded", name, context.Cause(c))
+		}
+	}
+}
+
+func TestBudgetLimits(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	stages := []Stage{{Name: "a"}, {Name: "b"}}
+
+	// The parent's deadline wins over a longer Total
+	ctx, cancel := context.WithDeadline(context.Background(), clk.Now().Add(time.Hour))
+	defer cancel()
+	b, err := NewBudget(ctx, stages, BudgetOptions{Total: 2 * time.Hour, Clock: clk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := b.Remaining(); rest != time.Hour {
+		t.Errorf("Remaining() = %v, want 1h", rest)
+	}
+
+	b, _ = NewBudget(context.Background(), stages, BudgetOptions{Clock: clk})
+	if _, ok := b.Remaining(); ok {
+		t.Error("Remaining() without a deadline: want unbounded")
+	}
+	sctx, done, err := b.Start("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sctx.Deadline(); ok {
+		t.Error("unbounded stage has a deadline")
+	}
+	done()
+
+	for _, bad := range [][]Stage{
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "a", Weight: -1}},
+	} {
+		if _, err := NewBudget(context.Background(), bad, BudgetOptions{}); err == nil {
+			t.Errorf("NewBudget(%v): want an error", bad)
+		}
+	}
+	if _, _, err := b.Start("c"); !errors.Is(err, ErrUnknownStage) {
+		t.Errorf("Start() of an unknown stage = %v, want ErrUnknownStage", err)
+	}
+	if _, _, err := b.Start("a"); !errors.Is(err, ErrStageStarted) {
+		t.Errorf("Start() of a stage that already ran = %v, want ErrStageStarted", err)
+	}
+}
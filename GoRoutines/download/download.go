@@ -0,0 +1,407 @@
+// Package download streams files to disk. Each file is written to a
+// ".part" temp file next to its destination and renamed into place only
+// after it is complete and its checksum matches, so readers never see a
+// half-written file. Interrupted downloads resume with HTTP Range requests.
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrChecksumMismatch is returned when a downloaded file does not match
+// its manifest entry. The partial file is removed so the next attempt
+// starts from scratch.
+var ErrChecksumMismatch = errors.New("download: sha256 checksum mismatch")
+
+// partSuffix is appended to the destination path while downloading
+const partSuffix = ".part"
+
+// validatorSuffix names the file next to the partial file that holds the
+// ETag or Last-Modified of the response it came from, sent as If-Range on
+// resume so a file that changed on the server is downloaded again
+const validatorSuffix = partSuffix + ".validator"
+
+// ErrUnsafeFileName is returned for a file name that would leave the
+// destination directory
+var ErrUnsafeFileName = errors.New("download: unsafe file name")
+
+// errRangeMismatch is returned when a partial response does not continue
+// the partial file
+var errRangeMismatch = errors.New("download: Content-Range does not match the partial file")
+
+// StatusError is returned for unexpected HTTP status codes
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("download: %s: unexpected status %d %s", e.URL, e.Code, http.StatusText(e.Code))
+}
+
+// Result is the outcome of downloading one URL
+type Result struct {
+	URL string
+	// Path is the destination file, set even if the download failed
+	Path   string
+	Status int
+	// Bytes is the number of body bytes received by this attempt
+	Bytes int64
+	// Size is the size of the finished file
+	Size int64
+	// Resumed is true if the download continued a partial file
+	Resumed bool
+	// Skipped is true if an existing file already matched the manifest
+	Skipped  bool
+	SHA256   string
+	Duration time.Duration
+	Err      error
+}
+
+// Progress is reported on Options.Progress while a file downloads
+type Progress struct {
+	URL  string
+	Path string
+	// Bytes is the number of bytes on disk so far, including any
+	// resumed prefix
+	Bytes int64
+	// Total is the expected final size, or -1 if unknown
+	Total int64
+	Done  bool
+	Err   error
+}
+
+// Options configure a Manager
+type Options struct {
+	// Dir is the destination directory (default: current directory)
+	Dir string
+	// Client performs the requests (default: http.DefaultClient)
+	Client *http.Client
+	// Manifest maps a file name to its expected hex SHA-256; files not
+	// listed are not verified
+	Manifest map[string]string
+	// Progress, if set, receives progress events. Sends block, so the
+	// consumer must keep reading until the downloads finish.
+	Progress chan<- Progress
+	// FileName picks the destination file name for a URL
+	// (default: the last path element of the URL)
+	FileName func(rawURL string) string
+}
+
+// Manager downloads files into one directory
+type Manager struct {
+	opts Options
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// New creates a Manager
+func New(opts Options) *Manager {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.FileName == nil {
+		opts.FileName = FileNameFromURL
+	}
+	return &Manager{opts: opts, locks: make(map[string]*sync.Mutex)}
+}
+
+// Download fetches one URL into the destination directory, resuming a
+// previous partial download if one exists. It is safe to call
+// concurrently; downloads to the same file are serialized.
+func (m *Manager) Download(ctx context.Context, rawURL string) Result {
+	start := time.Now()
+	name := m.opts.FileName(rawURL)
+	if !safeFileName(name) {
+		res := Result{URL: rawURL, Err: fmt.Errorf("%w: %q", ErrUnsafeFileName, name)}
+		m.report(ctx, Progress{URL: rawURL, Done: true, Err: res.Err})
+		return res
+	}
+	res := Result{URL: rawURL, Path: filepath.Join(m.opts.Dir, name)}
+
+	unlock := m.lockPath(res.Path)
+	defer unlock()
+
+	res.Err = m.download(ctx, &res, name)
+	res.Duration = time.Since(start)
+	m.report(ctx, Progress{URL: rawURL, Path: res.Path, Bytes: res.Size, Total: res.Size, Done: true, Err: res.Err})
+	return res
+}
+
+func (m *Manager) download(ctx context.Context, res *Result, name string) (err error) {
+	want := strings.ToLower(m.opts.Manifest[name])
+	if want != "" {
+		if sum, size, err := fileSHA256(res.Path); err == nil && sum == want {
+			res.Skipped, res.SHA256, res.Size = true, sum, size
+			return nil
+		}
+	}
+
+	part := res.Path + partSuffix
+	validator := res.Path + validatorSuffix
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if f == nil {
+			return
+		}
+		info, statErr := f.Stat()
+		f.Close()
+		// Do not leave empty partial files behind for failed requests
+		if err != nil && statErr == nil && info.Size() == 0 {
+			os.Remove(part)
+			os.Remove(validator)
+		}
+	}()
+
+	// Hash the bytes we already have so the final checksum covers the
+	// whole file, not just the resumed tail
+	h := sha256.New()
+	offset, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, res.URL, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// The server sends the whole file instead if it has changed since
+		// the partial file was started
+		if v, err := os.ReadFile(validator); err == nil && len(v) > 0 {
+			req.Header.Set("If-Range", string(v))
+		}
+	}
+	resp, err := m.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	res.Status = resp.StatusCode
+
+	total := int64(-1)
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		first, size, ok := contentRange(resp.Header.Get("Content-Range"))
+		if !ok || first != offset {
+			// Splicing these bytes in would corrupt the file; empty it so
+			// the next attempt starts over
+			restart(f, h)
+			return fmt.Errorf("%w: got %q for offset %d", errRangeMismatch, resp.Header.Get("Content-Range"), offset)
+		}
+		res.Resumed = true
+		total = size
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file is already complete if it has the size the
+		// server reports; just verify and rename it
+		if _, size, ok := contentRange(resp.Header.Get("Content-Range")); ok && size != offset {
+			restart(f, h)
+			return fmt.Errorf("%w: got %q for offset %d", errRangeMismatch, resp.Header.Get("Content-Range"), offset)
+		}
+		res.Resumed = true
+		total = offset
+	case resp.StatusCode == http.StatusOK:
+		// Either a fresh download, a file that changed since the partial
+		// file was started, or the server ignored the Range header
+		if err := restart(f, h); err != nil {
+			return err
+		}
+		offset = 0
+		total = resp.ContentLength
+		if err := saveValidator(validator, resp.Header); err != nil {
+			return err
+		}
+	default:
+		return &StatusError{URL: res.URL, Code: resp.StatusCode}
+	}
+
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		w := &progressWriter{m: m, ctx: ctx, url: res.URL, path: res.Path, written: offset, total: total}
+		n, err := io.Copy(io.MultiWriter(f, h, w), resp.Body)
+		res.Bytes = n
+		if err != nil {
+			// Keep the partial file so the next attempt can resume
+			return err
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		f = nil
+		return err
+	}
+	f = nil
+
+	res.SHA256 = hex.EncodeToString(h.Sum(nil))
+	os.Remove(validator)
+	if want != "" && res.SHA256 != want {
+		os.Remove(part)
+		return fmt.Errorf("%w: %s: got %s, want %s", ErrChecksumMismatch, name, res.SHA256, want)
+	}
+	if err := os.Rename(part, res.Path); err != nil {
+		return err
+	}
+	info, err := os.Stat(res.Path)
+	if err != nil {
+		return err
+	}
+	res.Size = info.Size()
+	return nil
+}
+
+// lockPath serializes downloads to the same destination file
+func (m *Manager) lockPath(p string) func() {
+	m.mu.Lock()
+	l, ok := m.locks[p]
+	if !ok {
+		l = &sync.Mutex{}
+		m.locks[p] = l
+	}
+	m.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+func (m *Manager) report(ctx context.Context, p Progress) {
+	if m.opts.Progress == nil {
+		return
+	}
+	select {
+	case m.opts.Progress <- p:
+	case <-ctx.Done():
+	}
+}
+
+// progressWriter reports the running byte count of a download
+type progressWriter struct {
+	m       *Manager
+	ctx     context.Context
+	url     string
+	path    string
+	written int64
+	total   int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	w.m.report(w.ctx, Progress{URL: w.url, Path: w.path, Bytes: w.written, Total: w.total})
+	return len(p), nil
+}
+
+// restart empties the partial file and resets the running hash
+func restart(f *os.File, h hash.Hash) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h.Reset()
+	return nil
+}
+
+// saveValidator stores the strong ETag, or else the Last-Modified date,
+// of a response for a later If-Range. If-Range needs a strong ETag.
+func saveValidator(p string, h http.Header) error {
+	v := h.Get("ETag")
+	if v == "" || strings.HasPrefix(v, "W/") {
+		v = h.Get("Last-Modified")
+	}
+	if v == "" {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(p, []byte(v), 0o644)
+}
+
+// contentRange parses "bytes first-last/size" and "bytes */size". size is
+// -1 if the server does not know it.
+func contentRange(v string) (first, size int64, ok bool) {
+	v, ok = strings.CutPrefix(v, "bytes ")
+	if !ok {
+		return 0, 0, false
+	}
+	rng, total, ok := strings.Cut(v, "/")
+	if !ok {
+		return 0, 0, false
+	}
+	size = -1
+	if total != "*" {
+		if _, err := fmt.Sscan(total, &size); err != nil {
+			return 0, 0, false
+		}
+	}
+	first = -1
+	if rng != "*" {
+		lo, _, ok := strings.Cut(rng, "-")
+		if !ok {
+			return 0, 0, false
+		}
+		if _, err := fmt.Sscan(lo, &first); err != nil {
+			return 0, 0, false
+		}
+	}
+	return first, size, true
+}
+
+// FileNameFromURL returns the last path element of rawURL, or "index"
+// if the URL has no usable path. Names that would leave the destination
+// directory, such as "..", are replaced the same way.
+func FileNameFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "index"
+	}
+	name := path.Base(u.Path)
+	if !safeFileName(name) {
+		if u.Host != "" {
+			return u.Hostname() + "-index"
+		}
+		return "index"
+	}
+	return name
+}
+
+// safeFileName reports whether name is a plain file name that stays in
+// the directory it is joined to
+func safeFileName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, `/\`)
+}
+
+func fileSHA256(p string) (string, int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,410 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFileServer serves content at every path with Range support
+func newFileServer(content []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func testContent(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + i%26)
+	}
+	return b
+}
+
+func sha(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDownload(t *testing.T) {
+	content := testContent(100 << 10)
+	srv := newFileServer(content)
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		partial     []byte
+		manifest    map[string]string
+		wantErr     error
+		wantResumed bool
+		wantBytes   int64
+	}{
+		{"fresh download", "/file.bin", nil, nil, nil, false, int64(len(content))},
+		{"verified download", "/file.bin", nil, map[string]string{"file.bin": sha(content)}, nil, false, int64(len(content))},
+		{"resume partial file", "/file.bin", content[:40<<10], nil, nil, true, int64(len(content) - 40<<10)},
+		{"resume complete part file", "/file.bin", content, nil, nil, true, 0},
+		{"checksum mismatch", "/file.bin", nil, map[string]string{"file.bin": sha([]byte("other"))}, ErrChecksumMismatch, false, int64(len(content))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dest := filepath.Join(dir, "file.bin")
+			if tt.partial != nil {
+				if err := os.WriteFile(dest+partSuffix, tt.partial, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			m := New(Options{Dir: dir, Manifest: tt.manifest})
+			res := m.Download(context.Background(), srv.URL+tt.path)
+
+			if !errors.Is(res.Err, tt.wantErr) {
+				t.Fatalf("Download() error = %v, want %v", res.Err, tt.wantErr)
+			}
+			if res.Resumed != tt.wantResumed {
+				t.Errorf("Download() Resumed = %v, want %v", res.Resumed, tt.wantResumed)
+			}
+			if res.Bytes != tt.wantBytes {
+				t.Errorf("Download() Bytes = %d, want %d", res.Bytes, tt.wantBytes)
+			}
+			if _, err := os.Stat(dest + partSuffix); !os.IsNotExist(err) {
+				t.Errorf("partial file still exists after Download(): %v", err)
+			}
+
+			got, err := os.ReadFile(dest)
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Error("destination file exists after failed Download()")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("downloaded %d bytes that differ from the %d byte source", len(got), len(content))
+			}
+			if res.SHA256 != sha(content) || res.Size != int64(len(content)) {
+				t.Errorf("Download() SHA256, Size = %s, %d, want %s, %d", res.SHA256, res.Size, sha(content), len(content))
+			}
+		})
+	}
+}
+
+func TestDownloadServerIgnoresRange(t *testing.T) {
+	content := testContent(10 << 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "file.bin")
+	os.WriteFile(dest+partSuffix, []byte("stale bytes from another version"), 0o644)
+
+	res := New(Options{Dir: dir}).Download(context.Background(), srv.URL+"/file.bin")
+	if res.Err != nil {
+		t.Fatalf("Download() unexpected error: %v", res.Err)
+	}
+	if res.Resumed {
+		t.Error("Download() Resumed = true although the server sent the whole file")
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, content) {
+		t.Error("Download() kept stale bytes after a 200 response")
+	}
+}
+
+func TestDownloadInterruptedThenResumed(t *testing.T) {
+	content := testContent(64 << 10)
+	var calls int
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+		if first {
+			// Promise the whole file, send half, then drop the connection
+			w.Header().Set("Content-Length", "65536")
+			w.Write(content[:32<<10])
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	m := New(Options{Dir: dir, Manifest: map[string]string{"file.bin": sha(content)}})
+
+	first := m.Download(context.Background(), srv.URL+"/file.bin")
+	if first.Err == nil {
+		t.Fatal("Download() over a dropped connection unexpectedly succeeded")
+	}
+	info, err := os.Stat(filepath.Join(dir, "file.bin"+partSuffix))
+	if err != nil || info.Size() == 0 {
+		t.Fatalf("partial file not kept after interruption: %v", err)
+	}
+
+	second := m.Download(context.Background(), srv.URL+"/file.bin")
+	if second.Err != nil {
+		t.Fatalf("resumed Download() unexpected error: %v", second.Err)
+	}
+	if !second.Resumed || second.Bytes != int64(len(content))-info.Size() {
+		t.Errorf("resumed Download() Resumed, Bytes = %v, %d, want true, %d", second.Resumed, second.Bytes, int64(len(content))-info.Size())
+	}
+}
+
+func TestDownloadUnsafeFileName(t *testing.T) {
+	srv := newFileServer(testContent(10))
+	defer srv.Close()
+
+	for _, name := range []string{"", ".", "..", "../escape", `a\b`, "a/b"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			m := New(Options{Dir: filepath.Join(dir, "out"), FileName: func(string) string { return name }})
+			os.Mkdir(filepath.Join(dir, "out"), 0o755)
+			res := m.Download(context.Background(), srv.URL+"/file.bin")
+			if !errors.Is(res.Err, ErrUnsafeFileName) {
+				t.Errorf("Download() error = %v, want ErrUnsafeFileName", res.Err)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 1 {
+				t.Errorf("Download() wrote outside the destination directory: %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestDownloadResumeChangedFile(t *testing.T) {
+	v1, v2 := testContent(64<<10), bytes.Repeat([]byte("v2"), 40<<10)
+	var mu sync.Mutex
+	content, etag, drop := v1, `"v1"`, true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		body, tag, first := content, etag, drop
+		drop = false
+		mu.Unlock()
+		w.Header().Set("ETag", tag)
+		if first {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body[:32<<10])
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	m := New(Options{Dir: dir})
+	if res := m.Download(context.Background(), srv.URL+"/file.bin"); res.Err == nil {
+		t.Fatal("Download() over a dropped connection unexpectedly succeeded")
+	}
+
+	mu.Lock()
+	content, etag = v2, `"v2"`
+	mu.Unlock()
+	res := m.Download(context.Background(), srv.URL+"/file.bin")
+	if res.Err != nil {
+		t.Fatalf("Download() unexpected error: %v", res.Err)
+	}
+	if res.Resumed {
+		t.Error("Download() resumed a partial file of an older version")
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "file.bin")); !bytes.Equal(got, v2) {
+		t.Error("Download() spliced the old partial file into the new version")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file.bin"+validatorSuffix)); !os.IsNotExist(err) {
+		t.Errorf("validator file still exists after Download(): %v", err)
+	}
+}
+
+func TestDownloadContentRangeMismatch(t *testing.T) {
+	content := testContent(10 << 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "" {
+			w.Write(content)
+			return
+		}
+		// A broken server answers every range from the start
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "file.bin")
+	os.WriteFile(dest+partSuffix, content[:100], 0o644)
+	m := New(Options{Dir: dir})
+
+	if res := m.Download(context.Background(), srv.URL+"/file.bin"); res.Err == nil {
+		t.Fatal("Download() accepted a Content-Range that does not continue the partial file")
+	}
+	res := m.Download(context.Background(), srv.URL+"/file.bin")
+	if res.Err != nil || res.Resumed {
+		t.Fatalf("Download() after a bad range = Resumed %v, %v, want a fresh download", res.Resumed, res.Err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, content) {
+		t.Error("Download() after a bad range wrote the wrong bytes")
+	}
+}
+
+func TestDownloadSkipsVerifiedFile(t *testing.T) {
+	content := testContent(1024)
+	srv := newFileServer(content)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "file.bin"), content, 0o644)
+
+	m := New(Options{Dir: dir, Manifest: map[string]string{"file.bin": sha(content)}})
+	res := m.Download(context.Background(), srv.URL+"/file.bin")
+	if res.Err != nil || !res.Skipped {
+		t.Errorf("Download() = skipped %v, err %v, want skipped without error", res.Skipped, res.Err)
+	}
+}
+
+func TestDownloadStatusError(t *testing.T) {
+	srv := newFileServer(nil)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	res := New(Options{Dir: dir}).Download(context.Background(), srv.URL+"/missing")
+
+	var statusErr *StatusError
+	if !errors.As(res.Err, &statusErr) || statusErr.Code != http.StatusNotFound {
+		t.Fatalf("Download() error = %v, want StatusError 404", res.Err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Download() left %d files behind after a 404", len(entries))
+	}
+}
+
+func TestDownloadProgress(t *testing.T) {
+	content := testContent(256 << 10)
+	srv := newFileServer(content)
+	defer srv.Close()
+
+	progress := make(chan Progress)
+	m := New(Options{Dir: t.TempDir(), Progress: progress})
+
+	done := make(chan Result)
+	go func() {
+		done <- m.Download(context.Background(), srv.URL+"/file.bin")
+	}()
+
+	var events []Progress
+	for p := range progress {
+		events = append(events, p)
+		if p.Done {
+			break
+		}
+	}
+	if res := <-done; res.Err != nil {
+		t.Fatalf("Download() unexpected error: %v", res.Err)
+	}
+
+	if len(events) < 2 {
+		t.Fatalf("got %d progress events, want at least 2", len(events))
+	}
+	var last int64
+	for _, p := range events {
+		if p.Bytes < last {
+			t.Errorf("progress went backwards: %d after %d", p.Bytes, last)
+		}
+		last = p.Bytes
+	}
+	final := events[len(events)-1]
+	if !final.Done || final.Bytes != int64(len(content)) || final.Total != int64(len(content)) {
+		t.Errorf("final progress = %+v, want Done with %d bytes", final, len(content))
+	}
+}
+
+func TestDownloadSameFileConcurrently(t *testing.T) {
+	content := testContent(32 << 10)
+	srv := newFileServer(content)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	m := New(Options{Dir: dir})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res := m.Download(context.Background(), srv.URL+"/file.bin"); res.Err != nil {
+				t.Errorf("Download() unexpected error: %v", res.Err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, _ := os.ReadFile(filepath.Join(dir, "file.bin")); !bytes.Equal(got, content) {
+		t.Error("concurrent downloads of the same file corrupted it")
+	}
+}
+
+func TestFileNameFromURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/a/b/file.tar.gz", "file.tar.gz"},
+		{"http://example.com/file.txt?version=2", "file.txt"},
+		{"http://example.com/", "example.com-index"},
+		{"http://example.com", "example.com-index"},
+		{"::bad", "index"},
+		{"http://example.com/..", "example.com-index"},
+		{"http://example.com/a/%2e%2e", "example.com-index"},
+		{"http://example.com/.", "example.com-index"},
+		{"http://example.com/a%5Cb", "example.com-index"},
+		{"/..", "index"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := FileNameFromURL(tt.url); got != tt.want {
+				t.Errorf("FileNameFromURL(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	sum := sha([]byte("x"))
+	input := "# checksums\n" + sum + "  a.txt\n\n" + strings.ToUpper(sum) + " *b.bin\n"
+
+	manifest, err := ParseManifest(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseManifest() unexpected error: %v", err)
+	}
+	if manifest["a.txt"] != sum || manifest["b.bin"] != sum || len(manifest) != 2 {
+		t.Errorf("ParseManifest() = %v", manifest)
+	}
+
+	if _, err := ParseManifest(strings.NewReader("not a checksum line\n")); err == nil {
+		t.Error("ParseManifest() with malformed line expected error")
+	}
+}
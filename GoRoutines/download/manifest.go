@@ -0,0 +1,35 @@
+package download
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseManifest reads checksums in the format written by sha256sum:
+// one "<hex digest>  <file name>" pair per line. Blank lines and lines
+// starting with '#' are ignored.
+func ParseManifest(r io.Reader) (map[string]string, error) {
+	manifest := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 || len(fields[0]) != 64 {
+			return nil, fmt.Errorf("download: manifest line %d: want \"<sha256>  <name>\"", line)
+		}
+		// sha256sum marks binary mode with a leading '*'
+		name := strings.TrimPrefix(fields[1], "*")
+		manifest[name] = strings.ToLower(fields[0])
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
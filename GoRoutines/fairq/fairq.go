@@ -0,0 +1,327 @@
+// Package fairq is a job queue that shares a worker pool fairly between
+// tenants.
+//
+// Every tenant has its own bounded queue. Workers take jobs from the
+// tenants in deficit round-robin order: on each turn a tenant earns
+// Quantum × Weight credit and may dispatch jobs while their cost fits its
+// credit, so a tenant with a flood of queued jobs gets its share of the
+// workers and no more. Within a tenant, jobs with a higher priority go
+// first, and a queued job gains one priority level for every
+// AgingInterval it waits, so low priority work is never starved.
+package fairq
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+var (
+	// ErrQueueFull is returned by Push when the tenant's queue is full
+	ErrQueueFull = errors.New("fairq: tenant queue full")
+	// ErrClosed is returned by Push after Close, and by Pop once the
+	// queue is closed and empty
+	ErrClosed = errors.New("fairq: queue closed")
+)
+
+// Job is a unit of work for a tenant
+type Job[T any] struct {
+	Tenant string
+	// Priority orders jobs of the same tenant, higher first
+	Priority int
+	// Cost is what the job counts against the tenant's share [1]
+	Cost  int
+	Value T
+}
+
+// TenantOptions configure one tenant. Zero fields take the defaults from
+// Options.
+type TenantOptions struct {
+	// Weight is the tenant's share of the workers relative to the others
+	Weight int
+	// MaxQueued is how many jobs the tenant may have waiting
+	MaxQueued int
+}
+
+// Options configure a Queue
+type Options struct {
+	// Quantum is the credit a tenant of weight 1 earns per turn [1]
+	Quantum int
+	// Default applies to every tenant not listed in Tenants [weight 1,
+	// 1024 queued]
+	Default TenantOptions
+	// Tenants overrides Default for specific tenants
+	Tenants map[string]TenantOptions
+	// AgingInterval is how long a job waits to gain one priority level
+	// [1s]
+	AgingInterval time.Duration
+	// Clock measures waiting time [real time]
+	Clock clock.Clock
+}
+
+// TenantStats describe the queue of one tenant
+type TenantStats struct {
+	Queued int
+	// Dispatched counts jobs handed to workers
+	Dispatched uint64
+	// Rejected counts pushes refused with ErrQueueFull
+	Rejected uint64
+	// Waited is the total time dispatched jobs spent queued
+	Waited time.Duration
+}
+
+// Stats describe the whole queue
+type Stats struct {
+	Queued  int
+	Tenants map[string]TenantStats
+}
+
+// Queue is a multi-tenant priority queue. It is safe for concurrent use.
+type Queue[T any] struct {
+	opts Options
+	clk  clock.Clock
+
+	mu      sync.Mutex
+	tenants map[string]*tenant[T]
+	active  []*tenant[T] // tenants with queued jobs, in round-robin order
+	cursor  int          // tenant in active whose turn it is
+	queued  int
+	seq     uint64
+	closed  bool
+	changed chan struct{} // closed and replaced on every push and on Close
+}
+
+type tenant[T any] struct {
+	opts    TenantOptions
+	jobs    jobHeap[T]
+	deficit int
+	// credited is set once the tenant earned its quantum for this turn
+	credited bool
+	stats    TenantStats
+}
+
+type queued[T any] struct {
+	job  Job[T]
+	at   time.Time
+	rank time.Time // at moved back by AgingInterval per priority level
+	seq  uint64
+}
+
+// New creates a queue
+func New[T any](opts Options) *Queue[T] {
+	if opts.Quantum <= 0 {
+		opts.Quantum = 1
+	}
+	if opts.Default.Weight <= 0 {
+		opts.Default.Weight = 1
+	}
+	if opts.Default.MaxQueued <= 0 {
+		opts.Default.MaxQueued = 1024
+	}
+	if opts.AgingInterval <= 0 {
+		opts.AgingInterval = time.Second
+	}
+	return &Queue[T]{
+		opts:    opts,
+		clk:     clock.Or(opts.Clock),
+		tenants: make(map[string]*tenant[T]),
+		changed: make(chan struct{}),
+	}
+}
+
+// Push queues job without blocking. It returns ErrQueueFull if the
+// tenant already has MaxQueued jobs waiting.
+func (q *Queue[T]) Push(job Job[T]) error {
+	if job.Cost <= 0 {
+		job.Cost = 1
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	t := q.tenant(job.Tenant)
+	if t.jobs.Len() >= t.opts.MaxQueued {
+		t.stats.Rejected++
+		return ErrQueueFull
+	}
+
+	now := q.clk.Now()
+	q.seq++
+	// Ordering by the time a job would have had to arrive at priority 0
+	// to have the same aged priority keeps the heap order fixed as time
+	// passes
+	rank := now.Add(-time.Duration(job.Priority) * q.opts.AgingInterval)
+	heap.Push(&t.jobs, &queued[T]{job: job, at: now, rank: rank, seq: q.seq})
+	if t.jobs.Len() == 1 {
+		q.active = append(q.active, t)
+	}
+	q.queued++
+	close(q.changed)
+	q.changed = make(chan struct{})
+	return nil
+}
+
+// Pop waits for the next job in fair order. It returns ErrClosed once the
+// queue is closed and drained, or ctx.Err().
+func (q *Queue[T]) Pop(ctx context.Context) (Job[T], error) {
+	for {
+		q.mu.Lock()
+		if job, ok := q.next(); ok {
+			q.mu.Unlock()
+			return job, nil
+		}
+		closed, changed := q.closed, q.changed
+		q.mu.Unlock()
+		if closed {
+			return Job[T]{}, ErrClosed
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return Job[T]{}, ctx.Err()
+		}
+	}
+}
+
+// TryPop returns the next job if one is queued
+func (q *Queue[T]) TryPop() (Job[T], bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.next()
+}
+
+// Close stops new pushes. Queued jobs can still be popped.
+func (q *Queue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.changed)
+	}
+}
+
+// Len returns the number of queued jobs
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued
+}
+
+// Stats returns a snapshot of the queues
+func (q *Queue[T]) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := Stats{Queued: q.queued, Tenants: make(map[string]TenantStats, len(q.tenants))}
+	for name, t := range q.tenants {
+		ts := t.stats
+		ts.Queued = t.jobs.Len()
+		st.Tenants[name] = ts
+	}
+	return st
+}
+
+func (q *Queue[T]) tenant(name string) *tenant[T] {
+	t, ok := q.tenants[name]
+	if !ok {
+		opts, ok := q.opts.Tenants[name]
+		if !ok {
+			opts = q.opts.Default
+		}
+		if opts.Weight <= 0 {
+			opts.Weight = q.opts.Default.Weight
+		}
+		if opts.MaxQueued <= 0 {
+			opts.MaxQueued = q.opts.Default.MaxQueued
+		}
+		t = &tenant[T]{opts: opts}
+		q.tenants[name] = t
+	}
+	return t
+}
+
+// next removes the next job in deficit round-robin order. It must be
+// called with mu held.
+func (q *Queue[T]) next() (Job[T], bool) {
+	for len(q.active) > 0 {
+		if q.cursor >= len(q.active) {
+			q.cursor = 0
+		}
+		t := q.active[q.cursor]
+		if !t.credited {
+			t.deficit += q.opts.Quantum * t.opts.Weight
+			t.credited = true
+		}
+		head := t.jobs[0]
+		if head.job.Cost > t.deficit {
+			// Out of credit: keep the rest for the next turn
+			t.credited = false
+			q.cursor++
+			continue
+		}
+
+		heap.Pop(&t.jobs)
+		t.deficit -= head.job.Cost
+		t.stats.Dispatched++
+		t.stats.Waited += q.clk.Now().Sub(head.at)
+		q.queued--
+		if t.jobs.Len() == 0 {
+			// An idle tenant does not save up credit
+			t.deficit, t.credited = 0, false
+			q.active = append(q.active[:q.cursor], q.active[q.cursor+1:]...)
+		}
+		return head.job, true
+	}
+	return Job[T]{}, false
+}
+
+// jobHeap orders a tenant's jobs by aged priority, then arrival
+type jobHeap[T any] []*queued[T]
+
+func (h jobHeap[T]) Len() int { return len(h) }
+
+func (h jobHeap[T]) Less(i, j int) bool {
+	if !h[i].rank.Equal(h[j].rank) {
+		return h[i].rank.Before(h[j].rank)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h jobHeap[T]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *jobHeap[T]) Push(x any) { *h = append(*h, x.(*queued[T])) }
+
+func (h *jobHeap[T]) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+// Serve runs workers goroutines that pop jobs and pass them to fn until
+// the queue is closed and drained or ctx is cancelled. It returns when
+// every worker has exited, with ctx.Err() if ctx was cancelled.
+func Serve[T any](ctx context.Context, q *Queue[T], workers int, fn func(context.Context, Job[T])) error {
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := q.Pop(ctx)
+				if err != nil {
+					return
+				}
+				fn(ctx, job)
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
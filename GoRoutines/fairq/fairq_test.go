@@ -0,0 +1,262 @@
+package fairq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+// popAll drains the queue with TryPop and returns the job values
+func popAll[T any](q *Queue[T]) []T {
+	var out []T
+	for {
+		job, ok := q.TryPop()
+		if !ok {
+			return out
+		}
+		out = append(out, job.Value)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	q := New[string](Options{AgingInterval: time.Minute, Clock: fake})
+	for _, j := range []Job[string]{
+		{Priority: 0, Value: "low"},
+		{Priority: 2, Value: "high"},
+		{Priority: 1, Value: "mid"},
+		{Priority: 2, Value: "high2"},
+	} {
+		q.Push(j)
+	}
+	want := []string{"high", "high2", "mid", "low"}
+	if got := popAll(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("pop order = %v, want %v", got, want)
+	}
+}
+
+func TestAging(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	q := New[string](Options{AgingInterval: time.Second, Clock: fake})
+	q.Push(Job[string]{Priority: 0, Value: "old low"})
+	fake.Advance(3 * time.Second)
+	// The old job has aged to priority 3 by now
+	q.Push(Job[string]{Priority: 2, Value: "new mid"})
+	q.Push(Job[string]{Priority: 5, Value: "new high"})
+	q.Push(Job[string]{Priority: 3, Value: "new tie"})
+
+	want := []string{"new high", "old low", "new tie", "new mid"}
+	if got := popAll(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("pop order = %v, want %v", got, want)
+	}
+	if w := q.Stats().Tenants[""].Waited; w != 3*time.Second {
+		t.Errorf("Waited = %v, want 3s", w)
+	}
+}
+
+func TestFairShare(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		jobs  map[string][]int // tenant → costs
+		pops  int
+		wants map[string]int // tenant → jobs dispatched in the first pops
+	}{
+		{
+			name:  "equal weights",
+			jobs:  map[string][]int{"a": rep(1, 30), "b": rep(1, 30), "c": rep(1, 3)},
+			pops:  9,
+			wants: map[string]int{"a": 3, "b": 3, "c": 3},
+		},
+		{
+			name:  "weights",
+			opts:  Options{Tenants: map[string]TenantOptions{"a": {Weight: 3}}},
+			jobs:  map[string][]int{"a": rep(1, 30), "b": rep(1, 30)},
+			pops:  12,
+			wants: map[string]int{"a": 9, "b": 3},
+		},
+		{
+			name:  "costs",
+			opts:  Options{Quantum: 4},
+			jobs:  map[string][]int{"big": rep(4, 10), "small": rep(1, 30)},
+			pops:  10,
+			wants: map[string]int{"big": 2, "small": 8},
+		},
+		{
+			name:  "cost above quantum",
+			jobs:  map[string][]int{"big": rep(3, 5), "small": rep(1, 30)},
+			pops:  8,
+			wants: map[string]int{"big": 2, "small": 6},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := New[string](tt.opts)
+			// Push tenant by tenant, as a FIFO would then serve them
+			for _, name := range []string{"a", "b", "c", "big", "small"} {
+				for _, cost := range tt.jobs[name] {
+					if err := q.Push(Job[string]{Tenant: name, Cost: cost, Value: name}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			got := map[string]int{}
+			for i := 0; i < tt.pops; i++ {
+				job, ok := q.TryPop()
+				if !ok {
+					t.Fatalf("TryPop() %d found nothing", i)
+				}
+				got[job.Tenant]++
+			}
+			if !reflect.DeepEqual(got, tt.wants) {
+				t.Errorf("first %d pops by tenant = %v, want %v", tt.pops, got, tt.wants)
+			}
+		})
+	}
+}
+
+func rep(v, n int) []int {
+	out := make([]int, n)
+	for i := range out {
+		out[i] = v
+	}
+	return out
+}
+
+func TestQueueLimit(t *testing.T) {
+	q := New[int](Options{
+		Default: TenantOptions{MaxQueued: 2},
+		Tenants: map[string]TenantOptions{"big": {MaxQueued: 3}},
+	})
+	for tenant, limit := range map[string]int{"small": 2, "big": 3} {
+		for i := 0; i < limit; i++ {
+			if err := q.Push(Job[int]{Tenant: tenant}); err != nil {
+				t.Fatalf("Push(%s) %d = %v", tenant, i, err)
+			}
+		}
+		if err := q.Push(Job[int]{Tenant: tenant}); !errors.Is(err, ErrQueueFull) {
+			t.Errorf("Push(%s) over the limit = %v, want ErrQueueFull", tenant, err)
+		}
+	}
+
+	st := q.Stats()
+	if st.Queued != 5 || st.Tenants["small"].Rejected != 1 || st.Tenants["big"].Queued != 3 {
+		t.Errorf("Stats() = %+v", st)
+	}
+	q.TryPop()
+	q.TryPop()
+	// Popping made room for small again
+	if err := q.Push(Job[int]{Tenant: "small"}); err != nil {
+		t.Errorf("Push() after a pop = %v", err)
+	}
+}
+
+func TestPopWaitsAndClose(t *testing.T) {
+	q := New[int](Options{})
+	got := make(chan int)
+	go func() {
+		job, err := q.Pop(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		got <- job.Value
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Push(Job[int]{Value: 7})
+	if v := <-got; v != 7 {
+		t.Errorf("Pop() = %d, want 7", v)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Pop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Pop() on an empty queue = %v, want context.DeadlineExceeded", err)
+	}
+
+	q.Push(Job[int]{Value: 8})
+	q.Close()
+	if err := q.Push(Job[int]{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Push() after Close = %v, want ErrClosed", err)
+	}
+	if job, err := q.Pop(context.Background()); err != nil || job.Value != 8 {
+		t.Errorf("Pop() after Close = %v, %v, want the queued job", job.Value, err)
+	}
+	if _, err := q.Pop(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Pop() on a closed, empty queue = %v, want ErrClosed", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	q := New[int](Options{})
+	var sum atomic.Int64
+	done := make(chan error)
+	go func() {
+		done <- Serve(context.Background(), q, 3, func(_ context.Context, j Job[int]) {
+			sum.Add(int64(j.Value))
+		})
+	}()
+	for i := 1; i <= 100; i++ {
+		q.Push(Job[int]{Tenant: fmt.Sprint(i % 4), Value: i})
+	}
+	q.Close()
+	if err := <-done; err != nil || sum.Load() != 5050 {
+		t.Errorf("Serve() = %v, sum %d, want nil, 5050", err, sum.Load())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Serve(ctx, New[int](Options{}), 2, func(context.Context, Job[int]) {}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Serve() cancelled = %v, want context.Canceled", err)
+	}
+}
+
+// BenchmarkNoisyTenant queues 1000 jobs of a noisy tenant ahead of 10 jobs
+// of a quiet one and reports where, on average, the quiet jobs were
+// served. In a single FIFO they wait behind the whole flood; with fair
+// queueing they are served within the first few rounds.
+func BenchmarkNoisyTenant(b *testing.B) {
+	for _, fair := range []bool{false, true} {
+		name := "fifo"
+		if fair {
+			name = "fair"
+		}
+		b.Run(name, func(b *testing.B) {
+			var quietPos float64
+			for i := 0; i < b.N; i++ {
+				q := New[bool](Options{Default: TenantOptions{MaxQueued: 2000}})
+				quiet := "quiet"
+				if !fair {
+					quiet = "noisy"
+				}
+				for j := 0; j < 1000; j++ {
+					q.Push(Job[bool]{Tenant: "noisy"})
+				}
+				for j := 0; j < 10; j++ {
+					q.Push(Job[bool]{Tenant: quiet, Value: true})
+				}
+
+				var mu sync.Mutex
+				pos, sum := 0, 0
+				q.Close()
+				Serve(context.Background(), q, 4, func(_ context.Context, j Job[bool]) {
+					mu.Lock()
+					pos++
+					if j.Value {
+						sum += pos
+					}
+					mu.Unlock()
+				})
+				quietPos += float64(sum) / 10
+			}
+			b.ReportMetric(quietPos/float64(b.N), "quiet-pos/op")
+		})
+	}
+}
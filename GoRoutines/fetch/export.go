@@ -0,0 +1,109 @@
+package fetch
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// record is the flat, serializable form of a Result shared by the JSON
+// Lines and CSV exporters
+type record struct {
+	URL       string   `json:"url"`
+	FinalURL  string   `json:"final_url,omitempty"`
+	Status    int      `json:"status"`
+	Error     string   `json:"error,omitempty"`
+	Started   string   `json:"started"`
+	DNSMs     float64  `json:"dns_ms"`
+	ConnectMs float64  `json:"connect_ms"`
+	TLSMs     float64  `json:"tls_ms"`
+	TTFBMs    float64  `json:"ttfb_ms"`
+	TotalMs   float64  `json:"total_ms"`
+	Bytes     int64    `json:"bytes"`
+	Redirects []string `json:"redirects,omitempty"`
+}
+
+func toRecord(r Result) record {
+	rec := record{
+		URL:       r.URL,
+		FinalURL:  r.FinalURL,
+		Status:    r.Status,
+		Started:   r.Started.UTC().Format(time.RFC3339Nano),
+		DNSMs:     ms(r.DNS),
+		ConnectMs: ms(r.Connect),
+		TLSMs:     ms(r.TLS),
+		TTFBMs:    ms(r.TTFB),
+		TotalMs:   ms(r.Total),
+		Bytes:     r.Bytes,
+		Redirects: r.Redirects,
+	}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+	}
+	return rec
+}
+
+// MarshalJSON encodes a Result in the same shape as WriteJSONL
+func (r Result) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toRecord(r))
+}
+
+// WriteJSONL writes one JSON object per result per line
+func WriteJSONL(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	for _, r := range results {
+		if err := enc.Encode(toRecord(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvHeader lists the CSV columns in order
+var csvHeader = []string{
+	"url", "final_url", "status", "error", "started",
+	"dns_ms", "connect_ms", "tls_ms", "ttfb_ms", "total_ms",
+	"bytes", "redirects",
+}
+
+// WriteCSV writes a header row and one row per result. Redirects are
+// joined with spaces, which cannot appear in a valid URL.
+func WriteCSV(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range results {
+		rec := toRecord(r)
+		row := []string{
+			rec.URL,
+			rec.FinalURL,
+			strconv.Itoa(rec.Status),
+			rec.Error,
+			rec.Started,
+			formatMs(rec.DNSMs),
+			formatMs(rec.ConnectMs),
+			formatMs(rec.TLSMs),
+			formatMs(rec.TTFBMs),
+			formatMs(rec.TotalMs),
+			strconv.FormatInt(rec.Bytes, 10),
+			strings.Join(rec.Redirects, " "),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func formatMs(v float64) string {
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
@@ -0,0 +1,189 @@
+// Package fetch performs HTTP GET requests and records what happened to
+// each one: status, error, DNS/connect/TLS/TTFB timings, bytes read and
+// the redirect chain. Results can be exported as JSON Lines, CSV or HAR.
+package fetch
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// maxRedirects matches the default policy of http.Client
+const maxRedirects = 10
+
+// Result describes one fetch
+type Result struct {
+	URL string
+	// FinalURL is the URL of the last response after redirects
+	FinalURL string
+	Status   int
+	Proto    string
+	Err      error
+
+	Started time.Time
+	// DNS, Connect and TLS are summed over every hop of a redirect chain;
+	// they are zero when a pooled connection was reused
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// TTFB is the time from Started to the first byte of the final response
+	TTFB time.Duration
+	// Total is the time from Started until the body was read
+	Total time.Duration
+
+	// Bytes is the number of body bytes read
+	Bytes int64
+	// Redirects lists the URLs visited after URL, in order
+	Redirects  []string
+	ReusedConn bool
+
+	RequestHeader  http.Header
+	ResponseHeader http.Header
+}
+
+// OK reports whether the request completed without a transport error
+func (r *Result) OK() bool {
+	return r.Err == nil
+}
+
+// Get fetches url with client, reading and discarding the body
+func Get(ctx context.Context, client *http.Client, url string) Result {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Result{URL: url, Started: time.Now(), Err: err}
+	}
+	res := Do(client, req, io.Discard)
+	res.URL = url
+	return res
+}
+
+// Do sends req with client and copies the response body to w
+func Do(client *http.Client, req *http.Request, w io.Writer) Result {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res := Result{URL: req.URL.String(), Started: time.Now()}
+
+	t := &tracer{start: res.Started}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), t.clientTrace()))
+	res.RequestHeader = req.Header.Clone()
+
+	// Record redirects without changing the caller's client
+	traced := *client
+	traced.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		t.redirect(next.URL.String())
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(next, via)
+		}
+		if len(via) >= maxRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+
+	resp, err := traced.Do(req)
+	if err == nil {
+		res.Status = resp.StatusCode
+		res.Proto = resp.Proto
+		res.FinalURL = resp.Request.URL.String()
+		res.ResponseHeader = resp.Header
+		res.Bytes, err = io.Copy(w, resp.Body)
+		resp.Body.Close()
+	}
+	res.Err = err
+	res.Total = time.Since(res.Started)
+	t.fill(&res)
+	return res
+}
+
+// tracer collects httptrace events. Callbacks can run concurrently when
+// several addresses are dialed, hence the mutex.
+type tracer struct {
+	start time.Time
+
+	mu           sync.Mutex
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	dns          time.Duration
+	connect      time.Duration
+	tls          time.Duration
+	firstByte    time.Time
+	reused       bool
+	redirects    []string
+}
+
+func (t *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dns += time.Since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			if err == nil && !t.connectStart.IsZero() {
+				t.connect += time.Since(t.connectStart)
+				t.connectStart = time.Time{}
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.tls += time.Since(t.tlsStart)
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Now()
+			t.mu.Unlock()
+		},
+	}
+}
+
+func (t *tracer) redirect(url string) {
+	t.mu.Lock()
+	t.redirects = append(t.redirects, url)
+	t.mu.Unlock()
+}
+
+func (t *tracer) fill(res *Result) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res.DNS = t.dns
+	res.Connect = t.connect
+	res.TLS = t.tls
+	if !t.firstByte.IsZero() {
+		res.TTFB = t.firstByte.Sub(t.start)
+	}
+	res.ReusedConn = t.reused
+	res.Redirects = t.redirects
+}
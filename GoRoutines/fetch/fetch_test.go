@@ -0,0 +1,251 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("slow"))
+	})
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/b", http.StatusFound)
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	return httptest.NewServer(mux)
+}
+
+func TestGet(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	tests := []struct {
+		name          string
+		path          string
+		wantStatus    int
+		wantBytes     int64
+		wantRedirects []string
+		wantErr       bool
+	}{
+		{"ok", "/ok", 200, 5, nil, false},
+		{"not found", "/missing", 404, 19, nil, false},
+		{"redirect chain", "/a", 200, 5, []string{"/b", "/ok"}, false},
+		{"redirect loop", "/loop", 0, 0, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Get(context.Background(), srv.Client(), srv.URL+tt.path)
+
+			if (res.Err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", res.Err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if res.Status != tt.wantStatus || res.Bytes != tt.wantBytes {
+				t.Errorf("Get() status, bytes = %d, %d, want %d, %d", res.Status, res.Bytes, tt.wantStatus, tt.wantBytes)
+			}
+			if len(res.Redirects) != len(tt.wantRedirects) {
+				t.Fatalf("Get() redirects = %v, want %v", res.Redirects, tt.wantRedirects)
+			}
+			for i, want := range tt.wantRedirects {
+				if res.Redirects[i] != srv.URL+want {
+					t.Errorf("Get() redirect[%d] = %s, want %s", i, res.Redirects[i], srv.URL+want)
+				}
+			}
+			if len(tt.wantRedirects) > 0 && res.FinalURL != srv.URL+tt.wantRedirects[len(tt.wantRedirects)-1] {
+				t.Errorf("Get() FinalURL = %s", res.FinalURL)
+			}
+			if res.TTFB <= 0 || res.Total < res.TTFB {
+				t.Errorf("Get() TTFB, Total = %v, %v, want 0 < TTFB <= Total", res.TTFB, res.Total)
+			}
+		})
+	}
+}
+
+func TestGetTimings(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	// A fresh transport forces a new connection
+	client := &http.Client{Transport: &http.Transport{}}
+	res := Get(context.Background(), client, srv.URL+"/slow")
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if res.Connect <= 0 || res.ReusedConn {
+		t.Errorf("Get() on new connection Connect = %v, ReusedConn = %v", res.Connect, res.ReusedConn)
+	}
+	if res.TTFB < 20*time.Millisecond {
+		t.Errorf("Get() TTFB = %v, want at least the 20ms handler delay", res.TTFB)
+	}
+
+	again := Get(context.Background(), client, srv.URL+"/ok")
+	if !again.ReusedConn || again.Connect != 0 {
+		t.Errorf("second Get() ReusedConn = %v, Connect = %v, want reused with no connect time", again.ReusedConn, again.Connect)
+	}
+}
+
+func TestGetErrors(t *testing.T) {
+	srv := newTestServer()
+	url := srv.URL + "/ok"
+	srv.Close()
+
+	if res := Get(context.Background(), nil, url); res.Err == nil || res.OK() {
+		t.Error("Get() on closed server expected error")
+	}
+	if res := Get(context.Background(), nil, "://bad"); res.Err == nil {
+		t.Error("Get() with malformed URL expected error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res := Get(ctx, nil, url); !errors.Is(res.Err, context.Canceled) {
+		t.Errorf("Get() with cancelled context error = %v, want context.Canceled", res.Err)
+	}
+}
+
+func TestGetKeepsClientRedirectPolicy(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res := Get(context.Background(), client, srv.URL+"/a")
+	if res.Err != nil || res.Status != http.StatusFound {
+		t.Errorf("Get() = %d, %v, want 302 without following", res.Status, res.Err)
+	}
+	if client.CheckRedirect == nil {
+		t.Error("Get() modified the caller's client")
+	}
+}
+
+// sampleResults returns a success with a redirect and a failure
+func sampleResults(t *testing.T) []Result {
+	t.Helper()
+	srv := newTestServer()
+	defer srv.Close()
+	return []Result{
+		Get(context.Background(), srv.Client(), srv.URL+"/a?x=1&y=2"),
+		Get(context.Background(), srv.Client(), "http://127.0.0.1:1/unreachable"),
+	}
+}
+
+func TestWriteJSONL(t *testing.T) {
+	results := sampleResults(t)
+	var buf bytes.Buffer
+	if err := WriteJSONL(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(results) {
+		t.Fatalf("WriteJSONL() wrote %d lines, want %d", len(lines), len(results))
+	}
+	for i, line := range lines {
+		var rec record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("line %d is not valid JSON: %v", i, err)
+		}
+		if rec.URL != results[i].URL || rec.Status != results[i].Status || rec.Bytes != results[i].Bytes {
+			t.Errorf("line %d = %+v does not match result", i, rec)
+		}
+	}
+	if !strings.Contains(lines[1], `"error"`) || strings.Contains(lines[0], `"error"`) {
+		t.Error("WriteJSONL() error field should be present only for failures")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	results := sampleResults(t)
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("WriteCSV() output is not valid CSV: %v", err)
+	}
+	if len(rows) != len(results)+1 {
+		t.Fatalf("WriteCSV() wrote %d rows, want header + %d", len(rows), len(results))
+	}
+	if strings.Join(rows[0], ",") != strings.Join(csvHeader, ",") {
+		t.Errorf("WriteCSV() header = %v", rows[0])
+	}
+	if rows[1][2] != "200" || len(strings.Fields(rows[1][11])) != 2 {
+		t.Errorf("WriteCSV() first row = %v, want status 200 and two redirects", rows[1])
+	}
+	if rows[2][3] == "" {
+		t.Error("WriteCSV() failed request has empty error column")
+	}
+}
+
+func TestWriteHAR(t *testing.T) {
+	results := sampleResults(t)
+	var buf bytes.Buffer
+	if err := WriteHAR(&buf, results, "fetch-test", "1.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc harDoc
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("WriteHAR() output is not valid JSON: %v", err)
+	}
+	if doc.Log.Version != "1.2" || doc.Log.Creator.Name != "fetch-test" {
+		t.Errorf("WriteHAR() log header = %+v", doc.Log)
+	}
+	if len(doc.Log.Entries) != len(results) {
+		t.Fatalf("WriteHAR() wrote %d entries, want %d", len(doc.Log.Entries), len(results))
+	}
+
+	ok := doc.Log.Entries[0]
+	if ok.Response.Status != 200 || ok.Response.Content.Size != 5 {
+		t.Errorf("HAR entry response = %+v", ok.Response)
+	}
+	if len(ok.Request.QueryString) != 2 || ok.Request.QueryString[0].Name != "x" {
+		t.Errorf("HAR entry queryString = %+v", ok.Request.QueryString)
+	}
+	if len(ok.Redirects) != 2 || ok.Response.RedirectURL != "" {
+		t.Errorf("HAR entry redirects = %v, redirectURL = %q, want two redirects and no redirectURL on the final 200", ok.Redirects, ok.Response.RedirectURL)
+	}
+	if ok.Timings.Send < 0 || ok.Timings.Wait < 0 || ok.Timings.Receive < 0 {
+		t.Errorf("HAR timings send/wait/receive must be non-negative: %+v", ok.Timings)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, ok.StartedDateTime); err != nil {
+		t.Errorf("HAR startedDateTime %q is not ISO 8601: %v", ok.StartedDateTime, err)
+	}
+
+	if doc.Log.Entries[1].Error == "" {
+		t.Error("HAR entry for failed request has no _error")
+	}
+
+	// A redirect that was not followed points to its target
+	e := toHAREntry(Result{URL: "http://example.com/a", Status: http.StatusFound, ResponseHeader: http.Header{"Location": {"/b"}}})
+	if e.Response.RedirectURL != "/b" {
+		t.Errorf("HAR entry for a 302 redirectURL = %q, want /b", e.Response.RedirectURL)
+	}
+}
@@ -0,0 +1,211 @@
+package fetch
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// HAR 1.2 document types, see http://www.softwareishard.com/blog/har-12-spec/.
+// Only the fields we can fill from a Result are included; the rest are
+// set to the values the spec prescribes for "unknown".
+
+type harDoc struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Error           string      `json:"_error,omitempty"`
+	Redirects       []string    `json:"_redirects,omitempty"`
+}
+
+type harRequest struct {
+	Method      string    `json:"method"`
+	URL         string    `json:"url"`
+	HTTPVersion string    `json:"httpVersion"`
+	Cookies     []harPair `json:"cookies"`
+	Headers     []harPair `json:"headers"`
+	QueryString []harPair `json:"queryString"`
+	HeadersSize int       `json:"headersSize"`
+	BodySize    int       `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int        `json:"status"`
+	StatusText  string     `json:"statusText"`
+	HTTPVersion string     `json:"httpVersion"`
+	Cookies     []harPair  `json:"cookies"`
+	Headers     []harPair  `json:"headers"`
+	Content     harContent `json:"content"`
+	RedirectURL string     `json:"redirectURL"`
+	HeadersSize int        `json:"headersSize"`
+	BodySize    int64      `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// WriteHAR writes results as a HAR 1.2 log that browser dev tools and HAR
+// viewers can open. Each Result becomes one entry; the redirect chain and
+// any error are kept in the custom _redirects and _error fields.
+func WriteHAR(w io.Writer, results []Result, creator, version string) error {
+	doc := harDoc{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: creator, Version: version},
+		Entries: make([]harEntry, 0, len(results)),
+	}}
+	for _, r := range results {
+		doc.Log.Entries = append(doc.Log.Entries, toHAREntry(r))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func toHAREntry(r Result) harEntry {
+	proto := r.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+
+	// HAR connect includes ssl; wait is the server think time after the
+	// connection was ready
+	connect := r.Connect + r.TLS
+	wait := r.TTFB - r.DNS - connect
+	if wait < 0 {
+		wait = 0
+	}
+	receive := r.Total - r.TTFB
+	if r.TTFB == 0 || receive < 0 {
+		receive = 0
+	}
+
+	e := harEntry{
+		StartedDateTime: r.Started.UTC().Format(time.RFC3339Nano),
+		Time:            ms(r.Total),
+		Request: harRequest{
+			Method:      http.MethodGet,
+			URL:         r.URL,
+			HTTPVersion: proto,
+			Cookies:     []harPair{},
+			Headers:     harHeaders(r.RequestHeader),
+			QueryString: harQuery(r.URL),
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: harResponse{
+			Status:      r.Status,
+			StatusText:  http.StatusText(r.Status),
+			HTTPVersion: proto,
+			Cookies:     []harPair{},
+			Headers:     harHeaders(r.ResponseHeader),
+			Content: harContent{
+				Size:     r.Bytes,
+				MimeType: r.ResponseHeader.Get("Content-Type"),
+			},
+			HeadersSize: -1,
+			BodySize:    r.Bytes,
+		},
+		Timings: harTimings{
+			Blocked: -1,
+			DNS:     optionalMs(r.DNS, r.ReusedConn),
+			Connect: optionalMs(connect, r.ReusedConn),
+			SSL:     optionalMs(r.TLS, r.ReusedConn || r.TLS == 0),
+			Send:    0,
+			Wait:    ms(wait),
+			Receive: ms(receive),
+		},
+		Redirects: r.Redirects,
+	}
+	// redirectURL belongs to a 3xx response; the chain that led to a final
+	// response is only in _redirects
+	if r.Status >= 300 && r.Status < 400 {
+		e.Response.RedirectURL = r.ResponseHeader.Get("Location")
+	}
+	if r.Err != nil {
+		e.Error = r.Err.Error()
+		e.Response.BodySize = -1
+	}
+	return e
+}
+
+// optionalMs returns -1, HAR's "does not apply", when the phase was skipped
+func optionalMs(d time.Duration, skipped bool) float64 {
+	if skipped {
+		return -1
+	}
+	return ms(d)
+}
+
+func harHeaders(h http.Header) []harPair {
+	pairs := []harPair{}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range h[name] {
+			pairs = append(pairs, harPair{Name: name, Value: v})
+		}
+	}
+	return pairs
+}
+
+func harQuery(rawURL string) []harPair {
+	pairs := []harPair{}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return pairs
+	}
+	q := u.Query()
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range q[name] {
+			pairs = append(pairs, harPair{Name: name, Value: v})
+		}
+	}
+	return pairs
+}
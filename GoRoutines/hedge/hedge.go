@@ -0,0 +1,161 @@
+// Package hedge cuts tail latency by racing redundant attempts.
+//
+// Do is the timeout pattern turned around: instead of giving up when an
+// attempt is slow, it starts a backup attempt and takes whichever answers
+// first. FirstOf sends the same request to several replicas at once.
+// In both cases the losers are cancelled through their context.
+package hedge
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+// Options configure Do
+type Options struct {
+	// Delay is how long to wait for an attempt before starting the next
+	// one. It is used until Tracker has MinSamples observations.
+	// Zero starts every attempt at once.
+	Delay time.Duration
+	// Tracker, if set, learns the delay from the latency of successful
+	// calls: the delay becomes the Percentile of recent latencies
+	Tracker *Tracker
+	// Percentile of Tracker used as the delay [0.95]
+	Percentile float64
+	// MinSamples Tracker needs before its percentile is trusted [20]
+	MinSamples int
+	// MaxAttempts is the total number of attempts, the first included [2]
+	MaxAttempts int
+	// Clock drives the hedge timer [clock.Real()]
+	Clock clock.Clock
+}
+
+func (o Options) withDefaults() Options {
+	if o.Percentile <= 0 || o.Percentile > 1 {
+		o.Percentile = 0.95
+	}
+	if o.MinSamples <= 0 {
+		o.MinSamples = 20
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 2
+	}
+	o.Clock = clock.Or(o.Clock)
+	return o
+}
+
+// delay returns the hedge delay for the next call
+func (o Options) delay() time.Duration {
+	if o.Tracker != nil && o.Tracker.Count() >= o.MinSamples {
+		return o.Tracker.Percentile(o.Percentile)
+	}
+	return o.Delay
+}
+
+// Do calls fn and, if it has not succeeded after the hedge delay, calls it
+// again with the next attempt number, up to MaxAttempts. A failed attempt
+// starts the next one at once. Do returns the first success and cancels
+// the context of every other attempt; if every attempt fails it returns
+// their errors joined. fn must return soon after its context is done.
+func Do[T any](ctx context.Context, opts Options, fn func(ctx context.Context, attempt int) (T, error)) (T, error) {
+	opts = opts.withDefaults()
+	var observe func(time.Duration)
+	if opts.Tracker != nil {
+		observe = opts.Tracker.Observe
+	}
+	return race(ctx, opts.Clock, opts.MaxAttempts, opts.delay(), fn, observe)
+}
+
+// FirstOf calls every replica at once and returns the first success,
+// cancelling the others. If every replica fails it returns their errors
+// joined.
+func FirstOf[T any](ctx context.Context, replicas ...func(ctx context.Context) (T, error)) (T, error) {
+	if len(replicas) == 0 {
+		var zero T
+		return zero, errors.New("hedge: no replicas")
+	}
+	return race(ctx, clock.Real(), len(replicas), 0, func(ctx context.Context, i int) (T, error) {
+		return replicas[i](ctx)
+	}, nil)
+}
+
+// race runs up to n attempts, starting a new one every delay or as soon
+// as one fails, and returns the first success
+func race[T any](ctx context.Context, clk clock.Clock, n int, delay time.Duration, fn func(context.Context, int) (T, error), observe func(time.Duration)) (T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		v    T
+		err  error
+		took time.Duration
+	}
+	// Buffered so that losers can always deliver and exit
+	results := make(chan result, n)
+
+	started, running := 0, 0
+	start := func() {
+		i := started
+		started++
+		running++
+		go func() {
+			begin := clk.Now()
+			v, err := fn(ctx, i)
+			results <- result{v, err, clk.Since(begin)}
+		}()
+	}
+
+	var timer clock.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	// next starts the attempts that are due now and returns the channel
+	// that fires when the following one is due
+	next := func() <-chan time.Time {
+		start()
+		for delay <= 0 && started < n {
+			start()
+		}
+		if started >= n {
+			return nil
+		}
+		// A fresh timer, so a stale tick of the old one is never read
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = clk.NewTimer(delay)
+		return timer.C()
+	}
+
+	var errs []error
+	due := next()
+	for {
+		select {
+		case <-due:
+			due = next()
+		case r := <-results:
+			running--
+			if r.err == nil {
+				if observe != nil {
+					observe(r.took)
+				}
+				return r.v, nil
+			}
+			errs = append(errs, r.err)
+			if started < n {
+				due = next()
+			} else if running == 0 {
+				var zero T
+				return zero, errors.Join(errs...)
+			}
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package hedge
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+	"github.com/go-concurrency-lesson/internal/leaktest"
+)
+
+// attempts records which attempts ran and which were cancelled
+type attempts struct {
+	started   atomic.Int32
+	cancelled atomic.Int32
+}
+
+// blockUntilCancelled is an attempt that never answers on its own
+func (a *attempts) blockUntilCancelled(ctx context.Context) (string, error) {
+	a.started.Add(1)
+	<-ctx.Done()
+	a.cancelled.Add(1)
+	return "", ctx.Err()
+}
+
+func TestDoHedgesAfterDelay(t *testing.T) {
+	leaktest.Check(t)
+	clk := clock.NewFake(time.Now())
+	var a attempts
+
+	done := make(chan string)
+	go func() {
+		v, err := Do(context.Background(), Options{Delay: 50 * time.Millisecond, Clock: clk},
+			func(ctx context.Context, attempt int) (string, error) {
+				if attempt == 0 {
+					return a.blockUntilCancelled(ctx)
+				}
+				a.started.Add(1)
+				return "backup", nil
+			})
+		if err != nil {
+			t.Errorf("Do() unexpected error: %v", err)
+		}
+		done <- v
+	}()
+
+	clk.BlockUntil(1)
+	leaktest.WaitFor(t, func() bool { return a.started.Load() == 1 })
+	clk.Advance(49 * time.Millisecond)
+	if got := a.started.Load(); got != 1 {
+		t.Fatalf("%d attempts before the hedge delay, want 1", got)
+	}
+	clk.Advance(time.Millisecond)
+
+	if v := <-done; v != "backup" {
+		t.Errorf("Do() = %q, want backup", v)
+	}
+	leaktest.WaitFor(t, func() bool { return a.cancelled.Load() == 1 })
+}
+
+func TestDoFastFirstAttempt(t *testing.T) {
+	leaktest.Check(t)
+	clk := clock.NewFake(time.Now())
+	var calls atomic.Int32
+
+	v, err := Do(context.Background(), Options{Delay: time.Second, Clock: clk},
+		func(ctx context.Context, attempt int) (int, error) {
+			calls.Add(1)
+			return attempt, nil
+		})
+	if err != nil || v != 0 {
+		t.Errorf("Do() = %d, %v, want 0, nil", v, err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Do() made %d attempts, want 1", calls.Load())
+	}
+	if clk.Waiters() != 0 {
+		t.Errorf("hedge timer still pending after Do returned")
+	}
+}
+
+func TestDoFailures(t *testing.T) {
+	errA, errB, errC := errors.New("a"), errors.New("b"), errors.New("c")
+
+	tests := []struct {
+		name        string
+		maxAttempts int
+		errs        []error // error of each attempt; nil succeeds
+		wantValue   int
+		wantErrs    []error
+	}{
+		{"failure starts the backup at once", 2, []error{errA, nil}, 1, nil},
+		{"third attempt wins", 3, []error{errA, errB, nil}, 2, nil},
+		{"all fail", 3, []error{errA, errB, errC}, 0, []error{errA, errB, errC}},
+		{"single attempt", 1, []error{errA}, 0, []error{errA}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaktest.Check(t)
+			// The hedge delay never expires, so only failures start attempts
+			clk := clock.NewFake(time.Now())
+			v, err := Do(context.Background(), Options{Delay: time.Hour, MaxAttempts: tt.maxAttempts, Clock: clk},
+				func(ctx context.Context, attempt int) (int, error) {
+					return attempt, tt.errs[attempt]
+				})
+			if v != tt.wantValue {
+				t.Errorf("Do() = %d, want %d", v, tt.wantValue)
+			}
+			if (err != nil) != (tt.wantErrs != nil) {
+				t.Fatalf("Do() error = %v, want %v", err, tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !errors.Is(err, want) {
+					t.Errorf("Do() error = %v, want it to include %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDoCancelled(t *testing.T) {
+	leaktest.Check(t)
+	var a attempts
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := Do(ctx, Options{Delay: time.Millisecond, MaxAttempts: 3},
+		func(ctx context.Context, attempt int) (string, error) {
+			return a.blockUntilCancelled(ctx)
+		})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do() error = %v, want context.DeadlineExceeded", err)
+	}
+	leaktest.WaitFor(t, func() bool { return a.cancelled.Load() == 3 })
+}
+
+func TestDoLearnsDelay(t *testing.T) {
+	leaktest.Check(t)
+	tracker := NewTracker(100)
+	for i := 1; i <= 100; i++ {
+		tracker.Observe(time.Duration(i) * time.Millisecond)
+	}
+
+	clk := clock.NewFake(time.Now())
+	var a attempts
+	done := make(chan error)
+	go func() {
+		// Delay is ignored once the tracker has enough samples
+		_, err := Do(context.Background(), Options{Delay: time.Hour, Tracker: tracker, Clock: clk},
+			func(ctx context.Context, attempt int) (string, error) {
+				if attempt == 0 {
+					return a.blockUntilCancelled(ctx)
+				}
+				return "", nil
+			})
+		done <- err
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(94 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("Do() hedged before the learned p95 delay")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clk.Advance(time.Millisecond)
+	if err := <-done; err != nil {
+		t.Errorf("Do() unexpected error: %v", err)
+	}
+
+	// The winning backup took no fake time and was recorded
+	if tracker.Count() != 100 || tracker.Percentile(0) != 0 {
+		t.Errorf("tracker did not record the winner: Count() = %d, min = %v", tracker.Count(), tracker.Percentile(0))
+	}
+}
+
+func TestFirstOf(t *testing.T) {
+	t.Run("fastest wins", func(t *testing.T) {
+		leaktest.Check(t)
+		var a attempts
+		v, err := FirstOf(context.Background(),
+			a.blockUntilCancelled,
+			func(ctx context.Context) (string, error) {
+				time.Sleep(5 * time.Millisecond)
+				return "fast", nil
+			},
+			a.blockUntilCancelled,
+		)
+		if err != nil || v != "fast" {
+			t.Errorf("FirstOf() = %q, %v, want fast", v, err)
+		}
+		leaktest.WaitFor(t, func() bool { return a.cancelled.Load() == 2 })
+	})
+
+	t.Run("success beats earlier failure", func(t *testing.T) {
+		leaktest.Check(t)
+		v, err := FirstOf(context.Background(),
+			func(ctx context.Context) (int, error) { return 0, errors.New("down") },
+			func(ctx context.Context) (int, error) {
+				time.Sleep(5 * time.Millisecond)
+				return 2, nil
+			},
+		)
+		if err != nil || v != 2 {
+			t.Errorf("FirstOf() = %d, %v, want 2", v, err)
+		}
+	})
+
+	t.Run("all fail", func(t *testing.T) {
+		errA, errB := errors.New("a"), errors.New("b")
+		_, err := FirstOf(context.Background(),
+			func(ctx context.Context) (int, error) { return 0, errA },
+			func(ctx context.Context) (int, error) { return 0, errB },
+		)
+		if !errors.Is(err, errA) || !errors.Is(err, errB) {
+			t.Errorf("FirstOf() error = %v, want both replica errors", err)
+		}
+	})
+
+	t.Run("no replicas", func(t *testing.T) {
+		if _, err := FirstOf[int](context.Background()); err == nil {
+			t.Error("FirstOf() with no replicas returned no error")
+		}
+	})
+}
+
+func TestTrackerPercentile(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []int // milliseconds
+		p       float64
+		want    time.Duration
+	}{
+		{"empty", nil, 0.95, 0},
+		{"single", []int{7}, 0.95, 7 * time.Millisecond},
+		{"median", []int{5, 1, 3, 2, 4}, 0.5, 3 * time.Millisecond},
+		{"p95 of 20", makeMillis(20), 0.95, 19 * time.Millisecond},
+		{"max", []int{5, 1, 3}, 1, 5 * time.Millisecond},
+		{"min", []int{5, 1, 3}, 0, time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTracker(100)
+			for _, ms := range tt.samples {
+				tr.Observe(time.Duration(ms) * time.Millisecond)
+			}
+			if got := tr.Percentile(tt.p); got != tt.want {
+				t.Errorf("Percentile(%v) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("forgets old samples", func(t *testing.T) {
+		tr := NewTracker(3)
+		for _, ms := range []int{100, 100, 100, 1, 2, 3} {
+			tr.Observe(time.Duration(ms) * time.Millisecond)
+		}
+		if tr.Count() != 3 || tr.Percentile(1) != 3*time.Millisecond {
+			t.Errorf("Count(), Percentile(1) = %d, %v, want 3, 3ms", tr.Count(), tr.Percentile(1))
+		}
+	})
+
+	t.Run("zero value", func(t *testing.T) {
+		var tr Tracker
+		if tr.Percentile(0.5) != 0 {
+			t.Errorf("Percentile() of an empty zero Tracker = %v, want 0", tr.Percentile(0.5))
+		}
+		for ms := 1; ms <= 150; ms++ {
+			tr.Observe(time.Duration(ms) * time.Millisecond)
+		}
+		if tr.Count() != 100 || tr.Percentile(0) != 51*time.Millisecond {
+			t.Errorf("Count(), Percentile(0) = %d, %v, want 100, 51ms", tr.Count(), tr.Percentile(0))
+		}
+	})
+}
+
+func makeMillis(n int) []int {
+	ms := make([]int, n)
+	for i := range ms {
+		ms[i] = i + 1
+	}
+	return ms
+}
+
+func BenchmarkFirstOf(b *testing.B) {
+	replica := func(ctx context.Context) (int, error) { return 1, nil }
+	for i := 0; i < b.N; i++ {
+		FirstOf(context.Background(), replica, replica, replica)
+	}
+}
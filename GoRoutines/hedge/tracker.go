@@ -0,0 +1,73 @@
+package hedge
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultTrackerSize is how many latencies a Tracker remembers by default
+const defaultTrackerSize = 100
+
+// Tracker keeps the most recent latencies and reports their percentiles.
+// It is safe for concurrent use. The zero value remembers the last 100.
+type Tracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+// NewTracker creates a tracker that remembers the last size latencies
+func NewTracker(size int) *Tracker {
+	if size <= 0 {
+		size = defaultTrackerSize
+	}
+	return &Tracker{samples: make([]time.Duration, size)}
+}
+
+// Observe records one latency, replacing the oldest once the tracker is full
+func (t *Tracker) Observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.samples == nil {
+		t.samples = make([]time.Duration, defaultTrackerSize)
+	}
+	t.samples[t.next] = d
+	t.next++
+	if t.next == len(t.samples) {
+		t.next = 0
+		t.full = true
+	}
+}
+
+// Count returns the number of latencies remembered
+func (t *Tracker) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.full {
+		return len(t.samples)
+	}
+	return t.next
+}
+
+// Percentile returns the latency below which a fraction p of the
+// remembered latencies fall (nearest rank), or 0 if there are none
+func (t *Tracker) Percentile(p float64) time.Duration {
+	t.mu.Lock()
+	n := t.next
+	if t.full {
+		n = len(t.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, t.samples[:n])
+	t.mu.Unlock()
+
+	if n == 0 {
+		return 0
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p*float64(n))) - 1
+	return sorted[max(0, min(rank, n-1))]
+}
@@ -1,5 +1,12 @@
 package homework
 
+import (
+	"context"
+	"math/big"
+
+	"github.com/go-concurrency-lesson/reduce"
+)
+
 // Task 1: Concurrent Computing - Parallel Sum
 //
 // OBJECTIVE: Calculate sum of numbers using parallel goroutines
@@ -16,29 +23,86 @@ package homework
 //   result := <-ch             // receive
 //   close(ch)                  // close channel
 //
+// - Deterministic parallel reduction (../reduce/reduce.go): fixed chunks
+//   merged in a fixed tree, so float results do not depend on the workers
+//   sum, err := reduce.Reduce(ctx, items, reduce.Combiner[int, int]{Zero: zero, Add: add, Merge: add}, reduce.Options{Workers: 4})
+//   f := reduce.Sum(floats, reduce.Options{Workers: 4}) // Neumaier-compensated
+//
+// - Overflow-safe int sums (../reduce/ints.go): checked or widened to big.Int
+//   sum, err := reduce.SumSquares(ctx, numbers, opts) // errors.Is(err, reduce.ErrOverflow)
+//   exact, err := reduce.BigSumSquares(ctx, numbers, opts)
+//
 // HINT: Split slice into chunks, sum each chunk in goroutine, collect results
 
 // ParallelSum calculates the sum of numbers in parallel chunks
 func ParallelSum(numbers []int, workers int) int {
-	// TODO: Implement parallel sum
-	// 1. Handle edge cases for empty slice or invalid worker count
-	// 2. Calculate chunk size by dividing total numbers by worker count
-	// 3. Create buffered channel for collecting partial sums from workers
-	// 4. Create WaitGroup to track worker completion
-	// 5. For each worker:
-	//    a. Calculate start and end indices for this worker's chunk
-	//    b. Increment WaitGroup counter
-	//    c. Launch goroutine that sums its chunk and sends result to channel
-	// 6. Launch separate goroutine to wait for all workers and close results channel
-	// 7. Collect all partial sums from results channel
-	// 8. Return total sum
-	return 0
+	return sumOf(numbers, workers, func(v int) int { return v })
 }
 
 // SquareSum calculates sum of squares in parallel
 func SquareSum(numbers []int, workers int) int {
-	// TODO: Implement parallel sum of squares
-	// 1. Same algorithm as ParallelSum
-	// 2. But square each number before adding to the sum
-	return 0
+	return sumOf(numbers, workers, func(v int) int { return v * v })
+}
+
+// sumOf adds f(v) over numbers with one chunk per worker
+func sumOf(numbers []int, workers int, f func(int) int) int {
+	if workers <= 0 || len(numbers) == 0 {
+		return 0
+	}
+	sum, _ := reduce.Reduce(context.Background(), numbers, reduce.Combiner[int, int]{
+		Zero:  func() int { return 0 },
+		Add:   func(acc, v int) int { return acc + f(v) },
+		Merge: func(a, b int) int { return a + b },
+	}, chunkPerWorker(numbers, workers))
+	return sum
+}
+
+func chunkPerWorker(numbers []int, workers int) reduce.Options {
+	return reduce.Options{Workers: workers, ChunkSize: (len(numbers) + workers - 1) / workers}
+}
+
+// ParallelSumChecked is ParallelSum that returns a *reduce.OverflowError,
+// naming the chunk, instead of a sum that wrapped around
+func ParallelSumChecked(numbers []int, workers int) (int, error) {
+	if workers <= 0 || len(numbers) == 0 {
+		return 0, nil
+	}
+	return reduce.SumInts(context.Background(), numbers, chunkPerWorker(numbers, workers))
+}
+
+// SquareSumChecked is SquareSum that returns a *reduce.OverflowError,
+// naming the chunk, instead of a sum that wrapped around
+func SquareSumChecked(numbers []int, workers int) (int, error) {
+	if workers <= 0 || len(numbers) == 0 {
+		return 0, nil
+	}
+	return reduce.SumSquares(context.Background(), numbers, chunkPerWorker(numbers, workers))
+}
+
+// ParallelSumBig returns the exact sum of numbers, however large
+func ParallelSumBig(numbers []int, workers int) *big.Int {
+	if workers <= 0 || len(numbers) == 0 {
+		return new(big.Int)
+	}
+	sum, _ := reduce.BigSumInts(context.Background(), numbers, chunkPerWorker(numbers, workers))
+	return sum
+}
+
+// SquareSumBig returns the exact sum of squares of numbers, however large
+func SquareSumBig(numbers []int, workers int) *big.Int {
+	if workers <= 0 || len(numbers) == 0 {
+		return new(big.Int)
+	}
+	sum, _ := reduce.BigSumSquares(context.Background(), numbers, chunkPerWorker(numbers, workers))
+	return sum
+}
+
+// ParallelSumFloat adds numbers in parallel with compensated summation.
+// Unlike summing one chunk per worker, the result is bit-identical for
+// every worker count.
+func ParallelSumFloat(numbers []float64, workers int) float64 {
+	if workers <= 0 {
+		return 0
+	}
+	return reduce.Sum(numbers, reduce.Options{Workers: workers})
 }
@@ -1,7 +1,18 @@
 package homework
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
 	"time"
+
+	"github.com/go-concurrency-lesson/breaker"
+	"github.com/go-concurrency-lesson/fetch"
+	"github.com/go-concurrency-lesson/hostsched"
+	"github.com/go-concurrency-lesson/limiter"
 )
 
 // Task 2: HTTP API with Concurrency
@@ -18,30 +29,246 @@ import (
 //   ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 //   defer cancel()
 //
+// - Fetch results (../fetch): status, error, httptrace timings, bytes and
+//   redirects for every URL, exportable as JSON Lines, CSV or HAR
+//   res := fetch.Get(ctx, client, url)
+//
+// - Adaptive limiter (../limiter): lets latency and errors decide how
+//   many requests run at once instead of a guessed maxConcurrent
+//   tok, err := lim.Acquire(ctx)
+//   tok.Success() / tok.Dropped()
+//
+// - Response cache (../httpcache): coalesces concurrent requests for the
+//   same URL into one fetch and caches responses by Cache-Control, with
+//   ETag revalidation and stale-while-revalidate
+//   client := httpcache.NewTransport(nil, httpcache.NewCache(1<<20, 0, nil)).Client()
+//
+// - Host scheduler (../hostsched): caps requests in flight and per second
+//   for each host and overall, serves hosts round-robin and honours
+//   Retry-After on 429
+//   sched := hostsched.New(hostsched.Options{PerHost: hostsched.Limits{MaxInFlight: 4}})
+//
+// - Crawler (../crawler): the same building blocks, following links
+//   pages, _ := crawler.Crawl(ctx, seeds, crawler.Options{MaxDepth: 2})
+//
+// - Circuit breaker (../breaker): stops sending requests to a host that
+//   keeps failing, so retries fail fast instead of hammering it
+//   client := breaker.NewTransport(nil, breaker.NewSet(breaker.Settings{})).Client()
+//
 // HINT: Launch goroutine per URL, collect results in channel
 
-// FetchURLs fetches multiple URLs concurrently and returns their status codes
-func FetchURLs(urls []string, timeout time.Duration) (map[string]int, error) {
-	// TODO: Implement concurrent URL fetching
-	// 1. Create HTTP client with timeout
-	// 2. Create result channel for collecting responses
-	// 3. Create WaitGroup for tracking goroutines
-	// 4. For each URL, launch goroutine that fetches and sends result
-	// 5. Wait for all goroutines to complete
-	// 6. Collect results into map of URL to status code
-	// 7. Return map and any errors
-	return nil, nil
+// FetchResult describes one fetched URL: status, error, timings, bytes
+// read and redirect chain. Use fetch.WriteJSONL, fetch.WriteCSV or
+// fetch.WriteHAR to export a run.
+type FetchResult = fetch.Result
+
+// FetchURLs fetches multiple URLs concurrently and returns one result per
+// URL, in the order of urls. At most DefaultMaxPerHost requests run
+// against one host at a time. Unreachable hosts are reported in their
+// result; the error is only set if the timeout expired.
+func FetchURLs(urls []string, timeout time.Duration) ([]FetchResult, error) {
+	return fetchURLs(urls, timeout, FetchOptions{})
+}
+
+// FetchURLsWithLimiter is FetchURLs with the number of requests in flight
+// controlled by an adaptive limiter. Requests rejected by a load-shedding
+// limiter fail with limiter.ErrLimitExceeded.
+func FetchURLsWithLimiter(urls []string, timeout time.Duration, lim *limiter.Limiter) ([]FetchResult, error) {
+	return fetchURLs(urls, timeout, FetchOptions{Limiter: lim})
+}
+
+// DefaultMaxPerHost is the per-host concurrency cap used when FetchOptions
+// has no Scheduler
+const DefaultMaxPerHost = 8
+
+// FetchOptions configures FetchURLsWith
+type FetchOptions struct {
+	// Client sends the requests; nil means a plain http.Client. Use an
+	// httpcache client to share duplicate and cached requests.
+	Client *http.Client
+
+	// Limiter, if set, controls how many requests are in flight
+	Limiter *limiter.Limiter
+
+	// Scheduler caps requests per host and overall and pauses hosts that
+	// answer 429; nil means DefaultMaxPerHost requests per host
+	Scheduler *hostsched.Scheduler
+
+	// Breakers, if set, guards every host with a circuit breaker.
+	// Requests to an open host fail with breaker.ErrOpen.
+	Breakers *breaker.Set
+}
+
+// FetchURLsWith is FetchURLs with a custom client, scheduler, limiter and
+// circuit breakers
+func FetchURLsWith(urls []string, timeout time.Duration, opts FetchOptions) ([]FetchResult, error) {
+	return fetchURLs(urls, timeout, opts)
+}
+
+func fetchURLs(urls []string, timeout time.Duration, opts FetchOptions) ([]FetchResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	sched := opts.Scheduler
+	if sched == nil {
+		sched = hostsched.New(hostsched.Options{PerHost: hostsched.Limits{MaxInFlight: DefaultMaxPerHost}})
+	}
+	// The breaker records calls inside the scheduler slot, so time spent
+	// queued is not taken for a slow host, and a half-open trial does not
+	// hold its token while it waits. An open host still fails before it
+	// queues.
+	transport := client.Transport
+	if opts.Breakers != nil {
+		transport = breaker.NewTransport(transport, opts.Breakers)
+	}
+	transport = hostsched.NewTransport(transport, sched)
+	if opts.Breakers != nil {
+		transport = &breakerGate{base: transport, breakers: opts.Breakers}
+	}
+	scheduled := *client
+	scheduled.Transport = transport
+	client = &scheduled
+	lim := opts.Limiter
+	results := make([]FetchResult, len(urls))
+	var wg sync.WaitGroup
+
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			results[i] = fetchOne(ctx, client, url, lim)
+		}(i, url)
+	}
+	wg.Wait()
+
+	// An unreachable host only fails its own result, but running out of
+	// time fails the whole call
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+// breakerGate rejects requests to a host whose breaker is open without
+// taking a token
+type breakerGate struct {
+	base     http.RoundTripper
+	breakers *breaker.Set
 }
 
-// FetchWithRetry fetches a URL with retry logic
+func (g *breakerGate) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if g.breakers.Get(host).State() == breaker.Open {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, &breaker.OpenError{Host: host, Err: breaker.ErrOpen}
+	}
+	return g.base.RoundTrip(req)
+}
+
+// fetchOne performs one GET, reporting the outcome to lim if it is set
+func fetchOne(ctx context.Context, client *http.Client, url string, lim *limiter.Limiter) FetchResult {
+	if lim == nil {
+		return fetch.Get(ctx, client, url)
+	}
+
+	tok, err := lim.Acquire(ctx)
+	if err != nil {
+		return FetchResult{URL: url, Started: time.Now(), Err: err}
+	}
+	res := fetch.Get(ctx, client, url)
+	switch {
+	case res.Err != nil && ctx.Err() != nil, isBreakerOpen(res.Err):
+		// Nothing was learned about the server's load
+		tok.Ignore()
+	case res.Err != nil, res.Status == http.StatusTooManyRequests, res.Status >= 500:
+		tok.Dropped()
+	default:
+		tok.Success()
+	}
+	return res
+}
+
+// CountFetched returns the number of results that received a response
+func CountFetched(results []FetchResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// FetchWithRetry fetches a URL with retry logic. Transport errors, 429
+// and 5xx responses are retried up to maxRetries times with exponential
+// backoff; timeout bounds each attempt. It returns the last status code.
+// Retries stop as soon as a circuit breaker rejects the request.
 func FetchWithRetry(url string, maxRetries int, timeout time.Duration) (int, error) {
-	// TODO: Implement fetch with exponential backoff retry
-	// 1. Create HTTP client with timeout
-	// 2. Try to fetch URL
-	// 3. If successful, return status code
-	// 4. If error, check if retryable
-	// 5. Wait with exponential backoff
-	// 6. Retry up to maxRetries times
-	// 7. Return final result or error
-	return 0, nil
+	return FetchWithRetryClient(&http.Client{}, url, maxRetries, timeout)
+}
+
+// FetchWithRetryClient is FetchWithRetry using client, so that retries can
+// go through a cache or coalescing transport
+func FetchWithRetryClient(client *http.Client, url string, maxRetries int, timeout time.Duration) (int, error) {
+	// A malformed URL fails the same way on every attempt
+	if _, err := http.NewRequest(http.MethodGet, url, nil); err != nil {
+		return 0, err
+	}
+
+	backoff := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		status, err := fetchStatus(client, url, timeout)
+		if !retryable(status, err) || attempt >= maxRetries {
+			if err == nil && retryable(status, nil) {
+				err = fmt.Errorf("fetch %s: giving up after %d attempts: status %d", url, attempt+1, status)
+			}
+			return status, err
+		}
+		time.Sleep(backoff)
+		backoff = min(2*backoff, retryMaxDelay)
+	}
+}
+
+const (
+	retryBaseDelay = 50 * time.Millisecond
+	retryMaxDelay  = 2 * time.Second
+)
+
+// fetchStatus performs one GET with its own timeout and drains the body
+// so the connection can be reused
+func fetchStatus(client *http.Client, url string, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// retryable reports whether another attempt could succeed
+func retryable(status int, err error) bool {
+	if err != nil {
+		return !isBreakerOpen(err)
+	}
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// isBreakerOpen reports whether err is a request rejected by a circuit
+// breaker rather than a failure of the server
+func isBreakerOpen(err error) bool {
+	return errors.Is(err, breaker.ErrOpen) || errors.Is(err, breaker.ErrTooManyCalls)
 }
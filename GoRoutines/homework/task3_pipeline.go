@@ -1,5 +1,16 @@
 package homework
 
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-concurrency-lesson/deadline"
+	"github.com/go-concurrency-lesson/memq"
+	"github.com/go-concurrency-lesson/pipeline"
+	"github.com/go-concurrency-lesson/safe"
+)
+
 // Task 3: Pipeline Pattern
 //
 // OBJECTIVE: Create 3-stage pipeline: generate → square → filter even
@@ -10,83 +21,202 @@ package homework
 //   go func() { for i := 0; i < n; i++ { out <- i }; close(out) }()
 //   for val := range ch { /* process */ }
 //
+// - Batching stage (../pipeline/batch.go):
+//   batches := pipeline.Batch(ctx, ch, pipeline.BatchOptions[int]{Size: 100, MaxLatency: time.Second})
+//   items := pipeline.Unbatch(ctx, batches)
+//
+// - Panic-safe stage (../pipeline/stage.go):
+//   out := pipeline.Stage(ctx, ch, fn, pipeline.StageOptions{OnPanic: safe.SkipItem, OnError: log})
+//
+// - Deadline budget across stages (../deadline/budget.go):
+//   b, _ := deadline.NewBudget(ctx, []deadline.Stage{{Name: "fetch", Weight: 2}, {Name: "parse"}}, deadline.BudgetOptions{Total: time.Second})
+//   ctx, done, err := b.Start("fetch") // unused time goes to the next stage
+//   defer done()
+//   b.Report() // allocated vs spent per stage
+//
+// - Queue bounded by bytes (../memq/memq.go): backpressure by memory,
+//   with optional spill to temp files
+//   q, _ := memq.New(memq.Options[[]byte]{MaxBytes: 64 << 20, Spill: true})
+//   out := memq.Buffer(ctx, ch, q) // q.Stats(): Bytes, PeakBytes, Spills
+//
 // HINT: Each stage returns <-chan int, chain them together
 
-// ProcessPipeline creates a 3-stage pipeline
+// ProcessPipeline creates a 3-stage pipeline. A panic while squaring a
+// number is recovered and the number left out.
 func ProcessPipeline(n int) <-chan int {
-	// TODO: Chain the three stages together
-	// 1. Call generate function to create first stage
-	// 2. Pass its output channel to square function
-	// 3. Pass square output channel to filterEven function
-	// 4. Return the final output channel
-	genChan := generate(n)
-	squareChan := square(genChan)
-	return filterEven(squareChan)
+	return ProcessPipelineFunc(context.Background(), n, func(v int) int { return v * v }, safe.SkipItem, nil)
+}
+
+// ProcessPipelineBatches groups the output of ProcessPipeline into batches
+// of at most size values for sinks that write in bulk. A partial batch is
+// flushed once its first value has waited maxWait. Cancelling ctx stops
+// every stage.
+func ProcessPipelineBatches(ctx context.Context, n, size int, maxWait time.Duration) <-chan []int {
+	return pipeline.Batch(ctx, filterEven(ctx, square(ctx, generate(ctx, n))), pipeline.BatchOptions[int]{
+		Size:       size,
+		MaxLatency: maxWait,
+	})
 }
 
-func generate(n int) <-chan int {
-	// TODO: Generate numbers from 1 to n
-	// 1. Create output channel
-	// 2. Launch goroutine that loops from 1 to n
-	// 3. Send each number to output channel
-	// 4. Close channel when loop completes
-	// 5. Return output channel immediately
+// ProcessPipelineFunc is ProcessPipeline with fn in place of square. A
+// panic in fn is recovered and passed to onError with the number that
+// caused it, and policy decides whether the pipeline goes on. Cancelling
+// ctx stops every stage.
+func ProcessPipelineFunc(ctx context.Context, n int, fn func(int) int, policy safe.Policy, onError func(error)) <-chan int {
+	// A FailFast stage stops reading its input, so the source has to be
+	// cancelled when the pipeline ends
+	ctx, cancel := context.WithCancel(ctx)
+	applied := pipeline.Stage(ctx, generate(ctx, n), func(_ context.Context, v int) (int, error) {
+		return fn(v), nil
+	}, pipeline.StageOptions{OnPanic: policy, OnError: onError})
+	evens := filterEven(ctx, applied)
 	out := make(chan int)
+	go func() {
+		defer close(out)
+		defer cancel()
+		for v := range evens {
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
 
-	// Handle edge case of n <= 0
-	if n <= 0 {
-		close(out)
-		return out
+// ProcessPipelineBudget runs the stages of ProcessPipeline one after
+// another within total: "generate" makes 1..n, "square" applies fn and
+// "filter" keeps the even values. stages splits total between them
+// [weights 1, 2, 1] and must name all three; a missing name is an
+// error wrapping deadline.ErrUnknownStage, returned before any stage
+// runs. A stage that runs out of time passes on what it finished.
+// ProcessPipelineBudget returns the values that made it through, the time
+// each stage had and took, and the first stage timeout.
+func ProcessPipelineBudget(ctx context.Context, n int, total time.Duration, stages []deadline.Stage, fn func(context.Context, int) (int, error)) ([]int, []deadline.StageReport, error) {
+	if stages == nil {
+		stages = []deadline.Stage{{Name: "generate"}, {Name: "square", Weight: 2}, {Name: "filter"}}
+	}
+	b, err := deadline.NewBudget(ctx, stages, deadline.BudgetOptions{Total: total})
+	if err != nil {
+		return nil, nil, err
 	}
 
+	var nums, squares, evens []int
+	steps := []struct {
+		name string
+		run  func(ctx context.Context) error
+	}{
+		{"generate", func(ctx context.Context) error {
+			for i := 1; i <= n; i++ {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				nums = append(nums, i)
+			}
+			return nil
+		}},
+		{"square", func(ctx context.Context) (err error) {
+			squares, err = deadline.Process(ctx, nums, fn, deadline.Options{})
+			return err
+		}},
+		{"filter", func(ctx context.Context) error {
+			for _, v := range squares {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if v%2 == 0 {
+					evens = append(evens, v)
+				}
+			}
+			return nil
+		}},
+	}
+	declared := make(map[string]bool, len(stages))
+	for _, s := range stages {
+		declared[s.Name] = true
+	}
+	for _, step := range steps {
+		if !declared[step.name] {
+			return nil, nil, fmt.Errorf("%w: %q is not in stages", deadline.ErrUnknownStage, step.name)
+		}
+	}
+
+	var firstErr error
+	for _, step := range steps {
+		ctx, done, err := b.Start(step.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := step.run(ctx); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", step.name, err)
+		}
+		done()
+	}
+	return evens, b.Report(), firstErr
+}
+
+// ProcessPipelineBuffered is ProcessPipeline with a queue bounded by
+// bytes between square and filterEven, so a slow consumer holds back the
+// producer by memory used rather than by item count, or spills to disk
+// when opts.Spill is set. The queue is returned for its Stats. Cancelling
+// ctx stops every stage.
+func ProcessPipelineBuffered(ctx context.Context, n int, opts memq.Options[int]) (<-chan int, *memq.Queue[int], error) {
+	q, err := memq.New(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	squares := square(ctx, generate(ctx, n))
+	return filterEven(ctx, memq.Buffer(ctx, squares, q)), q, nil
+}
+
+// generate sends 1 to n, stopping early when ctx is done
+func generate(ctx context.Context, n int) <-chan int {
+	out := make(chan int)
 	go func() {
+		defer close(out)
 		for i := 1; i <= n; i++ {
-			out <- i
+			select {
+			case out <- i:
+			case <-ctx.Done():
+				return
+			}
 		}
-		close(out)
 	}()
-
 	return out
 }
 
-func square(in <-chan int) <-chan int {
-	// TODO: Square each number from input channel
-	// 1. Create output channel
-	// 2. Launch goroutine that ranges over input channel
-	// 3. For each number, calculate its square
-	// 4. Send squared number to output channel
-	// 5. Close output channel when input is exhausted
-	// 6. Return output channel immediately
+// square sends the square of every number from in
+func square(ctx context.Context, in <-chan int) <-chan int {
 	out := make(chan int)
-
 	go func() {
+		defer close(out)
 		for num := range in {
-			out <- num * num
+			select {
+			case out <- num * num:
+			case <-ctx.Done():
+				return
+			}
 		}
-		close(out)
 	}()
-
 	return out
 }
 
-func filterEven(in <-chan int) <-chan int {
-	// TODO: Filter and pass only even numbers
-	// 1. Create output channel
-	// 2. Launch goroutine that ranges over input channel
-	// 3. For each number, check if it's even
-	// 4. Send only even numbers to output channel
-	// 5. Close output channel when input is exhausted
-	// 6. Return output channel immediately
+// filterEven passes on the even numbers from in
+func filterEven(ctx context.Context, in <-chan int) <-chan int {
 	out := make(chan int)
-
 	go func() {
+		defer close(out)
 		for num := range in {
-			if num%2 == 0 {
-				out <- num
+			if num%2 != 0 {
+				continue
+			}
+			select {
+			case out <- num:
+			case <-ctx.Done():
+				return
 			}
 		}
-		close(out)
 	}()
-
 	return out
 }
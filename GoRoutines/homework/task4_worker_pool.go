@@ -1,6 +1,17 @@
 package homework
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/go-concurrency-lesson/fairq"
+	"github.com/go-concurrency-lesson/pool"
+	"github.com/go-concurrency-lesson/safe"
+	"github.com/go-concurrency-lesson/walq"
+)
 
 // Task 4: Worker Pool Pattern
 //
@@ -11,27 +22,155 @@ import "context"
 // - context: https://pkg.go.dev/context
 //   ctx.Done() returns <-chan struct{} that closes on cancellation
 //
+// - Generic worker pool (../pool/pool.go):
+//   results := pool.Run(ctx, numWorkers, jobsCh, fn)         // completion order
+//   results := pool.RunOrdered(ctx, numWorkers, jobsCh, fn)  // input order
+//
+// - Panic recovery (../safe/safe.go):
+//   results, err := pool.MapSafe(ctx, jobs, fn, pool.Options{Workers: n, OnPanic: safe.SkipItem})
+//   // a panicking job becomes a *safe.PanicError instead of crashing the process
+//
+// - Fair scheduling across tenants (../fairq/fairq.go):
+//   q := fairq.New[int](fairq.Options{Tenants: map[string]fairq.TenantOptions{"a": {Weight: 2}}})
+//   q.Push(fairq.Job[int]{Tenant: "a", Priority: 1, Value: job})
+//   fairq.Serve(ctx, q, numWorkers, fn)
+//
+// - Crash-safe queue on disk (../walq/walq.go):
+//   q, err := walq.Open(dir, walq.Options{})
+//   q.Enqueue(payload)
+//   walq.Drain(ctx, q, numWorkers, fn)  // acks on success, retries on error
+//
 // HINT: Create jobs channel, start workers, send jobs, collect results
 
-// WorkerPool processes jobs using fixed number of workers
+// WorkerPool processes jobs using fixed number of workers. A job that
+// panics is recovered and left out.
 func WorkerPool(jobs []int, numWorkers int) []int {
-	// TODO: Implement worker pool
-	// 1. Create jobs channel and results channel
-	// 2. Start fixed number of worker goroutines
-	// 3. Each worker reads from jobs channel, processes job, sends to results
-	// 4. Send all jobs to jobs channel and close it
-	// 5. Collect all results from results channel
-	// 6. Return results slice
-	return nil
+	results, _ := WorkerPoolFunc(context.Background(), jobs, numWorkers, double, safe.SkipItem)
+	return results
 }
 
-// WorkerPoolWithContext adds cancellation support
+// WorkerPoolWithContext adds cancellation support. It returns the results
+// finished so far and ctx.Err() if ctx is cancelled, or the
+// *safe.PanicError of the first job that panicked.
 func WorkerPoolWithContext(ctx context.Context, jobs []int, numWorkers int) ([]int, error) {
-	// TODO: Implement worker pool with context cancellation
-	// 1. Create jobs and results channels
-	// 2. Start workers that check context cancellation in select
-	// 3. Send jobs or stop if context is cancelled
-	// 4. Collect results or return error if cancelled
-	// 5. Return partial results and context error if cancelled
-	return nil, nil
+	return WorkerPoolFunc(ctx, jobs, numWorkers, double, safe.FailFast)
+}
+
+func double(job int) int { return job * 2 }
+
+// WorkerPoolFunc runs fn over jobs like WorkerPoolWithContext, but a
+// panic in fn is recovered and handled by policy. It returns the results
+// of the jobs that succeeded, in job order, and the panics joined into
+// one error; with safe.FailFast it stops at the first panic.
+func WorkerPoolFunc(ctx context.Context, jobs []int, numWorkers int, fn func(int) int, policy safe.Policy) ([]int, error) {
+	if numWorkers <= 0 {
+		return []int{}, nil
+	}
+	res, err := pool.MapSafe(ctx, jobs, func(_ context.Context, job int) (int, error) {
+		return fn(job), nil
+	}, pool.Options{Workers: numWorkers, OnPanic: policy})
+
+	results := []int{}
+	var errs []error
+	for _, r := range res {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+			continue
+		}
+		results = append(results, r.Value)
+	}
+	if policy == safe.FailFast {
+		return results, err
+	}
+	return results, errors.Join(append(errs, err)...)
+}
+
+// WorkerPoolScheduled processes jobs with numWorkers workers, taking them
+// in fair order across tenants and by priority within a tenant instead of
+// in slice order. It returns the doubled values in the order the workers
+// took them. A tenant whose MaxQueued opts leave unset may queue all of
+// its jobs; jobs over a limit that is set are left out and reported in
+// the error.
+func WorkerPoolScheduled(ctx context.Context, jobs []fairq.Job[int], numWorkers int, opts fairq.Options) ([]int, error) {
+	if numWorkers <= 0 {
+		return []int{}, nil
+	}
+	q := fairq.New[int](fitQueueLimits(jobs, opts))
+	var errs []error
+	for i, job := range jobs {
+		if err := q.Push(job); err != nil {
+			errs = append(errs, fmt.Errorf("job %d of %q: %w", i, job.Tenant, err))
+		}
+	}
+	q.Close()
+
+	var mu sync.Mutex
+	results := []int{}
+	err := fairq.Serve(ctx, q, numWorkers, func(_ context.Context, job fairq.Job[int]) {
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, job.Value*2)
+	})
+	return results, errors.Join(append(errs, err)...)
+}
+
+// fitQueueLimits returns opts with MaxQueued raised to the number of jobs
+// of each tenant that has no limit set, since every job is pushed before
+// the workers start
+func fitQueueLimits(jobs []fairq.Job[int], opts fairq.Options) fairq.Options {
+	if opts.Default.MaxQueued > 0 {
+		return opts
+	}
+	counts := map[string]int{}
+	for _, job := range jobs {
+		counts[job.Tenant]++
+	}
+	tenants := make(map[string]fairq.TenantOptions, len(counts))
+	for name, t := range opts.Tenants {
+		tenants[name] = t
+	}
+	for name, n := range counts {
+		t, ok := tenants[name]
+		if !ok {
+			t = opts.Default
+		}
+		if t.MaxQueued <= 0 {
+			t.MaxQueued = n
+			tenants[name] = t
+		}
+	}
+	opts.Tenants = tenants
+	return opts
+}
+
+// WorkerPoolDurable queues jobs in a write-ahead log in dir and processes
+// them with numWorkers workers, so a run that dies halfway can be resumed:
+// calling it again with no jobs finishes what the last run left. It
+// returns the doubled values of the jobs it processed, in completion
+// order.
+func WorkerPoolDurable(ctx context.Context, dir string, jobs []int, numWorkers int) ([]int, error) {
+	q, err := walq.Open(dir, walq.Options{})
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if _, err := q.Enqueue([]byte(strconv.Itoa(job))); err != nil {
+			q.Close()
+			return nil, err
+		}
+	}
+
+	var mu sync.Mutex
+	results := []int{}
+	err = walq.Drain(ctx, q, max(numWorkers, 1), func(_ context.Context, m walq.Message) error {
+		job, err := strconv.Atoi(string(m.Payload))
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, job*2)
+		return nil
+	})
+	return results, errors.Join(err, q.Close())
 }
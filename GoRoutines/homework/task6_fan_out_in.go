@@ -1,5 +1,13 @@
 package homework
 
+import (
+	"context"
+	"errors"
+
+	"github.com/go-concurrency-lesson/pool"
+	"github.com/go-concurrency-lesson/safe"
+)
+
 // Task 6: Fan-Out/Fan-In Pattern
 //
 // OBJECTIVE: Distribute work to workers (fan-out), collect results (fan-in)
@@ -8,16 +16,56 @@ package homework
 // - sync.WaitGroup: https://pkg.go.dev/sync#WaitGroup
 // - Channels for distribution and collection
 //
+// - Generic fan-out/fan-in (../pool/pool.go):
+//   outs := pool.FanOut(ctx, in, numWorkers, fn)  // one output per worker
+//   merged := pool.Merge(ctx, outs...)
+//
+// - Panic recovery (../safe/safe.go):
+//   results := pool.RunSafe(ctx, in, fn, pool.Options{Workers: n, OnPanic: safe.RestartWorker})
+//   // every result carries its input and an error, a *safe.PanicError if fn panicked
+//
 // HINT: Create input channel, start workers reading from it, merge outputs
 
-// FanOutFanIn distributes work across workers and collects results
+// FanOutFanIn distributes work across workers and collects results. A
+// number whose processing panics is recovered and left out.
 func FanOutFanIn(numbers []int, numWorkers int) []int {
-	// TODO: Implement fan-out/fan-in pattern
-	// 1. Create input channel for distributing work
-	// 2. Start multiple worker goroutines reading from input channel
-	// 3. Each worker processes numbers and sends to its output channel
-	// 4. Merge all worker output channels into single channel
-	// 5. Collect all results from merged channel
-	// 6. Return results slice
-	return nil
+	results, _ := FanOutFanInFunc(numbers, numWorkers, func(n int) int { return n * 3 }, safe.SkipItem)
+	return results
+}
+
+// FanOutFanInFunc distributes numbers over numWorkers workers running fn
+// and collects the results in completion order. A panic in fn is
+// recovered and handled by policy; the panics are returned joined into
+// one error, and with safe.FailFast the first one ends the run.
+func FanOutFanInFunc(numbers []int, numWorkers int, fn func(int) int, policy safe.Policy) ([]int, error) {
+	results := []int{}
+	if numWorkers <= 0 {
+		return results, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for _, n := range numbers {
+			select {
+			case in <- n:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var errs []error
+	for r := range pool.RunSafe(ctx, in, func(_ context.Context, n int) (int, error) {
+		return fn(n), nil
+	}, pool.Options{Workers: numWorkers, OnPanic: policy}) {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+			continue
+		}
+		results = append(results, r.Value)
+	}
+	return results, errors.Join(errs...)
 }
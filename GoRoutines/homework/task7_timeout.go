@@ -1,8 +1,12 @@
 package homework
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
+
+	"github.com/go-concurrency-lesson/deadline"
 )
 
 // Task 7: Timeout Pattern
@@ -14,18 +18,60 @@ import (
 //   timeout := time.After(5 * time.Second)
 //   select { case <-timeout: /* handle timeout */ }
 //
+// - Hedged requests (../hedge): instead of failing a slow attempt, start
+//   a backup after a delay and keep whichever answers first
+//   v, err := hedge.Do(ctx, hedge.Options{Delay: 50 * time.Millisecond}, fn)
+//   v, err := hedge.FirstOf(ctx, replicaA, replicaB)
+//
+// - Timer wheel (../wheel): when a service holds many timeouts, one
+//   scheduler with a single clock timer replaces a time.Timer per call
+//   sched := wheel.New(wheel.Options{})
+//   t := sched.After(timeout, func() { close(expired) })
+//   defer t.Cancel()
+//
+// - Deadlines that stop the work (../deadline/deadline.go): cancels the
+//   worker instead of abandoning it and keeps the partial results
+//   results, err := deadline.Process(ctx, data, fn, deadline.Options{Total: time.Second, PerItem: 10 * time.Millisecond})
+//   var te *deadline.TimeoutError // errors.As(err, &te): te.Processed, te.PerItem
+//
 // HINT: Use select with time.After and done channel
 
 var ErrTimeout = errors.New("processing timeout exceeded")
 
-// ProcessWithTimeout processes data with a timeout
+// ProcessWithTimeout processes data with a timeout. On timeout it returns
+// the number of items processed so far and an error matching both
+// ErrTimeout and context.DeadlineExceeded. A timeout that is not positive
+// has already passed, so nothing is processed.
 func ProcessWithTimeout(data []int, timeout time.Duration) (int, error) {
-	// TODO: Implement processing with timeout
-	// 1. Create done channel for completion signal
-	// 2. Launch goroutine to process all data items
-	// 3. Use select statement with timeout channel
-	// 4. If done channel receives first, return count
-	// 5. If timeout channel receives first, return error
-	// 6. Return processed count or timeout error
-	return 0, nil
+	if timeout <= 0 {
+		// deadline.Options reads a zero Total as no limit
+		return 0, fmt.Errorf("%w: %w", ErrTimeout, &deadline.TimeoutError{Total: len(data)})
+	}
+	results, err := ProcessWithDeadlines(context.Background(), data, deadline.Options{Total: timeout})
+	return len(results), err
+}
+
+// ProcessWithDeadlines processes data under the total budget and per-item
+// deadline in opts. When a deadline passes, the item being processed is
+// cancelled and the results finished so far are returned with an error
+// wrapping both ErrTimeout and a *deadline.TimeoutError.
+func ProcessWithDeadlines(ctx context.Context, data []int, opts deadline.Options) ([]int, error) {
+	results, err := deadline.Process(ctx, data, processItem, opts)
+	var te *deadline.TimeoutError
+	if errors.As(err, &te) {
+		err = fmt.Errorf("%w: %w", ErrTimeout, te)
+	}
+	return results, err
+}
+
+// processItem stands in for a millisecond of real work on v
+func processItem(ctx context.Context, v int) (int, error) {
+	t := time.NewTimer(time.Millisecond)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return v * 2, nil
+	case <-ctx.Done():
+		return 0, context.Cause(ctx)
+	}
 }
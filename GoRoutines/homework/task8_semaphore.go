@@ -1,8 +1,18 @@
 package homework
 
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/go-concurrency-lesson/download"
+	"github.com/go-concurrency-lesson/limiter"
+	"github.com/go-concurrency-lesson/syncx"
+)
+
 // Task 8: Semaphore Pattern
 //
-// OBJECTIVE: Limit concurrent operations using buffered channel
+// OBJECTIVE: Limit concurrent operations using a semaphore
 //
 // PACKAGES TO USE:
 // - Buffered channel as semaphore:
@@ -10,19 +20,148 @@ package homework
 //   sem <- struct{}{}  // acquire
 //   <-sem              // release
 //
+// - Weighted semaphore (../syncx/semaphore.go):
+//   sem := syncx.NewSemaphore(capacity)
+//   err := sem.Acquire(ctx, weight)  // blocks until weight fits or ctx is done
+//   sem.Release(weight)
+//
+// - Adaptive limiter (../limiter/limiter.go):
+//   lim := limiter.New(&limiter.Gradient{}, limiter.Options{})
+//   tok, err := lim.Acquire(ctx)
+//   tok.Success() / tok.Dropped()  // feeds latency and errors back
+//
+// - Download manager (../download/download.go):
+//   m := download.New(download.Options{Dir: dir})
+//   res := m.Download(ctx, url)  // temp file, resume, checksum, rename
+//
+// - Circuit breaker (../breaker/transport.go): downloads from a host that
+//   keeps failing are rejected at once instead of holding a slot
+//   client := breaker.NewTransport(nil, breaker.NewSet(breaker.Settings{})).Client()
+//   m := download.New(download.Options{Dir: dir, Client: client})
+//
 // HINT: Acquire before goroutine, defer release inside goroutine
+//
+// The buffered channel treats every download the same, cannot give up
+// waiting and cannot be resized. syncx.Semaphore fixes all three, so a
+// large file can take several slots of the budget.
+
+// DownloadResult is the outcome of downloading one URL
+type DownloadResult = download.Result
+
+// ConcurrentDownloader downloads files into dir with max concurrent limit.
+// It returns one result per URL, in the order of urls.
+func ConcurrentDownloader(urls []string, maxConcurrent int, dir string) []DownloadResult {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	// Every download weighs 1, so the capacity is the number of slots
+	m := download.New(download.Options{Dir: dir})
+	results, _ := WeightedConcurrentDownloader(context.Background(), m, urls, nil, int64(maxConcurrent))
+	return results
+}
+
+// WeightedConcurrentDownloader downloads files while limiting the total
+// expected size in flight instead of the number of downloads.
+// sizes maps a URL to its expected size in the same unit as maxInFlight;
+// URLs without a known size weigh 1. A file larger than maxInFlight runs
+// alone. It returns one result per URL and ctx.Err() if ctx was cancelled
+// before every download started; those downloads carry the same error.
+func WeightedConcurrentDownloader(ctx context.Context, m *download.Manager, urls []string, sizes map[string]int64, maxInFlight int64) ([]DownloadResult, error) {
+	if maxInFlight <= 0 {
+		return nil, nil
+	}
+
+	sem := syncx.NewSemaphore(maxInFlight)
+	results := make([]DownloadResult, len(urls))
+	var wg sync.WaitGroup
+
+	var err error
+	for i, url := range urls {
+		weight := downloadWeight(sizes[url], maxInFlight)
+		if err = sem.Acquire(ctx, weight); err != nil {
+			markNotStarted(results[i:], urls[i:], err)
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, url string, weight int64) {
+			defer wg.Done()
+			defer sem.Release(weight)
+			results[i] = m.Download(ctx, url)
+		}(i, url, weight)
+	}
+
+	wg.Wait()
+	return results, err
+}
+
+// AdaptiveConcurrentDownloader downloads files with the concurrency limit
+// chosen by lim from observed latency and errors. Downloads rejected by a
+// load-shedding limiter fail with limiter.ErrLimitExceeded. It returns one
+// result per URL and ctx.Err() if ctx was cancelled while waiting for a
+// slot.
+func AdaptiveConcurrentDownloader(ctx context.Context, m *download.Manager, urls []string, lim *limiter.Limiter) ([]DownloadResult, error) {
+	results := make([]DownloadResult, len(urls))
+	var wg sync.WaitGroup
+
+	var err error
+	for i, url := range urls {
+		tok, acqErr := lim.Acquire(ctx)
+		if errors.Is(acqErr, limiter.ErrLimitExceeded) {
+			results[i] = DownloadResult{URL: url, Err: acqErr}
+			continue
+		}
+		if acqErr != nil {
+			err = acqErr
+			markNotStarted(results[i:], urls[i:], err)
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, url string, tok *limiter.Token) {
+			defer wg.Done()
+			res := m.Download(ctx, url)
+			switch {
+			case res.Err == nil:
+				tok.Success()
+			case ctx.Err() != nil:
+				tok.Ignore()
+			default:
+				tok.Dropped()
+			}
+			results[i] = res
+		}(i, url, tok)
+	}
+
+	wg.Wait()
+	return results, err
+}
+
+// CountSuccessful returns the number of downloads that finished without error
+func CountSuccessful(results []DownloadResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// markNotStarted fills in results for downloads that never started
+func markNotStarted(results []DownloadResult, urls []string, err error) {
+	for i, url := range urls {
+		results[i] = DownloadResult{URL: url, Err: err}
+	}
+}
 
-// ConcurrentDownloader downloads files with max concurrent limit
-func ConcurrentDownloader(urls []string, maxConcurrent int) int {
-	// TODO: Implement semaphore pattern
-	// 1. Create buffered channel as semaphore with size maxConcurrent
-	// 2. Create WaitGroup and success counter with mutex
-	// 3. For each URL:
-	//    a. Send to semaphore channel to acquire slot
-	//    b. Launch goroutine
-	//    c. In goroutine, defer receive from semaphore to release slot
-	//    d. Simulate download and increment success counter
-	// 4. Wait for all goroutines to complete
-	// 5. Return success count
-	return 0
+// downloadWeight clamps an expected size to a valid semaphore weight
+func downloadWeight(size, maxInFlight int64) int64 {
+	if size <= 0 {
+		return 1
+	}
+	if size > maxInFlight {
+		return maxInFlight
+	}
+	return size
 }
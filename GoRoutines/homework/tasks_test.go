@@ -2,9 +2,30 @@ package homework
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/go-concurrency-lesson/breaker"
+	"github.com/go-concurrency-lesson/deadline"
+	"github.com/go-concurrency-lesson/download"
+	"github.com/go-concurrency-lesson/fairq"
+	"github.com/go-concurrency-lesson/hostsched"
+	"github.com/go-concurrency-lesson/httpcache"
+	"github.com/go-concurrency-lesson/internal/leaktest"
+	"github.com/go-concurrency-lesson/limiter"
+	"github.com/go-concurrency-lesson/memq"
+	"github.com/go-concurrency-lesson/reduce"
+	"github.com/go-concurrency-lesson/safe"
 )
 
 // Task 1: ParallelSum Tests
@@ -73,6 +94,107 @@ func TestSquareSum(t *testing.T) {
 	}
 }
 
+func TestParallelSumFloat(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	numbers := make([]float64, 50000)
+	for i := range numbers {
+		numbers[i] = rng.NormFloat64() * math.Pow(10, float64(rng.Intn(20)-10))
+	}
+	want := ParallelSumFloat(numbers, 1)
+	for _, workers := range []int{2, 3, 4, 8} {
+		if got := ParallelSumFloat(numbers, workers); math.Float64bits(got) != math.Float64bits(want) {
+			t.Errorf("ParallelSumFloat() with %d workers = %v, with 1 worker %v", workers, got, want)
+		}
+	}
+	if got := ParallelSumFloat([]float64{1e16, 1, -1e16}, 2); got != 1 {
+		t.Errorf("ParallelSumFloat() = %v, want 1", got)
+	}
+	if got := ParallelSumFloat([]float64{1, 2}, 0); got != 0 {
+		t.Errorf("ParallelSumFloat() with zero workers = %v, want 0", got)
+	}
+}
+
+func TestSumChecked(t *testing.T) {
+	const root = 3037000499 // the largest int whose square fits in int64
+	tests := []struct {
+		name    string
+		numbers []int
+		workers int
+		sum     int
+		sumErr  *reduce.OverflowError
+		squares int
+		sqErr   *reduce.OverflowError
+	}{
+		{"empty", nil, 2, 0, nil, 0, nil},
+		{"small", []int{1, -2, 3}, 2, 2, nil, 14, nil},
+		{"largest square", []int{root, -root}, 2, 0, nil, 0, &reduce.OverflowError{Chunk: -1, Start: 0, End: 2}},
+		{"square overflows", []int{1, 2, root + 1, 4}, 2, root + 8, nil, 0, &reduce.OverflowError{Chunk: 1, Start: 2, End: 4}},
+		{"sum overflows", []int{math.MaxInt, 1, 2}, 1, 0, &reduce.OverflowError{Chunk: 0, Start: 0, End: 3},
+			0, &reduce.OverflowError{Chunk: 0, Start: 0, End: 3}},
+		{"zero workers", []int{math.MaxInt, 1}, 0, 0, nil, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := func(name string, got int, err error, want int, wantErr *reduce.OverflowError) {
+				t.Helper()
+				var oe *reduce.OverflowError
+				switch {
+				case wantErr == nil && (err != nil || got != want):
+					t.Errorf("%s() = %d, %v, want %d", name, got, err, want)
+				case wantErr != nil && (!errors.As(err, &oe) || *oe != *wantErr):
+					t.Errorf("%s() = %d, %v, want %v", name, got, err, wantErr)
+				}
+			}
+			got, err := ParallelSumChecked(tt.numbers, tt.workers)
+			check("ParallelSumChecked", got, err, tt.sum, tt.sumErr)
+			got, err = SquareSumChecked(tt.numbers, tt.workers)
+			check("SquareSumChecked", got, err, tt.squares, tt.sqErr)
+		})
+	}
+}
+
+// FuzzSquareSum checks the checked and big.Int sums against a big.Int
+// oracle
+func FuzzSquareSum(f *testing.F) {
+	f.Add([]byte{1, 2, 3}, uint8(2))
+	f.Add([]byte("\xb3\x04\xf3\xb4\x00\x00\x00\x00\xb3\x04\xf3\xb4"), uint8(2))
+	f.Add([]byte("\xff\xff\xff\xff\xff\xff\xff\x7f\x01"), uint8(1))
+	f.Fuzz(func(t *testing.T, data []byte, workers uint8) {
+		var numbers []int
+		for len(data) > 0 {
+			var buf [8]byte
+			n := copy(buf[:], data)
+			data = data[n:]
+			numbers = append(numbers, int(int64(binary.LittleEndian.Uint64(buf[:]))))
+		}
+		w := int(workers%8) + 1
+		sum, squares := new(big.Int), new(big.Int)
+		for _, v := range numbers {
+			b := big.NewInt(int64(v))
+			sum.Add(sum, b)
+			squares.Add(squares, b.Mul(b, b))
+		}
+		if got := ParallelSumBig(numbers, w); got.Cmp(sum) != 0 {
+			t.Fatalf("ParallelSumBig(%v, %d) = %v, want %v", numbers, w, got, sum)
+		}
+		if got := SquareSumBig(numbers, w); got.Cmp(squares) != 0 {
+			t.Fatalf("SquareSumBig(%v, %d) = %v, want %v", numbers, w, got, squares)
+		}
+		// Squares are never negative, so a checked sum of them overflows
+		// exactly when the total does not fit
+		got, err := SquareSumChecked(numbers, w)
+		switch {
+		case squares.IsInt64() && (err != nil || int64(got) != squares.Int64()):
+			t.Fatalf("SquareSumChecked(%v, %d) = %d, %v, want %v", numbers, w, got, err, squares)
+		case !squares.IsInt64() && !errors.Is(err, reduce.ErrOverflow):
+			t.Fatalf("SquareSumChecked(%v, %d) = %d, %v, want overflow", numbers, w, got, err)
+		}
+		if got, err := ParallelSumChecked(numbers, w); err == nil && int64(got) != sum.Int64() {
+			t.Fatalf("ParallelSumChecked(%v, %d) = %d, want %v", numbers, w, got, sum)
+		}
+	})
+}
+
 func BenchmarkParallelSum(b *testing.B) {
 	numbers := makeRange(1, 10000)
 
@@ -123,13 +245,360 @@ func TestFetchURLs(t *testing.T) {
 				t.Errorf("FetchURLs() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !tt.wantErr && len(result) < tt.minCodes {
-				t.Errorf("FetchURLs() got %d results, want at least %d", len(result), tt.minCodes)
+			if !tt.wantErr && CountFetched(result) < tt.minCodes {
+				t.Errorf("FetchURLs() got %d responses, want at least %d", CountFetched(result), tt.minCodes)
+			}
+			if len(result) != len(tt.urls) {
+				t.Errorf("FetchURLs() got %d results, want one per URL (%d)", len(result), len(tt.urls))
 			}
 		})
 	}
 }
 
+func TestFetchURLsLocal(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+
+	urls := []string{srv.URL + "/ok", srv.URL + "/ok", srv.URL + "/redirect", srv.URL + "/missing", "http://127.0.0.1:1/"}
+	results, err := FetchURLs(urls, 2*time.Second)
+	if err != nil {
+		t.Fatalf("FetchURLs() unexpected error: %v", err)
+	}
+	if len(results) != len(urls) {
+		t.Fatalf("FetchURLs() got %d results, want %d (duplicates must not collapse)", len(results), len(urls))
+	}
+
+	wantStatus := []int{200, 200, 200, 404, 0}
+	for i, r := range results {
+		if r.URL != urls[i] || r.Status != wantStatus[i] {
+			t.Errorf("FetchURLs() result[%d] = %s %d, want %s %d", i, r.URL, r.Status, urls[i], wantStatus[i])
+		}
+	}
+	if len(results[2].Redirects) != 1 {
+		t.Errorf("FetchURLs() redirect chain = %v, want one hop", results[2].Redirects)
+	}
+	if results[0].Bytes != 2 {
+		t.Errorf("FetchURLs() bytes = %d, want 2", results[0].Bytes)
+	}
+	if results[4].Err == nil {
+		t.Error("FetchURLs() unreachable host has no error")
+	}
+}
+
+func TestFetchURLsWithLimiter(t *testing.T) {
+	var active, peak int32
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	urls := []string{srv.URL + "/fail"}
+	for i := 0; i < 20; i++ {
+		urls = append(urls, fmt.Sprintf("%s/page%d", srv.URL, i))
+	}
+
+	lim := limiter.New(&limiter.AIMD{}, limiter.Options{InitialLimit: 3, MaxLimit: 3})
+	results, err := FetchURLsWithLimiter(urls, 5*time.Second, lim)
+	if err != nil {
+		t.Fatalf("FetchURLsWithLimiter() unexpected error: %v", err)
+	}
+	if CountFetched(results) != len(urls) {
+		t.Errorf("FetchURLsWithLimiter() got %d responses, want %d", CountFetched(results), len(urls))
+	}
+	if results[0].Status != http.StatusServiceUnavailable {
+		t.Errorf("FetchURLsWithLimiter() /fail status = %d, want 503", results[0].Status)
+	}
+	if peak > 3 {
+		t.Errorf("FetchURLsWithLimiter() peak concurrency %d, want at most 3", peak)
+	}
+	if lim.InFlight() != 0 {
+		t.Errorf("limiter InFlight() = %d after FetchURLsWithLimiter, want 0", lim.InFlight())
+	}
+}
+
+func TestFetchURLsWithCache(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := httpcache.NewTransport(nil, httpcache.NewCache(1<<20, 0, nil)).Client()
+	urls := []string{srv.URL + "/a", srv.URL + "/a", srv.URL + "/a", srv.URL + "/b"}
+
+	results, err := FetchURLsWith(urls, 2*time.Second, FetchOptions{Client: client})
+	if err != nil {
+		t.Fatalf("FetchURLsWith() unexpected error: %v", err)
+	}
+	if CountFetched(results) != len(urls) {
+		t.Errorf("FetchURLsWith() got %d responses, want %d", CountFetched(results), len(urls))
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("server saw %d requests for duplicate URLs, want 2", got)
+	}
+
+	// A second run is served from the cache
+	if _, err := FetchURLsWith(urls, 2*time.Second, FetchOptions{Client: client}); err != nil {
+		t.Fatalf("FetchURLsWith() unexpected error: %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("server saw %d requests after a cached run, want 2", got)
+	}
+}
+
+// Task 2: FetchWithRetry Tests
+func TestFetchWithRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int32
+		failStatus int
+		maxRetries int
+		wantStatus int
+		wantErr    bool
+		wantCalls  int32
+	}{
+		{"first attempt", 0, 0, 3, 200, false, 1},
+		{"recovers after 5xx", 2, 503, 3, 200, false, 3},
+		{"recovers after 429", 1, 429, 1, 200, false, 2},
+		{"gives up", 5, 500, 2, 500, true, 3},
+		{"no retries", 1, 502, 0, 502, true, 1},
+		{"4xx is not retried", 5, 404, 3, 404, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) <= tt.failures {
+					w.WriteHeader(tt.failStatus)
+				}
+			}))
+			defer srv.Close()
+
+			status, err := FetchWithRetry(srv.URL, tt.maxRetries, time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FetchWithRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("FetchWithRetry() = %d, want %d", status, tt.wantStatus)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("FetchWithRetry() made %d attempts, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+
+	t.Run("attempt timeout", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				time.Sleep(200 * time.Millisecond)
+			}
+		}))
+		defer srv.Close()
+
+		status, err := FetchWithRetry(srv.URL, 2, 50*time.Millisecond)
+		if err != nil || status != http.StatusOK {
+			t.Errorf("FetchWithRetry() = %d, %v, want 200 after a timed out attempt", status, err)
+		}
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		if _, err := FetchWithRetry("://bad", 3, time.Second); err == nil {
+			t.Error("FetchWithRetry() with malformed URL returned no error")
+		}
+	})
+
+	t.Run("through cache", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60")
+		}))
+		defer srv.Close()
+
+		client := httpcache.NewTransport(nil, httpcache.NewCache(1<<20, 0, nil)).Client()
+		for i := 0; i < 3; i++ {
+			if status, err := FetchWithRetryClient(client, srv.URL, 1, time.Second); err != nil || status != 200 {
+				t.Fatalf("FetchWithRetryClient() = %d, %v, want 200", status, err)
+			}
+		}
+		if got := calls.Load(); got != 1 {
+			t.Errorf("server saw %d requests, want 1", got)
+		}
+	})
+}
+
+func TestFetchWithBreaker(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	t.Run("retries stop when the breaker opens", func(t *testing.T) {
+		calls.Store(0)
+		set := breaker.NewSet(breaker.Settings{MinCalls: 2})
+		client := breaker.NewTransport(nil, set).Client()
+
+		status, err := FetchWithRetryClient(client, srv.URL, 10, time.Second)
+		if !errors.Is(err, breaker.ErrOpen) {
+			t.Errorf("FetchWithRetryClient() error = %v, want breaker.ErrOpen", err)
+		}
+		if status != 0 {
+			t.Errorf("FetchWithRetryClient() status = %d, want 0 for a rejected request", status)
+		}
+		if got := calls.Load(); got != 2 {
+			t.Errorf("server saw %d requests, want 2 before the breaker opened", got)
+		}
+	})
+
+	t.Run("FetchURLsWith", func(t *testing.T) {
+		calls.Store(0)
+		set := breaker.NewSet(breaker.Settings{MinCalls: 3})
+		urls := make([]string, 10)
+		for i := range urls {
+			urls[i] = fmt.Sprintf("%s/page%d", srv.URL, i)
+		}
+
+		// Run sequentially through a limiter so the breaker sees each failure
+		lim := limiter.New(&limiter.AIMD{}, limiter.Options{InitialLimit: 1, MaxLimit: 1})
+		results, err := FetchURLsWith(urls, 2*time.Second, FetchOptions{Limiter: lim, Breakers: set})
+		if err != nil {
+			t.Fatalf("FetchURLsWith() unexpected error: %v", err)
+		}
+		if got := calls.Load(); got != 3 {
+			t.Errorf("server saw %d requests, want 3", got)
+		}
+		rejected := 0
+		for _, r := range results {
+			if errors.Is(r.Err, breaker.ErrOpen) {
+				rejected++
+			}
+		}
+		if rejected != len(urls)-3 {
+			t.Errorf("FetchURLsWith() rejected %d requests, want %d", rejected, len(urls)-3)
+		}
+		if lim.InFlight() != 0 {
+			t.Errorf("limiter InFlight() = %d, want 0", lim.InFlight())
+		}
+	})
+
+	t.Run("queueing is not slowness", func(t *testing.T) {
+		healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(20 * time.Millisecond)
+		}))
+		defer healthy.Close()
+		set := breaker.NewSet(breaker.Settings{MinCalls: 2, SlowCallDuration: 60 * time.Millisecond, SlowCallRate: 0.5})
+		sched := hostsched.New(hostsched.Options{PerHost: hostsched.Limits{MaxInFlight: 1}})
+		urls := make([]string, 8)
+		for i := range urls {
+			urls[i] = healthy.URL
+		}
+
+		// Each call is quick, but most wait well over SlowCallDuration for
+		// the host's only slot
+		results, err := FetchURLsWith(urls, 5*time.Second, FetchOptions{Scheduler: sched, Breakers: set})
+		if err != nil {
+			t.Fatalf("FetchURLsWith() unexpected error: %v", err)
+		}
+		if n := CountFetched(results); n != len(urls) {
+			t.Errorf("FetchURLsWith() fetched %d of %d", n, len(urls))
+		}
+		host := healthy.Listener.Addr().String()
+		if s := set.Get(host).State(); s != breaker.Closed {
+			t.Errorf("breaker state = %v, want closed", s)
+		}
+	})
+}
+
+func TestFetchURLsPerHostLimits(t *testing.T) {
+	var mu sync.Mutex
+	var active, peak int
+	var throttled atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy" && throttled.CompareAndSwap(false, true) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	urls := make([]string, 100)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("%s/page%d", srv.URL, i)
+	}
+
+	t.Run("default cap", func(t *testing.T) {
+		peak = 0
+		results, err := FetchURLs(urls, 5*time.Second)
+		if err != nil {
+			t.Fatalf("FetchURLs() unexpected error: %v", err)
+		}
+		if CountFetched(results) != len(urls) {
+			t.Errorf("FetchURLs() got %d responses, want %d", CountFetched(results), len(urls))
+		}
+		if peak > DefaultMaxPerHost {
+			t.Errorf("FetchURLs() peak concurrency per host %d, want at most %d", peak, DefaultMaxPerHost)
+		}
+	})
+
+	t.Run("custom scheduler", func(t *testing.T) {
+		peak = 0
+		sched := hostsched.New(hostsched.Options{PerHost: hostsched.Limits{MaxInFlight: 2}})
+		results, err := FetchURLsWith(append(urls[:20:20], srv.URL+"/busy"), 5*time.Second, FetchOptions{Scheduler: sched})
+		if err != nil {
+			t.Fatalf("FetchURLsWith() unexpected error: %v", err)
+		}
+		if peak > 2 {
+			t.Errorf("FetchURLsWith() peak concurrency %d, want at most 2", peak)
+		}
+		if got := results[20].Status; got != http.StatusTooManyRequests {
+			t.Errorf("FetchURLsWith() /busy status = %d, want 429", got)
+		}
+		st := sched.Stats()
+		hs := st.Hosts[srv.Listener.Addr().String()]
+		if hs.Started != 21 || hs.Throttled != 1 || st.InFlight != 0 || st.Queued != 0 {
+			t.Errorf("scheduler Stats() = %+v, want 21 started, 1 throttled, queues empty", hs)
+		}
+	})
+}
+
 func BenchmarkFetchURLs(b *testing.B) {
 	urls := []string{
 		"http://example.com",
@@ -213,6 +682,175 @@ func makeEvenSquares(n int) []int {
 	return result
 }
 
+func TestProcessPipelineBatches(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		size int
+		want [][]int
+	}{
+		{"empty", 0, 2, nil},
+		{"exact", 4, 2, [][]int{{4, 16}}},
+		{"partial last batch", 10, 2, [][]int{{4, 16}, {36, 64}, {100}}},
+		{"one batch", 10, 10, [][]int{{4, 16, 36, 64, 100}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]int
+			for b := range ProcessPipelineBatches(context.Background(), tt.n, tt.size, time.Second) {
+				got = append(got, b)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("ProcessPipelineBatches(%d, %d) = %v, want %v", tt.n, tt.size, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		leaktest.Check(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		out := ProcessPipelineBatches(ctx, 1000, 2, time.Second)
+		<-out
+		cancel() // and stop reading
+	})
+}
+
+func TestProcessPipelineFunc(t *testing.T) {
+	square := func(v int) int {
+		if v%7 == 0 {
+			panic("multiple of 7")
+		}
+		return v * v
+	}
+	for _, policy := range []safe.Policy{safe.SkipItem, safe.RestartWorker, safe.FailFast} {
+		t.Run(policy.String(), func(t *testing.T) {
+			var errs []error
+			var got []int
+			for v := range ProcessPipelineFunc(context.Background(), 20, square, policy, func(err error) { errs = append(errs, err) }) {
+				got = append(got, v)
+			}
+			want, wantPanics := []int{4, 16, 36, 64, 100, 144, 256, 324, 400}, 2
+			if policy == safe.FailFast {
+				want, wantPanics = []int{4, 16, 36}, 1
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("ProcessPipelineFunc() = %v, want %v", got, want)
+			}
+			if len(errs) != wantPanics || !safe.IsPanic(errs[0]) {
+				t.Errorf("onError got %v, want %d panics", errs, wantPanics)
+			}
+		})
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		leaktest.Check(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		out := ProcessPipelineFunc(ctx, 1000, func(v int) int { return v * v }, safe.SkipItem, nil)
+		<-out
+		cancel() // and stop reading
+	})
+}
+
+func TestProcessPipelineBudget(t *testing.T) {
+	slow := func(ctx context.Context, v int) (int, error) {
+		timer := time.NewTimer(time.Millisecond)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return v * v, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	t.Run("within budget", func(t *testing.T) {
+		got, report, err := ProcessPipelineBudget(context.Background(), 10, time.Second, nil, slow)
+		if err != nil {
+			t.Fatalf("ProcessPipelineBudget() unexpected error: %v", err)
+		}
+		if want := []int{4, 16, 36, 64, 100}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("ProcessPipelineBudget() = %v, want %v", got, want)
+		}
+		// generate finishes at once, so square gets about 2/3 of the budget
+		if len(report) != 3 || report[1].Allocated < 600*time.Millisecond || report[1].Exceeded {
+			t.Errorf("report = %+v", report)
+		}
+	})
+
+	t.Run("square runs out", func(t *testing.T) {
+		got, report, err := ProcessPipelineBudget(context.Background(), 1000, 60*time.Millisecond, nil, slow)
+		var te *deadline.TimeoutError
+		if !errors.As(err, &te) || te.Processed == 0 || te.Processed == 1000 {
+			t.Fatalf("ProcessPipelineBudget() error = %v, want a partial square stage timeout", err)
+		}
+		// filter still runs on what square finished
+		if len(got) != te.Processed/2 || !report[1].Exceeded || report[2].Exceeded || report[2].Allocated == 0 {
+			t.Errorf("ProcessPipelineBudget() = %d values after %d squares, report %+v", len(got), te.Processed, report)
+		}
+	})
+
+	t.Run("misspelled stage", func(t *testing.T) {
+		var calls atomic.Int32
+		fn := func(_ context.Context, v int) (int, error) { calls.Add(1); return v, nil }
+		stages := []deadline.Stage{{Name: "generate"}, {Name: "sqaure"}, {Name: "filter"}}
+		_, _, err := ProcessPipelineBudget(context.Background(), 10, time.Second, stages, fn)
+		if !errors.Is(err, deadline.ErrUnknownStage) || calls.Load() != 0 {
+			t.Errorf("ProcessPipelineBudget() error = %v after %d calls, want ErrUnknownStage before any", err, calls.Load())
+		}
+	})
+}
+
+func TestProcessPipelineBuffered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, q, err := ProcessPipelineBuffered(ctx, 200, memq.Options[int]{
+		MaxBytes: 80,
+		Sizer:    func(int) int { return 8 },
+		Spill:    true,
+		Dir:      t.TempDir(),
+		Encode:   func(v int) ([]byte, error) { return binary.AppendVarint(nil, int64(v)), nil },
+		Decode: func(b []byte) (int, error) {
+			v, _ := binary.Varint(b)
+			return int(v), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hold the consumer back until the queue has spilled
+	deadline := time.Now().Add(time.Second)
+	for q.Stats().Spills == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	var got []int
+	for v := range out {
+		got = append(got, v)
+	}
+	var want []int
+	for v := range ProcessPipeline(200) {
+		want = append(want, v)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ProcessPipelineBuffered() = %v, want %v", got, want)
+	}
+	if s := q.Stats(); s.Spills == 0 || s.PeakBytes > 80 || q.Err() != nil {
+		t.Errorf("Stats() = %+v, Err() = %v, want spills within 80 bytes", s, q.Err())
+	}
+}
+
+func TestProcessPipelineBufferedCancel(t *testing.T) {
+	leaktest.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out, _, err := ProcessPipelineBuffered(ctx, 1000, memq.Options[int]{MaxBytes: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-out
+	cancel() // and stop reading
+}
+
 func BenchmarkProcessPipeline(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		ch := ProcessPipeline(100)
@@ -318,6 +956,133 @@ func TestWorkerPoolWithContext(t *testing.T) {
 	})
 }
 
+// panicOn returns a doubling job function that panics on about one job
+// in ten, chosen at random but reproducibly, and the set of those jobs
+func panicOn(seed int64, jobs []int) (func(int) int, map[int]bool) {
+	rng := rand.New(rand.NewSource(seed))
+	bad := map[int]bool{}
+	for _, j := range jobs {
+		if rng.Intn(10) == 0 {
+			bad[j] = true
+		}
+	}
+	return func(j int) int {
+		if bad[j] {
+			panic(fmt.Sprintf("job %d", j))
+		}
+		return j * 2
+	}, bad
+}
+
+func TestWorkerPoolFunc(t *testing.T) {
+	jobs := makeRange(1, 100)
+	for seed := int64(1); seed <= 5; seed++ {
+		fn, bad := panicOn(seed, jobs)
+		for _, policy := range []safe.Policy{safe.SkipItem, safe.RestartWorker} {
+			t.Run(fmt.Sprintf("seed %d/%v", seed, policy), func(t *testing.T) {
+				results, err := WorkerPoolFunc(context.Background(), jobs, 4, fn, policy)
+				var want []int
+				for _, j := range jobs {
+					if !bad[j] {
+						want = append(want, j*2)
+					}
+				}
+				if fmt.Sprint(results) != fmt.Sprint(want) {
+					t.Errorf("WorkerPoolFunc() = %v, want %v", results, want)
+				}
+				if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != len(bad) || !safe.IsPanic(err) {
+					t.Errorf("WorkerPoolFunc() error has %d panics, want %d", n, len(bad))
+				}
+			})
+		}
+		t.Run(fmt.Sprintf("seed %d/fail-fast", seed), func(t *testing.T) {
+			results, err := WorkerPoolFunc(context.Background(), jobs, 4, fn, safe.FailFast)
+			var pe *safe.PanicError
+			if !errors.As(err, &pe) || !bad[pe.Input.(int)] {
+				t.Fatalf("WorkerPoolFunc() error = %v, want a panic", err)
+			}
+			first := pe.Input.(int)
+			for j := range bad {
+				first = min(first, j)
+			}
+			if pe.Input != first || len(results) != first-1 {
+				t.Errorf("WorkerPoolFunc() stopped at %v with %d results, want %d and %d", pe.Input, len(results), first, first-1)
+			}
+		})
+	}
+
+	if results, err := WorkerPoolFunc(context.Background(), []int{1, 2}, 2, func(j int) int { return j }, safe.FailFast); err != nil || len(results) != 2 {
+		t.Errorf("WorkerPoolFunc() without panics = %v, %v", results, err)
+	}
+}
+
+func TestWorkerPoolScheduled(t *testing.T) {
+	var jobs []fairq.Job[int]
+	for i := 1; i <= 20; i++ {
+		jobs = append(jobs, fairq.Job[int]{Tenant: "noisy", Value: i})
+	}
+	jobs = append(jobs,
+		fairq.Job[int]{Tenant: "quiet", Value: 100},
+		fairq.Job[int]{Tenant: "quiet", Value: 200, Priority: 1},
+	)
+
+	// One worker makes the order deterministic
+	results, err := WorkerPoolScheduled(context.Background(), jobs, 1, fairq.Options{
+		Tenants: map[string]fairq.TenantOptions{"noisy": {MaxQueued: 15}},
+	})
+	if !errors.Is(err, fairq.ErrQueueFull) {
+		t.Errorf("WorkerPoolScheduled() error = %v, want ErrQueueFull for the noisy overflow", err)
+	}
+	if len(results) != 17 {
+		t.Fatalf("WorkerPoolScheduled() got %d results, want 17", len(results))
+	}
+	// The quiet tenant does not wait for the noisy backlog, and its high
+	// priority job goes first
+	if want := []int{2, 400, 4, 200, 6}; fmt.Sprint(results[:5]) != fmt.Sprint(want) {
+		t.Errorf("WorkerPoolScheduled() starts with %v, want %v", results[:5], want)
+	}
+
+	// Without a limit set, a tenant may queue more than fairq's default
+	jobs = jobs[:0]
+	for i := 1; i <= 3000; i++ {
+		jobs = append(jobs, fairq.Job[int]{Tenant: "bulk", Value: i})
+	}
+	results, err = WorkerPoolScheduled(context.Background(), jobs, 4, fairq.Options{})
+	if err != nil || len(results) != 3000 {
+		t.Errorf("WorkerPoolScheduled() of 3000 jobs got %d results, error %v", len(results), err)
+	}
+}
+
+func TestWorkerPoolDurable(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // the first run dies before processing anything
+	if _, err := WorkerPoolDurable(ctx, dir, makeRange(1, 10), 2); !errors.Is(err, context.Canceled) {
+		t.Fatalf("WorkerPoolDurable() cancelled = %v", err)
+	}
+
+	// Resuming processes the jobs queued by the first run
+	results, err := WorkerPoolDurable(context.Background(), dir, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortInts(results)
+	if fmt.Sprint(results) != fmt.Sprint(doubled(makeRange(1, 10))) {
+		t.Errorf("WorkerPoolDurable() resumed = %v", results)
+	}
+	if results, _ := WorkerPoolDurable(context.Background(), dir, nil, 2); len(results) != 0 {
+		t.Errorf("WorkerPoolDurable() after the queue drained = %v, want nothing", results)
+	}
+}
+
+func doubled(in []int) []int {
+	out := make([]int, len(in))
+	for i, v := range in {
+		out[i] = v * 2
+	}
+	return out
+}
+
 func BenchmarkWorkerPool(b *testing.B) {
 	jobs := makeRange(1, 100)
 
@@ -643,6 +1408,35 @@ func TestFanOutFanIn(t *testing.T) {
 	}
 }
 
+func TestFanOutFanInFunc(t *testing.T) {
+	numbers := makeRange(1, 100)
+	for seed := int64(1); seed <= 5; seed++ {
+		fn, bad := panicOn(seed, numbers)
+		for _, policy := range []safe.Policy{safe.SkipItem, safe.RestartWorker} {
+			t.Run(fmt.Sprintf("seed %d/%v", seed, policy), func(t *testing.T) {
+				results, err := FanOutFanInFunc(numbers, 4, fn, policy)
+				if len(results) != len(numbers)-len(bad) {
+					t.Errorf("FanOutFanInFunc() got %d results, want %d", len(results), len(numbers)-len(bad))
+				}
+				for _, r := range results {
+					if bad[r/2] {
+						t.Errorf("FanOutFanInFunc() returned %d for a panicking number", r)
+					}
+				}
+				if !safe.IsPanic(err) {
+					t.Errorf("FanOutFanInFunc() error = %v, want the panics", err)
+				}
+			})
+		}
+		t.Run(fmt.Sprintf("seed %d/fail-fast", seed), func(t *testing.T) {
+			results, err := FanOutFanInFunc(numbers, 4, fn, safe.FailFast)
+			if !safe.IsPanic(err) || len(results) >= len(numbers)-len(bad) {
+				t.Errorf("FanOutFanInFunc() = %d results, %v, want an early stop with the panic", len(results), err)
+			}
+		})
+	}
+}
+
 // Helper function to generate tripled range
 func makeTripledRange(min, max int) []int {
 	result := make([]int, max-min+1)
@@ -716,6 +1510,15 @@ func TestProcessWithTimeout(t *testing.T) {
 		}
 	})
 
+	t.Run("timeout already passed", func(t *testing.T) {
+		for _, timeout := range []time.Duration{0, -time.Second} {
+			count, err := ProcessWithTimeout(makeRange(1, 10), timeout)
+			if count != 0 || !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("ProcessWithTimeout(%v) = %d, %v, want 0 and a timeout", timeout, count, err)
+			}
+		}
+	})
+
 	t.Run("very long timeout", func(t *testing.T) {
 		data := makeRange(1, 10)
 		count, err := ProcessWithTimeout(data, 10*time.Second)
@@ -750,8 +1553,50 @@ func TestProcessWithTimeout(t *testing.T) {
 	})
 }
 
+func TestProcessWithDeadlines(t *testing.T) {
+	data := makeRange(1, 1000)
+
+	t.Run("total budget keeps partial results", func(t *testing.T) {
+		results, err := ProcessWithDeadlines(context.Background(), data, deadline.Options{Total: 20 * time.Millisecond})
+		var te *deadline.TimeoutError
+		if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &te) {
+			t.Fatalf("ProcessWithDeadlines() error = %v, want ErrTimeout wrapping a *deadline.TimeoutError", err)
+		}
+		if len(results) == 0 || len(results) == len(data) || te.Processed != len(results) {
+			t.Errorf("ProcessWithDeadlines() processed %d items, TimeoutError says %d", len(results), te.Processed)
+		}
+		for i, r := range results {
+			if r != data[i]*2 {
+				t.Fatalf("results[%d] = %d, want %d", i, r, data[i]*2)
+			}
+		}
+		if te.Abandoned {
+			t.Error("ProcessWithDeadlines() abandoned the worker")
+		}
+	})
+
+	t.Run("per-item deadline", func(t *testing.T) {
+		_, err := ProcessWithDeadlines(context.Background(), data[:3], deadline.Options{PerItem: time.Microsecond})
+		var te *deadline.TimeoutError
+		if !errors.As(err, &te) || !te.PerItem || te.Processed != 0 {
+			t.Errorf("ProcessWithDeadlines() error = %v, want a per-item timeout on the first item", err)
+		}
+	})
+
+	t.Run("within budget", func(t *testing.T) {
+		results, err := ProcessWithDeadlines(context.Background(), data[:5], deadline.Options{Total: time.Second, PerItem: time.Second})
+		if err != nil || len(results) != 5 {
+			t.Errorf("ProcessWithDeadlines() = %v, %v, want 5 results", results, err)
+		}
+	})
+}
+
 // Task 8: ConcurrentDownloader Tests
 func TestConcurrentDownloader(t *testing.T) {
+	srv, _ := newDownloadServer(0)
+	defer srv.Close()
+
+	// Paths starting with "/" are served by the local test server
 	tests := []struct {
 		name          string
 		urls          []string
@@ -760,60 +1605,276 @@ func TestConcurrentDownloader(t *testing.T) {
 	}{
 		{"empty", []string{}, 2, 0},
 		{"nil urls", nil, 2, 0},
-		{"single", []string{"http://example.com"}, 1, 1},
-		{"multiple", []string{"http://example.com", "http://google.com", "http://github.com"}, 2, 3},
-		{"zero concurrent", []string{"http://example.com"}, 0, 0},
-		{"negative concurrent", []string{"http://example.com"}, -1, 0},
-		{"single url many concurrent", []string{"http://example.com"}, 100, 1},
+		{"single", []string{"/example"}, 1, 1},
+		{"multiple", []string{"/example", "/google", "/github"}, 2, 3},
+		{"zero concurrent", []string{"/example"}, 0, 0},
+		{"negative concurrent", []string{"/example"}, -1, 0},
+		{"single url many concurrent", []string{"/example"}, 100, 1},
 		{"many urls few concurrent", []string{
-			"http://example.com", "http://google.com", "http://github.com",
-			"http://stackoverflow.com", "http://reddit.com",
+			"/example", "/google", "/github",
+			"/stackoverflow", "/reddit",
 		}, 2, 5},
-		{"duplicate urls", []string{"http://example.com", "http://example.com"}, 2, 2},
+		{"duplicate urls", []string{"/example", "/example"}, 2, 2},
 		{"invalid urls", []string{"http://invalid.url.that.does.not.exist"}, 1, 0},
 		{"mixed valid/invalid", []string{
-			"http://example.com", "http://invalid.url.that.does.not.exist",
-			"http://google.com",
+			"/example", "http://invalid.url.that.does.not.exist",
+			"/google",
 		}, 2, 2},
+		{"missing file", []string{"/example", "/missing"}, 2, 1},
 		{"large concurrent limit", []string{
-			"http://example.com", "http://google.com", "http://github.com",
+			"/example", "/google", "/github",
 		}, 100, 3},
 		{"more urls than concurrent limit", makeExampleURLs(50), 5, 50},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			success := ConcurrentDownloader(tt.urls, tt.maxConcurrent)
-			if success < tt.minSuccess {
+			urls := resolveURLs(srv.URL, tt.urls)
+			results := ConcurrentDownloader(urls, tt.maxConcurrent, t.TempDir())
+			if success := CountSuccessful(results); success < tt.minSuccess {
 				t.Errorf("ConcurrentDownloader() got %d successes, want at least %d", success, tt.minSuccess)
 			}
+			if tt.maxConcurrent > 0 && len(results) != len(urls) {
+				t.Fatalf("ConcurrentDownloader() got %d results, want %d", len(results), len(urls))
+			}
+			for i, r := range results {
+				if r.URL != urls[i] {
+					t.Errorf("ConcurrentDownloader() result[%d].URL = %q, want %q", i, r.URL, urls[i])
+				}
+			}
 		})
 	}
 }
 
-// Helper function to generate example URLs
+func TestConcurrentDownloaderLimit(t *testing.T) {
+	srv, peak := newDownloadServer(2 * time.Millisecond)
+	defer srv.Close()
+
+	results := ConcurrentDownloader(resolveURLs(srv.URL, makeExampleURLs(20)), 3, t.TempDir())
+	if success := CountSuccessful(results); success != 20 {
+		t.Errorf("ConcurrentDownloader() got %d successes, want 20", success)
+	}
+	if got := peak(); got > 3 {
+		t.Errorf("ConcurrentDownloader() peak concurrency %d, want at most 3", got)
+	}
+}
+
+// newDownloadServer serves a small file at every path except /missing and
+// reports the peak number of concurrent requests
+func newDownloadServer(delay time.Duration) (*httptest.Server, func() int) {
+	var mu sync.Mutex
+	var active, peak int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		time.Sleep(delay)
+		fmt.Fprintf(w, "contents of %s\n", r.URL.Path)
+	}))
+	return srv, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return peak
+	}
+}
+
+// resolveURLs prefixes paths starting with "/" with base
+func resolveURLs(base string, urls []string) []string {
+	if urls == nil {
+		return nil
+	}
+	resolved := make([]string, len(urls))
+	for i, u := range urls {
+		if len(u) > 0 && u[0] == '/' {
+			u = base + u
+		}
+		resolved[i] = u
+	}
+	return resolved
+}
+
+// Helper function to generate example URL paths
 func makeExampleURLs(count int) []string {
 	urls := make([]string, count)
 	for i := 0; i < count; i++ {
-		urls[i] = fmt.Sprintf("http://example%d.com", i)
+		urls[i] = fmt.Sprintf("/example%d", i)
 	}
 	return urls
 }
 
 func BenchmarkConcurrentDownloader(b *testing.B) {
-	urls := []string{
-		"http://example.com",
-		"http://google.com",
-		"http://github.com",
-		"http://stackoverflow.com",
-	}
+	srv, _ := newDownloadServer(0)
+	defer srv.Close()
+	urls := resolveURLs(srv.URL, []string{"/example", "/google", "/github", "/stackoverflow"})
+	dir := b.TempDir()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		ConcurrentDownloader(urls, 2)
+		ConcurrentDownloader(urls, 2, dir)
 	}
 }
 
+func TestWeightedConcurrentDownloader(t *testing.T) {
+	srv, peak := newDownloadServer(2 * time.Millisecond)
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		urls        []string
+		sizes       map[string]int64
+		maxInFlight int64
+		wantSuccess int
+		maxPeak     int
+	}{
+		{"empty", nil, nil, 10, 0, 0},
+		{"zero capacity", []string{"/a"}, nil, 0, 0, 0},
+		{"unknown sizes weigh one", []string{"/a", "/b", "/c", "/d"}, nil, 2, 4, 2},
+		{"big files run alone", []string{"/big1", "/big2", "/big3"},
+			map[string]int64{"/big1": 10, "/big2": 10, "/big3": 10}, 10, 3, 1},
+		{"file larger than capacity", []string{"/huge", "/small"},
+			map[string]int64{"/huge": 100, "/small": 1}, 10, 2, 2},
+		{"missing file", []string{"/a", "/missing"}, nil, 2, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urls := resolveURLs(srv.URL, tt.urls)
+			sizes := make(map[string]int64)
+			for path, size := range tt.sizes {
+				sizes[srv.URL+path] = size
+			}
+
+			before := peak()
+			m := download.New(download.Options{Dir: t.TempDir()})
+			results, err := WeightedConcurrentDownloader(context.Background(), m, urls, sizes, tt.maxInFlight)
+			if err != nil {
+				t.Errorf("WeightedConcurrentDownloader() unexpected error: %v", err)
+			}
+			if success := CountSuccessful(results); success != tt.wantSuccess {
+				t.Errorf("WeightedConcurrentDownloader() got %d successes, want %d", success, tt.wantSuccess)
+			}
+			// peak only grows, so it is meaningful when this case raised it
+			if got := peak(); got > before && got > tt.maxPeak {
+				t.Errorf("WeightedConcurrentDownloader() peak concurrency %d, want at most %d", got, tt.maxPeak)
+			}
+		})
+	}
+}
+
+func TestWeightedConcurrentDownloaderCancellation(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// The first file fills the budget, so the second one can never start
+	urls := []string{srv.URL + "/big", srv.URL + "/next"}
+	sizes := map[string]int64{urls[0]: 4, urls[1]: 4}
+	m := download.New(download.Options{Dir: t.TempDir()})
+	results, err := WeightedConcurrentDownloader(ctx, m, urls, sizes, 4)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WeightedConcurrentDownloader() error = %v, want context.DeadlineExceeded", err)
+	}
+	if len(results) != 2 || !errors.Is(results[1].Err, context.DeadlineExceeded) {
+		t.Errorf("WeightedConcurrentDownloader() did not mark the unstarted download as cancelled: %+v", results)
+	}
+}
+
+func TestWeightedConcurrentDownloaderWithBreaker(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	client := breaker.NewTransport(nil, breaker.NewSet(breaker.Settings{MinCalls: 2})).Client()
+	m := download.New(download.Options{Dir: t.TempDir(), Client: client})
+	urls := resolveURLs(srv.URL, makeExampleURLs(20))
+
+	results, err := WeightedConcurrentDownloader(context.Background(), m, urls, nil, 1)
+	if err != nil {
+		t.Fatalf("WeightedConcurrentDownloader() unexpected error: %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("failing host saw %d downloads, want 2 before the breaker opened", got)
+	}
+	for _, r := range results[2:] {
+		if !errors.Is(r.Err, breaker.ErrOpen) {
+			t.Errorf("download after the breaker opened: error = %v, want breaker.ErrOpen", r.Err)
+		}
+	}
+}
+
+func TestAdaptiveConcurrentDownloader(t *testing.T) {
+	t.Run("blocking", func(t *testing.T) {
+		srv, peak := newDownloadServer(time.Millisecond)
+		defer srv.Close()
+
+		lim := limiter.New(&limiter.AIMD{}, limiter.Options{InitialLimit: 2, MaxLimit: 4})
+		urls := resolveURLs(srv.URL, append(makeExampleURLs(20), "/missing"))
+		m := download.New(download.Options{Dir: t.TempDir()})
+		results, err := AdaptiveConcurrentDownloader(context.Background(), m, urls, lim)
+		if err != nil {
+			t.Errorf("AdaptiveConcurrentDownloader() unexpected error: %v", err)
+		}
+		if success := CountSuccessful(results); success != 20 {
+			t.Errorf("AdaptiveConcurrentDownloader() got %d successes, want 20", success)
+		}
+		if got := peak(); got > 4 {
+			t.Errorf("AdaptiveConcurrentDownloader() peak concurrency %d, want at most MaxLimit 4", got)
+		}
+	})
+
+	t.Run("load shedding", func(t *testing.T) {
+		release := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer srv.Close()
+
+		lim := limiter.New(&limiter.AIMD{}, limiter.Options{InitialLimit: 2, MaxLimit: 2, Shed: true})
+		m := download.New(download.Options{Dir: t.TempDir()})
+		done := make(chan []DownloadResult)
+		go func() {
+			results, _ := AdaptiveConcurrentDownloader(context.Background(), m, resolveURLs(srv.URL, makeExampleURLs(5)), lim)
+			done <- results
+		}()
+
+		// Everything past the first two downloads is rejected without waiting
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		results := <-done
+		if success := CountSuccessful(results); success != 2 {
+			t.Errorf("AdaptiveConcurrentDownloader() with shedding got %d successes, want 2", success)
+		}
+		for _, r := range results[2:] {
+			if !errors.Is(r.Err, limiter.ErrLimitExceeded) {
+				t.Errorf("shed download error = %v, want limiter.ErrLimitExceeded", r.Err)
+			}
+		}
+	})
+}
+
 // Helper functions
 func makeRange(min, max int) []int {
 	result := make([]int, max-min+1)
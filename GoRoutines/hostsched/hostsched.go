@@ -0,0 +1,378 @@
+// Package hostsched schedules requests so that no single host is flooded.
+//
+// A Scheduler caps requests in flight and requests per second, both per
+// host and across all hosts. Callers queue per host, and when a slot
+// frees up the hosts with queued requests take turns (round-robin), so a
+// long list of URLs for one host cannot starve the others. A host that
+// answers 429 Too Many Requests is paused for its Retry-After.
+package hostsched
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+// Limits bound the requests sent to one host, or to all hosts together.
+// Zero fields mean no limit.
+type Limits struct {
+	// MaxInFlight caps concurrent requests
+	MaxInFlight int
+	// RatePerSecond caps the rate at which requests start
+	RatePerSecond float64
+	// Burst is the number of requests that may start at once when the
+	// rate allows [1]
+	Burst int
+}
+
+// Options configure a Scheduler
+type Options struct {
+	// PerHost applies to every host not listed in Hosts
+	PerHost Limits
+	// Hosts overrides PerHost for specific hosts
+	Hosts map[string]Limits
+	// Global applies to all hosts together
+	Global Limits
+	// DefaultRetryAfter pauses a host that answers 429 without a usable
+	// Retry-After header [1s]
+	DefaultRetryAfter time.Duration
+	// MaxRetryAfter caps the pause requested by a server [1m]
+	MaxRetryAfter time.Duration
+	// Clock is the time source [clock.Real()]
+	Clock clock.Clock
+}
+
+// HostStats describe the queue of one host
+type HostStats struct {
+	Queued   int
+	InFlight int
+	// Started counts requests that left the queue
+	Started uint64
+	// Throttled counts pauses requested by the server
+	Throttled uint64
+	// Waited is the total time requests spent queued
+	Waited time.Duration
+	// PausedUntil is set while the host is paused
+	PausedUntil time.Time
+}
+
+// Stats describe the whole scheduler
+type Stats struct {
+	Queued   int
+	InFlight int
+	Hosts    map[string]HostStats
+}
+
+// Scheduler hands out per-host request slots. It is safe for concurrent
+// use.
+type Scheduler struct {
+	opts Options
+	clk  clock.Clock
+
+	mu       sync.Mutex
+	hosts    map[string]*host
+	active   []*host // hosts with queued requests, in round-robin order
+	cursor   int     // next host in active to serve
+	global   bucket
+	inFlight int
+	queued   int
+	timer    clock.Timer
+	wake     time.Time
+}
+
+type host struct {
+	name        string
+	limits      Limits
+	bucket      bucket
+	queue       list.List // of *waiter
+	inFlight    int
+	pausedUntil time.Time
+	stats       HostStats
+}
+
+type waiter struct {
+	ready   chan struct{}
+	queued  time.Time
+	elem    *list.Element
+	granted bool
+}
+
+// New creates a scheduler
+func New(opts Options) *Scheduler {
+	if opts.DefaultRetryAfter <= 0 {
+		opts.DefaultRetryAfter = time.Second
+	}
+	if opts.MaxRetryAfter <= 0 {
+		opts.MaxRetryAfter = time.Minute
+	}
+	clk := clock.Or(opts.Clock)
+	return &Scheduler{
+		opts:   opts,
+		clk:    clk,
+		hosts:  make(map[string]*host),
+		global: newBucket(opts.Global, clk.Now()),
+	}
+}
+
+// Acquire waits for a slot to send a request to host. The returned ticket
+// must be released once the request is finished.
+func (s *Scheduler) Acquire(ctx context.Context, hostname string) (*Ticket, error) {
+	s.mu.Lock()
+	h := s.host(hostname)
+	w := &waiter{ready: make(chan struct{}), queued: s.clk.Now()}
+	w.elem = h.queue.PushBack(w)
+	if h.queue.Len() == 1 {
+		s.active = append(s.active, h)
+	}
+	s.queued++
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return &Ticket{s: s, h: h}, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if w.granted {
+			// Granted while we were giving up: hand the slot back
+			s.mu.Unlock()
+			(&Ticket{s: s, h: h}).Release()
+			return nil, ctx.Err()
+		}
+		h.queue.Remove(w.elem)
+		s.queued--
+		if h.queue.Len() == 0 {
+			s.deactivate(h)
+		}
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Pause stops starting requests to host for d, for example because the
+// server asked to Retry-After. Overlapping pauses keep the later end.
+func (s *Scheduler) Pause(hostname string, d time.Duration) {
+	d = min(d, s.opts.MaxRetryAfter)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.host(hostname)
+	if until := s.clk.Now().Add(d); until.After(h.pausedUntil) {
+		h.pausedUntil = until
+	}
+	h.stats.Throttled++
+	s.dispatch()
+}
+
+// SetHostLimits replaces the limits of one host, for example with a
+// crawl delay learned from its robots.txt
+func (s *Scheduler) SetHostLimits(hostname string, l Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.host(hostname)
+	h.limits = l
+	tokens := h.bucket.tokens
+	h.bucket = newBucket(l, h.bucket.last)
+	h.bucket.tokens = math.Min(tokens, h.bucket.burst)
+	s.dispatch()
+}
+
+// Stats returns a snapshot of the queues
+func (s *Scheduler) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clk.Now()
+	st := Stats{Queued: s.queued, InFlight: s.inFlight, Hosts: make(map[string]HostStats, len(s.hosts))}
+	for name, h := range s.hosts {
+		hs := h.stats
+		hs.Queued = h.queue.Len()
+		hs.InFlight = h.inFlight
+		if h.pausedUntil.After(now) {
+			hs.PausedUntil = h.pausedUntil
+		}
+		st.Hosts[name] = hs
+	}
+	return st
+}
+
+func (s *Scheduler) host(name string) *host {
+	h, ok := s.hosts[name]
+	if !ok {
+		limits, ok := s.opts.Hosts[name]
+		if !ok {
+			limits = s.opts.PerHost
+		}
+		h = &host{name: name, limits: limits, bucket: newBucket(limits, s.clk.Now())}
+		s.hosts[name] = h
+	}
+	return h
+}
+
+// deactivate removes h from the round-robin ring
+func (s *Scheduler) deactivate(h *host) {
+	for i, a := range s.active {
+		if a == h {
+			s.active = append(s.active[:i], s.active[i+1:]...)
+			if s.cursor > i {
+				s.cursor--
+			}
+			return
+		}
+	}
+}
+
+// dispatch grants as many queued requests as the limits allow, one host
+// at a time in round-robin order, and arms the timer for the earliest
+// moment a blocked host could go. It must be called with mu held.
+func (s *Scheduler) dispatch() {
+	now := s.clk.Now()
+	var wake time.Time
+	later := func(t time.Time) {
+		if wake.IsZero() || t.Before(wake) {
+			wake = t
+		}
+	}
+
+	for progress := true; progress && len(s.active) > 0; {
+		progress = false
+		for tried := len(s.active); tried > 0 && len(s.active) > 0; tried-- {
+			if s.opts.Global.MaxInFlight > 0 && s.inFlight >= s.opts.Global.MaxInFlight {
+				progress = false
+				break
+			}
+			if at := s.global.availableAt(now); at.After(now) {
+				later(at)
+				progress = false
+				break
+			}
+
+			if s.cursor >= len(s.active) {
+				s.cursor = 0
+			}
+			h := s.active[s.cursor]
+			if at, ok := h.ready(now); !ok {
+				if !at.IsZero() {
+					later(at)
+				}
+				s.cursor++
+				continue
+			}
+
+			s.global.take()
+			h.bucket.take()
+			w := h.queue.Remove(h.queue.Front()).(*waiter)
+			w.granted = true
+			close(w.ready)
+			h.inFlight++
+			h.stats.Started++
+			h.stats.Waited += now.Sub(w.queued)
+			s.inFlight++
+			s.queued--
+			progress = true
+
+			if h.queue.Len() == 0 {
+				s.active = append(s.active[:s.cursor], s.active[s.cursor+1:]...)
+			} else {
+				s.cursor++
+			}
+		}
+	}
+
+	s.schedule(now, wake)
+}
+
+// schedule makes sure dispatch runs again at wake
+func (s *Scheduler) schedule(now, wake time.Time) {
+	if wake.IsZero() || (s.timer != nil && !s.wake.After(wake)) {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.wake = wake
+	var timer clock.Timer
+	timer = s.clk.AfterFunc(wake.Sub(now), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.timer == timer {
+			s.timer = nil
+			s.wake = time.Time{}
+		}
+		s.dispatch()
+	})
+	s.timer = timer
+}
+
+func (s *Scheduler) release(h *host) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h.inFlight--
+	s.inFlight--
+	s.dispatch()
+}
+
+// ready reports whether h may start a request now, and if not, when it
+// can (zero if it waits for a request to finish)
+func (h *host) ready(now time.Time) (time.Time, bool) {
+	if h.pausedUntil.After(now) {
+		return h.pausedUntil, false
+	}
+	if h.limits.MaxInFlight > 0 && h.inFlight >= h.limits.MaxInFlight {
+		return time.Time{}, false
+	}
+	if at := h.bucket.availableAt(now); at.After(now) {
+		return at, false
+	}
+	return now, true
+}
+
+// Ticket is a slot for one request
+type Ticket struct {
+	s    *Scheduler
+	h    *host
+	once sync.Once
+}
+
+// Release frees the slot. Calling it more than once has no effect.
+func (t *Ticket) Release() {
+	t.once.Do(func() { t.s.release(t.h) })
+}
+
+// bucket is a token bucket; a zero rate never runs out
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(l Limits, now time.Time) bucket {
+	burst := float64(max(l.Burst, 1))
+	return bucket{rate: l.RatePerSecond, burst: burst, tokens: burst, last: now}
+}
+
+// availableAt returns when the next token is available
+func (b *bucket) availableAt(now time.Time) time.Time {
+	if b.rate <= 0 {
+		return now
+	}
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	// Allow for float rounding so a timer set for the exact moment works
+	missing := 1 - b.tokens
+	if missing <= 1e-9 {
+		return now
+	}
+	return now.Add(time.Duration(math.Ceil(missing / b.rate * float64(time.Second))))
+}
+
+func (b *bucket) take() {
+	if b.rate > 0 {
+		b.tokens--
+	}
+}
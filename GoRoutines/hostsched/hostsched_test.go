@@ -0,0 +1,363 @@
+package hostsched
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+	"github.com/go-concurrency-lesson/internal/leaktest"
+)
+
+// acquireAsync starts Acquire in a goroutine and returns a channel that
+// receives the ticket
+func acquireAsync(s *Scheduler, host string) <-chan *Ticket {
+	ch := make(chan *Ticket, 1)
+	go func() {
+		tok, err := s.Acquire(context.Background(), host)
+		if err == nil {
+			ch <- tok
+		}
+	}()
+	return ch
+}
+
+func assertBlocked(t *testing.T, ch <-chan *Ticket) {
+	t.Helper()
+	select {
+	case <-ch:
+		t.Fatal("Acquire() returned while the limit was reached")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSchedulerMaxInFlight(t *testing.T) {
+	s := New(Options{
+		PerHost: Limits{MaxInFlight: 2},
+		Hosts:   map[string]Limits{"big": {MaxInFlight: 3}},
+	})
+	ctx := context.Background()
+
+	var held []*Ticket
+	for i := 0; i < 2; i++ {
+		tok, err := s.Acquire(ctx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, tok)
+	}
+	third := acquireAsync(s, "a")
+	assertBlocked(t, third)
+
+	// Other hosts have their own limits
+	for i := 0; i < 3; i++ {
+		if _, err := s.Acquire(ctx, "big"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st := s.Stats()
+	if st.InFlight != 5 || st.Queued != 1 || st.Hosts["a"].Queued != 1 || st.Hosts["big"].InFlight != 3 {
+		t.Errorf("Stats() = %+v, want 5 in flight and one request queued for a", st)
+	}
+
+	held[0].Release()
+	held[0].Release() // no effect
+	tok := <-third
+	if got := s.Stats().Hosts["a"].InFlight; got != 2 {
+		t.Errorf("host a InFlight = %d after release and handoff, want 2", got)
+	}
+	tok.Release()
+	held[1].Release()
+	if got := s.Stats().Hosts["a"]; got.InFlight != 0 || got.Started != 3 {
+		t.Errorf("host a stats = %+v, want nothing in flight and 3 started", got)
+	}
+}
+
+func TestSchedulerRoundRobin(t *testing.T) {
+	s := New(Options{Global: Limits{MaxInFlight: 1}})
+	ctx := context.Background()
+	first, _ := s.Acquire(ctx, "a")
+
+	var mu sync.Mutex
+	var order []string
+	tickets := make(chan *Ticket)
+	enqueue := func(host string, n int) {
+		for i := 0; i < n; i++ {
+			queued := s.Stats().Queued
+			go func() {
+				tok, _ := s.Acquire(ctx, host)
+				mu.Lock()
+				order = append(order, host)
+				mu.Unlock()
+				tickets <- tok
+			}()
+			leaktest.WaitFor(t, func() bool { return s.Stats().Queued == queued+1 })
+		}
+	}
+	// Host a queues a long list before b and c show up
+	enqueue("a", 5)
+	enqueue("b", 2)
+	enqueue("c", 1)
+
+	first.Release()
+	for i := 0; i < 8; i++ {
+		(<-tickets).Release()
+	}
+
+	want := []string{"a", "b", "c", "a", "b", "a", "a", "a"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("grant order = %v, want %v", order, want)
+	}
+}
+
+func TestSchedulerRate(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	s := New(Options{PerHost: Limits{RatePerSecond: 10}, Clock: clk})
+	ctx := context.Background()
+
+	if _, err := s.Acquire(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	second := acquireAsync(s, "a")
+	clk.BlockUntil(1)
+	clk.Advance(99 * time.Millisecond)
+	assertBlocked(t, second)
+	clk.Advance(time.Millisecond)
+	<-second
+
+	// The rate is per host
+	if _, err := s.Acquire(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchedulerBurstAndGlobalRate(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	s := New(Options{Global: Limits{RatePerSecond: 2, Burst: 3}, Clock: clk})
+	ctx := context.Background()
+
+	for i, host := range []string{"a", "b", "c"} {
+		if _, err := s.Acquire(ctx, host); err != nil {
+			t.Fatalf("Acquire() %d within burst: %v", i, err)
+		}
+	}
+	next := acquireAsync(s, "d")
+	clk.BlockUntil(1)
+	clk.Advance(499 * time.Millisecond)
+	assertBlocked(t, next)
+	clk.Advance(time.Millisecond)
+	<-next
+}
+
+func TestSchedulerPause(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	s := New(Options{Clock: clk, MaxRetryAfter: 10 * time.Second})
+
+	s.Pause("a", 2*time.Second)
+	s.Pause("a", time.Second) // an earlier end does not shorten the pause
+	paused := acquireAsync(s, "a")
+	if _, err := s.Acquire(context.Background(), "b"); err != nil {
+		t.Fatalf("Acquire() on another host: %v", err)
+	}
+
+	st := s.Stats().Hosts["a"]
+	if st.Throttled != 2 || st.PausedUntil.IsZero() {
+		t.Errorf("host a stats = %+v, want 2 throttles and a pause", st)
+	}
+
+	clk.Advance(1999 * time.Millisecond)
+	assertBlocked(t, paused)
+	clk.Advance(time.Millisecond)
+	<-paused
+	if st := s.Stats().Hosts["a"]; !st.PausedUntil.IsZero() {
+		t.Errorf("host a still reported paused: %+v", st)
+	}
+
+	// Pauses are capped by MaxRetryAfter
+	s.Pause("c", time.Hour)
+	if until := s.Stats().Hosts["c"].PausedUntil; until.Sub(clk.Now()) != 10*time.Second {
+		t.Errorf("pause of an hour lasts %v, want MaxRetryAfter 10s", until.Sub(clk.Now()))
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := New(Options{PerHost: Limits{MaxInFlight: 1}})
+	held, _ := s.Acquire(context.Background(), "a")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.Acquire(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() error = %v, want context.DeadlineExceeded", err)
+	}
+	if st := s.Stats(); st.Queued != 0 || st.Hosts["a"].Queued != 0 {
+		t.Errorf("Stats() = %+v, want the cancelled request dequeued", st)
+	}
+
+	held.Release()
+	if _, err := s.Acquire(context.Background(), "a"); err != nil {
+		t.Errorf("Acquire() after cancel and release: %v", err)
+	}
+}
+
+func TestTransportRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	clk := clock.NewFake(time.Now())
+	s := New(Options{Clock: clk})
+	tr := NewTransport(nil, s)
+	tr.Retries = 1
+
+	done := make(chan int)
+	go func() {
+		resp, err := tr.Client().Get(srv.URL)
+		if err != nil {
+			t.Errorf("Get() error = %v", err)
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+
+	clk.BlockUntil(1)
+	if calls.Load() != 1 {
+		t.Fatalf("server saw %d requests before the pause ended, want 1", calls.Load())
+	}
+	clk.Advance(3 * time.Second)
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("Get() status = %d, want 200 after retrying", status)
+	}
+	if st := s.Stats(); st.InFlight != 0 || st.Hosts[srv.Listener.Addr().String()].Throttled != 1 {
+		t.Errorf("Stats() = %+v, want no request in flight and one throttle", st)
+	}
+}
+
+func TestTransportHoldsSlotUntilBodyClosed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("body"))
+	}))
+	defer srv.Close()
+
+	client := NewTransport(nil, New(Options{PerHost: Limits{MaxInFlight: 1}})).Client()
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		if resp, err := client.Get(srv.URL); err == nil {
+			resp.Body.Close()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("second request ran while the first body was open")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	<-done
+}
+
+func TestTransportLimitsPerHost(t *testing.T) {
+	var mu sync.Mutex
+	active, peak := map[string]int{}, map[string]int{}
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			active[name]++
+			peak[name] = max(peak[name], active[name])
+			mu.Unlock()
+			time.Sleep(2 * time.Millisecond)
+			mu.Lock()
+			active[name]--
+			mu.Unlock()
+		}
+	}
+	a := httptest.NewServer(handler("a"))
+	defer a.Close()
+	b := httptest.NewServer(handler("b"))
+	defer b.Close()
+
+	client := NewTransport(nil, New(Options{PerHost: Limits{MaxInFlight: 3}})).Client()
+	var wg sync.WaitGroup
+	for i := 0; i < 60; i++ {
+		url := a.URL
+		if i%3 == 0 {
+			url = b.URL
+		}
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			if resp, err := client.Get(url); err == nil {
+				resp.Body.Close()
+			}
+		}(url)
+	}
+	wg.Wait()
+
+	if peak["a"] > 3 || peak["b"] > 3 {
+		t.Errorf("peak concurrency per host = %v, want at most 3", peak)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second, true},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0, true},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func BenchmarkSchedulerAcquire(b *testing.B) {
+	s := New(Options{PerHost: Limits{MaxInFlight: 4}})
+	hosts := []string{"a", "b", "c", "d"}
+	ctx := context.Background()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			tok, err := s.Acquire(ctx, hosts[i%len(hosts)])
+			if err != nil {
+				b.Fatal(err)
+			}
+			tok.Release()
+			i++
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package hostsched
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Transport is an http.RoundTripper that sends every request through a
+// Scheduler. The slot is held until the response body is closed, so the
+// limits count connections in use, not just requests being sent.
+type Transport struct {
+	// Base performs the requests; nil means http.DefaultTransport
+	Base http.RoundTripper
+	// Scheduler hands out the slots
+	Scheduler *Scheduler
+	// Retries is how many times a request without a body is sent again
+	// after a 429, once the host's pause is over
+	Retries int
+}
+
+// NewTransport creates a transport scheduling base with s
+func NewTransport(base http.RoundTripper, s *Scheduler) *Transport {
+	return &Transport{Base: base, Scheduler: s}
+}
+
+// Client returns an http.Client that uses t
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	host := req.URL.Host
+	canRetry := req.Body == nil || req.Body == http.NoBody
+
+	for attempt := 0; ; attempt++ {
+		tok, err := t.Scheduler.Acquire(req.Context(), host)
+		if err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+
+		resp, err := base.RoundTrip(req)
+		if err != nil {
+			tok.Release()
+			return nil, err
+		}
+
+		if d, ok := t.Scheduler.throttled(resp); ok {
+			t.Scheduler.Pause(host, d)
+			if canRetry && attempt < t.Retries {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				tok.Release()
+				continue
+			}
+		}
+
+		resp.Body = &releaseBody{ReadCloser: resp.Body, tok: tok}
+		return resp, nil
+	}
+}
+
+// throttled reports whether resp asks the client to back off, and for how
+// long: a 429, or a 503 with Retry-After
+func (s *Scheduler) throttled(resp *http.Response) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+	case resp.StatusCode == http.StatusServiceUnavailable && header != "":
+	default:
+		return 0, false
+	}
+	if d, ok := parseRetryAfter(header, s.clk.Now()); ok {
+		return d, true
+	}
+	return s.opts.DefaultRetryAfter, true
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an
+// HTTP date
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// releaseBody releases the scheduler slot when the body is closed
+type releaseBody struct {
+	io.ReadCloser
+	tok *Ticket
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.tok.Release()
+	return err
+}
@@ -0,0 +1,249 @@
+// Package httpcache provides an http.RoundTripper that coalesces
+// concurrent identical GET requests into one upstream fetch and keeps
+// responses in a size-bounded LRU cache. Freshness follows Cache-Control
+// max-age, no-cache, no-store and stale-while-revalidate, with ETag and
+// Last-Modified revalidation once an entry goes stale.
+package httpcache
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+// entry is a cached response
+type entry struct {
+	key    string
+	status int
+	proto  string
+	header http.Header
+	body   []byte
+	// stream replaces body for a response that was not read into memory,
+	// and length is then its Content-Length
+	stream *stream
+	length int64
+
+	stored  time.Time
+	expires time.Time
+	// swr is how long a stale entry may still be served while it is
+	// revalidated in the background
+	swr time.Duration
+}
+
+func (e *entry) size() int64 {
+	n := int64(len(e.key) + len(e.body))
+	for k, vs := range e.header {
+		for _, v := range vs {
+			n += int64(len(k) + len(v))
+		}
+	}
+	return n
+}
+
+func (e *entry) fresh(now time.Time) bool {
+	return now.Before(e.expires)
+}
+
+func (e *entry) staleServable(now time.Time) bool {
+	return e.swr > 0 && now.Before(e.expires.Add(e.swr))
+}
+
+func (e *entry) validators() (etag, lastModified string) {
+	return e.header.Get("ETag"), e.header.Get("Last-Modified")
+}
+
+// Cache is a size-bounded LRU store of responses
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+
+	// defaultTTL applies to responses without max-age or Expires
+	defaultTTL time.Duration
+	clock      clock.Clock
+}
+
+// NewCache creates a cache holding at most maxBytes of keys, headers and
+// bodies. defaultTTL is the freshness lifetime of responses that do not
+// specify one. clk may be nil to use the real clock.
+func NewCache(maxBytes int64, defaultTTL time.Duration, clk clock.Clock) *Cache {
+	return &Cache{
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		defaultTTL: defaultTTL,
+		clock:      clock.Or(clk),
+	}
+}
+
+// Len returns the number of cached responses
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Size returns the number of bytes accounted to cached responses
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Delete removes the response stored under key
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *Cache) get(key string) (*entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry), true
+}
+
+// put stores e, evicting least recently used entries to make room.
+// Entries larger than the whole cache are not stored.
+func (c *Cache) put(e *entry) {
+	size := e.size()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[e.key]; ok {
+		c.removeElement(el)
+	}
+	if size > c.maxBytes {
+		return
+	}
+	for c.size+size > c.maxBytes {
+		c.removeElement(c.ll.Back())
+	}
+	c.items[e.key] = c.ll.PushFront(e)
+	c.size += size
+}
+
+// removeElement must be called with c.mu held
+func (c *Cache) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.size -= e.size()
+}
+
+// storable reports whether resp may be stored, judging by its status and
+// headers alone
+func storable(resp *http.Response) bool {
+	if !cacheableStatus(resp.StatusCode) {
+		return false
+	}
+	if _, ok := parseCacheControl(resp.Header.Get("Cache-Control"))["no-store"]; ok {
+		return false
+	}
+	// We key on the URL only, so we cannot tell variants apart
+	return resp.Header.Get("Vary") == ""
+}
+
+// newEntry builds a cache entry for a response, or returns nil if the
+// response must not be stored
+func (c *Cache) newEntry(key string, resp *http.Response, body []byte) *entry {
+	if !storable(resp) {
+		return nil
+	}
+	cc := parseCacheControl(resp.Header.Get("Cache-Control"))
+
+	now := c.clock.Now()
+	e := &entry{
+		key:    key,
+		status: resp.StatusCode,
+		proto:  resp.Proto,
+		header: resp.Header.Clone(),
+		body:   body,
+		stored: now,
+	}
+	e.expires = now.Add(c.lifetime(cc, resp.Header))
+	if v, ok := cc["stale-while-revalidate"]; ok {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			e.swr = time.Duration(secs) * time.Second
+		}
+	}
+	return e
+}
+
+// lifetime returns the freshness lifetime of a response
+func (c *Cache) lifetime(cc map[string]string, h http.Header) time.Duration {
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	if v, ok := cc["max-age"]; ok {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+		return 0
+	}
+	if v := h.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		date := c.clock.Now()
+		if d, err := http.ParseTime(h.Get("Date")); err == nil {
+			date = d
+		}
+		if ttl := expires.Sub(date); ttl > 0 {
+			return ttl
+		}
+		return 0
+	}
+	return c.defaultTTL
+}
+
+// refresh updates a stale entry from a 304 Not Modified response
+func (c *Cache) refresh(e *entry, resp *http.Response) *entry {
+	updated := *e
+	updated.header = e.header.Clone()
+	for k, vs := range resp.Header {
+		updated.header[k] = vs
+	}
+	cc := parseCacheControl(updated.header.Get("Cache-Control"))
+	now := c.clock.Now()
+	updated.stored = now
+	updated.expires = now.Add(c.lifetime(cc, updated.header))
+	return &updated
+}
+
+func cacheableStatus(code int) bool {
+	switch code {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+// parseCacheControl splits a Cache-Control header into lower-case
+// directives and their (unquoted) values
+func parseCacheControl(h string) map[string]string {
+	cc := make(map[string]string)
+	for _, part := range strings.Split(h, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return cc
+}
@@ -0,0 +1,441 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+	"github.com/go-concurrency-lesson/internal/leaktest"
+)
+
+// origin is a test server whose responses and request log are controllable
+type origin struct {
+	*httptest.Server
+	requests    atomic.Int32
+	conditional atomic.Int32
+	version     atomic.Int32
+	header      http.Header
+}
+
+func newOrigin(header http.Header) *origin {
+	o := &origin{header: header}
+	o.version.Store(1)
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.requests.Add(1)
+		etag := fmt.Sprintf(`"v%d"`, o.version.Load())
+		for k, vs := range o.header {
+			w.Header()[k] = vs
+		}
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") != "" {
+			o.conditional.Add(1)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		fmt.Fprintf(w, "version %d of %s", o.version.Load(), r.URL.Path)
+	}))
+	return o
+}
+
+func get(t *testing.T, client *http.Client, url string) (string, string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Get(%s) unexpected error: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body), resp.Header.Get(XCache)
+}
+
+func TestTransportFreshness(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		advance      time.Duration
+		wantSecond   string
+		wantRequests int32
+	}{
+		{"fresh hit", "max-age=60", 30 * time.Second, "HIT", 1},
+		{"stale revalidated", "max-age=60", 61 * time.Second, "REVALIDATED", 2},
+		{"no-cache always revalidates", "no-cache", 0, "REVALIDATED", 2},
+		{"no-store never cached", "no-store", 0, "MISS", 2},
+		{"default ttl", "", 5 * time.Second, "HIT", 1},
+		{"default ttl expired", "", 11 * time.Second, "REVALIDATED", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.cacheControl != "" {
+				header.Set("Cache-Control", tt.cacheControl)
+			}
+			o := newOrigin(header)
+			defer o.Close()
+
+			clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			tr := NewTransport(nil, NewCache(1<<20, 10*time.Second, clk))
+			client := tr.Client()
+
+			body, xcache := get(t, client, o.URL+"/page")
+			if xcache != "MISS" || body != "version 1 of /page" {
+				t.Fatalf("first Get() = %q, %s, want version 1, MISS", body, xcache)
+			}
+
+			clk.Advance(tt.advance)
+			body, xcache = get(t, client, o.URL+"/page")
+			if xcache != tt.wantSecond || body != "version 1 of /page" {
+				t.Errorf("second Get() = %q, %s, want version 1, %s", body, xcache, tt.wantSecond)
+			}
+			if got := o.requests.Load(); got != tt.wantRequests {
+				t.Errorf("origin saw %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestTransportRevalidationFetchesChangedContent(t *testing.T) {
+	o := newOrigin(http.Header{"Cache-Control": {"max-age=1"}})
+	defer o.Close()
+
+	clk := clock.NewFake(time.Now())
+	tr := NewTransport(nil, NewCache(1<<20, 0, clk))
+	get(t, tr.Client(), o.URL)
+
+	o.version.Store(2)
+	clk.Advance(2 * time.Second)
+	body, xcache := get(t, tr.Client(), o.URL)
+	if body != "version 2 of /" || xcache != "MISS" {
+		t.Errorf("Get() after change = %q, %s, want version 2, MISS", body, xcache)
+	}
+	if o.conditional.Load() != 1 {
+		t.Errorf("origin saw %d conditional requests, want 1", o.conditional.Load())
+	}
+}
+
+func TestTransportStaleWhileRevalidate(t *testing.T) {
+	o := newOrigin(http.Header{"Cache-Control": {"max-age=1, stale-while-revalidate=60"}})
+	defer o.Close()
+
+	clk := clock.NewFake(time.Now())
+	tr := NewTransport(nil, NewCache(1<<20, 0, clk))
+	get(t, tr.Client(), o.URL)
+
+	o.version.Store(2)
+	clk.Advance(2 * time.Second)
+	body, xcache := get(t, tr.Client(), o.URL)
+	if body != "version 1 of /" || xcache != "STALE" {
+		t.Fatalf("Get() within stale-while-revalidate = %q, %s, want version 1, STALE", body, xcache)
+	}
+
+	// The background revalidation replaces the entry
+	deadline := time.Now().Add(time.Second)
+	for {
+		body, xcache = get(t, tr.Client(), o.URL)
+		if body == "version 2 of /" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background revalidation never updated the cache")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if xcache != "HIT" {
+		t.Errorf("Get() after revalidation X-Cache = %s, want HIT", xcache)
+	}
+
+	// Past the stale window the caller waits for a fresh response
+	clk.Advance(2 * time.Minute)
+	if _, xcache := get(t, tr.Client(), o.URL); xcache == "STALE" {
+		t.Error("Get() beyond stale-while-revalidate served stale content")
+	}
+}
+
+func TestTransportCoalescesConcurrentRequests(t *testing.T) {
+	for _, withCache := range []bool{true, false} {
+		t.Run(fmt.Sprintf("cache=%v", withCache), func(t *testing.T) {
+			release := make(chan struct{})
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				<-release
+				w.Write([]byte("shared"))
+			}))
+			defer srv.Close()
+
+			var cache *Cache
+			if withCache {
+				cache = NewCache(1<<20, time.Minute, nil)
+			}
+			tr := NewTransport(nil, cache)
+
+			const callers = 10
+			var wg sync.WaitGroup
+			for i := 0; i < callers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					body, _ := get(t, tr.Client(), srv.URL+"/slow")
+					if body != "shared" {
+						t.Errorf("Get() body = %q, want shared", body)
+					}
+				}()
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if got := requests.Load(); got != 1 {
+				t.Errorf("origin saw %d requests for %d concurrent callers, want 1", got, callers)
+			}
+			if got := tr.Stats().Coalesced; got != callers-1 {
+				t.Errorf("Stats().Coalesced = %d, want %d", got, callers-1)
+			}
+		})
+	}
+}
+
+func TestTransportBypass(t *testing.T) {
+	o := newOrigin(http.Header{"Cache-Control": {"max-age=60"}})
+	defer o.Close()
+	tr := NewTransport(nil, NewCache(1<<20, 0, nil))
+	client := tr.Client()
+
+	get(t, client, o.URL)
+	resp, err := client.Post(o.URL, "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, o.URL, nil)
+	req.Header.Set("Cache-Control", "no-store")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := o.requests.Load(); got != 3 {
+		t.Errorf("origin saw %d requests, want 3 (GET, POST, no-store GET)", got)
+	}
+	if got := tr.Stats().Bypassed; got != 2 {
+		t.Errorf("Stats().Bypassed = %d, want 2", got)
+	}
+}
+
+func TestTransportStreamsUnstorableBodies(t *testing.T) {
+	release := make(chan struct{})
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte("head "))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("tail"))
+	}))
+	defer srv.Close()
+	tr := NewTransport(nil, NewCache(1<<20, time.Minute, nil))
+
+	// The response arrives before the body is complete
+	resps := make(chan *http.Response, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := tr.Client().Get(srv.URL)
+			if err != nil {
+				t.Errorf("Get() unexpected error: %v", err)
+			}
+			resps <- resp
+		}()
+	}
+	var got []*http.Response
+	for len(got) < 2 {
+		select {
+		case resp := <-resps:
+			got = append(got, resp)
+		case <-time.After(time.Second):
+			close(release)
+			t.Fatal("Get() of a no-store response waited for the whole body")
+		}
+	}
+	close(release)
+
+	// Every caller reads the whole body, joined callers by repeating the
+	// request
+	for _, resp := range got {
+		if resp == nil {
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "head tail" {
+			t.Errorf("Get() body = %q, want head tail", body)
+		}
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("origin saw %d requests, want 2", got)
+	}
+	if tr.Cache.Len() != 0 {
+		t.Errorf("cache holds %d responses, want 0", tr.Cache.Len())
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestTransportReleasesAbandonedStreams(t *testing.T) {
+	var open atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		open.Add(1)
+		defer open.Add(-1)
+		w.Header().Set("Cache-Control", "no-store")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	defer srv.CloseClientConnections()
+
+	// The only caller gives up as the response arrives, so it races the
+	// end of the fetch; whichever wins, the upstream connection must go
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := http.DefaultTransport.RoundTrip(req)
+			cancel()
+			return resp, err
+		})
+		tr := NewTransport(base, NewCache(1<<20, time.Minute, nil))
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		if resp, err := tr.RoundTrip(req); err == nil {
+			resp.Body.Close()
+		}
+	}
+	leaktest.WaitFor(t, func() bool { return open.Load() == 0 })
+}
+
+func TestTransportMaxBodySize(t *testing.T) {
+	const big = "more than eight bytes"
+	for _, chunked := range []bool{false, true} {
+		t.Run(fmt.Sprintf("chunked=%v", chunked), func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.Header().Set("Cache-Control", "max-age=60")
+				if chunked {
+					// Flushing first leaves the length unknown
+					w.(http.Flusher).Flush()
+				}
+				w.Write([]byte(big))
+			}))
+			defer srv.Close()
+			tr := NewTransport(nil, NewCache(1<<20, 0, nil))
+			tr.MaxBodySize = 8
+
+			for i := 0; i < 2; i++ {
+				if body, xcache := get(t, tr.Client(), srv.URL); body != big || xcache != "MISS" {
+					t.Errorf("Get() = %q, %s, want %q, MISS", body, xcache, big)
+				}
+			}
+			if got := requests.Load(); got != 2 {
+				t.Errorf("origin saw %d requests, want 2 (a body over MaxBodySize is not stored)", got)
+			}
+		})
+	}
+}
+
+func TestCacheLRUEviction(t *testing.T) {
+	c := NewCache(300, time.Minute, nil)
+	mk := func(key string) *entry {
+		return &entry{key: key, header: http.Header{}, body: make([]byte, 90)}
+	}
+
+	c.put(mk("a"))
+	c.put(mk("b"))
+	c.put(mk("c"))
+	if c.Len() != 3 || c.Size() != 273 {
+		t.Fatalf("Len(), Size() = %d, %d, want 3, 273", c.Len(), c.Size())
+	}
+
+	c.get("a") // a is now most recently used
+	c.put(mk("d"))
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry b was not evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("entry %s was evicted", key)
+		}
+	}
+
+	c.put(&entry{key: "huge", header: http.Header{}, body: make([]byte, 1000)})
+	if _, ok := c.get("huge"); ok || c.Len() != 3 {
+		t.Error("entry larger than the cache was stored")
+	}
+
+	c.Delete("a")
+	if c.Len() != 2 || c.Size() != 182 {
+		t.Errorf("after Delete Len(), Size() = %d, %d, want 2, 182", c.Len(), c.Size())
+	}
+}
+
+func TestCacheLifetime(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewCache(1<<20, 7*time.Second, clock.NewFake(now))
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=30"}}, 30 * time.Second},
+		{"no-cache wins", http.Header{"Cache-Control": {"no-cache, max-age=30"}}, 0},
+		{"expires", http.Header{
+			"Date":    {now.Format(http.TimeFormat)},
+			"Expires": {now.Add(time.Hour).Format(http.TimeFormat)},
+		}, time.Hour},
+		{"expires in the past", http.Header{"Expires": {now.Add(-time.Hour).Format(http.TimeFormat)}}, 0},
+		{"invalid expires", http.Header{"Expires": {"0"}}, 0},
+		{"default", http.Header{}, 7 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := parseCacheControl(tt.header.Get("Cache-Control"))
+			if got := c.lifetime(cc, tt.header); got != tt.want {
+				t.Errorf("lifetime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkTransportHit(b *testing.B) {
+	o := newOrigin(http.Header{"Cache-Control": {"max-age=3600"}})
+	defer o.Close()
+	client := NewTransport(nil, NewCache(1<<20, 0, nil)).Client()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := client.Get(o.URL)
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
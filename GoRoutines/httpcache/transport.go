@@ -0,0 +1,355 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-concurrency-lesson/syncx"
+)
+
+// XCache is the response header that tells how a response was served:
+// HIT, STALE, REVALIDATED, MISS or BYPASS
+const XCache = "X-Cache"
+
+// defaultRevalidateTimeout bounds background stale-while-revalidate fetches
+const defaultRevalidateTimeout = 30 * time.Second
+
+// defaultMaxBodySize bounds the bodies read into memory
+const defaultMaxBodySize = 1 << 20
+
+// Stats counts how responses were served
+type Stats struct {
+	Hits        uint64
+	Stale       uint64
+	Revalidated uint64
+	Misses      uint64
+	Bypassed    uint64
+	// Coalesced counts callers that shared another caller's fetch
+	Coalesced uint64
+}
+
+// Transport is an http.RoundTripper that deduplicates concurrent GET
+// requests for the same URL and serves them from Cache when possible.
+// Requests are keyed by URL only: concurrent callers share the response
+// to whichever request arrived first, so do not put requests that differ
+// in credentials through the same Transport.
+//
+// Only storable bodies up to MaxBodySize are read into memory and shared.
+// Any other body is streamed to one of the callers, and the rest repeat
+// the request on their own.
+type Transport struct {
+	// Base performs the real requests (default: http.DefaultTransport)
+	Base http.RoundTripper
+	// Cache stores responses; if nil, requests are only coalesced
+	Cache *Cache
+	// RevalidateTimeout bounds background revalidations (default 30s)
+	RevalidateTimeout time.Duration
+	// MaxBodySize is the largest body stored or shared (default 1 MiB)
+	MaxBodySize int64
+
+	flight syncx.Group[string, *entry]
+
+	hits, stale, revalidated, misses, bypassed, coalesced atomic.Uint64
+}
+
+// NewTransport returns a Transport over base backed by cache
+func NewTransport(base http.RoundTripper, cache *Cache) *Transport {
+	return &Transport{Base: base, Cache: cache}
+}
+
+// Client returns an http.Client that uses t
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+// Stats returns a snapshot of the counters
+func (t *Transport) Stats() Stats {
+	return Stats{
+		Hits:        t.hits.Load(),
+		Stale:       t.stale.Load(),
+		Revalidated: t.revalidated.Load(),
+		Misses:      t.misses.Load(),
+		Bypassed:    t.bypassed.Load(),
+		Coalesced:   t.coalesced.Load(),
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if bypass(req) {
+		t.bypassed.Add(1)
+		return t.base().RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	if t.Cache != nil && !requestNoCache(req) {
+		if e, ok := t.Cache.get(key); ok {
+			now := t.Cache.clock.Now()
+			if e.fresh(now) {
+				t.hits.Add(1)
+				return e.response(req, "HIT", now), nil
+			}
+			if e.staleServable(now) {
+				t.stale.Add(1)
+				go t.revalidate(req, key)
+				return e.response(req, "STALE", now), nil
+			}
+		}
+	}
+
+	// Only callers that joined someone else's fetch count as coalesced;
+	// the caller that started it is reported as shared too
+	var leader bool
+	e, err, shared := t.flight.DoContextRelease(req.Context(), key, func(ctx context.Context) (*entry, error) {
+		leader = true
+		return t.fetch(ctx, req, key)
+	}, discard)
+	if err != nil {
+		return nil, err
+	}
+	if e.stream != nil {
+		if body := e.stream.take(req.Context()); body != nil {
+			return e.streamResponse(req, body), nil
+		}
+		return t.base().RoundTrip(req)
+	}
+	if shared && !leader {
+		t.coalesced.Add(1)
+	}
+	return e.response(req, e.header.Get(XCache), t.now()), nil
+}
+
+// revalidate refreshes a stale entry in the background, joining any fetch
+// for the same key that is already running
+func (t *Transport) revalidate(req *http.Request, key string) {
+	timeout := t.RevalidateTimeout
+	if timeout <= 0 {
+		timeout = defaultRevalidateTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), timeout)
+	defer cancel()
+	e, _, _ := t.flight.DoContextRelease(ctx, key, func(ctx context.Context) (*entry, error) {
+		return t.fetch(ctx, req, key)
+	}, discard)
+	// Nobody reads a streamed body here; a caller that joined the fetch
+	// and misses it repeats the request
+	if e != nil {
+		discard(e)
+	}
+}
+
+// discard closes the stream of an entry that no caller will read
+func discard(e *entry) {
+	if e.stream != nil && e.stream.taken.CompareAndSwap(false, true) {
+		e.stream.body.Close()
+		e.stream.cancel()
+	}
+}
+
+// fetch performs the upstream request, conditionally if a cached entry
+// has validators, and updates the cache. A body that is not read into
+// memory is returned as a stream.
+func (t *Transport) fetch(ctx context.Context, req *http.Request, key string) (*entry, error) {
+	// A streamed body outlives fetch, so the upstream request follows ctx
+	// only until fetch returns
+	upstream, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, cancel)
+	out := req.Clone(upstream)
+	var cached *entry
+	if t.Cache != nil {
+		cached, _ = t.Cache.get(key)
+	}
+	if cached != nil {
+		etag, lastModified := cached.validators()
+		if etag != "" {
+			out.Header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			out.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := t.base().RoundTrip(out)
+	if err != nil {
+		stop()
+		cancel()
+		return nil, err
+	}
+	done := func() {
+		resp.Body.Close()
+		stop()
+		cancel()
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		io.Copy(io.Discard, resp.Body)
+		done()
+		e := t.Cache.refresh(cached, resp)
+		t.Cache.put(e)
+		t.revalidated.Add(1)
+		return e.tagged("REVALIDATED"), nil
+	}
+
+	e := &entry{
+		key:    key,
+		status: resp.StatusCode,
+		proto:  resp.Proto,
+		header: resp.Header.Clone(),
+	}
+	body := resp.Body
+	if max := t.maxBodySize(); storable(resp) && resp.ContentLength <= max {
+		// Read one byte past max to tell a body of unknown length that is
+		// too long
+		buf, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+		if err != nil {
+			done()
+			return nil, err
+		}
+		if int64(len(buf)) <= max {
+			done()
+			t.misses.Add(1)
+			if t.Cache != nil {
+				if stored := t.Cache.newEntry(key, resp, buf); stored != nil {
+					t.Cache.put(stored)
+					return stored.tagged("MISS"), nil
+				}
+			}
+			e.body = buf
+			return e.tagged("MISS"), nil
+		}
+		body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), resp.Body), resp.Body}
+	}
+	t.misses.Add(1)
+	stop()
+	e.stream = &stream{body: body, cancel: cancel}
+	e.length = resp.ContentLength
+	return e.tagged("MISS"), nil
+}
+
+func (t *Transport) maxBodySize() int64 {
+	if t.MaxBodySize > 0 {
+		return t.MaxBodySize
+	}
+	return defaultMaxBodySize
+}
+
+func (t *Transport) now() time.Time {
+	if t.Cache != nil {
+		return t.Cache.clock.Now()
+	}
+	return time.Now()
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// tagged returns a copy of e whose header carries the X-Cache value, so
+// coalesced callers learn how the shared fetch was served
+func (e *entry) tagged(xcache string) *entry {
+	c := *e
+	c.header = e.header.Clone()
+	c.header.Set(XCache, xcache)
+	return &c
+}
+
+// response builds an independent *http.Response for one caller
+func (e *entry) response(req *http.Request, xcache string, now time.Time) *http.Response {
+	header := e.header.Clone()
+	header.Set(XCache, xcache)
+	if !e.stored.IsZero() {
+		header.Set("Age", strconv.Itoa(int(now.Sub(e.stored).Seconds())))
+	}
+	proto := e.proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	major, minor, _ := http.ParseHTTPVersion(proto)
+	return &http.Response{
+		Status:        strconv.Itoa(e.status) + " " + http.StatusText(e.status),
+		StatusCode:    e.status,
+		Proto:         proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// streamResponse builds the response of the caller that took e's stream
+func (e *entry) streamResponse(req *http.Request, body io.ReadCloser) *http.Response {
+	resp := e.response(req, e.header.Get(XCache), time.Time{})
+	resp.Body = body
+	resp.ContentLength = e.length
+	return resp
+}
+
+// stream is a response body that was not read into memory, so only one
+// caller can have it
+type stream struct {
+	body   io.ReadCloser
+	cancel context.CancelFunc // ends the upstream request
+	taken  atomic.Bool
+}
+
+// take returns the body to the first caller, and nil to the others. From
+// then on the upstream request ends with ctx or when the body is closed.
+func (s *stream) take(ctx context.Context) io.ReadCloser {
+	if !s.taken.CompareAndSwap(false, true) {
+		return nil
+	}
+	stop := context.AfterFunc(ctx, s.cancel)
+	return &streamBody{ReadCloser: s.body, done: func() {
+		stop()
+		s.cancel()
+	}}
+}
+
+// streamBody ends the upstream request once it is closed
+type streamBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+// bypass reports whether req must go straight to the base transport
+func bypass(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return true
+	}
+	// Range and caller-driven conditional requests need the real response
+	for _, h := range []string{"Range", "If-None-Match", "If-Modified-Since"} {
+		if req.Header.Get(h) != "" {
+			return true
+		}
+	}
+	_, noStore := parseCacheControl(req.Header.Get("Cache-Control"))["no-store"]
+	return noStore
+}
+
+// requestNoCache reports whether the caller demands revalidation
+func requestNoCache(req *http.Request) bool {
+	cc := parseCacheControl(req.Header.Get("Cache-Control"))
+	if _, ok := cc["no-cache"]; ok {
+		return true
+	}
+	return cc["max-age"] == "0"
+}
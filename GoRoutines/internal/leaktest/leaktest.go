@@ -0,0 +1,58 @@
+// Package leaktest holds the helpers the package tests share: a check
+// that a test leaves no goroutines behind, polling for a condition, and
+// channel sources and sinks for pipeline stages.
+package leaktest
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Check fails the test if goroutines started during it are still running
+// shortly after it ends. Call it first, before the test starts any.
+func Check(t testing.TB) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				t.Errorf("goroutines leaked: %d before, %d after", before, runtime.NumGoroutine())
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+// WaitFor polls cond until it holds, failing the test after a second
+func WaitFor(t testing.TB, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 1s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Source returns a closed channel holding items
+func Source[T any](items ...T) <-chan T {
+	ch := make(chan T, len(items))
+	for _, v := range items {
+		ch <- v
+	}
+	close(ch)
+	return ch
+}
+
+// Drain reads ch until it is closed
+func Drain[T any](ch <-chan T) []T {
+	var out []T
+	for v := range ch {
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,156 @@
+package limiter
+
+import (
+	"math"
+	"time"
+)
+
+// AIMD grows the limit by one for every successful sample while the limit
+// is in use and multiplies it by BackoffRatio on every drop, like TCP
+// congestion control
+type AIMD struct {
+	// BackoffRatio is applied on drops (default 0.9)
+	BackoffRatio float64
+	// Timeout treats slower samples as drops when positive
+	Timeout time.Duration
+}
+
+// Update implements Algorithm
+func (a *AIMD) Update(limit int, s Sample) int {
+	if s.Dropped || (a.Timeout > 0 && s.RTT > a.Timeout) {
+		ratio := a.BackoffRatio
+		if ratio <= 0 || ratio >= 1 {
+			ratio = 0.9
+		}
+		return int(float64(limit) * ratio)
+	}
+	// Only grow when the limit is actually what holds us back
+	if s.InFlight*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// Vegas estimates the queue size as limit * (1 - minRTT/RTT) and keeps it
+// between Alpha and Beta, growing fast when there is no queue and
+// shrinking when latency shows requests are waiting
+type Vegas struct {
+	// Alpha and Beta are queue size thresholds; when zero they scale with
+	// log10(limit) as 3*log and 6*log
+	Alpha int
+	Beta  int
+
+	minRTT time.Duration
+}
+
+// Update implements Algorithm
+func (v *Vegas) Update(limit int, s Sample) int {
+	if s.RTT <= 0 {
+		return limit
+	}
+	if v.minRTT == 0 || s.RTT < v.minRTT {
+		v.minRTT = s.RTT
+	}
+
+	log := log10(limit)
+	if s.Dropped {
+		return limit - log
+	}
+	if s.InFlight*2 < limit {
+		return limit
+	}
+
+	alpha, beta := v.Alpha, v.Beta
+	if alpha <= 0 {
+		alpha = 3 * log
+	}
+	if beta <= alpha {
+		beta = 2 * alpha
+	}
+
+	queue := int(math.Ceil(float64(limit) * (1 - float64(v.minRTT)/float64(s.RTT))))
+	switch {
+	case queue <= log:
+		return limit + beta
+	case queue < alpha:
+		return limit + log
+	case queue > beta:
+		return limit - log
+	default:
+		return limit
+	}
+}
+
+// Gradient compares a long-term average RTT with the latest RTT. When
+// latency rises above the long-term baseline the gradient drops below one
+// and shrinks the limit; otherwise the limit grows by QueueSize.
+type Gradient struct {
+	// Tolerance is how much RTT growth is accepted before backing off
+	// (default 1.5)
+	Tolerance float64
+	// Smoothing weights the new limit against the old one (default 0.2)
+	Smoothing float64
+	// QueueSize is the headroom added on top of the scaled limit
+	// (default 4)
+	QueueSize int
+	// LongWindow is the number of samples in the long-term average
+	// (default 100)
+	LongWindow int
+
+	longRTT float64
+}
+
+// Update implements Algorithm
+func (g *Gradient) Update(limit int, s Sample) int {
+	tolerance := g.Tolerance
+	if tolerance < 1 {
+		tolerance = 1.5
+	}
+	smoothing := g.Smoothing
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 0.2
+	}
+	queueSize := g.QueueSize
+	if queueSize <= 0 {
+		queueSize = 4
+	}
+	window := g.LongWindow
+	if window <= 0 {
+		window = 100
+	}
+
+	if s.Dropped {
+		return int(float64(limit) * 0.5)
+	}
+	rtt := float64(s.RTT)
+	if rtt <= 0 {
+		return limit
+	}
+
+	if g.longRTT == 0 {
+		g.longRTT = rtt
+	} else {
+		g.longRTT += (rtt - g.longRTT) * 2 / float64(window+1)
+	}
+	// The baseline drifts up under sustained load; pull it back down
+	// when current latency is far below it so the limit can recover
+	if g.longRTT/rtt > 2 {
+		g.longRTT *= 0.95
+	}
+
+	if s.InFlight*2 < limit {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, tolerance*g.longRTT/rtt))
+	next := float64(limit)*gradient + float64(queueSize)
+	return int(math.Round(float64(limit)*(1-smoothing) + next*smoothing))
+}
+
+// log10 returns max(1, log10(n)) as an int
+func log10(n int) int {
+	if n < 10 {
+		return 1
+	}
+	return int(math.Log10(float64(n)))
+}
@@ -0,0 +1,171 @@
+// Package limiter adapts the number of concurrent operations to observed
+// latency and errors instead of relying on a hand-picked maxConcurrent.
+//
+// A Limiter hands out tokens up to its current limit. Each token reports
+// how its operation went, and an Algorithm (AIMD, Vegas or Gradient)
+// turns those samples into a new limit.
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-concurrency-lesson/syncx"
+)
+
+// ErrLimitExceeded is returned by Acquire in load-shedding mode when every
+// slot is taken
+var ErrLimitExceeded = errors.New("limiter: concurrency limit exceeded")
+
+// Sample describes one completed operation
+type Sample struct {
+	// RTT is the time between Acquire and the token being released
+	RTT time.Duration
+	// InFlight is the number of operations running when this one started
+	InFlight int
+	// Dropped is true if the operation failed because of overload:
+	// an error, a timeout or a 429/5xx response
+	Dropped bool
+}
+
+// Algorithm computes a new concurrency limit from a sample.
+// Limiter serializes calls, so implementations need no locking.
+type Algorithm interface {
+	Update(limit int, s Sample) int
+}
+
+// Options configure a Limiter
+type Options struct {
+	// InitialLimit is the limit before any samples arrive (default 10)
+	InitialLimit int
+	// MinLimit and MaxLimit bound the adaptive limit (defaults 1 and 1000)
+	MinLimit int
+	MaxLimit int
+	// Shed makes Acquire fail fast with ErrLimitExceeded instead of
+	// waiting for a slot
+	Shed bool
+}
+
+// Limiter is an adaptive concurrency limiter
+type Limiter struct {
+	alg  Algorithm
+	opts Options
+	sem  *syncx.Semaphore
+
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+}
+
+// New creates a limiter driven by alg
+func New(alg Algorithm, opts Options) *Limiter {
+	if opts.MinLimit <= 0 {
+		opts.MinLimit = 1
+	}
+	if opts.MaxLimit <= 0 {
+		opts.MaxLimit = 1000
+	}
+	if opts.MaxLimit < opts.MinLimit {
+		opts.MaxLimit = opts.MinLimit
+	}
+	if opts.InitialLimit <= 0 {
+		opts.InitialLimit = 10
+	}
+	limit := clamp(opts.InitialLimit, opts.MinLimit, opts.MaxLimit)
+	return &Limiter{
+		alg:   alg,
+		opts:  opts,
+		sem:   syncx.NewSemaphore(int64(limit)),
+		limit: limit,
+	}
+}
+
+// Acquire takes a slot, waiting until one is free or ctx is done.
+// In load-shedding mode it returns ErrLimitExceeded instead of waiting.
+// The returned token must be released with exactly one of Success,
+// Dropped or Ignore.
+func (l *Limiter) Acquire(ctx context.Context) (*Token, error) {
+	if l.opts.Shed {
+		if !l.sem.TryAcquire(1) {
+			return nil, ErrLimitExceeded
+		}
+	} else if err := l.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	l.inFlight++
+	inFlight := l.inFlight
+	l.mu.Unlock()
+	return &Token{l: l, start: time.Now(), inFlight: inFlight}, nil
+}
+
+// Limit returns the current concurrency limit
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// InFlight returns the number of tokens currently held
+func (l *Limiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+func (l *Limiter) release(s *Sample) {
+	l.mu.Lock()
+	l.inFlight--
+	if s != nil {
+		limit := clamp(l.alg.Update(l.limit, *s), l.opts.MinLimit, l.opts.MaxLimit)
+		if limit != l.limit {
+			l.limit = limit
+			l.sem.Resize(int64(limit))
+		}
+	}
+	l.mu.Unlock()
+	l.sem.Release(1)
+}
+
+// Token is a slot held for one operation
+type Token struct {
+	l        *Limiter
+	start    time.Time
+	inFlight int
+	once     sync.Once
+}
+
+// Success releases the token and records a successful sample
+func (t *Token) Success() {
+	t.once.Do(func() {
+		t.l.release(&Sample{RTT: time.Since(t.start), InFlight: t.inFlight})
+	})
+}
+
+// Dropped releases the token and records an overload sample
+func (t *Token) Dropped() {
+	t.once.Do(func() {
+		t.l.release(&Sample{RTT: time.Since(t.start), InFlight: t.inFlight, Dropped: true})
+	})
+}
+
+// Ignore releases the token without recording a sample, for failures
+// that say nothing about load (bad input, cancelled by the caller)
+func (t *Token) Ignore() {
+	t.once.Do(func() {
+		t.l.release(nil)
+	})
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,256 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAIMD(t *testing.T) {
+	tests := []struct {
+		name   string
+		alg    AIMD
+		limit  int
+		sample Sample
+		want   int
+	}{
+		{"grows when saturated", AIMD{}, 10, Sample{RTT: time.Millisecond, InFlight: 10}, 11},
+		{"holds when app-limited", AIMD{}, 10, Sample{RTT: time.Millisecond, InFlight: 2}, 10},
+		{"backs off on drop", AIMD{}, 10, Sample{RTT: time.Millisecond, InFlight: 10, Dropped: true}, 9},
+		{"custom backoff", AIMD{BackoffRatio: 0.5}, 10, Sample{Dropped: true}, 5},
+		{"timeout counts as drop", AIMD{Timeout: time.Millisecond}, 10, Sample{RTT: time.Second, InFlight: 10}, 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.alg.Update(tt.limit, tt.sample); got != tt.want {
+				t.Errorf("AIMD.Update() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVegas(t *testing.T) {
+	v := &Vegas{}
+	base := 10 * time.Millisecond
+
+	limit := v.Update(20, Sample{RTT: base, InFlight: 20})
+	if limit <= 20 {
+		t.Errorf("Vegas.Update() with no queue = %d, want growth above 20", limit)
+	}
+
+	// Doubled latency means half of the requests are queued
+	if got := v.Update(100, Sample{RTT: 2 * base, InFlight: 100}); got >= 100 {
+		t.Errorf("Vegas.Update() with long queue = %d, want below 100", got)
+	}
+	if got := v.Update(100, Sample{RTT: base, InFlight: 100, Dropped: true}); got >= 100 {
+		t.Errorf("Vegas.Update() on drop = %d, want below 100", got)
+	}
+	if got := v.Update(100, Sample{RTT: base, InFlight: 10}); got != 100 {
+		t.Errorf("Vegas.Update() when app-limited = %d, want 100", got)
+	}
+}
+
+func TestGradient(t *testing.T) {
+	g := &Gradient{}
+	base := 10 * time.Millisecond
+
+	limit := 20
+	for i := 0; i < 10; i++ {
+		limit = g.Update(limit, Sample{RTT: base, InFlight: limit})
+	}
+	if limit <= 20 {
+		t.Errorf("Gradient limit with steady RTT = %d, want growth above 20", limit)
+	}
+
+	grown := limit
+	for i := 0; i < 10; i++ {
+		limit = g.Update(limit, Sample{RTT: 5 * base, InFlight: limit})
+	}
+	if limit >= grown {
+		t.Errorf("Gradient limit after latency spike = %d, want below %d", limit, grown)
+	}
+
+	if got := g.Update(40, Sample{RTT: base, Dropped: true}); got != 20 {
+		t.Errorf("Gradient.Update() on drop = %d, want 20", got)
+	}
+}
+
+func TestLimiterBounds(t *testing.T) {
+	l := New(&AIMD{BackoffRatio: 0.1}, Options{InitialLimit: 5, MinLimit: 2, MaxLimit: 6})
+	if l.Limit() != 5 {
+		t.Fatalf("Limit() = %d, want 5", l.Limit())
+	}
+
+	for i := 0; i < 5; i++ {
+		tok, err := l.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tok.Dropped()
+	}
+	if l.Limit() != 2 {
+		t.Errorf("Limit() after drops = %d, want MinLimit 2", l.Limit())
+	}
+
+	// Hold every slot so each success counts as saturated
+	for i := 0; i < 10; i++ {
+		var toks []*Token
+		for j := 0; j < l.Limit(); j++ {
+			tok, err := l.Acquire(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			toks = append(toks, tok)
+		}
+		for _, tok := range toks {
+			tok.Success()
+		}
+	}
+	if l.Limit() != 6 {
+		t.Errorf("Limit() after successes = %d, want MaxLimit 6", l.Limit())
+	}
+	if l.InFlight() != 0 {
+		t.Errorf("InFlight() = %d, want 0", l.InFlight())
+	}
+}
+
+func TestLimiterShed(t *testing.T) {
+	l := New(&AIMD{}, Options{InitialLimit: 1, MaxLimit: 1, Shed: true})
+	tok, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := l.Acquire(context.Background()); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Acquire() on saturated limiter error = %v, want ErrLimitExceeded", err)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("Acquire() in shed mode did not fail fast")
+	}
+
+	tok.Ignore()
+	tok.Success() // releasing twice is a no-op
+	if _, err := l.Acquire(context.Background()); err != nil {
+		t.Errorf("Acquire() after release unexpected error: %v", err)
+	}
+}
+
+func TestLimiterWaitCancellation(t *testing.T) {
+	l := New(&AIMD{}, Options{InitialLimit: 1, MaxLimit: 1})
+	if _, err := l.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+// TestLimiterSimulation drives each algorithm against a server whose
+// latency grows with the number of concurrent requests and which starts
+// failing once it is overloaded. The limit must stay near the server's
+// capacity instead of growing to the demand of the clients.
+func TestLimiterSimulation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simulation is slow")
+	}
+
+	const (
+		capacity = 8
+		clients  = 40
+		requests = 400
+	)
+
+	algorithms := []struct {
+		name string
+		alg  Algorithm
+	}{
+		{"AIMD", &AIMD{}},
+		{"Vegas", &Vegas{}},
+		{"Gradient", &Gradient{}},
+	}
+
+	for _, tt := range algorithms {
+		t.Run(tt.name, func(t *testing.T) {
+			var active int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&active, 1)
+				defer atomic.AddInt32(&active, -1)
+				if n > capacity {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				time.Sleep(time.Millisecond + time.Duration(n)*500*time.Microsecond)
+			}))
+			defer srv.Close()
+
+			l := New(tt.alg, Options{InitialLimit: clients, MaxLimit: 100})
+			var served, failed int32
+			var limitSum int64
+			var wg sync.WaitGroup
+			work := make(chan struct{}, requests)
+			for i := 0; i < requests; i++ {
+				work <- struct{}{}
+			}
+			close(work)
+
+			for c := 0; c < clients; c++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range work {
+						tok, err := l.Acquire(context.Background())
+						if err != nil {
+							t.Errorf("Acquire() unexpected error: %v", err)
+							return
+						}
+						resp, err := http.Get(srv.URL)
+						if err != nil || resp.StatusCode >= 500 {
+							atomic.AddInt32(&failed, 1)
+							tok.Dropped()
+						} else {
+							atomic.AddInt32(&served, 1)
+							tok.Success()
+						}
+						if resp != nil {
+							resp.Body.Close()
+						}
+						atomic.AddInt64(&limitSum, int64(l.Limit()))
+					}
+				}()
+			}
+			wg.Wait()
+
+			avg := float64(limitSum) / requests
+			t.Logf("average limit %.1f, final limit %d, served %d, failed %d", avg, l.Limit(), served, failed)
+			if avg > 3*capacity {
+				t.Errorf("average limit = %.1f during simulation, want at most %d", avg, 3*capacity)
+			}
+			if served < requests/3 {
+				t.Errorf("served %d of %d requests, want at least a third", served, requests)
+			}
+		})
+	}
+}
+
+func BenchmarkLimiter(b *testing.B) {
+	l := New(&Gradient{}, Options{InitialLimit: 16})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tok, err := l.Acquire(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+			tok.Success()
+		}
+	})
+}
@@ -0,0 +1,374 @@
+// Package memq is a queue between pipeline stages that is bounded by
+// bytes rather than by item count.
+//
+// A buffered channel of capacity 100 holds 100 items whatever their size,
+// so a pipeline of large payloads can still run out of memory. A Queue
+// measures every item with a Sizer and blocks Push once the bytes in
+// memory reach MaxBytes. With Spill set it writes the overflow to temp
+// files instead and reads it back in order as the consumer catches up,
+// trading disk I/O for a hard memory bound.
+package memq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrClosed is returned by Push after Close, and by Pop once the queue is
+// closed and empty
+var ErrClosed = errors.New("memq: queue closed")
+
+// Options configure a Queue
+type Options[T any] struct {
+	// MaxBytes is the size of the items held in memory before Push blocks
+	// or spills [64 MiB]. An item larger than MaxBytes is let in when the
+	// queue is otherwise empty.
+	MaxBytes int64
+	// Sizer returns the memory size of an item [len for []byte and
+	// string, 1 otherwise]
+	Sizer func(T) int
+	// Spill writes items to disk when memory is full instead of blocking
+	Spill bool
+	// Dir is where spill files are created [os.TempDir()]
+	Dir string
+	// MaxSpillBytes bounds the spill files; Push blocks beyond it
+	// (0 = no limit)
+	MaxSpillBytes int64
+	// SegmentSize is the size at which a new spill file is started, so
+	// disk space is returned as the queue drains [4 MiB]
+	SegmentSize int64
+	// Encode and Decode convert items for the spill files. They are
+	// required with Spill unless T is []byte or string.
+	Encode func(T) ([]byte, error)
+	Decode func([]byte) (T, error)
+}
+
+// Stats describe the queue
+type Stats struct {
+	// Items and Bytes are held in memory
+	Items int
+	Bytes int64
+	// PeakBytes is the most ever held in memory
+	PeakBytes int64
+	// SpilledItems and SpilledBytes are on disk waiting to be read back
+	SpilledItems int
+	SpilledBytes int64
+	SpillFiles   int
+	Pushed       uint64
+	Popped       uint64
+	// Spills counts items ever written to disk
+	Spills uint64
+	// Blocked counts Push calls that had to wait for room
+	Blocked uint64
+}
+
+type entry[T any] struct {
+	v    T
+	size int64
+}
+
+// Queue is a FIFO queue bounded by bytes. It is safe for concurrent use.
+type Queue[T any] struct {
+	opts Options[T]
+
+	mu       sync.Mutex
+	mem      []entry[T] // items older than anything on disk
+	memBytes int64
+	spill    *spill // nil until the first spill
+	stats    Stats
+	err      error // first spill error; the queue is unusable after it
+	closed   bool
+	changed  chan struct{} // closed and replaced on every state change
+}
+
+// New returns an empty queue
+func New[T any](opts Options[T]) (*Queue[T], error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 64 << 20
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = 4 << 20
+	}
+	if opts.Dir == "" {
+		opts.Dir = os.TempDir()
+	}
+	var zero T
+	if opts.Sizer == nil {
+		switch any(zero).(type) {
+		case []byte:
+			opts.Sizer = func(v T) int { return len(any(v).([]byte)) }
+		case string:
+			opts.Sizer = func(v T) int { return len(any(v).(string)) }
+		default:
+			opts.Sizer = func(T) int { return 1 }
+		}
+	}
+	if opts.Spill && (opts.Encode == nil || opts.Decode == nil) {
+		switch any(zero).(type) {
+		case []byte:
+			opts.Encode = func(v T) ([]byte, error) { return any(v).([]byte), nil }
+			opts.Decode = func(b []byte) (T, error) { return any(b).(T), nil }
+		case string:
+			opts.Encode = func(v T) ([]byte, error) { return []byte(any(v).(string)), nil }
+			opts.Decode = func(b []byte) (T, error) { return any(string(b)).(T), nil }
+		default:
+			return nil, fmt.Errorf("memq: Spill needs Encode and Decode for %T", zero)
+		}
+	}
+	return &Queue[T]{opts: opts, changed: make(chan struct{})}, nil
+}
+
+// Push adds v to the queue. It blocks while the queue is full, or returns
+// ctx's error if ctx is done first.
+func (q *Queue[T]) Push(ctx context.Context, v T) error {
+	size := int64(q.opts.Sizer(v))
+	var data []byte // v encoded, once it has to spill
+	waited := false
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed {
+			return ErrClosed
+		}
+		if q.err != nil {
+			return q.err
+		}
+		// Items only go to memory while nothing is on disk, so that they
+		// come out in order
+		if q.spilled() == 0 && (q.memBytes+size <= q.opts.MaxBytes || len(q.mem) == 0) {
+			q.mem = append(q.mem, entry[T]{v, size})
+			q.memBytes += size
+			q.stats.PeakBytes = max(q.stats.PeakBytes, q.memBytes)
+			q.stats.Pushed++
+			q.notify()
+			return nil
+		}
+		if q.opts.Spill {
+			if data == nil {
+				var err error
+				if data, err = q.opts.Encode(v); err != nil {
+					return fmt.Errorf("memq: encode: %w", err)
+				}
+			}
+			if q.opts.MaxSpillBytes == 0 || q.spilled() == 0 ||
+				q.spill.bytes+recordSize(size, data) <= q.opts.MaxSpillBytes {
+				return q.write(size, data)
+			}
+		}
+
+		if !waited {
+			waited = true
+			q.stats.Blocked++
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			q.mu.Lock()
+			return ctx.Err()
+		}
+		q.mu.Lock()
+	}
+}
+
+// write spills data; q.mu is held
+func (q *Queue[T]) write(size int64, data []byte) error {
+	if q.spill == nil {
+		dir, err := os.MkdirTemp(q.opts.Dir, "memq-")
+		if err != nil {
+			q.err = fmt.Errorf("memq: %w", err)
+			return q.err
+		}
+		q.spill = &spill{dir: dir, segmentSize: q.opts.SegmentSize}
+	}
+	if err := q.spill.write(size, data); err != nil {
+		q.err = fmt.Errorf("memq: spill: %w", err)
+		return q.err
+	}
+	q.stats.Pushed++
+	q.stats.Spills++
+	q.notify()
+	return nil
+}
+
+// Pop removes and returns the oldest item. It blocks while the queue is
+// empty and returns ErrClosed once it is closed and drained, or ctx's
+// error if ctx is done first.
+func (q *Queue[T]) Pop(ctx context.Context) (T, error) {
+	var zero T
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if len(q.mem) == 0 && q.spilled() > 0 && q.err == nil {
+			q.refill()
+		}
+		if len(q.mem) > 0 {
+			e := q.mem[0]
+			q.mem[0] = entry[T]{}
+			q.mem = q.mem[1:]
+			q.memBytes -= e.size
+			q.stats.Popped++
+			q.notify()
+			return e.v, nil
+		}
+		if q.err != nil {
+			return zero, q.err
+		}
+		if q.closed {
+			q.release()
+			return zero, ErrClosed
+		}
+
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			q.mu.Lock()
+			return zero, ctx.Err()
+		}
+		q.mu.Lock()
+	}
+}
+
+// refill reads spilled items back into memory while they fit in
+// MaxBytes; q.mu is held
+func (q *Queue[T]) refill() {
+	for q.spilled() > 0 {
+		size, err := q.spill.peek()
+		if err != nil {
+			q.err = fmt.Errorf("memq: spill: %w", err)
+			return
+		}
+		if len(q.mem) > 0 && q.memBytes+size > q.opts.MaxBytes {
+			return
+		}
+		data, err := q.spill.read()
+		if err != nil {
+			q.err = fmt.Errorf("memq: spill: %w", err)
+			return
+		}
+		v, err := q.opts.Decode(data)
+		if err != nil {
+			q.err = fmt.Errorf("memq: decode: %w", err)
+			return
+		}
+		q.mem = append(q.mem, entry[T]{v, size})
+		q.memBytes += size
+		q.stats.PeakBytes = max(q.stats.PeakBytes, q.memBytes)
+	}
+}
+
+// spilled returns the number of items on disk; q.mu is held
+func (q *Queue[T]) spilled() int {
+	if q.spill == nil {
+		return 0
+	}
+	return q.spill.items
+}
+
+// Len returns the number of queued items, in memory and on disk
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.mem) + q.spilled()
+}
+
+// Stats returns the current accounting
+func (q *Queue[T]) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := q.stats
+	s.Items, s.Bytes = len(q.mem), q.memBytes
+	if q.spill != nil {
+		s.SpilledItems, s.SpilledBytes, s.SpillFiles = q.spill.items, q.spill.bytes, len(q.spill.segs)
+	}
+	return s
+}
+
+// Err returns the spill error that stopped the queue, if any
+func (q *Queue[T]) Err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// Close stops further pushes. Queued items can still be popped; the spill
+// files are removed once the queue is drained.
+func (q *Queue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	if len(q.mem) == 0 && q.spilled() == 0 {
+		q.release()
+	}
+	q.notify()
+}
+
+// Discard closes the queue, drops every queued item and removes the
+// spill files
+func (q *Queue[T]) Discard() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.mem, q.memBytes = nil, 0
+	q.release()
+	q.notify()
+}
+
+// release removes the spill files; q.mu is held
+func (q *Queue[T]) release() {
+	if q.spill != nil {
+		q.spill.remove()
+		q.spill = nil
+	}
+}
+
+// notify wakes every waiting Push and Pop; q.mu is held
+func (q *Queue[T]) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Buffer connects two pipeline stages through q: it pushes everything
+// read from in into q and sends it on in order on the returned channel.
+// When in is closed the output is closed after the last item; when ctx is
+// cancelled or q fails, the output is closed and q discarded. Check
+// q.Err() after the output closes.
+func Buffer[T any](ctx context.Context, in <-chan T, q *Queue[T]) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer q.Close()
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok || q.Push(ctx, v) != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(out)
+		defer q.Discard()
+		for {
+			v, err := q.Pop(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
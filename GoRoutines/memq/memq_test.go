@@ -0,0 +1,382 @@
+package memq
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/internal/leaktest"
+)
+
+func mustNew[T any](t testing.TB, opts Options[T]) *Queue[T] {
+	t.Helper()
+	q, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// dirEntries returns the number of files and directories in dir
+func dirEntries(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestBackpressure(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	q := mustNew(t, Options[[]byte]{MaxBytes: 10})
+	for i := 0; i < 2; i++ {
+		if err := q.Push(ctx, make([]byte, 4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pushed := make(chan error)
+	go func() { pushed <- q.Push(ctx, make([]byte, 4)) }()
+	leaktest.WaitFor(t, func() bool { return q.Stats().Blocked == 1 })
+	select {
+	case <-pushed:
+		t.Fatal("Push() over MaxBytes did not block")
+	default:
+	}
+	if s := q.Stats(); s.Items != 2 || s.Bytes != 8 {
+		t.Errorf("Stats() = %+v, want 2 items, 8 bytes", s)
+	}
+
+	if _, err := q.Pop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-pushed; err != nil {
+		t.Errorf("blocked Push() = %v", err)
+	}
+
+	// A blocked Push gives up with its context
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := q.Push(tctx, make([]byte, 4)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Push() on a full queue = %v, want context.DeadlineExceeded", err)
+	}
+	if s := q.Stats(); s.PeakBytes != 8 || s.Pushed != 3 || s.Popped != 1 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
+
+func TestOversizedItem(t *testing.T) {
+	ctx := context.Background()
+	q := mustNew(t, Options[string]{MaxBytes: 4})
+	if err := q.Push(ctx, "too large"); err != nil {
+		t.Fatalf("Push() of an oversized item into an empty queue = %v", err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := q.Push(tctx, "x"); err == nil {
+		t.Error("Push() behind an oversized item did not block")
+	}
+	if v, _ := q.Pop(ctx); v != "too large" {
+		t.Errorf("Pop() = %q", v)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	q := mustNew(t, Options[string]{MaxBytes: 100, Spill: true, Dir: dir, SegmentSize: 256})
+	const n = 1000
+	for i := 0; i < n; i++ {
+		if err := q.Push(ctx, fmt.Sprintf("item-%04d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := q.Stats()
+	// 11 items of 9 bytes fit in memory
+	if s.Items != 11 || s.SpilledItems != n-11 || s.Spills != n-11 || s.Blocked != 0 {
+		t.Errorf("Stats() = %+v, want 11 in memory and the rest spilled", s)
+	}
+	// A record is two one-byte lengths and the item
+	if s.SpillFiles < 10 || s.SpilledBytes != int64(s.SpilledItems)*11 {
+		t.Errorf("Stats() = %+v, want 11-byte records over many files", s)
+	}
+
+	for i := 0; i < n; i++ {
+		v, err := q.Pop(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("item-%04d", i); v != want {
+			t.Fatalf("Pop() = %q, want %q", v, want)
+		}
+		// Segments are deleted as they are read
+		if i == n/2 {
+			if files := q.Stats().SpillFiles; files >= s.SpillFiles {
+				t.Errorf("%d spill files half way, started with %d", files, s.SpillFiles)
+			}
+		}
+	}
+	if s := q.Stats(); s.PeakBytes > 100 || s.Items+s.SpilledItems != 0 {
+		t.Errorf("Stats() = %+v after draining", s)
+	}
+	q.Close()
+	if _, err := q.Pop(ctx); err != ErrClosed {
+		t.Errorf("Pop() after Close = %v, want ErrClosed", err)
+	}
+	if dirEntries(t, dir) != 0 {
+		t.Error("spill directory left behind")
+	}
+}
+
+func TestSpillConcurrent(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(1))
+	const n = 5000
+	items := make([][]byte, n)
+	for i := range items {
+		items[i] = make([]byte, 4+rng.Intn(200))
+		binary.BigEndian.PutUint32(items[i], uint32(i))
+	}
+	const maxBytes = 2000
+	q := mustNew(t, Options[[]byte]{MaxBytes: maxBytes, Spill: true, Dir: t.TempDir(), SegmentSize: 4096})
+
+	go func() {
+		for _, it := range items {
+			if err := q.Push(ctx, it); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		q.Close()
+	}()
+	for i := 0; ; i++ {
+		v, err := q.Pop(ctx)
+		if err == ErrClosed {
+			if i != n {
+				t.Errorf("%d items popped, want %d", i, n)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := binary.BigEndian.Uint32(v); got != uint32(i) || len(v) != len(items[i]) {
+			t.Fatalf("item %d: got item %d of %d bytes", i, got, len(v))
+		}
+		if i%100 == 0 {
+			time.Sleep(100 * time.Microsecond) // let the spill build up
+		}
+	}
+	if peak := q.Stats().PeakBytes; peak > maxBytes {
+		t.Errorf("PeakBytes = %d, want at most %d", peak, maxBytes)
+	}
+}
+
+func TestMaxSpillBytes(t *testing.T) {
+	ctx := context.Background()
+	q := mustNew(t, Options[string]{MaxBytes: 10, Spill: true, Dir: t.TempDir(), MaxSpillBytes: 24})
+	defer q.Discard()
+	// 10 bytes in memory, then two 12-byte records on disk
+	for _, v := range []string{"aaaaaaaaaa", "bbbbbbbbbb", "cccccccccc"} {
+		if err := q.Push(ctx, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := q.Push(tctx, "dddddddddd"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Push() over MaxSpillBytes = %v, want it to block", err)
+	}
+	if s := q.Stats(); s.SpilledBytes != 24 || s.Blocked != 1 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
+
+func TestCodec(t *testing.T) {
+	if _, err := New(Options[int]{Spill: true}); err == nil {
+		t.Error("New() with Spill and no codec for int: want an error")
+	}
+
+	ctx := context.Background()
+	q := mustNew(t, Options[int]{
+		MaxBytes: 8,
+		Sizer:    func(int) int { return 8 },
+		Spill:    true,
+		Dir:      t.TempDir(),
+		Encode:   func(v int) ([]byte, error) { return binary.AppendVarint(nil, int64(v)), nil },
+		Decode: func(b []byte) (int, error) {
+			v, n := binary.Varint(b)
+			if n <= 0 {
+				return 0, errors.New("bad varint")
+			}
+			return int(v), nil
+		},
+	})
+	for i := -50; i < 50; i++ {
+		if err := q.Push(ctx, i*1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := -50; i < 50; i++ {
+		if v, err := q.Pop(ctx); err != nil || v != i*1000 {
+			t.Fatalf("Pop() = %d, %v, want %d", v, err, i*1000)
+		}
+	}
+
+	// An item that cannot be encoded is refused
+	bad := mustNew(t, Options[int]{MaxBytes: 1, Spill: true, Encode: func(int) ([]byte, error) {
+		return nil, errors.New("no")
+	}, Decode: func([]byte) (int, error) { return 0, nil }})
+	bad.Push(ctx, 1)
+	if err := bad.Push(ctx, 2); err == nil || bad.Len() != 1 {
+		t.Errorf("Push() of an item that fails to encode = %v", err)
+	}
+}
+
+func TestCloseAndDiscard(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	q := mustNew(t, Options[string]{MaxBytes: 1, Spill: true, Dir: dir})
+	q.Push(ctx, "a")
+	q.Push(ctx, "b")
+
+	// A waiting Pop returns the items, and ErrClosed after Close
+	empty := mustNew(t, Options[string]{})
+	popped := make(chan error)
+	go func() {
+		_, err := empty.Pop(ctx)
+		popped <- err
+	}()
+	empty.Close()
+	if err := <-popped; err != ErrClosed {
+		t.Errorf("Pop() on Close = %v, want ErrClosed", err)
+	}
+
+	q.Close()
+	if err := q.Push(ctx, "c"); err != ErrClosed {
+		t.Errorf("Push() after Close = %v, want ErrClosed", err)
+	}
+	if v, err := q.Pop(ctx); v != "a" || err != nil {
+		t.Errorf("Pop() after Close = %q, %v, want a", v, err)
+	}
+	if dirEntries(t, dir) != 1 {
+		t.Error("spill files removed before the queue drained")
+	}
+	q.Discard()
+	if _, err := q.Pop(ctx); err != ErrClosed {
+		t.Errorf("Pop() after Discard = %v, want ErrClosed", err)
+	}
+	if dirEntries(t, dir) != 0 {
+		t.Error("Discard() left spill files")
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	leaktest.Check(t)
+	tests := []struct {
+		name   string
+		cancel bool
+	}{
+		{"drain", false},
+		{"cancel", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			dir := t.TempDir()
+			q := mustNew(t, Options[string]{MaxBytes: 64, Spill: true, Dir: dir})
+
+			in := make(chan string)
+			go func() {
+				defer close(in)
+				for i := 0; i < 500; i++ {
+					select {
+					case in <- strings.Repeat("x", i%32):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			out := Buffer(ctx, in, q)
+			// Let the producer get ahead of a slow consumer
+			leaktest.WaitFor(t, func() bool { return q.Stats().Spills > 0 })
+
+			got := 0
+			for v := range out {
+				if len(v) != got%32 {
+					t.Fatalf("item %d has %d bytes, want %d", got, len(v), got%32)
+				}
+				got++
+				if tt.cancel && got == 100 {
+					cancel()
+				}
+			}
+			if !tt.cancel && got != 500 {
+				t.Errorf("Buffer() passed %d items, want 500", got)
+			}
+			if q.Err() != nil {
+				t.Errorf("Err() = %v", q.Err())
+			}
+			leaktest.WaitFor(t, func() bool { return dirEntries(t, dir) == 0 })
+		})
+	}
+}
+
+func BenchmarkQueue(b *testing.B) {
+	payload := make([]byte, 1024)
+	for _, bc := range []struct {
+		name  string
+		opts  Options[[]byte]
+		chanq bool
+	}{
+		{name: "chan", chanq: true},
+		{name: "memory", opts: Options[[]byte]{MaxBytes: 64 << 10}},
+		{name: "spill", opts: Options[[]byte]{MaxBytes: 64 << 10, Spill: true, Dir: b.TempDir()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			ctx := context.Background()
+			var wg sync.WaitGroup
+			wg.Add(1)
+			if bc.chanq {
+				ch := make(chan []byte, 64)
+				go func() {
+					defer wg.Done()
+					for range ch {
+					}
+				}()
+				for i := 0; i < b.N; i++ {
+					ch <- payload
+				}
+				close(ch)
+				wg.Wait()
+				return
+			}
+			q := mustNew(b, bc.opts)
+			go func() {
+				defer wg.Done()
+				for {
+					if _, err := q.Pop(ctx); err != nil {
+						return
+					}
+				}
+			}()
+			for i := 0; i < b.N; i++ {
+				q.Push(ctx, payload)
+			}
+			q.Close()
+			wg.Wait()
+		})
+	}
+}
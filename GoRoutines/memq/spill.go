@@ -0,0 +1,182 @@
+package memq
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Spill files hold records of two uvarints, the item's Sizer size and
+// the length of the encoded item, followed by the encoded item. The size
+// lets the reader check an item fits in memory before decoding it.
+// Records are appended to the newest file and read from the oldest; a
+// file is sealed before it is read and deleted once it is read to the
+// end.
+
+// spill is the on-disk part of a queue. It is guarded by the queue's
+// mutex.
+type spill struct {
+	dir         string
+	segmentSize int64
+	segs        []*segment // oldest first
+	next        int        // number of the next segment file
+
+	w  *os.File // newest segment, nil once sealed
+	bw *bufio.Writer
+	r  *os.File // oldest segment, nil until it is read
+	br *bufio.Reader
+
+	items int     // unread records
+	bytes int64   // unread record bytes
+	head  *header // of the next record, once peeked
+}
+
+type header struct {
+	size int64  // Sizer size of the item
+	n    uint64 // encoded length
+	len  int    // of the header
+}
+
+type segment struct {
+	path  string
+	items int // unread records
+	size  int64
+}
+
+func recordSize(size int64, data []byte) int64 {
+	return int64(uvarintLen(uint64(size)) + uvarintLen(uint64(len(data))) + len(data))
+}
+
+func uvarintLen(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+// write appends a record, starting a new segment when the newest one is
+// sealed or full
+func (s *spill) write(size int64, data []byte) error {
+	if s.w == nil {
+		path := filepath.Join(s.dir, fmt.Sprintf("%08d.spill", s.next))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		s.next++
+		s.w, s.bw = f, bufio.NewWriter(f)
+		s.segs = append(s.segs, &segment{path: path})
+	}
+	var hdr [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(size))
+	n += binary.PutUvarint(hdr[n:], uint64(len(data)))
+	if _, err := s.bw.Write(hdr[:n]); err != nil {
+		return err
+	}
+	if _, err := s.bw.Write(data); err != nil {
+		return err
+	}
+	seg := s.segs[len(s.segs)-1]
+	rec := int64(n + len(data))
+	seg.items++
+	seg.size += rec
+	s.items++
+	s.bytes += rec
+	if seg.size >= s.segmentSize {
+		return s.seal()
+	}
+	return nil
+}
+
+// seal flushes and closes the newest segment
+func (s *spill) seal() error {
+	if s.w == nil {
+		return nil
+	}
+	err := s.bw.Flush()
+	if cerr := s.w.Close(); err == nil {
+		err = cerr
+	}
+	s.w, s.bw = nil, nil
+	return err
+}
+
+// peek returns the Sizer size of the oldest record. There must be an
+// unread record.
+func (s *spill) peek() (int64, error) {
+	if s.head != nil {
+		return s.head.size, nil
+	}
+	if s.r == nil {
+		if len(s.segs) == 1 {
+			if err := s.seal(); err != nil {
+				return 0, err
+			}
+		}
+		f, err := os.Open(s.segs[0].path)
+		if err != nil {
+			return 0, err
+		}
+		s.r, s.br = f, bufio.NewReader(f)
+	}
+	size, err := binary.ReadUvarint(s.br)
+	if err != nil {
+		return 0, unexpected(err)
+	}
+	n, err := binary.ReadUvarint(s.br)
+	if err != nil {
+		return 0, unexpected(err)
+	}
+	s.head = &header{size: int64(size), n: n, len: uvarintLen(size) + uvarintLen(n)}
+	return s.head.size, nil
+}
+
+// read returns the oldest record and deletes its segment once it is
+// used up. There must be an unread record.
+func (s *spill) read() ([]byte, error) {
+	if _, err := s.peek(); err != nil {
+		return nil, err
+	}
+	h := s.head
+	data := make([]byte, h.n)
+	if _, err := io.ReadFull(s.br, data); err != nil {
+		return nil, unexpected(err)
+	}
+	s.head = nil
+	seg := s.segs[0]
+	seg.items--
+	s.items--
+	s.bytes -= int64(h.len) + int64(h.n)
+	if seg.items == 0 {
+		s.r.Close()
+		s.r, s.br = nil, nil
+		s.segs = s.segs[1:]
+		if err := os.Remove(seg.path); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// remove closes and deletes every spill file
+func (s *spill) remove() {
+	if s.w != nil {
+		s.w.Close()
+	}
+	if s.r != nil {
+		s.r.Close()
+	}
+	os.RemoveAll(s.dir)
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+// Package pipeline provides generic stages for channel pipelines like the
+// generate → square → filterEven chain of task 3.
+//
+// Batch groups items for sinks that prefer bulk writes, flushing on a
+// count, a byte size or a latency limit; Unbatch turns batches back into
+// single items. Stage runs a function that can fail or panic over every
+// item, so one bad item cannot crash the pipeline.
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+// cancelGrace is how long Batch waits to hand off its last batch after
+// ctx is cancelled
+const cancelGrace = 100 * time.Millisecond
+
+// BatchOptions configure Batch. A batch is flushed as soon as any limit
+// is reached; with no limits set everything ends up in one batch that is
+// flushed when the input closes.
+type BatchOptions[T any] struct {
+	// Size is the maximum number of items in a batch (0 = no limit)
+	Size int
+	// MaxBytes is the maximum total Sizer size of a batch (0 = no limit).
+	// An item bigger than MaxBytes is sent in a batch of its own.
+	MaxBytes int
+	// Sizer returns the size of an item for MaxBytes [1 per item]
+	Sizer func(T) int
+	// MaxLatency is the longest the first item of a batch waits before
+	// the batch is flushed (0 = no limit)
+	MaxLatency time.Duration
+	// Clock drives MaxLatency [real time]
+	Clock clock.Clock
+}
+
+// Batch reads items from in and sends them in batches, in order. When in
+// is closed or ctx is cancelled the last partial batch is sent and then
+// the output is closed. After a cancel the send waits only a short grace
+// period, so a consumer that stopped reading cannot hold Batch; the batch
+// is dropped if nobody takes it by then.
+func Batch[T any](ctx context.Context, in <-chan T, opts BatchOptions[T]) <-chan []T {
+	clk := clock.Or(opts.Clock)
+	sizer := opts.Sizer
+	if sizer == nil {
+		sizer = func(T) int { return 1 }
+	}
+
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+		var (
+			batch   []T
+			bytes   int
+			timer   clock.Timer
+			timeout <-chan time.Time
+		)
+		// flush sends the batch; it reports false if ctx was cancelled
+		// first, leaving the batch unsent
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, timeout = nil, nil
+			}
+			if len(batch) == 0 {
+				return true
+			}
+			select {
+			case out <- batch:
+			case <-ctx.Done():
+				return false
+			}
+			batch, bytes = nil, 0
+			return true
+		}
+		// handOff makes a last attempt to send the batch once ctx is
+		// cancelled
+		handOff := func() {
+			if timer != nil {
+				timer.Stop()
+			}
+			if len(batch) == 0 {
+				return
+			}
+			grace := time.NewTimer(cancelGrace)
+			defer grace.Stop()
+			select {
+			case out <- batch:
+			case <-grace.C:
+			}
+		}
+
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				n := sizer(v)
+				if opts.MaxBytes > 0 && len(batch) > 0 && bytes+n > opts.MaxBytes && !flush() {
+					handOff()
+					return
+				}
+				if len(batch) == 0 && opts.MaxLatency > 0 {
+					timer = clk.NewTimer(opts.MaxLatency)
+					timeout = timer.C()
+				}
+				batch = append(batch, v)
+				bytes += n
+				if (opts.Size > 0 && len(batch) >= opts.Size) || (opts.MaxBytes > 0 && bytes >= opts.MaxBytes) {
+					if !flush() {
+						handOff()
+						return
+					}
+				}
+			case <-timeout:
+				if !flush() {
+					handOff()
+					return
+				}
+			case <-ctx.Done():
+				handOff()
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Unbatch sends the items of every batch from in one by one. The output
+// is closed when in is closed or ctx is cancelled.
+func Unbatch[T any](ctx context.Context, in <-chan []T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case batch, ok := <-in:
+				if !ok {
+					return
+				}
+				for _, v := range batch {
+					select {
+					case out <- v:
+					case <-ctx.Done():
+						return
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,288 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+	"github.com/go-concurrency-lesson/internal/leaktest"
+	"github.com/go-concurrency-lesson/safe"
+)
+
+func TestBatch(t *testing.T) {
+	words := []string{"ab", "cd", "e", "fghijk", "l"}
+	tests := []struct {
+		name string
+		opts BatchOptions[string]
+		want [][]string
+	}{
+		{"size", BatchOptions[string]{Size: 2}, [][]string{{"ab", "cd"}, {"e", "fghijk"}, {"l"}}},
+		{"size one", BatchOptions[string]{Size: 1}, [][]string{{"ab"}, {"cd"}, {"e"}, {"fghijk"}, {"l"}}},
+		{"bytes", BatchOptions[string]{MaxBytes: 5, Sizer: func(s string) int { return len(s) }},
+			[][]string{{"ab", "cd", "e"}, {"fghijk"}, {"l"}}},
+		{"bytes before size", BatchOptions[string]{Size: 2, MaxBytes: 4, Sizer: func(s string) int { return len(s) }},
+			[][]string{{"ab", "cd"}, {"e"}, {"fghijk"}, {"l"}}},
+		{"bytes without sizer", BatchOptions[string]{MaxBytes: 3}, [][]string{{"ab", "cd", "e"}, {"fghijk", "l"}}},
+		{"no limits", BatchOptions[string]{}, [][]string{words}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaktest.Check(t)
+			got := leaktest.Drain(Batch(context.Background(), leaktest.Source(words...), tt.opts))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Batch() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := leaktest.Drain(Batch(context.Background(), leaktest.Source[int](), BatchOptions[int]{Size: 3})); len(got) != 0 {
+		t.Errorf("Batch() of empty input = %v, want no batches", got)
+	}
+}
+
+func TestBatchMaxLatency(t *testing.T) {
+	leaktest.Check(t)
+	fake := clock.NewFake(time.Now())
+	in := make(chan int)
+	defer close(in)
+	out := Batch(context.Background(), in, BatchOptions[int]{Size: 10, MaxLatency: 100 * time.Millisecond, Clock: fake})
+
+	expectNone := func() {
+		t.Helper()
+		select {
+		case b := <-out:
+			t.Fatalf("batch %v flushed early", b)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	expect := func(want ...int) {
+		t.Helper()
+		select {
+		case b := <-out:
+			if !reflect.DeepEqual(b, want) {
+				t.Fatalf("batch = %v, want %v", b, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("batch %v not flushed", want)
+		}
+	}
+
+	in <- 1
+	in <- 2
+	fake.BlockUntil(1)
+	fake.Advance(99 * time.Millisecond)
+	expectNone()
+	fake.Advance(time.Millisecond)
+	expect(1, 2)
+
+	// The latency counts from the first item of a batch
+	in <- 3
+	fake.BlockUntil(1)
+	fake.Advance(50 * time.Millisecond)
+	in <- 4
+	fake.Advance(50 * time.Millisecond)
+	expect(3, 4)
+}
+
+func TestBatchSizeStopsTimer(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	in := make(chan int)
+	out := Batch(context.Background(), in, BatchOptions[int]{Size: 2, MaxLatency: time.Second, Clock: fake})
+	in <- 1
+	in <- 2
+	if b := <-out; !reflect.DeepEqual(b, []int{1, 2}) {
+		t.Errorf("batch = %v, want [1 2]", b)
+	}
+	if n := fake.Waiters(); n != 0 {
+		t.Errorf("%d timers pending after a full batch, want 0", n)
+	}
+	close(in)
+	<-out
+}
+
+func TestBatchCancelFlushes(t *testing.T) {
+	leaktest.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	out := Batch(ctx, in, BatchOptions[int]{Size: 10})
+	in <- 1
+	in <- 2
+	cancel()
+
+	if got := leaktest.Drain(out); !reflect.DeepEqual(got, [][]int{{1, 2}}) {
+		t.Errorf("batches after cancel = %v, want [[1 2]]", got)
+	}
+}
+
+func TestBatchCancel(t *testing.T) {
+	// A partial batch nobody reads must not hold Batch after cancel
+	t.Run("partial batch", func(t *testing.T) {
+		leaktest.Check(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		in := make(chan int)
+		Batch(ctx, in, BatchOptions[int]{Size: 10})
+		in <- 1
+		in <- 2
+		cancel()
+	})
+
+	// Nor a full batch
+	t.Run("blocked flush", func(t *testing.T) {
+		leaktest.Check(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		in := make(chan int)
+		Batch(ctx, in, BatchOptions[int]{Size: 2})
+		in <- 1
+		in <- 2
+		cancel()
+	})
+}
+
+func TestUnbatch(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	items := []int{1, 2, 3, 4, 5, 6, 7}
+	got := leaktest.Drain(Unbatch(ctx, Batch(ctx, leaktest.Source(items...), BatchOptions[int]{Size: 3})))
+	if !reflect.DeepEqual(got, items) {
+		t.Errorf("Unbatch(Batch()) = %v, want %v", got, items)
+	}
+
+	if got := leaktest.Drain(Unbatch(ctx, leaktest.Source([]int{}, nil, []int{8}))); !reflect.DeepEqual(got, []int{8}) {
+		t.Errorf("Unbatch() with empty batches = %v, want [8]", got)
+	}
+}
+
+func TestUnbatchCancel(t *testing.T) {
+	leaktest.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan []int, 1)
+	in <- []int{1, 2, 3}
+	out := Unbatch(ctx, in)
+	<-out
+	cancel()
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("Unbatch() output not closed after cancel")
+	case _, ok := <-out:
+		for ok {
+			_, ok = <-out
+		}
+	}
+}
+
+func TestStage(t *testing.T) {
+	const n = 300
+	for seed := int64(1); seed <= 5; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		bad := map[int]bool{}
+		items := make([]int, n)
+		for i := range items {
+			items[i] = i
+			if rng.Intn(8) == 0 {
+				bad[i] = true
+			}
+		}
+		// halve panics on the bad items and fails on odd ones
+		halve := func(_ context.Context, v int) (int, error) {
+			if bad[v] {
+				panic(fmt.Sprintf("bad item %d", v))
+			}
+			if v%2 == 1 {
+				return 0, errors.New("odd")
+			}
+			return v / 2, nil
+		}
+
+		for _, policy := range []safe.Policy{safe.SkipItem, safe.RestartWorker} {
+			t.Run(fmt.Sprintf("seed %d/%v", seed, policy), func(t *testing.T) {
+				leaktest.Check(t)
+				var mu sync.Mutex
+				var panics, failures int
+				ctx := context.Background()
+				out := Stage(ctx, leaktest.Source(items...), halve, StageOptions{
+					Workers: 3,
+					OnPanic: policy,
+					OnError: func(err error) {
+						mu.Lock()
+						defer mu.Unlock()
+						if safe.IsPanic(err) {
+							panics++
+						} else {
+							failures++
+						}
+					},
+				})
+				// A later stage keeps working after an earlier one panicked
+				var want []int
+				for _, v := range items {
+					if !bad[v] && v%2 == 0 {
+						want = append(want, v/2)
+					}
+				}
+				got := leaktest.Drain(Unbatch(ctx, Batch(ctx, out, BatchOptions[int]{Size: 16})))
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Stage() = %v, want %v", got, want)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				oddBad := 0
+				for v := range bad {
+					oddBad += v % 2
+				}
+				if panics != len(bad) || failures != n/2-oddBad {
+					t.Errorf("OnError saw %d panics and %d failures, want %d and %d", panics, failures, len(bad), n/2-oddBad)
+				}
+			})
+		}
+	}
+}
+
+func TestStageFailFast(t *testing.T) {
+	leaktest.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; ; i++ {
+			select {
+			case in <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var errs []error
+	out := Stage(ctx, in, func(_ context.Context, v int) (int, error) {
+		if v == 50 {
+			panic("boom")
+		}
+		return v, nil
+	}, StageOptions{Workers: 4, OnError: func(err error) { errs = append(errs, err) }})
+
+	got := leaktest.Drain(out)
+	if len(got) != 50 || got[49] != 49 {
+		t.Errorf("Stage() returned %d items, want the 50 before the panic", len(got))
+	}
+	var pe *safe.PanicError
+	if len(errs) != 1 || !errors.As(errs[0], &pe) || pe.Input != 50 {
+		t.Errorf("OnError got %v, want the panic on 50", errs)
+	}
+}
+
+func BenchmarkBatch(b *testing.B) {
+	ctx := context.Background()
+	items := make([]int, 1000)
+	for i := 0; i < b.N; i++ {
+		for range Batch(ctx, leaktest.Source(items...), BatchOptions[int]{Size: 64}) {
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/go-concurrency-lesson/pool"
+	"github.com/go-concurrency-lesson/safe"
+)
+
+// StageOptions configure Stage
+type StageOptions struct {
+	// Workers is the number of goroutines running fn; results keep the
+	// input order either way [1]
+	Workers int
+	// OnPanic decides what happens after fn panics [safe.FailFast]
+	OnPanic safe.Policy
+	// OnError is called with the error of every item that failed or
+	// panicked (a *safe.PanicError); nil ignores them
+	OnError func(error)
+}
+
+// Stage applies fn to every item from in and sends the results, in
+// order. Items for which fn fails are reported to OnError and left out.
+// A panic is recovered and reported the same way; with FailFast it also
+// ends the stage, which closes its output like a closed input would.
+func Stage[T, R any](ctx context.Context, in <-chan T, fn func(context.Context, T) (R, error), opts StageOptions) <-chan R {
+	results := pool.RunSafe(ctx, in, fn, pool.Options{
+		Workers: opts.Workers,
+		Ordered: true,
+		OnPanic: opts.OnPanic,
+	})
+	out := make(chan R)
+	go func() {
+		defer close(out)
+		for r := range results {
+			if r.Err != nil {
+				if opts.OnError != nil {
+					opts.OnError(r.Err)
+				}
+				continue
+			}
+			select {
+			case out <- r.Value:
+			case <-ctx.Done():
+				// results closes once ctx is done
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,152 @@
+// Package pool provides generic versions of the worker pool and
+// fan-out/fan-in patterns from the homework.
+//
+// Run processes a stream with a fixed number of workers and returns
+// results as they finish; RunOrdered returns them in input order. FanOut
+// and Merge are the two halves of fan-out/fan-in. RunSafe and MapSafe
+// take functions that can fail or panic and report each item's error;
+// a panic never takes down the process. Every function stops
+// when its context is cancelled and closes its output channels, so no
+// goroutine is left behind.
+package pool
+
+import (
+	"context"
+
+	"github.com/go-concurrency-lesson/chanx"
+)
+
+// Run starts workers goroutines that apply fn to every value from in and
+// send the results on the returned channel, in completion order. The
+// channel is closed once in is drained or ctx is cancelled. workers < 1
+// is treated as 1.
+func Run[T, R any](ctx context.Context, workers int, in <-chan T, fn func(context.Context, T) R) <-chan R {
+	return Merge(ctx, FanOut(ctx, in, max(workers, 1), fn)...)
+}
+
+// FanOut starts n workers reading from the same input channel. Each
+// worker applies fn and sends its results on its own channel.
+func FanOut[T, R any](ctx context.Context, in <-chan T, n int, fn func(context.Context, T) R) []<-chan R {
+	outs := make([]<-chan R, n)
+	for i := range outs {
+		out := make(chan R)
+		outs[i] = out
+		go func() {
+			defer close(out)
+			for {
+				select {
+				case v, ok := <-in:
+					if !ok {
+						return
+					}
+					select {
+					case out <- fn(ctx, v):
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return outs
+}
+
+// Merge forwards the values of every input channel to one output channel,
+// which is closed when all inputs are closed or ctx is cancelled
+func Merge[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	return chanx.Merge(ctx, ins...)
+}
+
+// RunOrdered is Run with results delivered in the order of in. At most
+// workers results wait for a slower earlier one, so memory stays bounded
+// and a slow item holds back only a window of the stream.
+func RunOrdered[T, R any](ctx context.Context, workers int, in <-chan T, fn func(context.Context, T) R) <-chan R {
+	workers = max(workers, 1)
+	type job struct {
+		v   T
+		res chan R
+	}
+	jobs := make(chan job)
+	// order holds one result slot per job, in input order; its buffer is
+	// the reorder window
+	order := make(chan chan R, workers)
+
+	go func() {
+		defer close(jobs)
+		defer close(order)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				j := job{v: v, res: make(chan R, 1)}
+				select {
+				case order <- j.res:
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case jobs <- j:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range jobs {
+				// res is buffered, so this never blocks
+				j.res <- fn(ctx, j.v)
+			}
+		}()
+	}
+
+	out := make(chan R)
+	go func() {
+		defer close(out)
+		for res := range order {
+			var r R
+			select {
+			case r = <-res:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Map applies fn to every item with workers goroutines and returns the
+// results in the order of items. If ctx is cancelled it returns the
+// results finished so far and ctx.Err().
+func Map[T, R any](ctx context.Context, items []T, workers int, fn func(context.Context, T) R) ([]R, error) {
+	in := make(chan T)
+	go func() {
+		defer close(in)
+		for _, v := range items {
+			select {
+			case in <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make([]R, 0, len(items))
+	for r := range RunOrdered(ctx, workers, in, fn) {
+		results = append(results, r)
+	}
+	return results, ctx.Err()
+}
@@ -0,0 +1,341 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/internal/leaktest"
+	"github.com/go-concurrency-lesson/safe"
+)
+
+func feed(ctx context.Context, n int) <-chan int {
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < n; i++ {
+			select {
+			case in <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return in
+}
+
+// jitter makes later items finish first
+func jitter(_ context.Context, v int) int {
+	time.Sleep(time.Duration(5-v%5) * time.Millisecond)
+	return v * v
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		n       int
+	}{
+		{"empty", 3, 0},
+		{"single worker", 1, 10},
+		{"several workers", 4, 50},
+		{"zero workers means one", 0, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaktest.Check(t)
+			ctx := context.Background()
+			got := leaktest.Drain(Run(ctx, tt.workers, feed(ctx, tt.n), jitter))
+			sort.Ints(got)
+			if len(got) != tt.n {
+				t.Fatalf("Run() returned %d results, want %d", len(got), tt.n)
+			}
+			for i, v := range got {
+				if v != i*i {
+					t.Errorf("Run() sorted result[%d] = %d, want %d", i, v, i*i)
+				}
+			}
+		})
+	}
+}
+
+func TestRunConcurrency(t *testing.T) {
+	var active, peak atomic.Int32
+	fn := func(_ context.Context, v int) int {
+		n := active.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		active.Add(-1)
+		return v
+	}
+
+	ctx := context.Background()
+	leaktest.Drain(Run(ctx, 3, feed(ctx, 30), fn))
+	if p := peak.Load(); p > 3 {
+		t.Errorf("Run() peak concurrency %d, want at most 3", p)
+	}
+	peak.Store(0)
+	leaktest.Drain(RunOrdered(ctx, 3, feed(ctx, 30), fn))
+	if p := peak.Load(); p > 3 {
+		t.Errorf("RunOrdered() peak concurrency %d, want at most 3", p)
+	}
+}
+
+func TestRunOrdered(t *testing.T) {
+	leaktest.Check(t)
+	for _, workers := range []int{1, 3, 8} {
+		ctx := context.Background()
+		got := leaktest.Drain(RunOrdered(ctx, workers, feed(ctx, 40), jitter))
+		if len(got) != 40 {
+			t.Fatalf("RunOrdered(workers=%d) returned %d results, want 40", workers, len(got))
+		}
+		for i, v := range got {
+			if v != i*i {
+				t.Errorf("RunOrdered(workers=%d) result[%d] = %d, want %d", workers, i, v, i*i)
+				break
+			}
+		}
+	}
+}
+
+func TestRunOrderedWindow(t *testing.T) {
+	// Item 0 blocks; the others may only run ahead by the window
+	release := make(chan struct{})
+	var started atomic.Int32
+	fn := func(_ context.Context, v int) int {
+		started.Add(1)
+		if v == 0 {
+			<-release
+		}
+		return v
+	}
+
+	ctx := context.Background()
+	out := RunOrdered(ctx, 2, feed(ctx, 100), fn)
+	time.Sleep(20 * time.Millisecond)
+	// workers running plus the window of waiting results
+	if n := started.Load(); n > 2+2+1 {
+		t.Errorf("%d items started while the first was stuck, want a bounded window", n)
+	}
+	close(release)
+	if got := leaktest.Drain(out); len(got) != 100 {
+		t.Errorf("RunOrdered() returned %d results, want 100", len(got))
+	}
+}
+
+func TestCancel(t *testing.T) {
+	runners := map[string]func(context.Context, int, <-chan int, func(context.Context, int) int) <-chan int{
+		"Run":        Run[int, int],
+		"RunOrdered": RunOrdered[int, int],
+	}
+	for name, run := range runners {
+		t.Run(name, func(t *testing.T) {
+			leaktest.Check(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			out := run(ctx, 4, feed(ctx, 1000), func(ctx context.Context, v int) int {
+				select {
+				case <-time.After(time.Millisecond):
+				case <-ctx.Done():
+				}
+				return v
+			})
+			<-out
+			<-out
+			cancel()
+
+			done := make(chan int)
+			go func() { done <- len(leaktest.Drain(out)) }()
+			select {
+			case n := <-done:
+				if n >= 998 {
+					t.Errorf("%s() delivered %d more results after cancel", name, n)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s() output not closed after cancel", name)
+			}
+		})
+	}
+}
+
+func TestFanOutMerge(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	outs := FanOut(ctx, feed(ctx, 20), 4, func(_ context.Context, v int) int { return v + 100 })
+	if len(outs) != 4 {
+		t.Fatalf("FanOut() returned %d channels, want 4", len(outs))
+	}
+	got := leaktest.Drain(Merge(ctx, outs...))
+	sort.Ints(got)
+	for i, v := range got {
+		if v != i+100 {
+			t.Fatalf("merged result[%d] = %d, want %d", i, v, i+100)
+		}
+	}
+	if len(leaktest.Drain(Merge[int](ctx))) != 0 {
+		t.Error("Merge() of no channels returned values")
+	}
+}
+
+func TestMap(t *testing.T) {
+	leaktest.Check(t)
+	got, err := Map(context.Background(), []string{"a", "bb", "ccc"}, 2, func(_ context.Context, s string) int {
+		return len(s)
+	})
+	if err != nil || len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("Map() = %v, %v, want [1 2 3]", got, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Map(ctx, []int{1, 2, 3}, 2, jitter); !errors.Is(err, context.Canceled) {
+		t.Errorf("Map() on cancelled context error = %v, want context.Canceled", err)
+	}
+}
+
+// panicky squares its input but panics on the items in bad and fails on
+// negative ones
+func panicky(bad map[int]bool) func(context.Context, int) (int, error) {
+	return func(_ context.Context, v int) (int, error) {
+		if bad[v] {
+			panic(fmt.Sprintf("bad item %d", v))
+		}
+		if v < 0 {
+			return 0, errors.New("negative")
+		}
+		return v * v, nil
+	}
+}
+
+// randomPanics picks about one item in ten, at random but reproducibly
+func randomPanics(seed int64, n int) map[int]bool {
+	rng := rand.New(rand.NewSource(seed))
+	bad := map[int]bool{}
+	for i := 0; i < n; i++ {
+		if rng.Intn(10) == 0 {
+			bad[i] = true
+		}
+	}
+	return bad
+}
+
+func TestRunSafe(t *testing.T) {
+	const n = 200
+	for seed := int64(1); seed <= 5; seed++ {
+		bad := randomPanics(seed, n)
+		for _, policy := range []safe.Policy{safe.SkipItem, safe.RestartWorker} {
+			for _, ordered := range []bool{false, true} {
+				t.Run(fmt.Sprintf("seed %d/%v/ordered=%v", seed, policy, ordered), func(t *testing.T) {
+					leaktest.Check(t)
+					var restarts atomic.Int32
+					ctx := context.Background()
+					out := RunSafe(ctx, feed(ctx, n), panicky(bad), Options{
+						Workers:   4,
+						Ordered:   ordered,
+						OnPanic:   policy,
+						OnRestart: func(error) { restarts.Add(1) },
+					})
+					got := leaktest.Drain(out)
+					if len(got) != n {
+						t.Fatalf("RunSafe() returned %d results, want %d", len(got), n)
+					}
+					panics := 0
+					for i, r := range got {
+						if ordered && r.Input != i {
+							t.Fatalf("result %d is for input %d, want input order", i, r.Input)
+						}
+						var pe *safe.PanicError
+						switch {
+						case bad[r.Input]:
+							panics++
+							if !errors.As(r.Err, &pe) || pe.Input != r.Input || len(pe.Stack) == 0 {
+								t.Errorf("result for %d = %v, want a PanicError with input and stack", r.Input, r.Err)
+							}
+						case r.Err != nil || r.Value != r.Input*r.Input:
+							t.Errorf("result for %d = %d, %v, want %d", r.Input, r.Value, r.Err, r.Input*r.Input)
+						}
+					}
+					if panics != len(bad) {
+						t.Errorf("got %d panics, want %d", panics, len(bad))
+					}
+					wantRestarts := 0
+					if policy == safe.RestartWorker {
+						wantRestarts = len(bad)
+					}
+					if got := int(restarts.Load()); got != wantRestarts {
+						t.Errorf("%d workers restarted, want %d", got, wantRestarts)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestRunSafeFailFast(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		for _, ordered := range []bool{false, true} {
+			t.Run(fmt.Sprintf("seed %d/ordered=%v", seed, ordered), func(t *testing.T) {
+				leaktest.Check(t)
+				bad := randomPanics(seed, 1000)
+				// feed is left blocked on the rest of the input
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				got := leaktest.Drain(RunSafe(ctx, feed(ctx, 1000), panicky(bad), Options{Workers: 4, Ordered: ordered}))
+				if len(got) == 0 || len(got) >= 1000 {
+					t.Fatalf("RunSafe() returned %d results, want it to stop early", len(got))
+				}
+				last := got[len(got)-1]
+				if !safe.IsPanic(last.Err) || !bad[last.Input] {
+					t.Errorf("last result = %v for %d, want the panic", last.Err, last.Input)
+				}
+				for _, r := range got[:len(got)-1] {
+					if r.Err != nil {
+						t.Errorf("result for %d before the panic = %v", r.Input, r.Err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestMapSafe(t *testing.T) {
+	leaktest.Check(t)
+	items := []int{1, -2, 3, 4, 5}
+	got, err := MapSafe(context.Background(), items, panicky(map[int]bool{4: true}), Options{Workers: 2, OnPanic: safe.SkipItem})
+	if err != nil || len(got) != 5 {
+		t.Fatalf("MapSafe() = %d results, %v, want 5, nil", len(got), err)
+	}
+	if got[0].Value != 1 || got[1].Err == nil || safe.IsPanic(got[1].Err) || !safe.IsPanic(got[3].Err) || got[4].Value != 25 {
+		t.Errorf("MapSafe() = %+v", got)
+	}
+
+	got, err = MapSafe(context.Background(), items, panicky(map[int]bool{4: true}), Options{Workers: 2})
+	if !safe.IsPanic(err) || len(got) != 4 {
+		t.Errorf("MapSafe() with FailFast = %d results, %v, want 4 and the panic", len(got), err)
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	square := func(_ context.Context, v int) int { return v * v }
+	ctx := context.Background()
+	b.Run("unordered", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			leaktest.Drain(Run(ctx, 4, feed(ctx, 100), square))
+		}
+	})
+	b.Run("ordered", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			leaktest.Drain(RunOrdered(ctx, 4, feed(ctx, 100), square))
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package pool
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-concurrency-lesson/safe"
+)
+
+// Result is the outcome of one item of RunSafe
+type Result[T, R any] struct {
+	Input T
+	Value R
+	// Err is the error fn returned, or a *safe.PanicError if it panicked
+	Err error
+}
+
+// Options configure RunSafe and MapSafe
+type Options struct {
+	// Workers is the number of worker goroutines [1]
+	Workers int
+	// Ordered delivers results in input order instead of completion order
+	Ordered bool
+	// OnPanic decides what happens after fn panics [safe.FailFast]
+	OnPanic safe.Policy
+	// OnRestart is called with the panic each time RestartWorker replaces
+	// a worker
+	OnRestart func(err error)
+}
+
+// RunSafe is Run for functions that can fail or panic. Every item yields
+// a Result; a panic in fn is recovered and reported as a *safe.PanicError
+// holding the item and the stack, then handled by opts.OnPanic. With
+// FailFast the panic is the last result and the run is cancelled. The
+// output is closed once every worker has exited.
+func RunSafe[T, R any](ctx context.Context, in <-chan T, fn func(context.Context, T) (R, error), opts Options) <-chan Result[T, R] {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	workers := max(opts.Workers, 1)
+
+	type job struct {
+		v   T
+		res chan Result[T, R] // buffered, filled exactly once
+	}
+	jobs := make(chan job)
+	// order holds the result slots in input order when opts.Ordered
+	var order chan chan Result[T, R]
+	if opts.Ordered {
+		order = make(chan chan Result[T, R], workers)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		if order != nil {
+			defer close(order)
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				j := job{v: v, res: make(chan Result[T, R], 1)}
+				if order != nil {
+					select {
+					case order <- j.res:
+					case <-ctx.Done():
+						return
+					}
+				}
+				select {
+				case jobs <- j:
+				case <-ctx.Done():
+					// the slot is already queued, so fill it
+					j.res <- Result[T, R]{Input: v, Err: ctx.Err()}
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// slots yields the result slots in the order results are delivered:
+	// input order, or the order workers finish them in
+	slots := order
+	if slots == nil {
+		slots = make(chan chan Result[T, R])
+	}
+
+	call := func(v T) (R, error) { return fn(ctx, v) }
+	var work func()
+	work = func() {
+		defer wg.Done()
+		for j := range jobs {
+			r, err := safe.Call(call, j.v)
+			j.res <- Result[T, R]{Input: j.v, Value: r, Err: err}
+			if order == nil {
+				select {
+				case slots <- j.res:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if _, panicked := err.(*safe.PanicError); !panicked {
+				continue
+			}
+			switch opts.OnPanic {
+			case safe.SkipItem:
+			case safe.RestartWorker:
+				if opts.OnRestart != nil {
+					opts.OnRestart(err)
+				}
+				wg.Add(1)
+				go work()
+				return
+			default:
+				cancel()
+			}
+		}
+	}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go work()
+	}
+	if order == nil {
+		go func() {
+			wg.Wait()
+			close(slots)
+		}()
+	}
+
+	out := make(chan Result[T, R])
+	go func() {
+		defer close(out)
+		defer wg.Wait()
+		defer cancel()
+		for res := range slots {
+			var r Result[T, R]
+			select {
+			case r = <-res:
+			case <-parent.Done():
+				return
+			}
+			select {
+			case out <- r:
+			case <-parent.Done():
+				return
+			}
+			if _, panicked := r.Err.(*safe.PanicError); panicked && opts.OnPanic == safe.FailFast {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// MapSafe applies fn to every item like Map but returns a Result per
+// item, in the order of items. The error is the panic that stopped a
+// FailFast run or ctx.Err(); the results before it are still returned.
+func MapSafe[T, R any](ctx context.Context, items []T, fn func(context.Context, T) (R, error), opts Options) ([]Result[T, R], error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	in := make(chan T)
+	go func() {
+		defer close(in)
+		for _, v := range items {
+			select {
+			case in <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	opts.Ordered = true
+	results := make([]Result[T, R], 0, len(items))
+	for r := range RunSafe(ctx, in, fn, opts) {
+		results = append(results, r)
+		if _, panicked := r.Err.(*safe.PanicError); panicked && opts.OnPanic == safe.FailFast {
+			return results, r.Err
+		}
+	}
+	return results, ctx.Err()
+}
@@ -0,0 +1,350 @@
+// Package pubsub is an in-process publish/subscribe broker built on
+// channels.
+//
+// Subscribers choose topics with wildcard patterns and get a bounded
+// buffer; what happens when it is full is set per subscriber by a Policy.
+// Stats reports how far every subscriber lags behind. Close stops new
+// publishes, lets in-flight ones finish and closes every subscription,
+// whose buffered messages can still be read.
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-concurrency-lesson/chanx"
+)
+
+var (
+	// ErrClosed is returned by a closed broker and is the Err of its
+	// subscriptions after Close
+	ErrClosed = errors.New("pubsub: broker closed")
+	// ErrSlowConsumer is the Err of a subscription dropped by the
+	// Disconnect policy
+	ErrSlowConsumer = errors.New("pubsub: subscriber too slow")
+)
+
+// Policy decides what Publish does when a subscriber's buffer is full
+type Policy = chanx.Policy
+
+const (
+	// Block waits for the subscriber to make room
+	Block = chanx.Block
+	// DropNewest discards the message being published
+	DropNewest = chanx.DropNewest
+	// DropOldest discards the oldest buffered message to make room
+	DropOldest = chanx.DropOldest
+	// Disconnect closes the subscription with ErrSlowConsumer
+	Disconnect = chanx.Disconnect
+)
+
+// Message is one published value
+type Message[T any] struct {
+	Topic   string
+	Payload T
+}
+
+// SubOptions configure a subscription
+type SubOptions struct {
+	// Buffer is the number of messages held for the subscriber [64]
+	Buffer int
+	// Policy applies when the buffer is full [Block]
+	Policy Policy
+}
+
+// SubStats describe one subscription
+type SubStats struct {
+	ID      uint64
+	Pattern string
+	Policy  Policy
+	Buffer  int
+	// Pending is the number of messages waiting to be read: the lag
+	Pending int
+	// MaxPending is the highest Pending seen
+	MaxPending int
+	// Delivered and Dropped count messages put into or lost before the
+	// buffer
+	Delivered uint64
+	Dropped   uint64
+	// Blocked is the total time publishers waited on this subscriber
+	Blocked time.Duration
+}
+
+// Broker routes published messages to matching subscriptions. The zero
+// value is not usable; create brokers with New.
+type Broker[T any] struct {
+	mu       sync.RWMutex
+	subs     map[uint64]*Subscription[T]
+	nextID   uint64
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+// New creates an empty broker
+func New[T any]() *Broker[T] {
+	return &Broker[T]{subs: make(map[uint64]*Subscription[T])}
+}
+
+// Subscribe registers a subscription for the topics matching pattern
+func (b *Broker[T]) Subscribe(pattern string, opts SubOptions) (*Subscription[T], error) {
+	tokens, err := splitPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 64
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	b.nextID++
+	s := &Subscription[T]{
+		b:       b,
+		id:      b.nextID,
+		pattern: pattern,
+		tokens:  tokens,
+		opts:    opts,
+		ch:      make(chan Message[T], opts.Buffer),
+		done:    make(chan struct{}),
+	}
+	b.subs[s.id] = s
+	return s, nil
+}
+
+// Publish sends payload to every subscription matching topic, applying
+// each one's policy. It returns ctx.Err() if ctx is done while waiting on
+// a Block subscriber, in which case later subscribers are skipped.
+func (b *Broker[T]) Publish(ctx context.Context, topic string, payload T) error {
+	tokens, err := splitTopic(topic)
+	if err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return ErrClosed
+	}
+	b.inflight.Add(1)
+	defer b.inflight.Done()
+	var targets []*Subscription[T]
+	for _, s := range b.subs {
+		if match(s.tokens, tokens) {
+			targets = append(targets, s)
+		}
+	}
+	b.mu.RUnlock()
+
+	// Deliver in subscription order so one publisher's messages keep
+	// their order everywhere
+	sort.Slice(targets, func(i, j int) bool { return targets[i].id < targets[j].id })
+	msg := Message[T]{Topic: topic, Payload: payload}
+	for _, s := range targets {
+		if err := s.deliver(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the broker: Publish and Subscribe fail with ErrClosed, and
+// once in-flight publishes finish every subscription is closed. If ctx is
+// done first, publishers still blocked are released and Close returns
+// ctx.Err().
+func (b *Broker[T]) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	subs := make([]*Subscription[T], 0, len(b.subs))
+	for _, s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		b.inflight.Wait()
+		close(finished)
+	}()
+	var err error
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	for _, s := range subs {
+		s.close(ErrClosed)
+	}
+	<-finished
+	return err
+}
+
+// Stats returns the stats of every open subscription, ordered by ID
+func (b *Broker[T]) Stats() []SubStats {
+	b.mu.RLock()
+	stats := make([]SubStats, 0, len(b.subs))
+	for _, s := range b.subs {
+		stats = append(stats, s.Stats())
+	}
+	b.mu.RUnlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+func (b *Broker[T]) remove(id uint64) {
+	b.mu.Lock()
+	delete(b.subs, id)
+	b.mu.Unlock()
+}
+
+// Subscription receives the messages of the topics matching its pattern
+type Subscription[T any] struct {
+	b       *Broker[T]
+	id      uint64
+	pattern string
+	tokens  []string
+	opts    SubOptions
+
+	ch   chan Message[T]
+	done chan struct{}
+	once sync.Once
+	err  error
+
+	// Publishers hold mu for reading while they send on ch; close takes
+	// it for writing so ch is never closed under a sender
+	mu sync.RWMutex
+
+	delivered  atomic.Uint64
+	dropped    atomic.Uint64
+	maxPending atomic.Int64
+	blocked    atomic.Int64
+}
+
+// C returns the channel of messages. It is closed by Unsubscribe, by the
+// Disconnect policy and by Broker.Close; messages already buffered can
+// still be read.
+func (s *Subscription[T]) C() <-chan Message[T] {
+	return s.ch
+}
+
+// Unsubscribe stops delivery and closes C. It is safe to call more than
+// once.
+func (s *Subscription[T]) Unsubscribe() {
+	s.close(nil)
+}
+
+// Err returns why the subscription was closed: nil while open or after
+// Unsubscribe, ErrSlowConsumer or ErrClosed
+func (s *Subscription[T]) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Stats returns the subscription's counters
+func (s *Subscription[T]) Stats() SubStats {
+	return SubStats{
+		ID:         s.id,
+		Pattern:    s.pattern,
+		Policy:     s.opts.Policy,
+		Buffer:     s.opts.Buffer,
+		Pending:    len(s.ch),
+		MaxPending: int(s.maxPending.Load()),
+		Delivered:  s.delivered.Load(),
+		Dropped:    s.dropped.Load(),
+		Blocked:    time.Duration(s.blocked.Load()),
+	}
+}
+
+func (s *Subscription[T]) close(reason error) {
+	s.once.Do(func() {
+		s.err = reason
+		close(s.done)
+		s.b.remove(s.id)
+		s.mu.Lock()
+		close(s.ch)
+		s.mu.Unlock()
+	})
+}
+
+// deliver puts msg into the buffer according to the policy
+func (s *Subscription[T]) deliver(ctx context.Context, msg Message[T]) error {
+	disconnect := false
+	defer func() {
+		if disconnect {
+			s.close(ErrSlowConsumer)
+		}
+	}()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+
+	if s.trySend(msg) {
+		return nil
+	}
+	switch s.opts.Policy {
+	case Block:
+		start := time.Now()
+		defer func() { s.blocked.Add(int64(time.Since(start))) }()
+		select {
+		case s.ch <- msg:
+			s.sent()
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case DropNewest:
+		s.dropped.Add(1)
+	case DropOldest:
+		for !s.trySend(msg) {
+			select {
+			case <-s.ch:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	case Disconnect:
+		s.dropped.Add(1)
+		disconnect = true
+	}
+	return nil
+}
+
+func (s *Subscription[T]) trySend(msg Message[T]) bool {
+	select {
+	case s.ch <- msg:
+		s.sent()
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Subscription[T]) sent() {
+	s.delivered.Add(1)
+	n := int64(len(s.ch))
+	for {
+		m := s.maxPending.Load()
+		if n <= m || s.maxPending.CompareAndSwap(m, n) {
+			return
+		}
+	}
+}
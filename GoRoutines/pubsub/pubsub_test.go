@@ -0,0 +1,352 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/internal/leaktest"
+)
+
+func payloads[T any](s *Subscription[T]) []T {
+	var out []T
+	for m := range s.C() {
+		out = append(out, m.Payload)
+	}
+	return out
+}
+
+func mustSubscribe[T any](t *testing.T, b *Broker[T], pattern string, opts SubOptions) *Subscription[T] {
+	t.Helper()
+	s, err := b.Subscribe(pattern, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.created", "orders.deleted", false},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.*", "orders", false},
+		{"*.created", "users.created", true},
+		{"orders.>", "orders.eu.created", true},
+		{"orders.>", "orders.created", true},
+		{"orders.>", "orders", false},
+		{">", "anything.at.all", true},
+		{"*.*.created", "orders.eu.created", true},
+		{"orders.created", "orders.created.late", false},
+	}
+	for _, tt := range tests {
+		p, _ := splitPattern(tt.pattern)
+		topic, _ := splitTopic(tt.topic)
+		if got := match(p, topic); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidTopics(t *testing.T) {
+	b := New[int]()
+	for _, p := range []string{"", "a..b", "a.>.b", ".a"} {
+		if _, err := b.Subscribe(p, SubOptions{}); err == nil {
+			t.Errorf("Subscribe(%q) returned no error", p)
+		}
+	}
+	for _, topic := range []string{"", "a.*", "a.>", "a."} {
+		if err := b.Publish(context.Background(), topic, 1); err == nil {
+			t.Errorf("Publish(%q) returned no error", topic)
+		}
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	leaktest.Check(t)
+	b := New[string]()
+	exact := mustSubscribe(t, b, "orders.eu.created", SubOptions{})
+	star := mustSubscribe(t, b, "orders.*.created", SubOptions{})
+	tail := mustSubscribe(t, b, "orders.>", SubOptions{})
+	other := mustSubscribe(t, b, "users.>", SubOptions{})
+
+	ctx := context.Background()
+	for _, topic := range []string{"orders.eu.created", "orders.us.created", "orders.eu.deleted", "users.created"} {
+		if err := b.Publish(ctx, topic, topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[*Subscription[string]][]string{
+		exact: {"orders.eu.created"},
+		star:  {"orders.eu.created", "orders.us.created"},
+		tail:  {"orders.eu.created", "orders.us.created", "orders.eu.deleted"},
+		other: {"users.created"},
+	}
+	for s, w := range want {
+		if got := payloads(s); !reflect.DeepEqual(got, w) {
+			t.Errorf("subscription %s got %v, want %v", s.pattern, got, w)
+		}
+		if !errors.Is(s.Err(), ErrClosed) {
+			t.Errorf("subscription %s Err() = %v, want ErrClosed", s.pattern, s.Err())
+		}
+	}
+
+	if err := b.Publish(ctx, "orders.eu.created", "late"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish() after Close = %v, want ErrClosed", err)
+	}
+	if _, err := b.Subscribe("orders.>", SubOptions{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Subscribe() after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	tests := []struct {
+		policy      Policy
+		want        []int
+		wantDropped uint64
+		wantErr     error
+	}{
+		{DropNewest, []int{1, 2, 3}, 2, ErrClosed},
+		{DropOldest, []int{3, 4, 5}, 2, ErrClosed},
+		{Disconnect, []int{1, 2, 3}, 1, ErrSlowConsumer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			b := New[int]()
+			s := mustSubscribe(t, b, "t", SubOptions{Buffer: 3, Policy: tt.policy})
+			for v := 1; v <= 5; v++ {
+				if err := b.Publish(context.Background(), "t", v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			st := s.Stats()
+			b.Close(context.Background())
+
+			if got := payloads(s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("received %v, want %v", got, tt.want)
+			}
+			if st.Dropped != tt.wantDropped || st.MaxPending != 3 {
+				t.Errorf("stats %+v, want %d dropped and max pending 3", st, tt.wantDropped)
+			}
+			if !errors.Is(s.Err(), tt.wantErr) {
+				t.Errorf("Err() = %v, want %v", s.Err(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBlock(t *testing.T) {
+	leaktest.Check(t)
+	b := New[int]()
+	s := mustSubscribe(t, b, "t", SubOptions{Buffer: 1})
+	fast := mustSubscribe(t, b, "t", SubOptions{Buffer: 10})
+	ctx := context.Background()
+	b.Publish(ctx, "t", 1)
+
+	published := make(chan error)
+	go func() { published <- b.Publish(ctx, "t", 2) }()
+	select {
+	case <-published:
+		t.Fatal("Publish() did not block on a full subscriber")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if m := <-s.C(); m.Payload != 1 {
+		t.Errorf("got %d, want 1", m.Payload)
+	}
+	if err := <-published; err != nil {
+		t.Errorf("Publish() = %v", err)
+	}
+	if st := s.Stats(); st.Blocked < 10*time.Millisecond || st.Pending != 1 || st.Delivered != 2 {
+		t.Errorf("stats %+v, want blocked time, 1 pending, 2 delivered", st)
+	}
+
+	// A publisher gives up with its context
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := b.Publish(tctx, "t", 3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Publish() on full subscriber = %v, want context.DeadlineExceeded", err)
+	}
+
+	// Unsubscribing releases a blocked publisher
+	go func() { published <- b.Publish(ctx, "t", 4) }()
+	time.Sleep(10 * time.Millisecond)
+	s.Unsubscribe()
+	if err := <-published; err != nil {
+		t.Errorf("Publish() after Unsubscribe = %v", err)
+	}
+	if got := payloads(s); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("after Unsubscribe read %v, want the buffered [2]", got)
+	}
+	if s.Err() != nil {
+		t.Errorf("Err() after Unsubscribe = %v, want nil", s.Err())
+	}
+	s.Unsubscribe()
+
+	b.Close(ctx)
+	if got := payloads(fast); !reflect.DeepEqual(got, []int{1, 2, 4}) {
+		t.Errorf("fast subscriber got %v, want [1 2 4]", got)
+	}
+}
+
+func TestCloseDrains(t *testing.T) {
+	leaktest.Check(t)
+	b := New[int]()
+	s := mustSubscribe(t, b, "t", SubOptions{Buffer: 1})
+	ctx := context.Background()
+	b.Publish(ctx, "t", 1)
+	published := make(chan error)
+	go func() { published <- b.Publish(ctx, "t", 2) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// Close waits for the blocked publish, which needs a reader
+	closed := make(chan error)
+	go func() { closed <- b.Close(ctx) }()
+	var got []int
+	for m := range s.C() {
+		got = append(got, m.Payload)
+	}
+	if err := <-closed; err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	if err := <-published; err != nil {
+		t.Errorf("Publish() = %v", err)
+	}
+	if !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("drained %v, want [1 2]", got)
+	}
+	if err := b.Close(ctx); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	leaktest.Check(t)
+	b := New[int]()
+	s := mustSubscribe(t, b, "t", SubOptions{Buffer: 1})
+	ctx := context.Background()
+	b.Publish(ctx, "t", 1)
+	published := make(chan error)
+	go func() { published <- b.Publish(ctx, "t", 2) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// Nobody reads: Close gives up and releases the publisher
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := b.Close(tctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close() = %v, want context.DeadlineExceeded", err)
+	}
+	if err := <-published; err != nil {
+		t.Errorf("Publish() = %v", err)
+	}
+	if got := payloads(s); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("read %v after Close, want [1]", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	b := New[int]()
+	a := mustSubscribe(t, b, "a.>", SubOptions{Buffer: 4})
+	mustSubscribe(t, b, "b", SubOptions{Policy: DropOldest})
+	for i := 0; i < 3; i++ {
+		b.Publish(context.Background(), "a.x", i)
+	}
+	<-a.C()
+
+	stats := b.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("Stats() returned %d subscriptions, want 2", len(stats))
+	}
+	want := SubStats{ID: 1, Pattern: "a.>", Policy: Block, Buffer: 4, Pending: 2, MaxPending: 3, Delivered: 3}
+	if stats[0] != want {
+		t.Errorf("Stats()[0] = %+v, want %+v", stats[0], want)
+	}
+	if stats[1].Pattern != "b" || stats[1].Buffer != 64 || stats[1].Policy != DropOldest {
+		t.Errorf("Stats()[1] = %+v, want pattern b with the default buffer", stats[1])
+	}
+
+	a.Unsubscribe()
+	if n := len(b.Stats()); n != 1 {
+		t.Errorf("Stats() after Unsubscribe returned %d subscriptions, want 1", n)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	leaktest.Check(t)
+	b := New[int]()
+	ctx := context.Background()
+	var wg sync.WaitGroup
+
+	counts := make([]int, 4)
+	for i := range counts {
+		s := mustSubscribe(t, b, fmt.Sprintf("t.%d.>", i%2), SubOptions{Buffer: 8})
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for range s.C() {
+				counts[i]++
+			}
+		}(i)
+	}
+	// A subscriber that comes and goes while messages flow
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			s, err := b.Subscribe("t.>", SubOptions{Buffer: 1, Policy: DropNewest})
+			if err != nil {
+				return
+			}
+			s.Unsubscribe()
+		}
+	}()
+
+	var pubs sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		pubs.Add(1)
+		go func(p int) {
+			defer pubs.Done()
+			for i := 0; i < 100; i++ {
+				b.Publish(ctx, fmt.Sprintf("t.%d.x", p%2), i)
+			}
+		}(p)
+	}
+	pubs.Wait()
+	b.Close(ctx)
+	wg.Wait()
+
+	for i, n := range counts {
+		if n != 200 {
+			t.Errorf("subscriber %d got %d messages, want 200", i, n)
+		}
+	}
+}
+
+func BenchmarkPublish(b *testing.B) {
+	br := New[int]()
+	for i := 0; i < 10; i++ {
+		s, _ := br.Subscribe(fmt.Sprintf("bench.%d.>", i%3), SubOptions{Policy: DropNewest})
+		go func() {
+			for range s.C() {
+			}
+		}()
+	}
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br.Publish(ctx, "bench.1.event", i)
+	}
+	b.StopTimer()
+	br.Close(ctx)
+}
@@ -0,0 +1,43 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topics are dot-separated tokens such as "orders.eu.created". In a
+// subscription pattern "*" matches exactly one token and ">", allowed
+// only as the last token, matches one or more.
+
+func splitTopic(topic string) ([]string, error) {
+	tokens := strings.Split(topic, ".")
+	for _, t := range tokens {
+		if t == "" || t == "*" || t == ">" {
+			return nil, fmt.Errorf("pubsub: invalid topic %q", topic)
+		}
+	}
+	return tokens, nil
+}
+
+func splitPattern(pattern string) ([]string, error) {
+	tokens := strings.Split(pattern, ".")
+	for i, t := range tokens {
+		if t == "" || (t == ">" && i != len(tokens)-1) {
+			return nil, fmt.Errorf("pubsub: invalid pattern %q", pattern)
+		}
+	}
+	return tokens, nil
+}
+
+// match reports whether a split topic matches a split pattern
+func match(pattern, topic []string) bool {
+	for i, p := range pattern {
+		if p == ">" {
+			return len(topic) > i
+		}
+		if i >= len(topic) || (p != "*" && p != topic[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(topic)
+}
@@ -0,0 +1,70 @@
+package reduce
+
+import (
+	"context"
+	"math"
+)
+
+// FloatSum is a running float64 sum with Neumaier compensation: Comp
+// holds the low-order bits that rounding dropped from Sum
+type FloatSum struct {
+	Sum, Comp float64
+}
+
+// Add returns s plus x
+func (s FloatSum) Add(x float64) FloatSum {
+	t := s.Sum + x
+	// Whichever operand is larger keeps its bits; the rounding error of
+	// t is what is left of the smaller one
+	if math.Abs(s.Sum) >= math.Abs(x) {
+		s.Comp += (s.Sum - t) + x
+	} else {
+		s.Comp += (x - t) + s.Sum
+	}
+	s.Sum = t
+	return s
+}
+
+// Merge returns the sum of s and o
+func (s FloatSum) Merge(o FloatSum) FloatSum {
+	s = s.Add(o.Sum)
+	s.Comp += o.Comp
+	return s
+}
+
+// Value returns the compensated sum
+func (s FloatSum) Value() float64 {
+	// Past an infinity the compensation is NaN and means nothing
+	if math.IsInf(s.Sum, 0) || math.IsNaN(s.Sum) {
+		return s.Sum
+	}
+	return s.Sum + s.Comp
+}
+
+// FloatSummer is the Combiner behind Sum, for use with Reduce on items
+// that map to float64
+func FloatSummer[T any](f func(T) float64) Combiner[T, FloatSum] {
+	return Combiner[T, FloatSum]{
+		Zero:  func() FloatSum { return FloatSum{} },
+		Add:   func(s FloatSum, v T) FloatSum { return s.Add(f(v)) },
+		Merge: FloatSum.Merge,
+	}
+}
+
+// Sum adds xs in parallel with compensated summation. The result is the
+// same bit for bit for any Workers; it depends only on xs and ChunkSize.
+func Sum(xs []float64, opts Options) float64 {
+	c := Combiner[float64, FloatSum]{
+		Zero:  func() FloatSum { return FloatSum{} },
+		Add:   FloatSum.Add,
+		Merge: FloatSum.Merge,
+		Fold: func(s FloatSum, xs []float64) FloatSum {
+			for _, x := range xs {
+				s = s.Add(x)
+			}
+			return s
+		},
+	}
+	s, _ := Reduce(context.Background(), xs, c, opts)
+	return s.Value()
+}
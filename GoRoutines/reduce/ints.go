@@ -0,0 +1,212 @@
+package reduce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// Integer sums wrap around silently when they leave the range of an int.
+// SumInts and SumSquares check every addition and fail with an
+// *OverflowError instead; BigSumInts and BigSumSquares never fail, because
+// a chunk that would overflow carries the excess into a big.Int.
+
+// ErrOverflow is matched by every *OverflowError
+var ErrOverflow = errors.New("reduce: integer overflow")
+
+// OverflowError reports a checked sum that left the range of an int
+type OverflowError struct {
+	// Chunk is the index of the chunk that overflowed, and Start and End
+	// bound its items. Chunk is -1 if every chunk fit but merging two runs
+	// of chunks overflowed; Start and End then bound both runs.
+	Chunk      int
+	Start, End int
+}
+
+func (e *OverflowError) Error() string {
+	if e.Chunk < 0 {
+		return fmt.Sprintf("reduce: integer overflow merging items [%d, %d)", e.Start, e.End)
+	}
+	return fmt.Sprintf("reduce: integer overflow in chunk %d, items [%d, %d)", e.Chunk, e.Start, e.End)
+}
+
+func (e *OverflowError) Unwrap() error { return ErrOverflow }
+
+// SumInts adds items in parallel and fails with an *OverflowError if a
+// partial sum does not fit in an int. A running sum that overflows is an
+// error even if later items would bring it back into range.
+func SumInts(ctx context.Context, items []int, opts Options) (int, error) {
+	return checkedSum(ctx, items, identity, opts)
+}
+
+// SumSquares adds the squares of items like SumInts
+func SumSquares(ctx context.Context, items []int, opts Options) (int, error) {
+	return checkedSum(ctx, items, square, opts)
+}
+
+// BigSumInts returns the exact sum of items. Each chunk adds in an int and
+// only touches a big.Int when that int would overflow, so sums that fit
+// cost little more than SumInts.
+func BigSumInts(ctx context.Context, items []int, opts Options) (*big.Int, error) {
+	return bigSum(ctx, items, identity, opts)
+}
+
+// BigSumSquares returns the exact sum of the squares of items like
+// BigSumInts
+func BigSumSquares(ctx context.Context, items []int, opts Options) (*big.Int, error) {
+	return bigSum(ctx, items, square, opts)
+}
+
+// term is what an item adds to a sum: fit returns it as an int, or false
+// if it does not fit, and exact returns it as a big.Int
+type term struct {
+	fit   func(v int) (int, bool)
+	exact func(v int) *big.Int
+}
+
+var identity = term{
+	fit:   func(v int) (int, bool) { return v, true },
+	exact: func(v int) *big.Int { return big.NewInt(int64(v)) },
+}
+
+var square = term{
+	fit: func(v int) (int, bool) {
+		// uint(-v) is right for math.MinInt too
+		a := uint(v)
+		if v < 0 {
+			a = uint(-v)
+		}
+		hi, lo := bits.Mul(a, a)
+		if hi != 0 || lo > math.MaxInt {
+			return 0, false
+		}
+		return int(lo), true
+	},
+	exact: func(v int) *big.Int {
+		b := big.NewInt(int64(v))
+		return b.Mul(b, b)
+	},
+}
+
+// add returns a+b, or false if it overflows
+func add(a, b int) (int, bool) {
+	s := a + b
+	// Overflow flips the sign of a sum of two operands of the same sign
+	if (a >= 0) == (b >= 0) && (s >= 0) != (a >= 0) {
+		return 0, false
+	}
+	return s, true
+}
+
+type checked struct {
+	sum        int
+	start, end int
+	err        *OverflowError
+}
+
+func checkedSum(ctx context.Context, items []int, t term, opts Options) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+	fold := func(chunk, lo, hi int) checked {
+		c := checked{start: lo, end: hi}
+		for _, v := range items[lo:hi] {
+			x, ok := t.fit(v)
+			if ok {
+				c.sum, ok = add(c.sum, x)
+			}
+			if !ok {
+				c.err = &OverflowError{Chunk: chunk, Start: lo, End: hi}
+				return c
+			}
+		}
+		return c
+	}
+	merge := func(l, r checked) checked {
+		// The leftmost error wins, so the one reported does not depend on
+		// scheduling
+		if l.err != nil {
+			return l
+		}
+		if r.err != nil {
+			return r
+		}
+		s, ok := add(l.sum, r.sum)
+		if !ok {
+			l.err = &OverflowError{Chunk: -1, Start: l.start, End: r.end}
+			return l
+		}
+		return checked{sum: s, start: l.start, end: r.end}
+	}
+	c, err := tree(ctx, len(items), opts, fold, merge)
+	if err != nil {
+		return 0, err
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.sum, nil
+}
+
+// widening is a sum of big and small. Terms are added to small until it
+// would overflow, and only then is small moved into big.
+type widening struct {
+	small int
+	big   *big.Int // nil until small first overflows
+}
+
+func (w *widening) add(x int) {
+	if s, ok := add(w.small, x); ok {
+		w.small = s
+		return
+	}
+	w.flush()
+	w.small = x
+}
+
+// flush moves small into big
+func (w *widening) flush() {
+	if w.big == nil {
+		w.big = new(big.Int)
+	}
+	w.big.Add(w.big, big.NewInt(int64(w.small)))
+	w.small = 0
+}
+
+func bigSum(ctx context.Context, items []int, t term, opts Options) (*big.Int, error) {
+	if len(items) == 0 {
+		return new(big.Int), nil
+	}
+	fold := func(_, lo, hi int) widening {
+		var w widening
+		for _, v := range items[lo:hi] {
+			if x, ok := t.fit(v); ok {
+				w.add(x)
+				continue
+			}
+			w.flush()
+			w.big.Add(w.big, t.exact(v))
+		}
+		return w
+	}
+	merge := func(l, r widening) widening {
+		if r.big != nil {
+			if l.big == nil {
+				l.big = new(big.Int)
+			}
+			// l.big belongs to this subtree alone, so it can be reused
+			l.big.Add(l.big, r.big)
+		}
+		l.add(r.small)
+		return l
+	}
+	w, err := tree(ctx, len(items), opts, fold, merge)
+	if err != nil {
+		return nil, err
+	}
+	w.flush()
+	return w.big, nil
+}
@@ -0,0 +1,138 @@
+// Package reduce folds a slice in parallel with a result that does not
+// depend on the number of workers.
+//
+// The parallel sum of task 1 adds up one partial sum per worker in
+// whatever order the workers finish. That is fine for ints, but for
+// floats, where addition is not associative, the last bits of the answer
+// change with the worker count and with scheduling. Reduce instead cuts
+// the slice into chunks of a fixed size, folds each chunk, and merges the
+// partial results pairwise in a fixed binary tree. Workers only decide
+// who computes each node, never the shape of the computation, so any
+// combiner gives bit-identical results for 1 worker or 64.
+//
+// Sum adds float64s with Neumaier's compensated summation on top of
+// that, which keeps the error close to one rounding of the exact sum.
+package reduce
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Combiner describes a reduction from items of type T to a result of type
+// A. Merge must be associative, and Zero must be its identity.
+type Combiner[T, A any] struct {
+	// Zero returns the empty result; it is called once per chunk, so it
+	// may return fresh memory
+	Zero func() A
+	// Add folds one item into a partial result
+	Add func(A, T) A
+	// Merge combines the partial results of two adjacent runs of items,
+	// left then right
+	Merge func(A, A) A
+	// Fold, if set, folds a whole chunk at once in place of calling Add
+	// per item, for loops the compiler can optimise
+	Fold func(A, []T) A
+}
+
+// Options configure Reduce
+type Options struct {
+	// Workers is the number of goroutines [runtime.GOMAXPROCS(0)]
+	Workers int
+	// ChunkSize is the number of items folded sequentially [1024]. It,
+	// not Workers, decides the shape of the computation.
+	ChunkSize int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = runtime.GOMAXPROCS(0)
+	}
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = 1024
+	}
+	return o
+}
+
+// Reduce folds items with c. Chunks of ChunkSize items are folded in
+// parallel, then merged pairwise, level by level, in a binary tree fixed
+// by the number of chunks. It returns ctx's error if ctx is cancelled.
+func Reduce[T, A any](ctx context.Context, items []T, c Combiner[T, A], opts Options) (A, error) {
+	if len(items) == 0 {
+		return c.Zero(), nil
+	}
+	return tree(ctx, len(items), opts, func(_, lo, hi int) A {
+		acc := c.Zero()
+		if c.Fold != nil {
+			return c.Fold(acc, items[lo:hi])
+		}
+		for _, v := range items[lo:hi] {
+			acc = c.Add(acc, v)
+		}
+		return acc
+	}, c.Merge)
+}
+
+// tree calls fold for every chunk of n items, given the chunk's index and
+// bounds, and merges the results as Reduce does. n must not be 0.
+func tree[A any](ctx context.Context, n int, opts Options, fold func(chunk, lo, hi int) A, merge func(A, A) A) (A, error) {
+	opts = opts.withDefaults()
+	chunks := (n + opts.ChunkSize - 1) / opts.ChunkSize
+	parts := make([]A, chunks)
+	err := parallel(ctx, chunks, opts.Workers, func(i int) {
+		lo := i * opts.ChunkSize
+		parts[i] = fold(i, lo, min(lo+opts.ChunkSize, n))
+	})
+
+	// Each level merges neighbours 2k and 2k+1; an odd one out moves up
+	// unchanged
+	for len(parts) > 1 && err == nil {
+		level := parts
+		parts = make([]A, (len(level)+1)/2)
+		err = parallel(ctx, len(level)/2, opts.Workers, func(k int) {
+			parts[k] = merge(level[2*k], level[2*k+1])
+		})
+		if len(level)%2 == 1 {
+			parts[len(parts)-1] = level[len(level)-1]
+		}
+	}
+	if err != nil {
+		var zero A
+		return zero, err
+	}
+	return parts[0], nil
+}
+
+// parallel calls fn(0) to fn(n-1) on up to workers goroutines and waits
+// for them. It stops handing out work once ctx is done.
+func parallel(ctx context.Context, n, workers int, fn func(i int)) error {
+	workers = min(workers, n)
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			fn(i)
+		}
+		return ctx.Err()
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				i := int(next.Add(1) - 1)
+				if i >= n {
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
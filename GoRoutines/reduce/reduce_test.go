@@ -0,0 +1,416 @@
+package reduce
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/go-concurrency-lesson/internal/leaktest"
+)
+
+var intSum = Combiner[int, int]{
+	Zero:  func() int { return 0 },
+	Add:   func(a, v int) int { return a + v },
+	Merge: func(a, b int) int { return a + b },
+}
+
+// concat is associative but not commutative, so it shows the merge order
+var concat = Combiner[int, string]{
+	Zero:  func() string { return "" },
+	Add:   func(a string, v int) string { return a + strconv.Itoa(v%10) },
+	Merge: func(a, b string) string { return a + b },
+}
+
+func TestReduce(t *testing.T) {
+	leaktest.Check(t)
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		n    int
+		opts Options
+	}{
+		{"empty", 0, Options{}},
+		{"one chunk", 10, Options{Workers: 4}},
+		{"odd chunks", 1000, Options{Workers: 3, ChunkSize: 7}},
+		{"chunk per item", 33, Options{Workers: 8, ChunkSize: 1}},
+		{"more workers than chunks", 100, Options{Workers: 64, ChunkSize: 30}},
+		{"default options", 10000, Options{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := make([]int, tt.n)
+			want, wantStr := 0, ""
+			for i := range items {
+				items[i] = i
+				want += i
+				wantStr += strconv.Itoa(i % 10)
+			}
+			got, err := Reduce(ctx, items, intSum, tt.opts)
+			if err != nil || got != want {
+				t.Errorf("Reduce(sum) = %d, %v, want %d", got, err, want)
+			}
+			gotStr, err := Reduce(ctx, items, concat, tt.opts)
+			if err != nil || gotStr != wantStr {
+				t.Errorf("Reduce(concat) = %.20q..., %v, want %.20q...", gotStr, err, wantStr)
+			}
+			folded := concat
+			folded.Fold = func(a string, vs []int) string {
+				for _, v := range vs {
+					a = concat.Add(a, v)
+				}
+				return a
+			}
+			if gotStr, _ = Reduce(ctx, items, folded, tt.opts); gotStr != wantStr {
+				t.Errorf("Reduce(concat) with Fold = %.20q..., want %.20q...", gotStr, wantStr)
+			}
+		})
+	}
+}
+
+func TestReduceCancel(t *testing.T) {
+	leaktest.Check(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Reduce(ctx, make([]int, 10000), intSum, Options{Workers: 4}); err != context.Canceled {
+		t.Errorf("Reduce() with a cancelled context = %v, want context.Canceled", err)
+	}
+
+	// Cancelling part way stops the remaining chunks
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	added := 0
+	slow := Combiner[int, int]{
+		Zero: func() int { return 0 },
+		Add: func(a, v int) int {
+			if added++; added == 500 {
+				cancel()
+			}
+			return a + v
+		},
+		Merge: intSum.Merge,
+	}
+	if _, err := Reduce(ctx, make([]int, 10000), slow, Options{Workers: 1, ChunkSize: 100}); err != context.Canceled {
+		t.Errorf("Reduce() cancelled part way = %v, want context.Canceled", err)
+	}
+	if added != 500 {
+		t.Errorf("%d items folded, want the 500 up to the end of the chunk that cancelled", added)
+	}
+}
+
+// wide returns n floats spread over many magnitudes and both signs, the
+// kind of data where the order of additions shows in the result
+func wide(seed int64, n int) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	xs := make([]float64, n)
+	for i := range xs {
+		xs[i] = rng.NormFloat64() * math.Pow(10, float64(rng.Intn(30)-15))
+	}
+	return xs
+}
+
+// exact returns the sum of xs rounded once to float64
+func exact(xs []float64) float64 {
+	// 4096 bits hold any sum of float64s without rounding
+	sum := new(big.Float).SetPrec(4096)
+	for _, x := range xs {
+		sum.Add(sum, new(big.Float).SetFloat64(x))
+	}
+	f, _ := sum.Float64()
+	return f
+}
+
+func naive(xs []float64) float64 {
+	s := 0.0
+	for _, x := range xs {
+		s += x
+	}
+	return s
+}
+
+func ulp(x float64) float64 {
+	return math.Nextafter(math.Abs(x), math.Inf(1)) - math.Abs(x)
+}
+
+func TestSumDeterministic(t *testing.T) {
+	xs := wide(1, 100000)
+	want := Sum(xs, Options{Workers: 1, ChunkSize: 256})
+	for workers := 2; workers <= 16; workers++ {
+		if got := Sum(xs, Options{Workers: workers, ChunkSize: 256}); math.Float64bits(got) != math.Float64bits(want) {
+			t.Errorf("Sum() with %d workers = %v, with 1 worker %v", workers, got, want)
+		}
+	}
+}
+
+func TestSumAccuracy(t *testing.T) {
+	tests := []struct {
+		name string
+		xs   []float64
+		// maxUlps is the allowed error against the exact sum
+		maxUlps float64
+	}{
+		{"empty", nil, 0},
+		{"cancellation", []float64{1e16, 1, -1e16}, 0},
+		{"tiny terms", append([]float64{1}, repeat(1e-16, 10000)...), 0},
+		{"alternating", []float64{1e100, 1, -1e100, 1e-100, 3}, 0},
+		{"wide", wide(2, 100000), 1},
+		{"wide small chunks", wide(3, 10000), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := exact(tt.xs)
+			got := Sum(tt.xs, Options{Workers: 4, ChunkSize: 64})
+			if math.Abs(got-want) > tt.maxUlps*ulp(want) {
+				t.Errorf("Sum() = %v, exact %v (naive %v)", got, want, naive(tt.xs))
+			}
+		})
+	}
+
+	if got := Sum([]float64{1, math.Inf(1), 2}, Options{}); !math.IsInf(got, 1) {
+		t.Errorf("Sum() with +Inf = %v", got)
+	}
+	if got := Sum([]float64{math.Inf(1), math.Inf(-1)}, Options{}); !math.IsNaN(got) {
+		t.Errorf("Sum() of +Inf and -Inf = %v, want NaN", got)
+	}
+}
+
+func repeat(x float64, n int) []float64 {
+	xs := make([]float64, n)
+	for i := range xs {
+		xs[i] = x
+	}
+	return xs
+}
+
+// oracle returns the exact sum of items, or of their squares
+func oracle(items []int, squares bool) *big.Int {
+	sum := new(big.Int)
+	for _, v := range items {
+		b := big.NewInt(int64(v))
+		if squares {
+			b.Mul(b, b)
+		}
+		sum.Add(sum, b)
+	}
+	return sum
+}
+
+func TestSumInts(t *testing.T) {
+	ctx := context.Background()
+	const root = 3037000499 // the largest int whose square fits in int64
+	tests := []struct {
+		name    string
+		items   []int
+		squares bool
+		opts    Options
+		want    int
+		err     *OverflowError // nil if the sum fits
+	}{
+		{"empty", nil, false, Options{}, 0, nil},
+		{"small", []int{1, -2, 3, 4}, false, Options{ChunkSize: 1}, 6, nil},
+		{"max", []int{math.MaxInt - 1, 1}, false, Options{}, math.MaxInt, nil},
+		{"min", []int{math.MinInt + 1, -1}, false, Options{}, math.MinInt, nil},
+		{"chunk overflows", []int{1, 2, math.MaxInt, 1}, false, Options{ChunkSize: 2},
+			0, &OverflowError{Chunk: 1, Start: 2, End: 4}},
+		{"first chunk wins", []int{math.MaxInt, 1, math.MinInt, -1}, false, Options{ChunkSize: 2, Workers: 4},
+			0, &OverflowError{Chunk: 0, Start: 0, End: 2}},
+		{"merge overflows", []int{math.MaxInt, 1}, false, Options{ChunkSize: 1},
+			0, &OverflowError{Chunk: -1, Start: 0, End: 2}},
+		{"negative overflow", []int{math.MinInt, -1}, false, Options{},
+			0, &OverflowError{Chunk: 0, Start: 0, End: 2}},
+		{"squares", []int{-3, 4}, true, Options{}, 25, nil},
+		{"largest square", []int{-root, 1}, true, Options{ChunkSize: 1}, root*root + 1, nil},
+		{"square overflows", []int{1, root + 1}, true, Options{},
+			0, &OverflowError{Chunk: 0, Start: 0, End: 2}},
+		{"square of min", []int{math.MinInt}, true, Options{},
+			0, &OverflowError{Chunk: 0, Start: 0, End: 1}},
+		{"squares merge", []int{root, root, root}, true, Options{ChunkSize: 1},
+			0, &OverflowError{Chunk: -1, Start: 0, End: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum, name := SumInts, "SumInts"
+			bigSum := BigSumInts
+			if tt.squares {
+				sum, name, bigSum = SumSquares, "SumSquares", BigSumSquares
+			}
+			got, err := sum(ctx, tt.items, tt.opts)
+			if tt.err == nil {
+				if err != nil || got != tt.want {
+					t.Errorf("%s = %d, %v, want %d", name, got, err, tt.want)
+				}
+			} else {
+				var oe *OverflowError
+				if !errors.As(err, &oe) || *oe != *tt.err || !errors.Is(err, ErrOverflow) {
+					t.Errorf("%s = %d, %v, want %v", name, got, err, tt.err)
+				}
+			}
+			want := oracle(tt.items, tt.squares)
+			if got, err := bigSum(ctx, tt.items, tt.opts); err != nil || got.Cmp(want) != 0 {
+				t.Errorf("Big%s = %v, %v, want %v", name, got, err, want)
+			}
+		})
+	}
+}
+
+func TestBigSumWidens(t *testing.T) {
+	ctx := context.Background()
+	items := make([]int, 10000)
+	for i := range items {
+		items[i] = math.MaxInt - i
+		if i%3 == 0 {
+			items[i] = math.MinInt + i
+		}
+	}
+	want := oracle(items, false)
+	wantSquares := oracle(items, true)
+	for _, workers := range []int{1, 3, 8} {
+		for _, chunk := range []int{1, 7, 1024} {
+			opts := Options{Workers: workers, ChunkSize: chunk}
+			if got, err := BigSumInts(ctx, items, opts); err != nil || got.Cmp(want) != 0 {
+				t.Errorf("BigSumInts(%+v) = %v, %v, want %v", opts, got, err, want)
+			}
+			if got, err := BigSumSquares(ctx, items, opts); err != nil || got.Cmp(wantSquares) != 0 {
+				t.Errorf("BigSumSquares(%+v) = %v, %v, want %v", opts, got, err, wantSquares)
+			}
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := BigSumInts(ctx, items, Options{}); err != context.Canceled {
+		t.Errorf("BigSumInts after cancel: %v, want context.Canceled", err)
+	}
+}
+
+// FuzzSumInts decodes data into ints and checks the checked and widening
+// sums against a big.Int oracle
+func FuzzSumInts(f *testing.F) {
+	f.Add([]byte{1, 2, 3}, uint8(1), uint8(2), false)
+	f.Add([]byte("\xff\xff\xff\xff\xff\xff\xff\x7f\x01\x00\x00\x00\x00\x00\x00\x00"), uint8(1), uint8(4), false)
+	f.Add([]byte("\x00\x00\x00\x00\x00\x00\x00\x80\xff\xff\xff\xff\xff\xff\xff\xff"), uint8(2), uint8(3), true)
+	f.Add([]byte("\xb3\x04\xf3\xb4\x00\x00\x00\x00\xb4\x04\xf3\xb4"), uint8(3), uint8(1), true)
+	f.Fuzz(func(t *testing.T, data []byte, chunk, workers uint8, squares bool) {
+		var items []int
+		for len(data) > 0 {
+			var buf [8]byte
+			n := copy(buf[:], data)
+			data = data[n:]
+			items = append(items, int(int64(binary.LittleEndian.Uint64(buf[:]))))
+		}
+		ctx := context.Background()
+		opts := Options{Workers: int(workers%8) + 1, ChunkSize: int(chunk%16) + 1}
+		sum, bigSum := SumInts, BigSumInts
+		if squares {
+			sum, bigSum = SumSquares, BigSumSquares
+		}
+		want := oracle(items, squares)
+		got, err := bigSum(ctx, items, opts)
+		if err != nil || got.Cmp(want) != 0 {
+			t.Fatalf("big sum of %v (%+v) = %v, %v, want %v", items, opts, got, err, want)
+		}
+		// A checked sum may fail on a partial sum even when the total fits,
+		// but it must never return a wrong answer
+		n, err := sum(ctx, items, opts)
+		switch {
+		case err == nil && big.NewInt(int64(n)).Cmp(want) != 0:
+			t.Fatalf("checked sum of %v (%+v) = %d, want %v", items, opts, n, want)
+		case err != nil && !errors.Is(err, ErrOverflow):
+			t.Fatalf("checked sum of %v: %v", items, err)
+		case err == nil && !want.IsInt64():
+			t.Fatalf("checked sum of %v = %d, want overflow", items, n)
+		case err != nil && want.IsInt64() && !squares && allSameSign(items):
+			t.Fatalf("checked sum of %v: %v, want %v", items, err, want)
+		}
+	})
+}
+
+// allSameSign reports whether no partial sum of items can overflow unless
+// the total does
+func allSameSign(items []int) bool {
+	pos, neg := false, false
+	for _, v := range items {
+		pos = pos || v > 0
+		neg = neg || v < 0
+	}
+	return !pos || !neg
+}
+
+func BenchmarkReduce(b *testing.B) {
+	items := make([]int, 1<<20)
+	for i := range items {
+		items[i] = i
+	}
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s := 0
+			for _, v := range items {
+				s += v
+			}
+			_ = s
+		}
+	})
+	b.Run("Reduce", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Reduce(context.Background(), items, intSum, Options{ChunkSize: 16384})
+		}
+	})
+	fold := intSum
+	fold.Fold = func(a int, vs []int) int {
+		for _, v := range vs {
+			a += v
+		}
+		return a
+	}
+	b.Run("Reduce/Fold", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Reduce(context.Background(), items, fold, Options{ChunkSize: 16384})
+		}
+	})
+}
+
+func BenchmarkSum(b *testing.B) {
+	xs := wide(1, 1<<20)
+	b.Run("naive", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			naive(xs)
+		}
+	})
+	for _, workers := range []int{1, 4} {
+		b.Run("Sum/workers="+strconv.Itoa(workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Sum(xs, Options{Workers: workers, ChunkSize: 16384})
+			}
+		})
+	}
+}
+
+func BenchmarkSumSquares(b *testing.B) {
+	ctx := context.Background()
+	items := make([]int, 1<<20)
+	for i := range items {
+		items[i] = i
+	}
+	b.Run("checked", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			SumSquares(ctx, items, Options{})
+		}
+	})
+	b.Run("big", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			BigSumSquares(ctx, items, Options{})
+		}
+	})
+	b.Run("widening", func(b *testing.B) {
+		huge := make([]int, len(items))
+		for i := range huge {
+			huge[i] = math.MaxInt32 + i
+		}
+		for i := 0; i < b.N; i++ {
+			BigSumSquares(ctx, huge, Options{})
+		}
+	})
+}
@@ -0,0 +1,91 @@
+// Package safe keeps a panic in one goroutine from crashing the process.
+// Call and Go recover panics and turn them into a *PanicError that
+// carries the stack trace and the input being processed; Policy tells the
+// worker pool and pipeline stages what to do next.
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is a recovered panic
+type PanicError struct {
+	// Value is what was passed to panic
+	Value any
+	// Input is the item being processed, if any
+	Input any
+	// Stack is the stack trace of the panicking goroutine
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	if e.Input != nil {
+		return fmt.Sprintf("panic processing %v: %v", e.Input, e.Value)
+	}
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error, so errors.Is sees
+// through panic(err)
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// IsPanic reports whether err is or wraps a *PanicError
+func IsPanic(err error) bool {
+	var pe *PanicError
+	return errors.As(err, &pe)
+}
+
+// Call returns fn(in), converting a panic into a *PanicError for in
+func Call[T, R any](fn func(T) (R, error), in T) (r R, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Input: in, Stack: debug.Stack()}
+		}
+	}()
+	return fn(in)
+}
+
+// Go runs fn in a new goroutine. A panic is passed to onPanic instead of
+// crashing the process; a nil onPanic ignores it.
+func Go(fn func(), onPanic func(*PanicError)) {
+	go func() {
+		defer func() {
+			if v := recover(); v != nil && onPanic != nil {
+				onPanic(&PanicError{Value: v, Stack: debug.Stack()})
+			}
+		}()
+		fn()
+	}()
+}
+
+// Policy decides what a pool or stage does after a worker panics. The
+// panic is reported as the item's error in every case.
+type Policy int
+
+const (
+	// FailFast stops the whole run at the first panic
+	FailFast Policy = iota
+	// SkipItem drops the item and lets the worker go on
+	SkipItem
+	// RestartWorker replaces the worker goroutine with a new one, so
+	// nothing the old one held on to survives the panic
+	RestartWorker
+)
+
+func (p Policy) String() string {
+	switch p {
+	case FailFast:
+		return "fail-fast"
+	case SkipItem:
+		return "skip-item"
+	case RestartWorker:
+		return "restart-worker"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,85 @@
+package safe
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCall(t *testing.T) {
+	parse := func(s string) (int, error) {
+		if s == "" {
+			panic("empty")
+		}
+		if s == "eof" {
+			panic(io.EOF)
+		}
+		return strconv.Atoi(s)
+	}
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+		panics  bool
+	}{
+		{"42", 42, false, false},
+		{"x", 0, true, false},
+		{"", 0, true, true},
+		{"eof", 0, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Call(parse, tt.in)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Fatalf("Call(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+			}
+			if IsPanic(err) != tt.panics {
+				t.Fatalf("IsPanic(%v) = %v, want %v", err, IsPanic(err), tt.panics)
+			}
+			if !tt.panics {
+				return
+			}
+			var pe *PanicError
+			errors.As(err, &pe)
+			if pe.Input != tt.in || !strings.Contains(string(pe.Stack), "TestCall") {
+				t.Errorf("PanicError input %v, stack %q, want %q and the panicking stack", pe.Input, pe.Stack, tt.in)
+			}
+		})
+	}
+
+	_, err := Call(parse, "eof")
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("Call() error = %v, want it to wrap the panicked io.EOF", err)
+	}
+	if got := err.Error(); got != "panic processing eof: EOF" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestGo(t *testing.T) {
+	got := make(chan *PanicError, 1)
+	Go(func() { panic("boom") }, func(pe *PanicError) { got <- pe })
+	select {
+	case pe := <-got:
+		if pe.Value != "boom" || pe.Error() != "panic: boom" || len(pe.Stack) == 0 {
+			t.Errorf("onPanic got %v", pe)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("onPanic not called")
+	}
+
+	done := make(chan struct{})
+	Go(func() { close(done) }, func(*PanicError) { t.Error("onPanic called without a panic") })
+	<-done
+	Go(func() { panic("ignored") }, nil)
+}
+
+func BenchmarkCall(b *testing.B) {
+	double := func(v int) (int, error) { return v * 2, nil }
+	for i := 0; i < b.N; i++ {
+		Call(double, i)
+	}
+}
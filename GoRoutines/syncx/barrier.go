@@ -0,0 +1,161 @@
+package syncx
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrBrokenBarrier is returned by Await when the barrier was broken by a
+// cancelled waiter or by Reset while other parties were waiting
+var ErrBrokenBarrier = errors.New("syncx: barrier is broken")
+
+// generation holds the state of one barrier cycle
+type generation struct {
+	done   chan struct{}
+	broken bool
+}
+
+// Barrier is a cyclic barrier: a fixed number of parties wait for each
+// other, then all of them are released together and the barrier can be
+// reused for the next phase
+type Barrier struct {
+	mu      sync.Mutex
+	parties int
+	waiting int
+	action  func()
+	gen     *generation
+}
+
+// NewBarrier creates a barrier for the given number of parties.
+// action, if not nil, runs once per cycle in the goroutine of the last
+// arriving party before the others are released. It runs without the
+// barrier's lock, so it may call the barrier's methods; if it panics, the
+// other parties get ErrBrokenBarrier and the panic goes on in the last
+// party.
+func NewBarrier(parties int, action func()) *Barrier {
+	if parties <= 0 {
+		panic("syncx: barrier parties must be positive")
+	}
+	return &Barrier{
+		parties: parties,
+		action:  action,
+		gen:     &generation{done: make(chan struct{})},
+	}
+}
+
+// Parties returns the number of parties required to trip the barrier
+func (b *Barrier) Parties() int {
+	return b.parties
+}
+
+// Waiting returns the number of parties currently blocked in Await
+func (b *Barrier) Waiting() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.waiting
+}
+
+// Await blocks until all parties have called Await or ctx is done.
+// It returns the arrival index of the caller: parties-1 for the first
+// party to arrive and 0 for the last one. If ctx is done before the
+// barrier trips, the barrier is broken for every waiter of this cycle.
+func (b *Barrier) Await(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	gen := b.gen
+	if gen.broken {
+		b.mu.Unlock()
+		return 0, ErrBrokenBarrier
+	}
+
+	index := b.parties - 1 - b.waiting
+	b.waiting++
+	if b.waiting == b.parties {
+		// The next cycle starts now; the parties of this one are released
+		// once the action has run
+		b.waiting = 0
+		b.gen = &generation{done: make(chan struct{})}
+		b.mu.Unlock()
+		b.trip(gen)
+		return index, nil
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-gen.done:
+		if gen.broken {
+			return 0, ErrBrokenBarrier
+		}
+		return index, nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		if b.gen == gen {
+			b.breakBarrier()
+			b.mu.Unlock()
+			return 0, ctx.Err()
+		}
+		b.mu.Unlock()
+		// The barrier tripped while we were acquiring the lock; its action
+		// may still be running
+		<-gen.done
+		if gen.broken {
+			return 0, ErrBrokenBarrier
+		}
+		return index, nil
+	}
+}
+
+// trip runs the action and then releases the parties of gen, breaking it
+// if the action panics
+func (b *Barrier) trip(gen *generation) {
+	ok := false
+	defer func() {
+		if !ok {
+			b.mu.Lock()
+			gen.broken = true
+			b.mu.Unlock()
+		}
+		close(gen.done)
+	}()
+	if b.action != nil {
+		b.action()
+	}
+	ok = true
+}
+
+// Reset breaks the current cycle, releasing any waiters with
+// ErrBrokenBarrier, and starts a fresh one
+func (b *Barrier) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.breakBarrier()
+	b.nextGeneration()
+}
+
+// IsBroken reports whether the current cycle is broken
+func (b *Barrier) IsBroken() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.gen.broken
+}
+
+// nextGeneration releases the current waiters and starts a new cycle.
+// Must be called with b.mu held.
+func (b *Barrier) nextGeneration() {
+	if !b.gen.broken {
+		close(b.gen.done)
+	}
+	b.waiting = 0
+	b.gen = &generation{done: make(chan struct{})}
+}
+
+// breakBarrier marks the current cycle as broken and wakes all waiters.
+// Must be called with b.mu held.
+func (b *Barrier) breakBarrier() {
+	if b.gen.broken {
+		return
+	}
+	b.gen.broken = true
+	b.waiting = 0
+	close(b.gen.done)
+}
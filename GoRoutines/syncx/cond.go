@@ -0,0 +1,75 @@
+package syncx
+
+import (
+	"context"
+	"sync"
+)
+
+// Cond is a condition variable like sync.Cond whose Wait can be cancelled
+// with a context. Waiters are woken in FIFO order by Signal.
+type Cond struct {
+	// L is held while observing or changing the condition
+	L sync.Locker
+
+	mu      sync.Mutex
+	waiters []chan struct{}
+}
+
+// NewCond returns a new Cond with Locker l
+func NewCond(l sync.Locker) *Cond {
+	return &Cond{L: l}
+}
+
+// Wait atomically unlocks c.L and suspends the calling goroutine until it
+// is woken by Signal or Broadcast, or until ctx is done. c.L is locked
+// again before Wait returns, in both cases.
+//
+// As with sync.Cond, the caller should re-check the condition in a loop.
+func (c *Cond) Wait(ctx context.Context) error {
+	ch := make(chan struct{})
+	c.mu.Lock()
+	c.waiters = append(c.waiters, ch)
+	c.mu.Unlock()
+
+	c.L.Unlock()
+	defer c.L.Lock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, w := range c.waiters {
+			if w == ch {
+				c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// Signalled concurrently with cancellation: the wakeup was
+		// consumed by us, so report it rather than losing it
+		return nil
+	}
+}
+
+// Signal wakes the longest waiting goroutine, if any
+func (c *Cond) Signal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.waiters) == 0 {
+		return
+	}
+	close(c.waiters[0])
+	c.waiters[0] = nil
+	c.waiters = c.waiters[1:]
+}
+
+// Broadcast wakes all waiting goroutines
+func (c *Cond) Broadcast() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.waiters {
+		close(w)
+	}
+	c.waiters = nil
+}
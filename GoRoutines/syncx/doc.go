@@ -0,0 +1,8 @@
+// Package syncx contains synchronization primitives that the standard sync
+// package does not provide: cyclic barriers, count-down latches, phasers,
+// a context-aware condition variable, a weighted semaphore and a
+// singleflight Group that coalesces duplicate concurrent calls.
+//
+// Every blocking call takes a context.Context so callers can give up
+// waiting without leaking goroutines.
+package syncx
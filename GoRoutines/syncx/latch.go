@@ -0,0 +1,70 @@
+package syncx
+
+import (
+	"context"
+	"sync"
+)
+
+// CountDownLatch lets goroutines wait until a set of operations performed
+// by other goroutines completes. Unlike sync.WaitGroup the count is fixed
+// up front, waiting can be cancelled, and the latch can be observed with
+// select through Done.
+type CountDownLatch struct {
+	mu    sync.Mutex
+	count int
+	done  chan struct{}
+}
+
+// NewCountDownLatch creates a latch that opens after count calls to CountDown
+func NewCountDownLatch(count int) *CountDownLatch {
+	if count < 0 {
+		panic("syncx: negative latch count")
+	}
+	l := &CountDownLatch{count: count, done: make(chan struct{})}
+	if count == 0 {
+		close(l.done)
+	}
+	return l
+}
+
+// CountDown decrements the count, opening the latch when it reaches zero.
+// Calls after the latch is open have no effect.
+func (l *CountDownLatch) CountDown() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count == 0 {
+		return
+	}
+	l.count--
+	if l.count == 0 {
+		close(l.done)
+	}
+}
+
+// Count returns the current count
+func (l *CountDownLatch) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// Done returns a channel that is closed when the latch opens
+func (l *CountDownLatch) Done() <-chan struct{} {
+	return l.done
+}
+
+// Await blocks until the latch opens or ctx is done
+func (l *CountDownLatch) Await(ctx context.Context) error {
+	select {
+	case <-l.done:
+		return nil
+	default:
+	}
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
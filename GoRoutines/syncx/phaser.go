@@ -0,0 +1,180 @@
+package syncx
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPhaserTerminated is returned by Phaser methods once the phaser has
+// terminated
+var ErrPhaserTerminated = errors.New("syncx: phaser is terminated")
+
+// Phaser is a reusable barrier with a dynamic number of parties.
+// Parties may register and deregister at any time; the phase advances
+// when every registered party of the current phase has arrived.
+type Phaser struct {
+	mu         sync.Mutex
+	phase      int
+	parties    int
+	arrived    int
+	terminated bool
+	advance    chan struct{}
+	onAdvance  func(phase, registered int) bool
+}
+
+// NewPhaser creates a phaser with an initial number of registered parties.
+// onAdvance, if not nil, is called on every phase advance with the phase
+// being completed and the number of registered parties; returning true
+// terminates the phaser. The default terminates the phaser once no
+// parties remain registered.
+func NewPhaser(parties int, onAdvance func(phase, registered int) bool) *Phaser {
+	if parties < 0 {
+		panic("syncx: negative phaser parties")
+	}
+	if onAdvance == nil {
+		onAdvance = func(_, registered int) bool { return registered == 0 }
+	}
+	return &Phaser{
+		parties:   parties,
+		advance:   make(chan struct{}),
+		onAdvance: onAdvance,
+	}
+}
+
+// Register adds a new unarrived party and returns the current phase
+func (p *Phaser) Register() (int, error) {
+	return p.BulkRegister(1)
+}
+
+// BulkRegister adds n unarrived parties and returns the current phase
+func (p *Phaser) BulkRegister(n int) (int, error) {
+	if n < 0 {
+		panic("syncx: negative phaser registration")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.terminated {
+		return 0, ErrPhaserTerminated
+	}
+	p.parties += n
+	return p.phase, nil
+}
+
+// Arrive records the arrival of one party without waiting for the others
+// and returns the phase it arrived at
+func (p *Phaser) Arrive() (int, error) {
+	return p.arrive(false)
+}
+
+// ArriveAndDeregister records the arrival of one party and removes it from
+// future phases. It returns the phase it arrived at.
+func (p *Phaser) ArriveAndDeregister() (int, error) {
+	return p.arrive(true)
+}
+
+// ArriveAndAwaitAdvance records the arrival of one party and waits for the
+// others. It returns the new phase number.
+func (p *Phaser) ArriveAndAwaitAdvance(ctx context.Context) (int, error) {
+	phase, err := p.arrive(false)
+	if err != nil {
+		return 0, err
+	}
+	return p.AwaitAdvance(ctx, phase)
+}
+
+// AwaitAdvance waits for the phaser to move past the given phase.
+// It returns immediately if the current phase differs from phase.
+func (p *Phaser) AwaitAdvance(ctx context.Context, phase int) (int, error) {
+	p.mu.Lock()
+	if p.phase != phase {
+		current, terminated := p.phase, p.terminated
+		p.mu.Unlock()
+		if terminated {
+			return current, ErrPhaserTerminated
+		}
+		return current, nil
+	}
+	if p.terminated {
+		p.mu.Unlock()
+		return phase, ErrPhaserTerminated
+	}
+	advance := p.advance
+	p.mu.Unlock()
+
+	select {
+	case <-advance:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.terminated && p.phase == phase+1 {
+			// The advance we waited for terminated the phaser; report it
+			// so callers stop looping over phases
+			return p.phase, ErrPhaserTerminated
+		}
+		return phase + 1, nil
+	case <-ctx.Done():
+		return phase, ctx.Err()
+	}
+}
+
+// Phase returns the current phase number
+func (p *Phaser) Phase() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.phase
+}
+
+// Registered returns the number of registered parties
+func (p *Phaser) Registered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.parties
+}
+
+// Arrived returns the number of parties that arrived at the current phase
+func (p *Phaser) Arrived() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.arrived
+}
+
+// IsTerminated reports whether the phaser has terminated
+func (p *Phaser) IsTerminated() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.terminated
+}
+
+func (p *Phaser) arrive(deregister bool) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.terminated {
+		return 0, ErrPhaserTerminated
+	}
+	if p.parties == p.arrived {
+		panic("syncx: phaser arrival by unregistered party")
+	}
+
+	phase := p.phase
+	if deregister {
+		p.parties--
+	} else {
+		p.arrived++
+	}
+	if p.arrived == p.parties {
+		p.advancePhase()
+	}
+	return phase, nil
+}
+
+// advancePhase completes the current phase and wakes its waiters.
+// Must be called with p.mu held.
+func (p *Phaser) advancePhase() {
+	if p.onAdvance(p.phase, p.parties) {
+		p.terminated = true
+	}
+	p.phase++
+	p.arrived = 0
+	close(p.advance)
+	p.advance = make(chan struct{})
+}
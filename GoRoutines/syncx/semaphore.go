@@ -0,0 +1,150 @@
+package syncx
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// semWaiter is one blocked Acquire call
+type semWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// Semaphore is a weighted semaphore. Acquire calls are served in FIFO
+// order, so a large request at the head of the queue is not starved by a
+// stream of small ones. The capacity can be changed while in use.
+type Semaphore struct {
+	mu      sync.Mutex
+	size    int64
+	cur     int64
+	waiters list.List
+}
+
+// NewSemaphore creates a semaphore with the given total weight
+func NewSemaphore(n int64) *Semaphore {
+	if n < 0 {
+		panic("syncx: negative semaphore size")
+	}
+	return &Semaphore{size: n}
+}
+
+// Acquire acquires weight n, blocking until it is available or ctx is
+// done. On failure it returns ctx.Err() and leaves the semaphore unchanged.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	if n < 0 {
+		panic("syncx: negative semaphore acquire")
+	}
+
+	s.mu.Lock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+
+	w := semWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-w.ready:
+			// Acquired after the context was cancelled; give it back
+			s.cur -= n
+			s.notifyWaiters()
+		default:
+			isFront := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			// The next waiter may fit now that we stopped blocking it
+			if isFront {
+				s.notifyWaiters()
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// TryAcquire acquires weight n without blocking and reports whether it
+// succeeded. It fails if other callers are already waiting.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	if n < 0 {
+		panic("syncx: negative semaphore acquire")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		return true
+	}
+	return false
+}
+
+// Release releases weight n
+func (s *Semaphore) Release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cur -= n
+	if s.cur < 0 {
+		panic("syncx: semaphore released more than held")
+	}
+	s.notifyWaiters()
+}
+
+// Resize changes the total weight of the semaphore. Growing it wakes
+// waiters that now fit; shrinking it below the weight in use blocks new
+// acquisitions until enough weight is released.
+func (s *Semaphore) Resize(n int64) {
+	if n < 0 {
+		panic("syncx: negative semaphore size")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = n
+	s.notifyWaiters()
+}
+
+// Size returns the total weight of the semaphore
+func (s *Semaphore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// InUse returns the weight currently held
+func (s *Semaphore) InUse() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cur
+}
+
+// Waiting returns the number of blocked Acquire calls
+func (s *Semaphore) Waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waiters.Len()
+}
+
+// notifyWaiters grants weight to waiters in FIFO order, stopping at the
+// first one that does not fit. Must be called with s.mu held.
+func (s *Semaphore) notifyWaiters() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(semWaiter)
+		if s.size-s.cur < w.n {
+			return
+		}
+		s.cur += w.n
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
@@ -0,0 +1,134 @@
+package syncx
+
+import (
+	"context"
+	"sync"
+)
+
+// flightCall is one in-flight or completed Group call
+type flightCall[V any] struct {
+	done    chan struct{}
+	val     V
+	err     error
+	waiters int
+	dups    int
+	cancel  context.CancelFunc
+	// finished is set once fn has returned; release is called on a
+	// result that no caller received
+	finished bool
+	release  func(V)
+}
+
+// Group coalesces concurrent calls with the same key into one execution
+// whose result is shared by every caller (the "singleflight" pattern).
+// The zero value is ready to use.
+type Group[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*flightCall[V]
+}
+
+// Do runs fn for key unless a call for key is already running, in which
+// case it waits for that call and returns its result. shared reports
+// whether the result was given to more than one caller.
+func (g *Group[K, V]) Do(key K, fn func() (V, error)) (v V, err error, shared bool) {
+	return g.DoContext(context.Background(), key, func(context.Context) (V, error) {
+		return fn()
+	})
+}
+
+// DoContext is like Do, but a caller stops waiting when its ctx is done.
+// fn receives a context that keeps the values of the first caller's ctx
+// and is cancelled only once every caller waiting for key has given up,
+// so one impatient caller does not fail the call for the others.
+func (g *Group[K, V]) DoContext(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (v V, err error, shared bool) {
+	return g.DoContextRelease(ctx, key, fn, nil)
+}
+
+// DoContextRelease is like DoContext, but if every caller gives up and
+// fn still returns a value, release is called with it, so a value that
+// holds a resource such as an open response body is not left behind.
+// Only the release of the caller that started the call is used.
+func (g *Group[K, V]) DoContextRelease(ctx context.Context, key K, fn func(ctx context.Context) (V, error), release func(V)) (v V, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*flightCall[V])
+	}
+	c, ok := g.calls[key]
+	if ok {
+		c.waiters++
+		c.dups++
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &flightCall[V]{done: make(chan struct{}), waiters: 1, cancel: cancel, release: release}
+		g.calls[key] = c
+		go g.run(callCtx, key, c, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		g.mu.Lock()
+		shared = c.dups > 0
+		g.mu.Unlock()
+		return c.val, c.err, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		unclaimed := false
+		if c.waiters == 0 {
+			// Nobody is left to want the result; a new caller starts afresh
+			// rather than joining a cancelled call
+			c.cancel()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+			// fn may have returned while this caller was leaving
+			unclaimed = c.finished
+		}
+		g.mu.Unlock()
+		if unclaimed {
+			c.releaseResult()
+		}
+		var zero V
+		return zero, ctx.Err(), false
+	}
+}
+
+func (g *Group[K, V]) run(ctx context.Context, key K, c *flightCall[V], fn func(ctx context.Context) (V, error)) {
+	defer c.cancel()
+	c.val, c.err = fn(ctx)
+
+	g.mu.Lock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	c.finished = true
+	unclaimed := c.waiters == 0
+	g.mu.Unlock()
+	close(c.done)
+	if unclaimed {
+		c.releaseResult()
+	}
+}
+
+// releaseResult hands a result nobody received to release
+func (c *flightCall[V]) releaseResult() {
+	if c.release != nil && c.err == nil {
+		c.release(c.val)
+	}
+}
+
+// Forget makes the next call for key start a new execution instead of
+// joining the one in flight
+func (g *Group[K, V]) Forget(key K) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.calls, key)
+}
+
+// InFlight returns the number of keys with a running call
+func (g *Group[K, V]) InFlight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.calls)
+}
@@ -0,0 +1,1042 @@
+package syncx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/internal/leaktest"
+)
+
+// Barrier Tests
+func TestBarrier(t *testing.T) {
+	tests := []struct {
+		name    string
+		parties int
+		cycles  int
+	}{
+		{"single party", 1, 3},
+		{"two parties", 2, 5},
+		{"many parties", 16, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actions int32
+			b := NewBarrier(tt.parties, func() { atomic.AddInt32(&actions, 1) })
+
+			// The action of cycle c must have run exactly c times before
+			// any party starts cycle c
+			var wg sync.WaitGroup
+			errs := make(chan error, tt.parties*tt.cycles)
+			for p := 0; p < tt.parties; p++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for c := 0; c < tt.cycles; c++ {
+						if got := atomic.LoadInt32(&actions); int(got) != c {
+							errs <- errors.New("party ran ahead of the barrier")
+						}
+						if _, err := b.Await(context.Background()); err != nil {
+							errs <- err
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Error(err)
+			}
+			if got := atomic.LoadInt32(&actions); int(got) != tt.cycles {
+				t.Errorf("action ran %d times, want %d", got, tt.cycles)
+			}
+		})
+	}
+}
+
+func TestBarrierArrivalIndex(t *testing.T) {
+	const parties = 8
+	b := NewBarrier(parties, nil)
+
+	indices := make(chan int, parties)
+	var wg sync.WaitGroup
+	for i := 0; i < parties; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			idx, err := b.Await(context.Background())
+			if err != nil {
+				t.Errorf("Await() unexpected error: %v", err)
+			}
+			indices <- idx
+		}()
+	}
+	wg.Wait()
+	close(indices)
+
+	seen := make(map[int]bool)
+	for idx := range indices {
+		if idx < 0 || idx >= parties || seen[idx] {
+			t.Errorf("Await() returned invalid or duplicate index %d", idx)
+		}
+		seen[idx] = true
+	}
+}
+
+func TestBarrierCancellation(t *testing.T) {
+	b := NewBarrier(3, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	other := make(chan error, 1)
+	go func() {
+		_, err := b.Await(context.Background())
+		other <- err
+	}()
+
+	leaktest.WaitFor(t, func() bool { return b.Waiting() == 1 })
+
+	go func() {
+		for b.Waiting() != 2 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if _, err := b.Await(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Await() error = %v, want context.Canceled", err)
+	}
+	if err := <-other; !errors.Is(err, ErrBrokenBarrier) {
+		t.Errorf("other waiter error = %v, want ErrBrokenBarrier", err)
+	}
+	if !b.IsBroken() {
+		t.Error("IsBroken() = false after cancellation")
+	}
+	if _, err := b.Await(context.Background()); !errors.Is(err, ErrBrokenBarrier) {
+		t.Errorf("Await() on broken barrier error = %v, want ErrBrokenBarrier", err)
+	}
+
+	b.Reset()
+	if b.IsBroken() {
+		t.Error("IsBroken() = true after Reset")
+	}
+}
+
+func TestBarrierReset(t *testing.T) {
+	b := NewBarrier(2, nil)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := b.Await(context.Background())
+		errc <- err
+	}()
+	leaktest.WaitFor(t, func() bool { return b.Waiting() == 1 })
+	b.Reset()
+
+	if err := <-errc; !errors.Is(err, ErrBrokenBarrier) {
+		t.Errorf("waiter error after Reset = %v, want ErrBrokenBarrier", err)
+	}
+
+	// The barrier is usable again after Reset
+	go b.Await(context.Background())
+	if _, err := b.Await(context.Background()); err != nil {
+		t.Errorf("Await() after Reset unexpected error: %v", err)
+	}
+}
+
+func TestBarrierActionPanics(t *testing.T) {
+	var b *Barrier
+	cycles := 0
+	b = NewBarrier(2, func() {
+		cycles++
+		// The action may use the barrier
+		if n := b.Waiting(); n != 0 {
+			t.Errorf("Waiting() in action = %d, want 0", n)
+		}
+		if cycles == 1 {
+			panic("boom")
+		}
+	})
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := b.Await(context.Background())
+		errc <- err
+	}()
+	leaktest.WaitFor(t, func() bool { return b.Waiting() == 1 })
+	func() {
+		defer func() {
+			if v := recover(); v != "boom" {
+				t.Errorf("last party recovered %v, want the action's panic", v)
+			}
+		}()
+		b.Await(context.Background())
+	}()
+	if err := <-errc; !errors.Is(err, ErrBrokenBarrier) {
+		t.Errorf("waiter error after the action panicked = %v, want ErrBrokenBarrier", err)
+	}
+
+	// The next cycle is not held by the panic
+	go b.Await(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := b.Await(ctx); err != nil {
+		t.Errorf("Await() after the action panicked unexpected error: %v", err)
+	}
+}
+
+// CountDownLatch Tests
+func TestCountDownLatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+	}{
+		{"zero count", 0},
+		{"single", 1},
+		{"many", 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewCountDownLatch(tt.count)
+			for i := 0; i < tt.count; i++ {
+				go l.CountDown()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := l.Await(ctx); err != nil {
+				t.Fatalf("Await() unexpected error: %v", err)
+			}
+			if l.Count() != 0 {
+				t.Errorf("Count() = %d, want 0", l.Count())
+			}
+
+			// Extra calls are ignored
+			l.CountDown()
+			if l.Count() != 0 {
+				t.Errorf("Count() after extra CountDown = %d, want 0", l.Count())
+			}
+		})
+	}
+}
+
+func TestCountDownLatchTimeout(t *testing.T) {
+	l := NewCountDownLatch(2)
+	l.CountDown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Await(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Await() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	select {
+	case <-l.Done():
+		t.Error("Done() closed before count reached zero")
+	default:
+	}
+	l.CountDown()
+	select {
+	case <-l.Done():
+	case <-time.After(time.Second):
+		t.Error("Done() not closed after count reached zero")
+	}
+}
+
+// Phaser Tests
+func TestPhaserPhases(t *testing.T) {
+	const parties, phases = 4, 5
+	p := NewPhaser(parties, func(phase, _ int) bool { return phase == phases-1 })
+
+	var wg sync.WaitGroup
+	var counter int32
+	for i := 0; i < parties; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for phase := 0; ; phase++ {
+				atomic.AddInt32(&counter, 1)
+				next, err := p.ArriveAndAwaitAdvance(context.Background())
+				if errors.Is(err, ErrPhaserTerminated) {
+					return
+				}
+				if err != nil {
+					t.Errorf("ArriveAndAwaitAdvance() unexpected error: %v", err)
+					return
+				}
+				if next != phase+1 {
+					t.Errorf("ArriveAndAwaitAdvance() = %d, want %d", next, phase+1)
+				}
+				// Nobody may start phase n+1 before everyone finished phase n
+				if got := atomic.LoadInt32(&counter); got < int32(parties*(phase+1)) {
+					t.Errorf("phase %d: counter = %d, want at least %d", phase, got, parties*(phase+1))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !p.IsTerminated() {
+		t.Error("IsTerminated() = false after final phase")
+	}
+	if got := atomic.LoadInt32(&counter); got != parties*phases {
+		t.Errorf("counter = %d, want %d", got, parties*phases)
+	}
+}
+
+func TestPhaserDynamicRegistration(t *testing.T) {
+	p := NewPhaser(1, nil)
+
+	// A late party joins during phase 0
+	phase, err := p.Register()
+	if err != nil || phase != 0 {
+		t.Fatalf("Register() = %d, %v, want 0, nil", phase, err)
+	}
+	if p.Registered() != 2 {
+		t.Errorf("Registered() = %d, want 2", p.Registered())
+	}
+
+	if _, err := p.Arrive(); err != nil {
+		t.Fatalf("Arrive() unexpected error: %v", err)
+	}
+	if p.Phase() != 0 {
+		t.Errorf("Phase() = %d after partial arrival, want 0", p.Phase())
+	}
+	if _, err := p.ArriveAndDeregister(); err != nil {
+		t.Fatalf("ArriveAndDeregister() unexpected error: %v", err)
+	}
+	if p.Phase() != 1 || p.Registered() != 1 {
+		t.Errorf("Phase(), Registered() = %d, %d, want 1, 1", p.Phase(), p.Registered())
+	}
+
+	// Deregistering the last party terminates the phaser
+	if _, err := p.ArriveAndDeregister(); err != nil {
+		t.Fatalf("ArriveAndDeregister() unexpected error: %v", err)
+	}
+	if !p.IsTerminated() {
+		t.Error("IsTerminated() = false after last party deregistered")
+	}
+	if _, err := p.Register(); !errors.Is(err, ErrPhaserTerminated) {
+		t.Errorf("Register() on terminated phaser error = %v, want ErrPhaserTerminated", err)
+	}
+}
+
+func TestPhaserAwaitAdvanceCancellation(t *testing.T) {
+	p := NewPhaser(2, nil)
+	if _, err := p.Arrive(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.AwaitAdvance(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("AwaitAdvance() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	// Awaiting a phase that already passed returns immediately
+	if _, err := p.Arrive(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.AwaitAdvance(context.Background(), 0); got != 1 || err != nil {
+		t.Errorf("AwaitAdvance() on past phase = %d, %v, want 1, nil", got, err)
+	}
+}
+
+// Cond Tests
+func TestCondSignalOrder(t *testing.T) {
+	var mu sync.Mutex
+	c := NewCond(&mu)
+
+	const waiters = 5
+	order := make(chan int, waiters)
+	for i := 0; i < waiters; i++ {
+		ready := make(chan struct{})
+		go func(id int) {
+			mu.Lock()
+			close(ready)
+			if err := c.Wait(context.Background()); err != nil {
+				t.Errorf("Wait() unexpected error: %v", err)
+			}
+			order <- id
+			mu.Unlock()
+		}(i)
+		<-ready
+		// Wait registers the waiter before it releases the lock
+		mu.Lock()
+		mu.Unlock()
+	}
+
+	for i := 0; i < waiters; i++ {
+		c.Signal()
+		if got := <-order; got != i {
+			t.Errorf("Signal() woke waiter %d, want %d", got, i)
+		}
+	}
+}
+
+func TestCondBroadcast(t *testing.T) {
+	var mu sync.Mutex
+	c := NewCond(&mu)
+	ready := false
+
+	const waiters = 10
+	var wg sync.WaitGroup
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			for !ready {
+				if err := c.Wait(context.Background()); err != nil {
+					t.Errorf("Wait() unexpected error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	mu.Lock()
+	ready = true
+	c.Broadcast()
+	mu.Unlock()
+	wg.Wait()
+}
+
+func TestCondWaitCancellation(t *testing.T) {
+	var mu sync.Mutex
+	c := NewCond(&mu)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	mu.Lock()
+	err := c.Wait(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want context.DeadlineExceeded", err)
+	}
+	// The lock must be held again after a cancelled Wait
+	if mu.TryLock() {
+		t.Error("Wait() returned without reacquiring the lock")
+	}
+	mu.Unlock()
+
+	// A cancelled waiter must not swallow a later signal
+	c.Signal()
+	done := make(chan struct{})
+	go func() {
+		mu.Lock()
+		c.Wait(context.Background())
+		mu.Unlock()
+		close(done)
+	}()
+	leaktest.WaitFor(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.waiters) == 1
+	})
+	c.Signal()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Signal() did not wake the waiter")
+	}
+}
+
+// Semaphore Tests
+func TestSemaphoreWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int64
+		weights []int64
+	}{
+		{"unit weights", 3, []int64{1, 1, 1, 1, 1, 1, 1}},
+		{"mixed weights", 10, []int64{1, 5, 10, 3, 7, 2, 8}},
+		{"zero weight", 1, []int64{0, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sem := NewSemaphore(tt.size)
+			var inUse, peak int64
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			for _, w := range tt.weights {
+				wg.Add(1)
+				go func(w int64) {
+					defer wg.Done()
+					if err := sem.Acquire(context.Background(), w); err != nil {
+						t.Errorf("Acquire(%d) unexpected error: %v", w, err)
+						return
+					}
+					mu.Lock()
+					inUse += w
+					if inUse > peak {
+						peak = inUse
+					}
+					mu.Unlock()
+					time.Sleep(time.Millisecond)
+					mu.Lock()
+					inUse -= w
+					mu.Unlock()
+					sem.Release(w)
+				}(w)
+			}
+			wg.Wait()
+
+			if peak > tt.size {
+				t.Errorf("peak weight in use = %d, want at most %d", peak, tt.size)
+			}
+			if sem.InUse() != 0 {
+				t.Errorf("InUse() = %d after all releases, want 0", sem.InUse())
+			}
+		})
+	}
+}
+
+func TestSemaphoreTryAcquire(t *testing.T) {
+	sem := NewSemaphore(2)
+	if !sem.TryAcquire(2) {
+		t.Fatal("TryAcquire(2) on empty semaphore = false")
+	}
+	if sem.TryAcquire(1) {
+		t.Error("TryAcquire(1) on full semaphore = true")
+	}
+	sem.Release(1)
+	if !sem.TryAcquire(1) {
+		t.Error("TryAcquire(1) after Release(1) = false")
+	}
+}
+
+func TestSemaphoreFIFO(t *testing.T) {
+	sem := NewSemaphore(4)
+	sem.Acquire(context.Background(), 3)
+
+	// A big request queues first; a small one that would fit right now
+	// must still wait behind it
+	big := make(chan struct{})
+	go func() {
+		sem.Acquire(context.Background(), 4)
+		close(big)
+	}()
+	leaktest.WaitFor(t, func() bool { return sem.Waiting() == 1 })
+
+	if sem.TryAcquire(1) {
+		t.Fatal("TryAcquire(1) jumped ahead of a queued waiter")
+	}
+	small := make(chan struct{})
+	go func() {
+		sem.Acquire(context.Background(), 1)
+		close(small)
+	}()
+	leaktest.WaitFor(t, func() bool { return sem.Waiting() == 2 })
+
+	sem.Release(3)
+	select {
+	case <-big:
+	case <-time.After(time.Second):
+		t.Fatal("big waiter not woken after Release")
+	}
+	select {
+	case <-small:
+		t.Fatal("small waiter overtook the big one")
+	default:
+	}
+
+	sem.Release(4)
+	select {
+	case <-small:
+	case <-time.After(time.Second):
+		t.Fatal("small waiter not woken after big one released")
+	}
+}
+
+func TestSemaphoreCancellation(t *testing.T) {
+	sem := NewSemaphore(2)
+	sem.Acquire(context.Background(), 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := sem.Acquire(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() error = %v, want context.DeadlineExceeded", err)
+	}
+	if sem.Waiting() != 0 || sem.InUse() != 2 {
+		t.Errorf("Waiting(), InUse() = %d, %d after cancelled Acquire, want 0, 2", sem.Waiting(), sem.InUse())
+	}
+
+	// A cancelled head waiter must unblock the ones queued behind it
+	headCtx, headCancel := context.WithCancel(context.Background())
+	headErr := make(chan error, 1)
+	go func() { headErr <- sem.Acquire(headCtx, 2) }()
+	leaktest.WaitFor(t, func() bool { return sem.Waiting() == 1 })
+	tail := make(chan struct{})
+	go func() {
+		sem.Acquire(context.Background(), 1)
+		close(tail)
+	}()
+	leaktest.WaitFor(t, func() bool { return sem.Waiting() == 2 })
+
+	sem.Release(1)
+	headCancel()
+	if err := <-headErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("head Acquire() error = %v, want context.Canceled", err)
+	}
+	select {
+	case <-tail:
+	case <-time.After(time.Second):
+		t.Fatal("tail waiter not woken after head was cancelled")
+	}
+}
+
+func TestSemaphoreResize(t *testing.T) {
+	sem := NewSemaphore(1)
+	sem.Acquire(context.Background(), 1)
+
+	acquired := make(chan struct{})
+	go func() {
+		sem.Acquire(context.Background(), 2)
+		close(acquired)
+	}()
+	leaktest.WaitFor(t, func() bool { return sem.Waiting() == 1 })
+
+	sem.Resize(3)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken after Resize grew the semaphore")
+	}
+
+	// Shrinking below the weight in use blocks new acquisitions
+	sem.Resize(2)
+	if sem.TryAcquire(1) {
+		t.Error("TryAcquire(1) succeeded on over-committed semaphore")
+	}
+	sem.Release(2)
+	if !sem.TryAcquire(1) {
+		t.Error("TryAcquire(1) failed after releasing below new size")
+	}
+}
+
+// Group Tests
+func TestGroupCoalesces(t *testing.T) {
+	var g Group[string, int]
+	var calls int32
+	release := make(chan struct{})
+
+	const callers = 10
+	var wg sync.WaitGroup
+	results := make(chan int, callers)
+	sharedCount := int32(0)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.Do("key", func() (int, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return 42, nil
+			})
+			if err != nil {
+				t.Errorf("Do() unexpected error: %v", err)
+			}
+			if shared {
+				atomic.AddInt32(&sharedCount, 1)
+			}
+			results <- v
+		}()
+	}
+
+	leaktest.WaitFor(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		c := g.calls["key"]
+		return c != nil && c.waiters == callers
+	})
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if calls != 1 {
+		t.Errorf("fn ran %d times, want 1", calls)
+	}
+	for v := range results {
+		if v != 42 {
+			t.Errorf("Do() = %d, want 42", v)
+		}
+	}
+	if sharedCount != callers {
+		t.Errorf("%d callers saw shared = true, want %d", sharedCount, callers)
+	}
+	if g.InFlight() != 0 {
+		t.Errorf("InFlight() = %d after completion, want 0", g.InFlight())
+	}
+}
+
+func TestGroupSequentialCallsRunAgain(t *testing.T) {
+	var g Group[int, int]
+	calls := 0
+	for i := 0; i < 3; i++ {
+		v, err, shared := g.Do(1, func() (int, error) {
+			calls++
+			return calls, nil
+		})
+		if err != nil || shared || v != i+1 {
+			t.Errorf("Do() = %d, %v, %v, want %d, nil, false", v, err, shared, i+1)
+		}
+	}
+
+	wantErr := errors.New("boom")
+	if _, err, _ := g.Do(2, func() (int, error) { return 0, wantErr }); !errors.Is(err, wantErr) {
+		t.Errorf("Do() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestGroupCancellation(t *testing.T) {
+	var g Group[string, string]
+	fnCtx := make(chan context.Context, 1)
+	fn := func(ctx context.Context) (string, error) {
+		fnCtx <- ctx
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	err1, err2 := make(chan error, 1), make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoContext(ctx1, "key", fn)
+		err1 <- err
+	}()
+	callCtx := <-fnCtx
+	go func() {
+		_, err, _ := g.DoContext(ctx2, "key", fn)
+		err2 <- err
+	}()
+	leaktest.WaitFor(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.calls["key"].waiters == 2
+	})
+
+	// The first caller giving up must not cancel the shared call
+	cancel1()
+	if err := <-err1; !errors.Is(err, context.Canceled) {
+		t.Errorf("first DoContext() error = %v, want context.Canceled", err)
+	}
+	select {
+	case <-callCtx.Done():
+		t.Fatal("shared call cancelled while a caller was still waiting")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Once the last caller leaves, the call is cancelled
+	cancel2()
+	if err := <-err2; !errors.Is(err, context.Canceled) {
+		t.Errorf("second DoContext() error = %v, want context.Canceled", err)
+	}
+	select {
+	case <-callCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("shared call not cancelled after every caller left")
+	}
+	leaktest.WaitFor(t, func() bool { return g.InFlight() == 0 })
+}
+
+func TestGroupCallAfterCancellation(t *testing.T) {
+	var g Group[string, int]
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoContext(ctx, "key", func(context.Context) (int, error) {
+			close(started)
+			<-release // still running after its only caller left
+			return 1, nil
+		})
+		errc <- err
+	}()
+	<-started
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("DoContext() error = %v, want context.Canceled", err)
+	}
+
+	// A new caller must not join the abandoned call
+	v, err, shared := g.DoContext(context.Background(), "key", func(context.Context) (int, error) {
+		return 2, nil
+	})
+	if v != 2 || err != nil || shared {
+		t.Errorf("DoContext() after cancellation = %d, %v, shared %v, want 2, nil, false", v, err, shared)
+	}
+}
+
+func TestGroupReleaseUnclaimed(t *testing.T) {
+	var g Group[string, int]
+	released := make(chan int, 2)
+	run := func(ctx context.Context, v int, proceed <-chan struct{}) error {
+		_, err, _ := g.DoContextRelease(ctx, "key", func(context.Context) (int, error) {
+			<-proceed
+			return v, nil
+		}, func(v int) { released <- v })
+		return err
+	}
+
+	// Every caller left before fn returned
+	ctx, cancel := context.WithCancel(context.Background())
+	proceed := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() { errc <- run(ctx, 1, proceed) }()
+	leaktest.WaitFor(t, func() bool { return g.InFlight() == 1 })
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("DoContextRelease() error = %v, want context.Canceled", err)
+	}
+	close(proceed)
+	select {
+	case v := <-released:
+		if v != 1 {
+			t.Errorf("released %d, want 1", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unclaimed result never released")
+	}
+
+	// A result a caller received is not released
+	proceed = make(chan struct{})
+	close(proceed)
+	if err := run(context.Background(), 2, proceed); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-released:
+		t.Errorf("released %d, which a caller received", v)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestGroupForget(t *testing.T) {
+	var g Group[string, int]
+	release := make(chan struct{})
+	go g.Do("key", func() (int, error) {
+		<-release
+		return 1, nil
+	})
+	leaktest.WaitFor(t, func() bool { return g.InFlight() == 1 })
+
+	g.Forget("key")
+	v, _, shared := g.Do("key", func() (int, error) { return 2, nil })
+	if v != 2 || shared {
+		t.Errorf("Do() after Forget = %d, shared %v, want 2, false", v, shared)
+	}
+	close(release)
+}
+
+// Benchmarks against channel equivalents
+func BenchmarkBarrier(b *testing.B) {
+	const parties = 4
+
+	b.Run("Barrier", func(b *testing.B) {
+		bar := NewBarrier(parties, nil)
+		var wg sync.WaitGroup
+		for p := 0; p < parties; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < b.N; i++ {
+					bar.Await(context.Background())
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	b.Run("channels", func(b *testing.B) {
+		// Each party reports arrival; a coordinator releases everyone
+		arrive := make(chan struct{})
+		release := make([]chan struct{}, parties)
+		for i := range release {
+			release[i] = make(chan struct{})
+		}
+		go func() {
+			for i := 0; i < b.N; i++ {
+				for p := 0; p < parties; p++ {
+					<-arrive
+				}
+				for _, r := range release {
+					r <- struct{}{}
+				}
+			}
+		}()
+		var wg sync.WaitGroup
+		for p := 0; p < parties; p++ {
+			wg.Add(1)
+			go func(p int) {
+				defer wg.Done()
+				for i := 0; i < b.N; i++ {
+					arrive <- struct{}{}
+					<-release[p]
+				}
+			}(p)
+		}
+		wg.Wait()
+	})
+}
+
+func BenchmarkPhaser(b *testing.B) {
+	const parties = 4
+
+	b.Run("Phaser", func(b *testing.B) {
+		p := NewPhaser(parties, nil)
+		var wg sync.WaitGroup
+		for i := 0; i < parties; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < b.N; i++ {
+					phase, _ := p.Arrive()
+					p.AwaitAdvance(context.Background(), phase)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	b.Run("channels", func(b *testing.B) {
+		// Taking the current phase's channel is the arrival; the
+		// coordinator closes it once every party has arrived
+		current := make(chan chan struct{})
+		go func() {
+			phase := make(chan struct{})
+			for i := 0; i < b.N; i++ {
+				for p := 0; p < parties; p++ {
+					current <- phase
+				}
+				close(phase)
+				phase = make(chan struct{})
+			}
+		}()
+		var wg sync.WaitGroup
+		for p := 0; p < parties; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < b.N; i++ {
+					<-<-current
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func BenchmarkCountDownLatch(b *testing.B) {
+	const count = 8
+
+	b.Run("CountDownLatch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			l := NewCountDownLatch(count)
+			for j := 0; j < count; j++ {
+				go l.CountDown()
+			}
+			l.Await(context.Background())
+		}
+	})
+
+	b.Run("channels", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			done := make(chan struct{}, count)
+			for j := 0; j < count; j++ {
+				go func() { done <- struct{}{} }()
+			}
+			for j := 0; j < count; j++ {
+				<-done
+			}
+		}
+	})
+}
+
+func BenchmarkCond(b *testing.B) {
+	b.Run("Cond", func(b *testing.B) {
+		var mu sync.Mutex
+		c := NewCond(&mu)
+		benchmarkPingPong(b, &mu, func() { c.Wait(context.Background()) }, c.Signal)
+	})
+
+	b.Run("sync.Cond", func(b *testing.B) {
+		var mu sync.Mutex
+		c := sync.NewCond(&mu)
+		benchmarkPingPong(b, &mu, c.Wait, c.Signal)
+	})
+
+	b.Run("channels", func(b *testing.B) {
+		ping, pong := make(chan struct{}), make(chan struct{})
+		go func() {
+			for range ping {
+				pong <- struct{}{}
+			}
+		}()
+		for i := 0; i < b.N; i++ {
+			ping <- struct{}{}
+			<-pong
+		}
+		close(ping)
+	})
+}
+
+func BenchmarkSemaphore(b *testing.B) {
+	const limit = 4
+
+	b.Run("Semaphore", func(b *testing.B) {
+		sem := NewSemaphore(limit)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				sem.Acquire(context.Background(), 1)
+				sem.Release(1)
+			}
+		})
+	})
+
+	b.Run("channels", func(b *testing.B) {
+		sem := make(chan struct{}, limit)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				sem <- struct{}{}
+				<-sem
+			}
+		})
+	})
+}
+
+// benchmarkPingPong hands a turn flag back and forth between two goroutines
+func benchmarkPingPong(b *testing.B, mu *sync.Mutex, wait, signal func()) {
+	turn := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mu.Lock()
+		defer mu.Unlock()
+		for i := 0; i < b.N; i++ {
+			for turn != 1 {
+				wait()
+			}
+			turn = 0
+			signal()
+		}
+	}()
+
+	mu.Lock()
+	for i := 0; i < b.N; i++ {
+		turn = 1
+		signal()
+		for turn != 0 {
+			wait()
+		}
+	}
+	mu.Unlock()
+	<-done
+}
@@ -0,0 +1,171 @@
+package walq
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every change to the queue is one record appended to the log:
+//
+//	crc32 (4) | body length (4) | body
+//	body = type (1) | id (8) | attempts (4) | payload
+//
+// The checksum covers the length and the body, so a torn write at the end
+// of the last segment is detected and cut off when the log is opened.
+const headerSize = 8
+
+type recordType byte
+
+const (
+	// recEnqueue adds a pending message
+	recEnqueue recordType = iota + 1
+	// recLease counts a delivery of a message
+	recLease
+	// recAck removes a message, pending or dead
+	recAck
+	// recDead moves a message to the dead-letter queue
+	recDead
+	// recDeadLetter adds a dead message; written by compaction
+	recDeadLetter
+	// recReset starts a compacted segment: the state built so far is
+	// replaced by the records that follow
+	recReset
+)
+
+type record struct {
+	typ      recordType
+	id       uint64
+	attempts uint32
+	payload  []byte
+}
+
+func (r record) size() int {
+	return headerSize + 13 + len(r.payload)
+}
+
+func (r record) appendTo(b []byte) []byte {
+	start := len(b)
+	b = append(b, make([]byte, headerSize)...)
+	b = append(b, byte(r.typ))
+	b = binary.LittleEndian.AppendUint64(b, r.id)
+	b = binary.LittleEndian.AppendUint32(b, r.attempts)
+	b = append(b, r.payload...)
+	binary.LittleEndian.PutUint32(b[start+4:], uint32(len(b)-start-headerSize))
+	binary.LittleEndian.PutUint32(b[start:], crc32.ChecksumIEEE(b[start+4:]))
+	return b
+}
+
+var errTorn = errors.New("torn record")
+
+// readRecord reads one record. It returns io.EOF at a clean end and
+// errTorn for a short or damaged record.
+func readRecord(r *bufio.Reader) (record, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return record{}, io.EOF
+		}
+		return record{}, errTorn
+	}
+	n := binary.LittleEndian.Uint32(hdr[4:])
+	if n < 13 || n > maxRecord {
+		return record{}, errTorn
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return record{}, errTorn
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.LittleEndian.Uint32(hdr[:4]) {
+		return record{}, errTorn
+	}
+	rec := record{
+		typ:      recordType(body[0]),
+		id:       binary.LittleEndian.Uint64(body[1:]),
+		attempts: binary.LittleEndian.Uint32(body[9:]),
+	}
+	if len(body) > 13 {
+		rec.payload = body[13:]
+	}
+	return rec, nil
+}
+
+// maxRecord bounds the body length read from disk, so a damaged length
+// cannot make the reader allocate gigabytes
+const maxRecord = 1 << 30
+
+const segmentExt = ".wal"
+
+func segmentName(index uint64) string {
+	return fmt.Sprintf("%020d%s", index, segmentExt)
+}
+
+// segments lists the segment indexes in dir in order, removing leftovers
+// of an interrupted compaction
+func segments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var idx []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, segmentExt+".tmp") {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		i, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err == nil && name == segmentName(i) {
+			idx = append(idx, i)
+		}
+	}
+	sort.Slice(idx, func(a, b int) bool { return idx[a] < idx[b] })
+	return idx, nil
+}
+
+// replay calls apply for every record of a segment. If the segment ends
+// in a torn record, it returns the offset of the last good record and
+// errTorn.
+func replay(path string, apply func(record)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var off int64
+	for {
+		rec, err := readRecord(r)
+		if err == io.EOF {
+			return off, nil
+		}
+		if err != nil {
+			return off, err
+		}
+		apply(rec)
+		off += int64(rec.size())
+	}
+}
+
+// syncDir makes renames and removals in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,702 @@
+// Package walq is a durable job queue for worker pools that must survive
+// a crash.
+//
+// Every change is appended to a write-ahead log of segment files in a
+// directory, and Open rebuilds the queue from it, cutting off a record
+// torn by a crash. A received message is leased for the visibility
+// timeout: Ack removes it, Nack or an expired lease makes it visible
+// again, and after MaxAttempts deliveries it moves to the dead-letter
+// queue. Delivery is at least once: a message being processed when the
+// process died is delivered again after Open. Compact rewrites the log to
+// hold only live messages.
+package walq
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+	"github.com/go-concurrency-lesson/safe"
+)
+
+var (
+	// ErrClosed is returned by every method after Close
+	ErrClosed = errors.New("walq: queue closed")
+	// ErrNotFound is returned by Ack and Nack for an unknown message or a
+	// lease the caller no longer holds
+	ErrNotFound = errors.New("walq: message not found")
+	// ErrCorrupt is returned by Open when a segment other than the last
+	// one is damaged, which a crash cannot explain
+	ErrCorrupt = errors.New("walq: log corrupt")
+)
+
+// SyncPolicy decides when writes are flushed to stable storage
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every write: nothing acknowledged is lost
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs at most every SyncEvery: a crash loses at most
+	// that much
+	SyncInterval
+	// SyncNever leaves flushing to the operating system; only a machine
+	// crash, not a process crash, loses data
+	SyncNever
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	case SyncNever:
+		return "never"
+	default:
+		return "unknown"
+	}
+}
+
+// Options configure a Queue
+type Options struct {
+	// SegmentSize is the size at which a new segment file is started
+	// [4 MiB]
+	SegmentSize int64
+	// Sync is the fsync policy [SyncAlways]
+	Sync SyncPolicy
+	// SyncEvery is the flush interval of SyncInterval [100ms]
+	SyncEvery time.Duration
+	// Visibility is how long a received message stays invisible to other
+	// receivers before it is delivered again [30s]
+	Visibility time.Duration
+	// MaxAttempts is how many deliveries a message gets before it moves to
+	// the dead-letter queue [5]
+	MaxAttempts int
+	// Clock measures leases and drives SyncInterval [real time]
+	Clock clock.Clock
+}
+
+// Message is a queued job
+type Message struct {
+	ID      uint64
+	Payload []byte
+	// Attempts counts deliveries, including this one. It is also the
+	// lease token: Ack and Nack refuse a message whose lease expired and
+	// was delivered again.
+	Attempts int
+}
+
+// Stats describe the queue
+type Stats struct {
+	// Pending counts messages waiting to be received
+	Pending int
+	// InFlight counts leased messages
+	InFlight int
+	// Dead counts messages in the dead-letter queue
+	Dead     int
+	Segments int
+	LogBytes int64
+}
+
+// Queue is a durable FIFO queue. It is safe for concurrent use.
+type Queue struct {
+	dir  string
+	opts Options
+	clk  clock.Clock
+
+	mu      sync.Mutex
+	msgs    map[uint64]*message
+	ready   list.List // of *message, visible pending messages in FIFO order
+	leased  map[uint64]*message
+	dead    int
+	nextID  uint64
+	segs    []uint64 // segment indexes, the last one is active
+	active  *os.File
+	size    int64 // of the active segment
+	bytes   int64 // of all segments
+	buf     []byte
+	dirty   bool
+	err     error // first write error; the queue is unusable after it
+	closed  bool
+	changed chan struct{} // closed and replaced on every state change
+
+	stopSync chan struct{}
+	syncDone chan struct{}
+}
+
+type message struct {
+	id          uint64
+	payload     []byte
+	attempts    int
+	dead        bool
+	leasedUntil time.Time
+	elem        *list.Element // in ready
+}
+
+// Open opens the queue in dir, creating it if needed, and replays its log
+func Open(dir string, opts Options) (*Queue, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = 4 << 20
+	}
+	if opts.SyncEvery <= 0 {
+		opts.SyncEvery = 100 * time.Millisecond
+	}
+	if opts.Visibility <= 0 {
+		opts.Visibility = 30 * time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		dir:     dir,
+		opts:    opts,
+		clk:     clock.Or(opts.Clock),
+		msgs:    make(map[uint64]*message),
+		leased:  make(map[uint64]*message),
+		nextID:  1,
+		changed: make(chan struct{}),
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	if opts.Sync == SyncInterval {
+		q.stopSync = make(chan struct{})
+		q.syncDone = make(chan struct{})
+		go q.syncLoop()
+	}
+	return q, nil
+}
+
+// load replays the segments and opens the last one for appending
+func (q *Queue) load() error {
+	segs, err := segments(q.dir)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for i, idx := range segs {
+		path := filepath.Join(q.dir, segmentName(idx))
+		size, err = replay(path, q.apply)
+		if err == errTorn {
+			if i < len(segs)-1 {
+				return fmt.Errorf("%w: %s", ErrCorrupt, path)
+			}
+			// The crash interrupted this write
+			if err = os.Truncate(path, size); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		q.bytes += size
+	}
+
+	ids := make([]uint64, 0, len(q.msgs))
+	for id, m := range q.msgs {
+		if m.dead {
+			q.dead++
+		} else {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	for _, id := range ids {
+		m := q.msgs[id]
+		m.elem = q.ready.PushBack(m)
+	}
+
+	if len(segs) == 0 {
+		return q.createSegment(1)
+	}
+	q.segs = segs
+	q.size = size
+	q.active, err = os.OpenFile(filepath.Join(q.dir, segmentName(segs[len(segs)-1])), os.O_WRONLY|os.O_APPEND, 0)
+	return err
+}
+
+// apply replays one record into the state
+func (q *Queue) apply(r record) {
+	switch r.typ {
+	case recEnqueue, recDeadLetter:
+		q.msgs[r.id] = &message{id: r.id, payload: r.payload, attempts: int(r.attempts), dead: r.typ == recDeadLetter}
+		q.nextID = max(q.nextID, r.id+1)
+	case recLease:
+		if m, ok := q.msgs[r.id]; ok {
+			m.attempts++
+		}
+	case recAck:
+		delete(q.msgs, r.id)
+	case recDead:
+		if m, ok := q.msgs[r.id]; ok {
+			m.dead = true
+		}
+	case recReset:
+		q.msgs = make(map[uint64]*message)
+		q.nextID = max(q.nextID, r.id)
+	}
+}
+
+// createSegment starts segment idx as the active one
+func (q *Queue) createSegment(idx uint64) error {
+	f, err := os.OpenFile(filepath.Join(q.dir, segmentName(idx)), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(q.dir); err != nil {
+		f.Close()
+		return err
+	}
+	q.active, q.size = f, 0
+	q.segs = append(q.segs, idx)
+	return nil
+}
+
+// write appends records to the log as one write. It must be called with
+// mu held; after a failure every later call fails with the same error.
+func (q *Queue) write(recs ...record) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.buf = q.buf[:0]
+	for _, r := range recs {
+		q.buf = r.appendTo(q.buf)
+	}
+	n, err := q.active.Write(q.buf)
+	q.size += int64(n)
+	q.bytes += int64(n)
+	q.dirty = true
+	if err == nil && q.opts.Sync == SyncAlways {
+		err = q.flush()
+	}
+	if err == nil && q.size >= q.opts.SegmentSize {
+		err = q.rotate()
+	}
+	if err != nil {
+		q.err = fmt.Errorf("walq: write: %w", err)
+	}
+	return q.err
+}
+
+func (q *Queue) flush() error {
+	if !q.dirty {
+		return nil
+	}
+	q.dirty = false
+	return q.active.Sync()
+}
+
+func (q *Queue) rotate() error {
+	if err := q.flush(); err != nil {
+		return err
+	}
+	if err := q.active.Close(); err != nil {
+		return err
+	}
+	return q.createSegment(q.segs[len(q.segs)-1] + 1)
+}
+
+func (q *Queue) syncLoop() {
+	defer close(q.syncDone)
+	ticker := q.clk.NewTicker(q.opts.SyncEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			q.mu.Lock()
+			if q.err == nil {
+				if err := q.flush(); err != nil {
+					q.err = fmt.Errorf("walq: sync: %w", err)
+				}
+			}
+			q.mu.Unlock()
+		case <-q.stopSync:
+			return
+		}
+	}
+}
+
+// notify wakes up waiting receivers; mu is held
+func (q *Queue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Enqueue appends a message and returns its ID. With SyncAlways the
+// message is on stable storage when Enqueue returns.
+func (q *Queue) Enqueue(payload []byte) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, ErrClosed
+	}
+	id := q.nextID
+	payload = append([]byte(nil), payload...)
+	if err := q.write(record{typ: recEnqueue, id: id, payload: payload}); err != nil {
+		return 0, err
+	}
+	q.nextID++
+	m := &message{id: id, payload: payload}
+	m.elem = q.ready.PushBack(m)
+	q.msgs[id] = m
+	q.notify()
+	return id, nil
+}
+
+// Receive waits for the next visible message and leases it for the
+// visibility timeout. The caller must Ack or Nack it.
+func (q *Queue) Receive(ctx context.Context) (Message, error) {
+	return q.receive(ctx, false)
+}
+
+// errDrained ends Drain's workers once the queue is empty
+var errDrained = errors.New("walq: drained")
+
+func (q *Queue) receive(ctx context.Context, drain bool) (Message, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return Message{}, err
+		}
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return Message{}, ErrClosed
+		}
+		msg, ok, err := q.take()
+		if ok || err != nil {
+			q.mu.Unlock()
+			return msg, err
+		}
+		if drain && len(q.leased) == 0 {
+			q.mu.Unlock()
+			return Message{}, errDrained
+		}
+		changed := q.changed
+		var timer clock.Timer
+		var expiry <-chan time.Time
+		if next := q.nextExpiry(); !next.IsZero() {
+			timer = q.clk.NewTimer(next.Sub(q.clk.Now()))
+			expiry = timer.C()
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-expiry:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// take leases the next visible message, after returning expired leases
+// to the queue. It must be called with mu held.
+func (q *Queue) take() (Message, bool, error) {
+	now := q.clk.Now()
+	for _, m := range q.expired(now) {
+		delete(q.leased, m.id)
+		if err := q.retry(m); err != nil {
+			return Message{}, false, err
+		}
+	}
+	front := q.ready.Front()
+	if front == nil {
+		return Message{}, false, nil
+	}
+	m := front.Value.(*message)
+	if err := q.write(record{typ: recLease, id: m.id}); err != nil {
+		return Message{}, false, err
+	}
+	q.ready.Remove(front)
+	m.elem = nil
+	m.attempts++
+	m.leasedUntil = now.Add(q.opts.Visibility)
+	q.leased[m.id] = m
+	return Message{ID: m.id, Payload: m.payload, Attempts: m.attempts}, true, nil
+}
+
+// expired returns the leases that ran out, oldest first
+func (q *Queue) expired(now time.Time) []*message {
+	var out []*message
+	for _, m := range q.leased {
+		if !m.leasedUntil.After(now) {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].id < out[b].id })
+	return out
+}
+
+func (q *Queue) nextExpiry() time.Time {
+	var next time.Time
+	for _, m := range q.leased {
+		if next.IsZero() || m.leasedUntil.Before(next) {
+			next = m.leasedUntil
+		}
+	}
+	return next
+}
+
+// retry makes a message that was not acked visible again, or dead if it
+// used up its attempts. It must be called with mu held.
+func (q *Queue) retry(m *message) error {
+	if m.attempts >= q.opts.MaxAttempts {
+		if err := q.write(record{typ: recDead, id: m.id}); err != nil {
+			return err
+		}
+		m.dead = true
+		q.dead++
+	} else {
+		m.elem = q.ready.PushBack(m)
+	}
+	q.notify()
+	return nil
+}
+
+// Ack removes a message once it is processed. m must be the delivery the
+// caller holds the lease of, or a dead letter; Ack returns ErrNotFound if
+// the lease expired and the message was made visible or delivered again.
+func (q *Queue) Ack(m Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	msg, ok := q.msgs[m.ID]
+	if !ok || msg.attempts != m.Attempts || (!msg.dead && q.leased[m.ID] == nil) {
+		return ErrNotFound
+	}
+	if err := q.write(record{typ: recAck, id: m.ID}); err != nil {
+		return err
+	}
+	delete(q.msgs, m.ID)
+	delete(q.leased, m.ID)
+	if msg.dead {
+		q.dead--
+	}
+	q.notify()
+	return nil
+}
+
+// Nack gives up a leased message so it is delivered again, or moves it to
+// the dead-letter queue if it used up its attempts. Like Ack, it returns
+// ErrNotFound if the caller's lease is gone.
+func (q *Queue) Nack(m Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	msg, ok := q.leased[m.ID]
+	if !ok || msg.attempts != m.Attempts {
+		return ErrNotFound
+	}
+	delete(q.leased, m.ID)
+	return q.retry(msg)
+}
+
+// DeadLetters returns the messages in the dead-letter queue, by ID
+func (q *Queue) DeadLetters() []Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var out []Message
+	for _, m := range q.msgs {
+		if m.dead {
+			out = append(out, Message{ID: m.id, Payload: m.payload, Attempts: m.attempts})
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].ID < out[b].ID })
+	return out
+}
+
+// Stats returns a snapshot of the queue
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return Stats{
+		Pending:  q.ready.Len(),
+		InFlight: len(q.leased),
+		Dead:     q.dead,
+		Segments: len(q.segs),
+		LogBytes: q.bytes,
+	}
+}
+
+// Compact rewrites the log into a single segment holding only the live
+// messages, then deletes the old segments. A crash at any point leaves
+// either the old log or the new one.
+func (q *Queue) Compact() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	if q.err != nil {
+		return q.err
+	}
+
+	ids := make([]uint64, 0, len(q.msgs))
+	for id := range q.msgs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	buf := record{typ: recReset, id: q.nextID}.appendTo(nil)
+	for _, id := range ids {
+		m := q.msgs[id]
+		typ := recEnqueue
+		if m.dead {
+			typ = recDeadLetter
+		}
+		buf = record{typ: typ, id: id, attempts: uint32(m.attempts), payload: m.payload}.appendTo(buf)
+	}
+
+	idx := q.segs[len(q.segs)-1] + 1
+	path := filepath.Join(q.dir, segmentName(idx))
+	if err := writeFileSync(path+".tmp", buf); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if err := syncDir(q.dir); err != nil {
+		return err
+	}
+
+	// From here on the new segment is the log
+	if err := q.active.Close(); err != nil {
+		q.err = err
+		return err
+	}
+	for _, old := range q.segs {
+		if err := os.Remove(filepath.Join(q.dir, segmentName(old))); err != nil {
+			q.err = err
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		q.err = err
+		return err
+	}
+	q.active, q.segs = f, []uint64{idx}
+	q.size, q.bytes, q.dirty = int64(len(buf)), int64(len(buf)), false
+	return syncDir(q.dir)
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close flushes the log and closes the queue. Leased messages that were
+// not acked are delivered again after Open.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.notify()
+	q.mu.Unlock()
+
+	if q.stopSync != nil {
+		close(q.stopSync)
+		<-q.syncDone
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	err := q.err
+	if err == nil {
+		err = q.flush()
+	}
+	if cerr := q.active.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Serve runs workers goroutines that receive messages and pass them to
+// fn. A message is acked when fn returns nil and nacked when it returns
+// an error or panics. Serve returns when ctx is cancelled or the queue is
+// closed, or with the first error of the queue itself.
+func Serve(ctx context.Context, q *Queue, workers int, fn func(context.Context, Message) error) error {
+	return serve(ctx, q, workers, fn, false)
+}
+
+// Drain is Serve that returns once no message is pending or in flight,
+// for example to finish the work left behind by a crashed run
+func Drain(ctx context.Context, q *Queue, workers int, fn func(context.Context, Message) error) error {
+	return serve(ctx, q, workers, fn, true)
+}
+
+func serve(ctx context.Context, q *Queue, workers int, fn func(context.Context, Message) error, drain bool) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	call := func(m Message) (struct{}, error) { return struct{}{}, fn(ctx, m) }
+
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		err     error
+	)
+	fail := func(e error) {
+		errOnce.Do(func() { err = e })
+		cancel()
+	}
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				m, rerr := q.receive(ctx, drain)
+				if rerr != nil {
+					if rerr != errDrained && rerr != ErrClosed && ctx.Err() == nil {
+						fail(rerr)
+					}
+					return
+				}
+				if _, ferr := safe.Call(call, m); ferr == nil {
+					rerr = q.Ack(m)
+				} else {
+					rerr = q.Nack(m)
+				}
+				// The lease may have expired and the message moved on to
+				// another worker
+				if rerr != nil && rerr != ErrNotFound && rerr != ErrClosed {
+					fail(rerr)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	return parent.Err()
+}
@@ -0,0 +1,460 @@
+package walq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+	"github.com/go-concurrency-lesson/internal/leaktest"
+)
+
+func open(t *testing.T, dir string, opts Options) *Queue {
+	t.Helper()
+	q, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	return q
+}
+
+func enqueue(t *testing.T, q *Queue, payloads ...string) {
+	t.Helper()
+	for _, p := range payloads {
+		if _, err := q.Enqueue([]byte(p)); err != nil {
+			t.Fatalf("Enqueue(%s) error: %v", p, err)
+		}
+	}
+}
+
+func receive(t *testing.T, q *Queue) Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m, err := q.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive() error: %v", err)
+	}
+	return m
+}
+
+// tryReceive returns the next visible message without waiting
+func tryReceive(q *Queue) (Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m, ok, _ := q.take()
+	return m, ok
+}
+
+// state describes the durable state: every message with its attempts
+// and whether it is dead
+func state(q *Queue) map[uint64]string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make(map[uint64]string, len(q.msgs))
+	for id, m := range q.msgs {
+		out[id] = fmt.Sprintf("%s/%d/%v", m.payload, m.attempts, m.dead)
+	}
+	return out
+}
+
+func TestQueue(t *testing.T) {
+	leaktest.Check(t)
+	q := open(t, t.TempDir(), Options{})
+	defer q.Close()
+	enqueue(t, q, "a", "b", "c")
+
+	m := receive(t, q)
+	if string(m.Payload) != "a" || m.Attempts != 1 {
+		t.Errorf("Receive() = %s (attempt %d), want a (attempt 1)", m.Payload, m.Attempts)
+	}
+	if err := q.Ack(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(m); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Ack() = %v, want ErrNotFound", err)
+	}
+	b := receive(t, q)
+	if err := q.Nack(b); err != nil {
+		t.Fatal(err)
+	}
+	// b goes to the back of the queue
+	if m := receive(t, q); string(m.Payload) != "c" {
+		t.Errorf("Receive() after Nack = %s, want c", m.Payload)
+	}
+	if m := receive(t, q); string(m.Payload) != "b" || m.Attempts != 2 {
+		t.Errorf("Receive() = %s (attempt %d), want b (attempt 2)", m.Payload, m.Attempts)
+	}
+	if st := q.Stats(); st.Pending != 0 || st.InFlight != 2 || st.Segments != 1 {
+		t.Errorf("Stats() = %+v", st)
+	}
+
+	// Receive waits for an Enqueue
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Enqueue([]byte("d"))
+	}()
+	if m := receive(t, q); string(m.Payload) != "d" {
+		t.Errorf("Receive() = %s, want d", m.Payload)
+	}
+
+	q.Close()
+	if _, err := q.Enqueue(nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Enqueue() after Close = %v, want ErrClosed", err)
+	}
+	if _, err := q.Receive(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Receive() after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, Options{})
+	enqueue(t, q, "a", "b", "c", "d")
+	q.Ack(receive(t, q))
+	receive(t, q) // b is in flight when the process "dies"
+	want := state(q)
+	q.Close()
+
+	q = open(t, dir, Options{})
+	defer q.Close()
+	if got := state(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("state after reopen = %v, want %v", got, want)
+	}
+	// The unacked message is delivered again, in order
+	if m := receive(t, q); string(m.Payload) != "b" || m.Attempts != 2 {
+		t.Errorf("Receive() after reopen = %s (attempt %d), want b (attempt 2)", m.Payload, m.Attempts)
+	}
+	if id, _ := q.Enqueue([]byte("e")); id != 5 {
+		t.Errorf("Enqueue() after reopen got ID %d, want 5", id)
+	}
+}
+
+func TestVisibilityTimeout(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	q := open(t, t.TempDir(), Options{Visibility: time.Minute, Clock: fake})
+	defer q.Close()
+	enqueue(t, q, "a")
+	first := receive(t, q)
+
+	got := make(chan Message)
+	go func() {
+		m, _ := q.Receive(context.Background())
+		got <- m
+	}()
+	fake.BlockUntil(1)
+	select {
+	case m := <-got:
+		t.Fatalf("Receive() = %s while the lease is held", m.Payload)
+	case <-time.After(10 * time.Millisecond):
+	}
+	fake.Advance(time.Minute)
+	m := <-got
+	if m.ID != first.ID || m.Attempts != 2 {
+		t.Errorf("Receive() after the lease expired = %d (attempt %d), want %d (attempt 2)", m.ID, m.Attempts, first.ID)
+	}
+	// The first worker's lease is gone, the second one's is not
+	if err := q.Ack(first); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Ack() of the expired lease = %v, want ErrNotFound", err)
+	}
+	if err := q.Nack(first); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Nack() of the expired lease = %v, want ErrNotFound", err)
+	}
+	if st := q.Stats(); st.InFlight != 1 {
+		t.Errorf("Stats() after stale Ack and Nack = %+v, want the message in flight", st)
+	}
+	if err := q.Ack(m); err != nil {
+		t.Errorf("Ack() = %v", err)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, Options{MaxAttempts: 2})
+	enqueue(t, q, "poison", "ok")
+	for i := 0; i < 2; i++ {
+		m := receive(t, q)
+		if string(m.Payload) != "poison" {
+			q.Ack(m)
+			m = receive(t, q)
+		}
+		q.Nack(m)
+	}
+	dead := q.DeadLetters()
+	if len(dead) != 1 || string(dead[0].Payload) != "poison" || dead[0].Attempts != 2 {
+		t.Fatalf("DeadLetters() = %v, want poison after 2 attempts", dead)
+	}
+	if st := q.Stats(); st.Pending != 0 || st.Dead != 1 {
+		t.Errorf("Stats() = %+v", st)
+	}
+	q.Close()
+
+	q = open(t, dir, Options{MaxAttempts: 2})
+	defer q.Close()
+	if got := q.DeadLetters(); !reflect.DeepEqual(got, dead) {
+		t.Errorf("DeadLetters() after reopen = %v, want %v", got, dead)
+	}
+	if err := q.Ack(dead[0]); err != nil || len(q.DeadLetters()) != 0 || q.Stats().Dead != 0 {
+		t.Errorf("Ack() of a dead letter = %v, left %v", err, q.DeadLetters())
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, Options{SegmentSize: 256, MaxAttempts: 1})
+	for i := 0; i < 50; i++ {
+		enqueue(t, q, strconv.Itoa(i))
+	}
+	for i := 0; i < 45; i++ {
+		m := receive(t, q)
+		if i == 0 {
+			q.Nack(m) // dead after one attempt
+		} else {
+			q.Ack(m)
+		}
+	}
+	before := q.Stats()
+	if before.Segments < 3 {
+		t.Fatalf("Stats() = %+v, want several segments", before)
+	}
+	want := state(q)
+	if err := q.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after := q.Stats()
+	if after.Segments != 1 || after.LogBytes >= before.LogBytes/4 {
+		t.Errorf("Stats() after Compact = %+v, before %+v", after, before)
+	}
+	enqueue(t, q, "new")
+	q.Close()
+
+	q = open(t, dir, Options{})
+	defer q.Close()
+	want[51] = "new/0/false"
+	if got := state(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("state after Compact and reopen = %v, want %v", got, want)
+	}
+}
+
+func TestCompactCrash(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, Options{})
+	enqueue(t, q, "a", "b", "c")
+	q.Ack(receive(t, q))
+	want := state(q)
+	q.Close()
+	old, _ := segments(dir)
+
+	// Crash while writing the compacted segment: the temp file is ignored
+	os.WriteFile(filepath.Join(dir, segmentName(old[0]+1)+".tmp"), []byte("partial"), 0o644)
+	q = open(t, dir, Options{})
+	if got := state(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("state with a leftover temp file = %v, want %v", got, want)
+	}
+	// Crash after the rename, before the old segments are removed: the
+	// compacted segment resets the state, so nothing is applied twice
+	var oldData [][]byte
+	for _, idx := range old {
+		data, _ := os.ReadFile(filepath.Join(dir, segmentName(idx)))
+		oldData = append(oldData, data)
+	}
+	if err := q.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+	for i, idx := range old {
+		os.WriteFile(filepath.Join(dir, segmentName(idx)), oldData[i], 0o644)
+	}
+
+	q = open(t, dir, Options{})
+	defer q.Close()
+	if got := state(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("state with old and compacted segments = %v, want %v", got, want)
+	}
+	if id, _ := q.Enqueue(nil); id != 4 {
+		t.Errorf("Enqueue() got ID %d, want 4", id)
+	}
+}
+
+// TestCrashRecovery runs random operations, then simulates a crash at
+// random offsets of the log by cutting it there, and checks that the
+// reopened queue holds the state after the last complete operation.
+func TestCrashRecovery(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprint("seed ", seed), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(seed))
+			dir := t.TempDir()
+			opts := Options{SegmentSize: 512, MaxAttempts: 3}
+			q := open(t, dir, opts)
+
+			type snapshot struct {
+				seg   uint64
+				size  int64
+				state map[uint64]string
+			}
+			snap := func() snapshot {
+				return snapshot{q.segs[len(q.segs)-1], q.size, state(q)}
+			}
+			snaps := []snapshot{snap()}
+			var leased []Message
+			for i := 0; i < 200; i++ {
+				switch r := rng.Intn(10); {
+				case r < 4:
+					q.Enqueue([]byte(fmt.Sprint("job ", i)))
+				case r < 7:
+					if m, ok := tryReceive(q); ok {
+						leased = append(leased, m)
+					}
+				case len(leased) > 0:
+					j := rng.Intn(len(leased))
+					if r < 9 {
+						q.Ack(leased[j])
+					} else {
+						q.Nack(leased[j])
+					}
+					leased = append(leased[:j], leased[j+1:]...)
+				}
+				if s := snap(); s.seg != snaps[len(snaps)-1].seg || s.size != snaps[len(snaps)-1].size {
+					snaps = append(snaps, s)
+				}
+			}
+			q.Close()
+
+			for trial := 0; trial < 20; trial++ {
+				k := rng.Intn(len(snaps) - 1)
+				s, next := snaps[k], snaps[k+1]
+				// Cut into the record written after snapshot k
+				cut := s.size
+				if next.seg == s.seg {
+					cut += rng.Int63n(next.size - s.size)
+				}
+				crashed := t.TempDir()
+				segs, _ := segments(dir)
+				for _, idx := range segs {
+					if idx > s.seg {
+						continue
+					}
+					data, _ := os.ReadFile(filepath.Join(dir, segmentName(idx)))
+					if idx == s.seg {
+						data = data[:cut]
+					}
+					os.WriteFile(filepath.Join(crashed, segmentName(idx)), data, 0o644)
+				}
+
+				rq := open(t, crashed, opts)
+				if got := state(rq); !reflect.DeepEqual(got, s.state) {
+					t.Fatalf("crash at segment %d offset %d: state = %v, want %v", s.seg, cut, got, s.state)
+				}
+				// The torn tail is gone, so the log takes new writes
+				id, err := rq.Enqueue([]byte("after"))
+				rq.Close()
+				rq = open(t, crashed, opts)
+				if err != nil || state(rq)[id] != "after/0/false" {
+					t.Errorf("Enqueue() after recovery = %v, state %v", err, state(rq))
+				}
+				rq.Close()
+			}
+		})
+	}
+}
+
+func TestCorruptSegment(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, Options{SegmentSize: 64})
+	enqueue(t, q, "a", "b", "c")
+	q.Close()
+	segs, _ := segments(dir)
+	path := filepath.Join(dir, segmentName(segs[0]))
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+	if _, err := Open(dir, Options{}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open() of a damaged middle segment = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestDrainResume(t *testing.T) {
+	leaktest.Check(t)
+	dir := t.TempDir()
+	opts := Options{Sync: SyncInterval, MaxAttempts: 3}
+	q := open(t, dir, opts)
+	for i := 0; i < 40; i++ {
+		enqueue(t, q, strconv.Itoa(i))
+	}
+
+	var mu sync.Mutex
+	done := map[int]int{}
+	process := func(_ context.Context, m Message) error {
+		n, _ := strconv.Atoi(string(m.Payload))
+		switch {
+		case n == 7:
+			panic("poison")
+		case n%10 == 3 && m.Attempts == 1:
+			return errors.New("transient")
+		}
+		mu.Lock()
+		done[n]++
+		mu.Unlock()
+		return nil
+	}
+
+	// The first run is killed halfway
+	ctx, cancel := context.WithCancel(context.Background())
+	err := Serve(ctx, q, 3, func(ctx context.Context, m Message) error {
+		mu.Lock()
+		n := len(done)
+		mu.Unlock()
+		if n >= 20 {
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return process(ctx, m)
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Serve() = %v, want context.Canceled", err)
+	}
+	q.Close()
+
+	q = open(t, dir, opts)
+	defer q.Close()
+	if err := Drain(context.Background(), q, 3, process); err != nil {
+		t.Fatalf("Drain() = %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		if i != 7 && done[i] != 1 {
+			t.Errorf("job %d processed %d times, want once", i, done[i])
+		}
+	}
+	if st := q.Stats(); st.Pending != 0 || st.InFlight != 0 || st.Dead != 1 {
+		t.Errorf("Stats() after Drain = %+v, want only the poison job, dead", st)
+	}
+	if dead := q.DeadLetters(); len(dead) != 1 || string(dead[0].Payload) != "7" {
+		t.Errorf("DeadLetters() = %v, want job 7", dead)
+	}
+}
+
+func BenchmarkEnqueue(b *testing.B) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		b.Run(policy.String(), func(b *testing.B) {
+			q, err := Open(b.TempDir(), Options{Sync: policy})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer q.Close()
+			payload := make([]byte, 100)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				q.Enqueue(payload)
+			}
+		})
+	}
+}
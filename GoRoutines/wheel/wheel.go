@@ -0,0 +1,373 @@
+// Package wheel schedules delayed and recurring tasks on a hierarchical
+// timer wheel, for services holding far more timeouts than it is worth
+// giving each its own time.Timer.
+//
+// Time is cut into ticks. Level 0 of the wheel has one slot per tick for
+// the next 64 ticks, level 1 one slot per 64 ticks, and so on; a task
+// sits in the coarsest slot that tells it apart from now, and moves down
+// a level whenever time enters its slot. Adding and cancelling a task is
+// O(1), and the scheduler holds a single clock timer, set for the next
+// slot with work, so it runs unchanged on the fake clock.
+package wheel
+
+import (
+	"container/list"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+const (
+	slotBits = 6
+	slots    = 1 << slotBits
+	slotMask = slots - 1
+	// levels covers every uint64 tick
+	levels = (64 + slotBits - 1) / slotBits
+)
+
+// Options configure a Scheduler
+type Options struct {
+	// Tick is the resolution: a task runs within one tick after it is due
+	// [1ms]
+	Tick time.Duration
+	// Clock drives the scheduler [real time]
+	Clock clock.Clock
+}
+
+// Scheduler runs tasks at their time. Task functions run on the clock's
+// timer goroutine and must not block; start a goroutine for long work. A
+// Scheduler is safe for concurrent use.
+type Scheduler struct {
+	tick  time.Duration
+	clk   clock.Clock
+	start time.Time
+
+	mu       sync.Mutex
+	now      uint64 // last tick processed
+	wheel    [levels][slots]list.List
+	occupied [levels]uint64 // bit i is set if slot i holds tasks
+	count    int
+	timer    clock.Timer
+	armedAt  uint64 // tick the timer is set for, 0 if it is not armed
+	stopped  bool
+}
+
+type kind int
+
+const (
+	once kind = iota
+	fixedRate
+	fixedDelay
+	cron
+)
+
+// Task is a scheduled task
+type Task struct {
+	s      *Scheduler
+	fn     func()
+	kind   kind
+	period time.Duration
+	cron   *Cron
+	due    uint64 // tick
+	at     time.Time
+
+	// guarded by s.mu
+	elem         *list.Element // nil unless in the wheel
+	level, index uint64
+	cancelled    bool
+}
+
+// New starts a scheduler
+func New(opts Options) *Scheduler {
+	if opts.Tick <= 0 {
+		opts.Tick = time.Millisecond
+	}
+	clk := clock.Or(opts.Clock)
+	return &Scheduler{tick: opts.Tick, clk: clk, start: clk.Now()}
+}
+
+// After runs fn once, d from now
+func (s *Scheduler) After(d time.Duration, fn func()) *Task {
+	return s.schedule(&Task{s: s, fn: fn, kind: once}, s.clk.Now().Add(d))
+}
+
+// At runs fn once at t
+func (s *Scheduler) At(t time.Time, fn func()) *Task {
+	return s.schedule(&Task{s: s, fn: fn, kind: once}, t)
+}
+
+// Every runs fn every period at a fixed rate: the runs are due at
+// now+period, now+2×period and so on, however long fn takes. Runs that
+// fall behind are caught up one tick apart.
+func (s *Scheduler) Every(period time.Duration, fn func()) *Task {
+	period = max(period, s.tick)
+	return s.schedule(&Task{s: s, fn: fn, kind: fixedRate, period: period}, s.clk.Now().Add(period))
+}
+
+// EveryDelay runs fn repeatedly with a fixed delay: each run is due delay
+// after the previous one finished
+func (s *Scheduler) EveryDelay(delay time.Duration, fn func()) *Task {
+	delay = max(delay, s.tick)
+	return s.schedule(&Task{s: s, fn: fn, kind: fixedDelay, period: delay}, s.clk.Now().Add(delay))
+}
+
+// Cron runs fn at the times matched by a cron expression, see ParseCron.
+// The times are in the location of the scheduler's clock.
+func (s *Scheduler) Cron(expr string, fn func()) (*Task, error) {
+	c, err := ParseCron(expr)
+	if err != nil {
+		return nil, err
+	}
+	next := c.Next(s.clk.Now())
+	t := &Task{s: s, fn: fn, kind: cron, cron: c}
+	if next.IsZero() {
+		t.cancelled = true
+		return t, nil
+	}
+	return s.schedule(t, next), nil
+}
+
+// Cancel stops the task; a running recurring task finishes its current
+// run. It reports whether the task was still scheduled.
+func (t *Task) Cancel() bool {
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.cancelled {
+		return false
+	}
+	t.cancelled = true
+	if t.elem != nil {
+		s.unlink(t)
+	}
+	return true
+}
+
+// Len returns the number of scheduled tasks
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Stop cancels every task and releases the clock timer. Tasks scheduled
+// afterwards never run.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	for l := range s.wheel {
+		for i := range s.wheel[l] {
+			for e := s.wheel[l][i].Front(); e != nil; e = e.Next() {
+				e.Value.(*Task).cancelled = true
+			}
+			s.wheel[l][i].Init()
+		}
+		s.occupied[l] = 0
+	}
+	s.count = 0
+	if s.timer != nil {
+		s.timer.Stop()
+		s.armedAt = 0
+	}
+}
+
+func (s *Scheduler) schedule(t *Task, at time.Time) *Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		t.cancelled = true
+		return t
+	}
+	s.insert(t, at)
+	if s.armedAt == 0 || t.due < s.armedAt {
+		s.arm()
+	}
+	return t
+}
+
+// insert puts t in the wheel for time at; s.mu is held
+func (s *Scheduler) insert(t *Task, at time.Time) {
+	t.at = at
+	t.due = s.now + 1 // the current tick is already processed
+	if d := at.Sub(s.start); d > 0 {
+		// Round up: a task never runs early
+		if due := uint64((d + s.tick - 1) / s.tick); due > t.due {
+			t.due = due
+		}
+	}
+	s.link(t)
+	s.count++
+}
+
+// link puts t in the slot for t.due; s.mu is held
+func (s *Scheduler) link(t *Task) {
+	l := 0
+	for l < levels-1 && (t.due^s.now)>>(slotBits*(l+1)) != 0 {
+		l++
+	}
+	i := (t.due >> (slotBits * l)) & slotMask
+	t.level, t.index = uint64(l), i
+	t.elem = s.wheel[l][i].PushBack(t)
+	s.occupied[l] |= 1 << i
+}
+
+// unlink removes t from its slot; s.mu is held
+func (s *Scheduler) unlink(t *Task) {
+	slot := &s.wheel[t.level][t.index]
+	slot.Remove(t.elem)
+	if slot.Len() == 0 {
+		s.occupied[t.level] &^= 1 << t.index
+	}
+	t.elem = nil
+	s.count--
+}
+
+// next returns the next tick after now at which a slot with tasks is
+// reached, and false if the wheel is empty. s.mu is held.
+func (s *Scheduler) next() (uint64, bool) {
+	for l := 0; l < levels; l++ {
+		shift := slotBits * l
+		cur := (s.now >> shift) & slotMask
+		ahead := s.occupied[l] &^ (1<<(cur+1) - 1)
+		if ahead == 0 {
+			continue
+		}
+		i := uint64(bits.TrailingZeros64(ahead))
+		// Shifting by 64 or more gives 0, which is right for the top level
+		base := s.now >> (shift + slotBits) << (shift + slotBits)
+		return base | i<<shift, true
+	}
+	return 0, false
+}
+
+// advance processes every tick up to to and returns the tasks that are
+// due, skipping ticks where nothing happens. s.mu is held.
+func (s *Scheduler) advance(to uint64) []*Task {
+	var due []*Task
+	for s.now < to {
+		n, ok := s.next()
+		if !ok || n > to {
+			s.now = to
+			break
+		}
+		s.now = n
+		// Entering a coarse slot moves its tasks down a level, coarsest
+		// first so they can cascade all the way to level 0
+		for l := levels - 1; l > 0; l-- {
+			if n&(1<<(slotBits*l)-1) != 0 {
+				continue
+			}
+			i := (n >> (slotBits * l)) & slotMask
+			if s.occupied[l]&(1<<i) == 0 {
+				continue
+			}
+			slot := &s.wheel[l][i]
+			s.occupied[l] &^= 1 << i
+			for e := slot.Front(); e != nil; e = slot.Front() {
+				t := slot.Remove(e).(*Task)
+				s.link(t)
+			}
+		}
+		i := n & slotMask
+		if s.occupied[0]&(1<<i) == 0 {
+			continue
+		}
+		slot := &s.wheel[0][i]
+		s.occupied[0] &^= 1 << i
+		for e := slot.Front(); e != nil; e = slot.Front() {
+			t := slot.Remove(e).(*Task)
+			t.elem = nil
+			s.count--
+			due = append(due, t)
+		}
+	}
+	return due
+}
+
+// arm sets the clock timer for the next slot with tasks; s.mu is held
+func (s *Scheduler) arm() {
+	n, ok := s.next()
+	if !ok {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.armedAt = 0
+		return
+	}
+	s.armedAt = n
+	d := s.start.Add(time.Duration(n) * s.tick).Sub(s.clk.Now())
+	if s.timer == nil {
+		s.timer = s.clk.AfterFunc(d, s.fire)
+	} else {
+		s.timer.Reset(d)
+	}
+}
+
+// fire runs on the clock timer: it advances the wheel to the current
+// time and runs the tasks that are due
+func (s *Scheduler) fire() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	now := s.clk.Now()
+	to := s.now
+	if d := now.Sub(s.start); d > 0 {
+		to = max(to, uint64(d/s.tick))
+	}
+	due := s.advance(to)
+	s.armedAt = 0
+	s.mu.Unlock()
+
+	for _, t := range due {
+		s.run(t)
+	}
+
+	s.mu.Lock()
+	if !s.stopped && s.armedAt == 0 {
+		s.arm()
+	}
+	s.mu.Unlock()
+}
+
+func (s *Scheduler) run(t *Task) {
+	s.mu.Lock()
+	if t.cancelled {
+		s.mu.Unlock()
+		return
+	}
+	if t.kind == once {
+		t.cancelled = true // done: Cancel reports false
+	}
+	s.mu.Unlock()
+
+	t.fn()
+
+	var next time.Time
+	switch t.kind {
+	case once:
+		return
+	case fixedRate:
+		next = t.at.Add(t.period)
+	case fixedDelay:
+		next = s.clk.Now().Add(t.period)
+	case cron:
+		if next = t.cron.Next(t.at); next.IsZero() {
+			t.Cancel()
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.cancelled || s.stopped {
+		return
+	}
+	s.insert(t, next)
+	if s.armedAt != 0 && t.due < s.armedAt {
+		s.arm()
+	}
+}
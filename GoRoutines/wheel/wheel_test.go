@@ -0,0 +1,332 @@
+package wheel
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// recorder collects the fake time of every run, per task name
+type recorder struct {
+	clk *clock.Fake
+	mu  sync.Mutex
+	got map[string][]time.Duration
+}
+
+func newRecorder(clk *clock.Fake) *recorder {
+	return &recorder{clk: clk, got: map[string][]time.Duration{}}
+}
+
+func (r *recorder) fn(name string) func() {
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.got[name] = append(r.got[name], r.clk.Now().Sub(epoch))
+	}
+}
+
+func (r *recorder) runs(name string) []time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]time.Duration(nil), r.got[name]...)
+}
+
+func TestAfter(t *testing.T) {
+	fake := clock.NewFake(epoch)
+	s := New(Options{Clock: fake})
+	defer s.Stop()
+	rec := newRecorder(fake)
+	for _, d := range []time.Duration{
+		5 * time.Millisecond,
+		63 * time.Millisecond,
+		64 * time.Millisecond,
+		time.Second,
+		90 * time.Minute,
+		400 * 24 * time.Hour,
+	} {
+		s.After(d, rec.fn("once"))
+	}
+	s.After(1500*time.Microsecond, rec.fn("rounded up"))
+	s.After(-time.Second, rec.fn("past"))
+
+	fake.Advance(500 * 24 * time.Hour)
+	want := []time.Duration{5 * time.Millisecond, 63 * time.Millisecond, 64 * time.Millisecond, time.Second, 90 * time.Minute, 400 * 24 * time.Hour}
+	if got := rec.runs("once"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("runs = %v, want %v", got, want)
+	}
+	if got := rec.runs("rounded up"); len(got) != 1 || got[0] != 2*time.Millisecond {
+		t.Errorf("1.5 ticks ran at %v, want 2ms", got)
+	}
+	if got := rec.runs("past"); len(got) != 1 || got[0] != time.Millisecond {
+		t.Errorf("past deadline ran at %v, want the next tick", got)
+	}
+	if s.Len() != 0 || fake.Waiters() != 0 {
+		t.Errorf("Len() = %d, clock waiters = %d after every task ran", s.Len(), fake.Waiters())
+	}
+}
+
+func TestRandomDeadlines(t *testing.T) {
+	fake := clock.NewFake(epoch)
+	s := New(Options{Clock: fake})
+	defer s.Stop()
+	rng := rand.New(rand.NewSource(1))
+
+	var mu sync.Mutex
+	late := 0
+	var order []time.Duration
+	for i := 0; i < 5000; i++ {
+		at := fake.Now().Sub(epoch) + time.Duration(rng.Int63n(int64(10*time.Hour))).Truncate(time.Millisecond)
+		s.At(epoch.Add(at), func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if fake.Now().Sub(epoch) != at {
+				late++
+			}
+			order = append(order, at)
+		})
+		// Schedule some while time moves
+		if i%500 == 0 {
+			fake.Advance(time.Duration(rng.Int63n(int64(time.Minute))).Truncate(time.Millisecond))
+		}
+	}
+	fake.Advance(11 * time.Hour)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 5000 {
+		t.Fatalf("%d tasks ran, want 5000", len(order))
+	}
+	if !sort.SliceIsSorted(order, func(i, j int) bool { return order[i] < order[j] }) {
+		t.Error("tasks ran out of deadline order")
+	}
+	if late != 0 {
+		t.Errorf("%d tasks ran off their deadline", late)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	fake := clock.NewFake(epoch)
+	s := New(Options{Clock: fake})
+	defer s.Stop()
+	rec := newRecorder(fake)
+
+	keep := s.After(time.Second, rec.fn("keep"))
+	drop := s.After(time.Hour, rec.fn("drop"))
+	if !drop.Cancel() || drop.Cancel() {
+		t.Error("Cancel() should report true once")
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", s.Len())
+	}
+	fake.Advance(2 * time.Hour)
+	if len(rec.runs("keep")) != 1 || len(rec.runs("drop")) != 0 {
+		t.Errorf("runs = %v", rec.got)
+	}
+	if keep.Cancel() {
+		t.Error("Cancel() after the task ran = true")
+	}
+
+	// A task can cancel another one due in the same tick
+	var second *Task
+	s.After(time.Second, func() { second.Cancel() })
+	second = s.After(time.Second, rec.fn("second"))
+	fake.Advance(time.Second)
+	if len(rec.runs("second")) != 0 {
+		t.Error("task cancelled by an earlier task of the same tick ran")
+	}
+}
+
+func TestEvery(t *testing.T) {
+	fake := clock.NewFake(epoch)
+	s := New(Options{Clock: fake})
+	defer s.Stop()
+	rec := newRecorder(fake)
+
+	rate := s.Every(100*time.Millisecond, rec.fn("rate"))
+	// The first run takes 250ms, so the next one is due 100ms after that
+	first := true
+	s.EveryDelay(100*time.Millisecond, func() {
+		rec.fn("delay")()
+		if first {
+			first = false
+			fake.Advance(250 * time.Millisecond)
+		}
+	})
+	fake.Advance(time.Second)
+	rate.Cancel()
+	fake.Advance(time.Second)
+
+	ms := time.Millisecond
+	wantRate := []time.Duration{100 * ms, 200 * ms, 300 * ms, 400 * ms, 500 * ms, 600 * ms, 700 * ms, 800 * ms, 900 * ms, 1000 * ms}
+	if got := rec.runs("rate"); fmt.Sprint(got) != fmt.Sprint(wantRate) {
+		t.Errorf("fixed rate runs = %v, want %v", got, wantRate)
+	}
+	if got := rec.runs("delay"); len(got) < 3 || got[0] != 100*ms || got[1] != 450*ms || got[2] != 550*ms {
+		t.Errorf("fixed delay runs = %v, want 100ms, 450ms, 550ms, ...", got)
+	}
+}
+
+func TestCronTask(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 58, 30, 0, time.UTC)
+	fake := clock.NewFake(start)
+	s := New(Options{Tick: time.Second, Clock: fake})
+	defer s.Stop()
+	var runs []time.Time
+	if _, err := s.Cron("*/30 9-10 * * fri", func() { runs = append(runs, fake.Now()) }); err != nil {
+		t.Fatal(err)
+	}
+	fake.Advance(8 * 24 * time.Hour)
+	want := []string{"2024-03-01 10:00", "2024-03-01 10:30", "2024-03-08 09:00", "2024-03-08 09:30", "2024-03-08 10:00", "2024-03-08 10:30"}
+	var got []string
+	for _, r := range runs {
+		got = append(got, r.Format("2006-01-02 15:04"))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("cron runs = %v, want %v", got, want)
+	}
+	if _, err := s.Cron("61 * * * *", func() {}); err == nil {
+		t.Error("Cron() with a bad minute: want an error")
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	from := time.Date(2024, 1, 31, 23, 59, 0, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		expr string
+		want string // next run after from, "" for none, "error" for a parse error
+	}{
+		{"* * * * *", "2024-02-01 00:00"},
+		{"@hourly", "2024-02-01 00:00"},
+		{"@weekly", "2024-02-04 00:00"},
+		{"@yearly", "2025-01-01 00:00"},
+		{"15 8 * * *", "2024-02-01 08:15"},
+		{"0 0 29 2 *", "2024-02-29 00:00"},
+		{"0 0 30 2 *", ""},
+		{"0 12 * jan-mar sat,sun", "2024-02-03 12:00"},
+		{"0 12 * * 7", "2024-02-04 12:00"},
+		// Both day fields restricted: either matches
+		{"0 0 15 * mon", "2024-02-05 00:00"},
+		{"5/20 * * * *", "2024-02-01 00:05"},
+		{"0 0 1,15 */3 *", "2024-04-01 00:00"},
+		{"* * *", "error"},
+		{"60 * * * *", "error"},
+		{"5-1 * * * *", "error"},
+		{"*/0 * * * *", "error"},
+		{"0 0 * foo *", "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if tt.want == "error" {
+				if err == nil {
+					t.Errorf("ParseCron(%q): want an error", tt.expr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCron(%q) error: %v", tt.expr, err)
+			}
+			got := ""
+			if next := c.Next(from); !next.IsZero() {
+				got = next.Format("2006-01-02 15:04")
+			}
+			if got != tt.want {
+				t.Errorf("Next() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStop(t *testing.T) {
+	fake := clock.NewFake(epoch)
+	s := New(Options{Clock: fake})
+	rec := newRecorder(fake)
+	task := s.After(time.Second, rec.fn("a"))
+	s.Stop()
+	s.After(time.Second, rec.fn("b"))
+	fake.Advance(time.Minute)
+	if len(rec.got) != 0 || task.Cancel() || fake.Waiters() != 0 {
+		t.Errorf("after Stop: runs %v, waiters %d", rec.got, fake.Waiters())
+	}
+}
+
+func TestRealClock(t *testing.T) {
+	s := New(Options{})
+	defer s.Stop()
+	done := make(chan time.Time, 1)
+	start := time.Now()
+	s.After(20*time.Millisecond, func() { done <- time.Now() })
+	select {
+	case at := <-done:
+		if at.Sub(start) < 20*time.Millisecond {
+			t.Errorf("task ran after %v, want at least 20ms", at.Sub(start))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task did not run")
+	}
+}
+
+// The benchmarks schedule n timeouts that are cancelled before they fire,
+// the common case for request timeouts, with the wheel and with one
+// time.Timer per task
+func BenchmarkTimeouts(b *testing.B) {
+	const n = 10000
+	b.Run("wheel", func(b *testing.B) {
+		s := New(Options{})
+		defer s.Stop()
+		tasks := make([]*Task, n)
+		for i := 0; i < b.N; i++ {
+			for j := range tasks {
+				tasks[j] = s.After(time.Duration(j+1)*time.Millisecond+time.Minute, func() {})
+			}
+			for _, t := range tasks {
+				t.Cancel()
+			}
+		}
+	})
+	b.Run("time.Timer", func(b *testing.B) {
+		timers := make([]*time.Timer, n)
+		for i := 0; i < b.N; i++ {
+			for j := range timers {
+				timers[j] = time.AfterFunc(time.Duration(j+1)*time.Millisecond+time.Minute, func() {})
+			}
+			for _, t := range timers {
+				t.Stop()
+			}
+		}
+	})
+}
+
+// BenchmarkFire measures scheduling and firing n short delays
+func BenchmarkFire(b *testing.B) {
+	const n = 10000
+	b.Run("wheel", func(b *testing.B) {
+		s := New(Options{})
+		defer s.Stop()
+		for i := 0; i < b.N; i++ {
+			var wg sync.WaitGroup
+			wg.Add(n)
+			for j := 0; j < n; j++ {
+				s.After(time.Duration(j%10)*time.Millisecond, wg.Done)
+			}
+			wg.Wait()
+		}
+	})
+	b.Run("time.Timer", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var wg sync.WaitGroup
+			wg.Add(n)
+			for j := 0; j < n; j++ {
+				time.AfterFunc(time.Duration(j%10)*time.Millisecond, wg.Done)
+			}
+			wg.Wait()
+		}
+	})
+}
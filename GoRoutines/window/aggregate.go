@@ -0,0 +1,279 @@
+package window
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+// Options configure Aggregate
+type Options struct {
+	// Window assigns events to windows
+	Window Spec
+	// MaxOutOfOrder is how far the watermark trails the newest event
+	MaxOutOfOrder time.Duration
+	// AllowedLateness is how long after the watermark passes its end a
+	// window still accepts events
+	AllowedLateness time.Duration
+	// IdleTimeout lets the watermark follow the clock once no event has
+	// arrived for this long (0 = only events move the watermark)
+	IdleTimeout time.Duration
+	// Percentiles to compute for every window, each in (0, 1]
+	Percentiles []float64
+	// OnDrop is called with events that are too late for every window
+	OnDrop func(Event)
+	// Clock stamps events without a time and drives IdleTimeout [real time]
+	Clock clock.Clock
+}
+
+// Aggregate reads events from in and sends a Result for every key and
+// window once the window closes. When in is closed all open windows fire
+// and the output is closed; if ctx is cancelled the output is closed
+// without firing them.
+func Aggregate(ctx context.Context, in <-chan Event, opts Options) (<-chan Result, error) {
+	if err := opts.Window.validate(); err != nil {
+		return nil, err
+	}
+	for _, p := range opts.Percentiles {
+		if !(p > 0 && p <= 1) {
+			return nil, errors.New("window: percentiles must be in (0, 1]")
+		}
+	}
+	if opts.MaxOutOfOrder < 0 || opts.AllowedLateness < 0 || opts.IdleTimeout < 0 {
+		return nil, errors.New("window: negative duration in options")
+	}
+
+	a := &aggregator{
+		opts:  opts,
+		clock: clock.Or(opts.Clock),
+		panes: make(map[paneID]*pane),
+		out:   make(chan Result),
+	}
+	// Created here rather than in the goroutine so a fake clock sees the
+	// ticker as soon as Aggregate returns
+	var idle <-chan time.Time
+	var ticker clock.Ticker
+	if opts.IdleTimeout > 0 {
+		ticker = a.clock.NewTicker(opts.IdleTimeout)
+		idle = ticker.C()
+	}
+
+	go func() {
+		defer close(a.out)
+		if ticker != nil {
+			defer ticker.Stop()
+		}
+		for {
+			select {
+			case e, ok := <-in:
+				if !ok {
+					a.flush(ctx)
+					return
+				}
+				a.add(e)
+			case now := <-idle:
+				a.idle(now)
+			case <-ctx.Done():
+				return
+			}
+			if !a.fire(ctx, false) {
+				return
+			}
+		}
+	}()
+	return a.out, nil
+}
+
+type paneID struct {
+	key        string
+	start, end int64
+}
+
+// pane is the state of one key in one window
+type pane struct {
+	key   string
+	win   Window
+	acc   accumulator
+	fired bool // emitted at least once
+	dirty bool // changed since it was last emitted
+}
+
+type aggregator struct {
+	opts  Options
+	clock clock.Clock
+	out   chan Result
+
+	started     bool
+	maxTime     time.Time // newest event time seen
+	watermark   time.Time // never moves backwards
+	lastArrival time.Time // clock time of the last event
+
+	panes    map[paneID]*pane   // tumbling and sliding windows
+	sessions map[string][]*pane // session windows by key, ordered by start
+}
+
+// add puts e into its windows, or drops it if all of them are expired
+func (a *aggregator) add(e Event) {
+	now := a.clock.Now()
+	if e.Time.IsZero() {
+		e.Time = now
+	}
+	a.lastArrival = now
+
+	var added bool
+	if a.opts.Window.kind == session {
+		added = a.addSession(e)
+	} else {
+		for _, w := range a.opts.Window.windows(e.Time) {
+			if a.expired(w) {
+				continue
+			}
+			id := paneID{e.Key, w.Start.UnixNano(), w.End.UnixNano()}
+			p := a.panes[id]
+			if p == nil {
+				p = &pane{key: e.Key, win: w}
+				a.panes[id] = p
+			}
+			p.acc.add(e.Value, len(a.opts.Percentiles) > 0)
+			p.dirty = true
+			added = true
+		}
+	}
+	if !added && a.opts.OnDrop != nil {
+		a.opts.OnDrop(e)
+	}
+
+	if !a.started || e.Time.After(a.maxTime) {
+		a.maxTime = e.Time
+	}
+	a.started = true
+	a.advance(a.maxTime.Add(-a.opts.MaxOutOfOrder))
+}
+
+// addSession opens a session for e and merges it with every session of
+// the same key it overlaps
+func (a *aggregator) addSession(e Event) bool {
+	w := Window{Start: e.Time, End: e.Time.Add(a.opts.Window.size)}
+	if a.expired(w) {
+		return false
+	}
+	if a.sessions == nil {
+		a.sessions = make(map[string][]*pane)
+	}
+
+	merged := &pane{key: e.Key, win: w, dirty: true}
+	merged.acc.add(e.Value, len(a.opts.Percentiles) > 0)
+	var keep []*pane
+	for _, p := range a.sessions[e.Key] {
+		if !p.win.Start.Before(merged.win.End) || !merged.win.Start.Before(p.win.End) {
+			keep = append(keep, p)
+			continue
+		}
+		if p.win.Start.Before(merged.win.Start) {
+			merged.win.Start = p.win.Start
+		}
+		if p.win.End.After(merged.win.End) {
+			merged.win.End = p.win.End
+		}
+		merged.acc.merge(&p.acc)
+		merged.fired = merged.fired || p.fired
+	}
+	keep = append(keep, merged)
+	sort.Slice(keep, func(i, j int) bool { return keep[i].win.Start.Before(keep[j].win.Start) })
+	a.sessions[e.Key] = keep
+	return true
+}
+
+// idle moves the watermark along with the clock when no event arrived
+// for IdleTimeout
+func (a *aggregator) idle(now time.Time) {
+	if !a.started {
+		return
+	}
+	if quiet := now.Sub(a.lastArrival); quiet >= a.opts.IdleTimeout {
+		a.advance(a.maxTime.Add(quiet - a.opts.MaxOutOfOrder))
+	}
+}
+
+func (a *aggregator) advance(wm time.Time) {
+	if wm.After(a.watermark) || a.watermark.IsZero() {
+		a.watermark = wm
+	}
+}
+
+// expired reports whether w no longer accepts events
+func (a *aggregator) expired(w Window) bool {
+	return a.started && !w.End.Add(a.opts.AllowedLateness).After(a.watermark)
+}
+
+// fire emits every changed window the watermark has passed and forgets
+// expired ones; all fires every changed window. It returns false if ctx
+// was cancelled while sending.
+func (a *aggregator) fire(ctx context.Context, all bool) bool {
+	var ready []*pane
+	closed := func(p *pane) bool {
+		return p.dirty && (all || !p.win.End.After(a.watermark))
+	}
+	for id, p := range a.panes {
+		if closed(p) {
+			ready = append(ready, p)
+		}
+		if a.expired(p.win) {
+			delete(a.panes, id)
+		}
+	}
+	for key, ps := range a.sessions {
+		keep := ps[:0]
+		for _, p := range ps {
+			if closed(p) {
+				ready = append(ready, p)
+			}
+			if !a.expired(p.win) {
+				keep = append(keep, p)
+			}
+		}
+		if len(keep) == 0 {
+			delete(a.sessions, key)
+		} else {
+			a.sessions[key] = keep
+		}
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		pi, pj := ready[i], ready[j]
+		if !pi.win.End.Equal(pj.win.End) {
+			return pi.win.End.Before(pj.win.End)
+		}
+		if pi.key != pj.key {
+			return pi.key < pj.key
+		}
+		return pi.win.Start.Before(pj.win.Start)
+	})
+	for _, p := range ready {
+		r := Result{
+			Key:         p.key,
+			Window:      p.win,
+			Count:       p.acc.count,
+			Sum:         p.acc.sum,
+			Min:         p.acc.min,
+			Max:         p.acc.max,
+			Percentiles: p.acc.percentiles(a.opts.Percentiles),
+			Update:      p.fired,
+		}
+		p.fired, p.dirty = true, false
+		select {
+		case a.out <- r:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// flush fires every window that has not fired with its latest events
+func (a *aggregator) flush(ctx context.Context) {
+	a.fire(ctx, true)
+}
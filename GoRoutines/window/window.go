@@ -0,0 +1,166 @@
+// Package window aggregates a channel of timestamped values over tumbling,
+// sliding and session windows, the stream version of the fixed-duration
+// collection in MonitorChannel.
+//
+// Windows are based on event time: each Event carries its own timestamp
+// and may arrive out of order. A watermark trails the largest timestamp
+// seen by Options.MaxOutOfOrder; a window fires once the watermark passes
+// its end. Events that arrive after that, but within AllowedLateness,
+// update the window and fire it again; later events are dropped. If the
+// input goes quiet for IdleTimeout, the watermark follows the clock so
+// windows still close.
+package window
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Event is one value of a stream. Events with the same Key are windowed
+// separately. A zero Time is replaced by the clock time at arrival.
+type Event struct {
+	Key   string
+	Time  time.Time
+	Value float64
+}
+
+// Window is the half-open time interval [Start, End)
+type Window struct {
+	Start, End time.Time
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("[%s, %s)", w.Start.Format(time.RFC3339Nano), w.End.Format(time.RFC3339Nano))
+}
+
+// Result is the aggregate of one key in one window
+type Result struct {
+	Key    string
+	Window Window
+	Count  int
+	Sum    float64
+	Min    float64
+	Max    float64
+	// Percentiles holds the values of Options.Percentiles, in order
+	Percentiles []float64
+	// Update is set when late events changed a window that already fired
+	Update bool
+}
+
+// Mean returns the average value, or 0 for an empty result
+func (r Result) Mean() float64 {
+	if r.Count == 0 {
+		return 0
+	}
+	return r.Sum / float64(r.Count)
+}
+
+type kind int
+
+const (
+	tumbling kind = iota
+	sliding
+	session
+)
+
+// Spec describes how events are assigned to windows
+type Spec struct {
+	kind  kind
+	size  time.Duration
+	slide time.Duration
+}
+
+// Tumbling returns fixed, non-overlapping windows of the given size
+func Tumbling(size time.Duration) Spec {
+	return Spec{kind: tumbling, size: size, slide: size}
+}
+
+// Sliding returns windows of the given size that start every slide, so an
+// event belongs to about size/slide windows
+func Sliding(size, slide time.Duration) Spec {
+	return Spec{kind: sliding, size: size, slide: slide}
+}
+
+// Session returns windows that group events separated by less than gap.
+// A session ends gap after its last event.
+func Session(gap time.Duration) Spec {
+	return Spec{kind: session, size: gap}
+}
+
+func (s Spec) validate() error {
+	if s.size <= 0 {
+		return errors.New("window: window size must be positive")
+	}
+	if s.kind == sliding && s.slide <= 0 {
+		return errors.New("window: slide must be positive")
+	}
+	return nil
+}
+
+// windows returns the fixed windows t belongs to, oldest first. Windows
+// are aligned to multiples of the slide since the zero time.
+func (s Spec) windows(t time.Time) []Window {
+	var ws []Window
+	last := t.Truncate(s.slide)
+	for start := last.Add(-(s.size - 1).Truncate(s.slide)); !start.After(last); start = start.Add(s.slide) {
+		if end := start.Add(s.size); end.After(t) {
+			ws = append(ws, Window{Start: start, End: end})
+		}
+	}
+	return ws
+}
+
+// accumulator holds the running aggregate of one window
+type accumulator struct {
+	count    int
+	sum      float64
+	min, max float64
+	values   []float64 // kept only when percentiles are requested
+}
+
+func (a *accumulator) add(v float64, keep bool) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.count++
+	a.sum += v
+	if keep {
+		a.values = append(a.values, v)
+	}
+}
+
+func (a *accumulator) merge(b *accumulator) {
+	if b.count == 0 {
+		return
+	}
+	if a.count == 0 || b.min < a.min {
+		a.min = b.min
+	}
+	if a.count == 0 || b.max > a.max {
+		a.max = b.max
+	}
+	a.count += b.count
+	a.sum += b.sum
+	a.values = append(a.values, b.values...)
+}
+
+// percentiles returns the nearest-rank percentile of the values for each p
+func (a *accumulator) percentiles(ps []float64) []float64 {
+	if len(ps) == 0 {
+		return nil
+	}
+	sort.Float64s(a.values)
+	out := make([]float64, len(ps))
+	n := len(a.values)
+	for i, p := range ps {
+		rank := int(math.Ceil(p*float64(n))) - 1
+		out[i] = a.values[max(0, min(rank, n-1))]
+	}
+	return out
+}
@@ -0,0 +1,301 @@
+package window
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/go-concurrency-lesson/clock"
+)
+
+var base = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func at(sec int) time.Time {
+	return base.Add(time.Duration(sec) * time.Second)
+}
+
+func win(start, end int) Window {
+	return Window{Start: at(start), End: at(end)}
+}
+
+func ev(key string, sec int, v float64) Event {
+	return Event{Key: key, Time: at(sec), Value: v}
+}
+
+// run feeds events to Aggregate and collects every result and dropped event
+func run(t *testing.T, opts Options, events ...Event) ([]Result, []Event) {
+	t.Helper()
+	var dropped []Event
+	opts.OnDrop = func(e Event) { dropped = append(dropped, e) }
+	in := make(chan Event)
+	out, err := Aggregate(context.Background(), in, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer close(in)
+		for _, e := range events {
+			in <- e
+		}
+	}()
+	var results []Result
+	for r := range out {
+		results = append(results, r)
+	}
+	return results, dropped
+}
+
+// short drops the fields a test does not check
+type short struct {
+	Key    string
+	Window Window
+	Count  int
+	Sum    float64
+	Update bool
+}
+
+func shorten(rs []Result) []short {
+	out := make([]short, len(rs))
+	for i, r := range rs {
+		out[i] = short{r.Key, r.Window, r.Count, r.Sum, r.Update}
+	}
+	return out
+}
+
+func check(t *testing.T, got []Result, want []short) {
+	t.Helper()
+	if g := shorten(got); !reflect.DeepEqual(g, want) {
+		t.Errorf("results:\n got %+v\nwant %+v", g, want)
+	}
+}
+
+func TestTumbling(t *testing.T) {
+	got, dropped := run(t, Options{Window: Tumbling(10 * time.Second), MaxOutOfOrder: 2 * time.Second},
+		ev("a", 1, 1), ev("a", 3, 3), ev("b", 4, 4),
+		ev("a", 12, 12), // watermark 10 closes [0, 10)
+		ev("a", 9, 9),   // too late
+		ev("b", 11, 11), // out of order but not late
+		ev("a", 25, 25), // watermark 23 closes [10, 20)
+	)
+	check(t, got, []short{
+		{"a", win(0, 10), 2, 4, false},
+		{"b", win(0, 10), 1, 4, false},
+		{"a", win(10, 20), 1, 12, false},
+		{"b", win(10, 20), 1, 11, false},
+		{"a", win(20, 30), 1, 25, false}, // fired when the input closed
+	})
+	if len(dropped) != 1 || dropped[0].Value != 9 {
+		t.Errorf("dropped %v, want the event at 9s", dropped)
+	}
+}
+
+func TestAllowedLateness(t *testing.T) {
+	got, dropped := run(t, Options{Window: Tumbling(10 * time.Second), AllowedLateness: 5 * time.Second},
+		ev("a", 1, 1),
+		ev("a", 12, 12), // fires [0, 10)
+		ev("a", 8, 8),   // late but allowed: fires [0, 10) again
+		ev("a", 16, 16),
+		ev("a", 18, 18), // watermark 18 is past 10+5: [0, 10) is gone
+		ev("a", 2, 2),
+	)
+	check(t, got, []short{
+		{"a", win(0, 10), 1, 1, false},
+		{"a", win(0, 10), 2, 9, true},
+		{"a", win(10, 20), 3, 46, false},
+	})
+	if len(dropped) != 1 || dropped[0].Value != 2 {
+		t.Errorf("dropped %v, want the event at 2s", dropped)
+	}
+}
+
+func TestSliding(t *testing.T) {
+	got, _ := run(t, Options{Window: Sliding(10*time.Second, 5*time.Second)},
+		ev("a", 7, 1), ev("a", 11, 2), ev("a", 16, 4),
+	)
+	check(t, got, []short{
+		{"a", win(0, 10), 1, 1, false},
+		{"a", win(5, 15), 2, 3, false},
+		{"a", win(10, 20), 2, 6, false},
+		{"a", win(15, 25), 1, 4, false},
+	})
+}
+
+func TestSpecWindows(t *testing.T) {
+	tests := []struct {
+		name string
+		spec Spec
+		sec  int
+		want []Window
+	}{
+		{"tumbling", Tumbling(10 * time.Second), 17, []Window{win(10, 20)}},
+		{"tumbling on boundary", Tumbling(10 * time.Second), 20, []Window{win(20, 30)}},
+		{"sliding", Sliding(10*time.Second, 5*time.Second), 17, []Window{win(10, 20), win(15, 25)}},
+		{"sliding on boundary", Sliding(10*time.Second, 5*time.Second), 15, []Window{win(10, 20), win(15, 25)}},
+		{"sliding uneven", Sliding(10*time.Second, 3*time.Second), 9, []Window{win(0, 10), win(3, 13), win(6, 16), win(9, 19)}},
+		{"hopping with gaps", Sliding(2*time.Second, 5*time.Second), 8, nil},
+		{"hopping inside", Sliding(2*time.Second, 5*time.Second), 11, []Window{win(10, 12)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.windows(at(tt.sec)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("windows(%ds) = %v, want %v", tt.sec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSession(t *testing.T) {
+	got, _ := run(t, Options{Window: Session(5 * time.Second), MaxOutOfOrder: time.Minute},
+		ev("a", 0, 1), ev("a", 3, 1), // [0, 8)
+		ev("a", 20, 1),
+		ev("a", 12, 1),
+		ev("a", 8, 1), // 5s after 3 is not less than the gap; joins 12
+		ev("b", 1, 1),
+	)
+	check(t, got, []short{
+		{"b", win(1, 6), 1, 1, false},
+		{"a", win(0, 8), 2, 2, false},
+		{"a", win(8, 17), 2, 2, false},
+		{"a", win(20, 25), 1, 1, false},
+	})
+}
+
+func TestSessionLateMerge(t *testing.T) {
+	got, _ := run(t, Options{Window: Session(5 * time.Second), AllowedLateness: 10 * time.Second},
+		ev("a", 0, 1),
+		ev("a", 10, 10), // fires [0, 5)
+		ev("a", 4, 4),   // extends the fired session to [0, 9)
+		ev("a", 7, 7),   // bridges [0, 9) and [10, 15)
+	)
+	check(t, got, []short{
+		{"a", win(0, 5), 1, 1, false},
+		{"a", win(0, 9), 2, 5, true},
+		{"a", win(0, 15), 4, 22, true},
+	})
+}
+
+func TestAggregates(t *testing.T) {
+	events := make([]Event, 100)
+	for i := range events {
+		// 1..100 in a scrambled order
+		events[i] = ev("a", 1, float64((i*37)%100+1))
+	}
+	got, _ := run(t, Options{Window: Tumbling(time.Minute), Percentiles: []float64{0.5, 0.99, 1}}, events...)
+	if len(got) != 1 {
+		t.Fatalf("got %d results, want 1", len(got))
+	}
+	r := got[0]
+	if r.Count != 100 || r.Sum != 5050 || r.Min != 1 || r.Max != 100 || r.Mean() != 50.5 {
+		t.Errorf("count %d sum %v min %v max %v mean %v, want 100 5050 1 100 50.5", r.Count, r.Sum, r.Min, r.Max, r.Mean())
+	}
+	if want := []float64{50, 99, 100}; !reflect.DeepEqual(r.Percentiles, want) {
+		t.Errorf("percentiles = %v, want %v", r.Percentiles, want)
+	}
+}
+
+func TestIngestionTime(t *testing.T) {
+	fake := clock.NewFake(at(3))
+	got, _ := run(t, Options{Window: Tumbling(10 * time.Second), Clock: fake},
+		Event{Key: "a", Value: 1}, Event{Key: "a", Value: 2},
+	)
+	check(t, got, []short{{"a", win(0, 10), 2, 3, false}})
+}
+
+func TestIdleTimeout(t *testing.T) {
+	fake := clock.NewFake(base)
+	in := make(chan Event)
+	out, err := Aggregate(context.Background(), in, Options{
+		Window:      Tumbling(10 * time.Second),
+		IdleTimeout: time.Second,
+		Clock:       fake,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(in)
+	in <- ev("a", 0, 1)
+
+	// No more events: the window must close as the clock passes its end
+	for i := 0; i < 20; i++ {
+		fake.Advance(time.Second)
+		select {
+		case r := <-out:
+			if r.Window != win(0, 10) || r.Count != 1 {
+				t.Errorf("result %+v, want one event in [0, 10)", r)
+			}
+			if now := fake.Now(); now.Before(at(10)) {
+				t.Errorf("window fired at %v, before its end", now.Sub(base))
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Fatal("idle window never fired")
+}
+
+func TestCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan Event)
+	out, err := Aggregate(ctx, in, Options{Window: Tumbling(time.Second), IdleTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in <- ev("a", 0, 1)
+	in <- ev("a", 5, 1) // fires a window nobody reads
+	cancel()
+
+	select {
+	case _, ok := <-out:
+		for ok {
+			_, ok = <-out
+		}
+	case <-time.After(time.Second):
+		t.Fatal("output not closed after cancel")
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines leaked: %d before, %d after", before, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	tests := []Options{
+		{},
+		{Window: Tumbling(0)},
+		{Window: Sliding(time.Second, 0)},
+		{Window: Session(-time.Second)},
+		{Window: Tumbling(time.Second), Percentiles: []float64{0}},
+		{Window: Tumbling(time.Second), Percentiles: []float64{1.5}},
+		{Window: Tumbling(time.Second), AllowedLateness: -1},
+	}
+	for _, opts := range tests {
+		if _, err := Aggregate(context.Background(), nil, opts); err == nil {
+			t.Errorf("Aggregate(%+v) returned no error", opts)
+		}
+	}
+}
+
+func BenchmarkSliding(b *testing.B) {
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		in := make(chan Event)
+		out, _ := Aggregate(ctx, in, Options{
+			Window:      Sliding(10*time.Second, time.Second),
+			Percentiles: []float64{0.5, 0.99},
+		})
+		go func() {
+			defer close(in)
+			for j := 0; j < 1000; j++ {
+				in <- ev("k", j/10, float64(j))
+			}
+		}()
+		for range out {
+		}
+	}
+}